@@ -13,94 +13,194 @@ import (
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/config"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/handlers"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/logging"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/middleware"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/stock"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/tracing"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
 func main() {
+	// Used until the configured logger is built
 	logger, _ := zap.NewProduction()
+
+	cfg, err := config.Load()
+	if err != nil {
+		logger.Fatal("failed to load configuration", zap.Error(err))
+	}
+	if err := cfg.Validate(); err != nil {
+		logger.Fatal("invalid configuration", zap.Error(err))
+	}
+
+	configuredLogger, err := logging.New(cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		logger.Fatal("failed to build logger", zap.Error(err))
+	}
+	logger = configuredLogger
 	defer logger.Sync()
 
-	cfg := config.Load()
+	// Tracing must be set up before anything that makes outbound requests
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.OTelEnabled, cfg.OTelEndpoint)
+	if err != nil {
+		logger.Fatal("failed to set up tracing", zap.Error(err))
+	}
 
 	// Create cache
-	stockCache := cache.NewCache(cfg.CacheTTL)
+	stockCache := newCache(cfg, logger)
+
+	// Unknown symbols are remembered per instance; errors don't round-trip
+	// through the Redis JSON encoding, so this is always in memory.
+	var negativeCache cache.Cache
+	if cfg.NegativeCacheTTL > 0 {
+		negativeCache = cache.NewCacheWithCleanup(cfg.NegativeCacheTTL, cfg.CacheCleanupInterval)
+	}
+
+	// Exchange rates are few and cheap to refetch, so they stay in memory too
+	var fxCache cache.Cache
+	if cfg.FXCacheTTL > 0 {
+		fxCache = cache.NewCacheWithCleanup(cfg.FXCacheTTL, cfg.CacheCleanupInterval)
+	}
+
+	// Quotes are short-lived and small, so they are kept in memory as well
+	var quoteCache cache.Cache
+	if cfg.QuoteCacheTTL > 0 {
+		quoteCache = cache.NewCacheWithCleanup(cfg.QuoteCacheTTL, cfg.CacheCleanupInterval)
+	}
 
 	// Create circuit breaker
 	cb := circuitbreaker.NewCircuitBreaker(cfg.CircuitBreakerThreshold, cfg.CircuitBreakerSuccessThreshold, cfg.CircuitBreakerTimeout)
+	cb.SetBackoffMax(cfg.CircuitBreakerBackoffMax)
 
 	// Create Prometheus metrics
-	cacheHits := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "ping_service_cache_hits_total",
-		Help: "Total number of cache hits",
-	})
-	cacheMisses := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "ping_service_cache_misses_total",
-		Help: "Total number of cache misses",
-	})
-	externalCalls := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "ping_service_external_calls_total",
-		Help: "Total number of external API calls",
-	})
-	externalCallDuration := prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "ping_service_external_call_duration_seconds",
-		Help:    "Duration of external API calls in seconds",
-		Buckets: prometheus.DefBuckets,
-	})
 	circuitBreakerState := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "ping_service_circuit_breaker_state",
-		Help: "Circuit breaker state (0=closed, 1=open, 2=half-open)",
+		Namespace: cfg.MetricsNamespace,
+		Name:      "circuit_breaker_state",
+		Help:      "Circuit breaker state (0=closed, 1=open, 2=half-open)",
 	})
-	apiRequests := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "ping_service_api_requests_total",
-		Help: "Total number of API requests",
+	// Sampled on scrape so it never drifts from the cache's own view. Only
+	// the in-memory cache holds on to expired entries; Redis drops them
+	// itself, so all of its entries are live.
+	cacheSizes := func() (live, expired int) {
+		return stockCache.Len(), 0
+	}
+	if memoryCache, ok := stockCache.(*cache.MemoryCache); ok {
+		cacheSizes = memoryCache.Sizes
+	}
+	newCacheSize := func(state string, size func() int) prometheus.GaugeFunc {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   cfg.MetricsNamespace,
+			Name:        "cache_size",
+			Help:        "Number of entries in the stock data cache, by state: live, or expired but not yet removed",
+			ConstLabels: prometheus.Labels{"state": state},
+		}, func() float64 {
+			return float64(size())
+		})
+	}
+	cacheSizeLive := newCacheSize("live", func() int {
+		live, _ := cacheSizes()
+		return live
 	})
-	apiDuration := prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "ping_service_api_request_duration_seconds",
-		Help:    "Duration of API requests in seconds",
-		Buckets: prometheus.DefBuckets,
+	cacheSizeExpired := newCacheSize("expired", func() int {
+		_, expired := cacheSizes()
+		return expired
 	})
-	externalApiLatency := prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "stock_api_external_call_latency_seconds",
-			Help:    "Latency of external API calls to the stock service.",
-			Buckets: prometheus.DefBuckets,
-		},
-		[]string{"endpoint"},
-	)
+	// How long entries live and sit unread before they expire or are
+	// evicted, to check CACHE_TTL against access patterns. Only the
+	// in-memory cache can report this; Redis expires entries itself.
+	cacheEntryAge := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: cfg.MetricsNamespace,
+		Name:      "cache_entry_age_seconds",
+		Help:      "Age of stock data cache entries when they expired or were evicted",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 16),
+	}, []string{"reason"})
+	cacheEntryIdle := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: cfg.MetricsNamespace,
+		Name:      "cache_entry_idle_seconds",
+		Help:      "Time since stock data cache entries were last read when they expired or were evicted",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 16),
+	}, []string{"reason"})
+	if memoryCache, ok := stockCache.(*cache.MemoryCache); ok {
+		memoryCache.SetOnRemove(func(removal cache.Removal) {
+			cacheEntryAge.WithLabelValues(removal.Reason).Observe(removal.Age.Seconds())
+			cacheEntryIdle.WithLabelValues(removal.Reason).Observe(removal.Idle.Seconds())
+		})
+	}
 
-	// Register all metrics
-	prometheus.MustRegister(
-		cacheHits,
-		cacheMisses,
-		externalCalls,
-		externalCallDuration,
+	// Report breaker transitions
+	cb.OnStateChange = func(from, to circuitbreaker.State) {
+		logger.Warn("circuit breaker state changed",
+			zap.String("from", from.String()),
+			zap.String("to", to.String()),
+		)
+		circuitBreakerState.Set(float64(to))
+	}
+
+	// Register the shared metrics; the middleware, client and handler
+	// register their own
+	registry := prometheus.DefaultRegisterer
+	registry.MustRegister(
 		circuitBreakerState,
-		apiRequests,
-		apiDuration,
-		externalApiLatency,
+		cacheSizeLive,
+		cacheSizeExpired,
+		cacheEntryAge,
+		cacheEntryIdle,
 	)
+	httpMetrics := middleware.NewHTTPMetrics(registry, cfg.MetricsNamespace, cfg.LatencyBuckets)
+
+	providers, err := newProviders(cfg, logger)
+	if err != nil {
+		logger.Fatal("failed to configure stock providers", zap.Error(err))
+	}
 
 	// Create stock client with all dependencies
-	stockClient := stock.NewClient(
-		cfg.APIKey,
-		cfg.APITimeout,
-		logger,
-		stockCache,
-		cb,
-		cacheHits,
-		cacheMisses,
-		externalCalls,
-		externalCallDuration,
-		circuitBreakerState,
-		externalApiLatency,
-	)
+	stockClient := stock.NewClient(stock.ClientOptions{
+		Providers:             providers,
+		Logger:                logger,
+		Cache:                 stockCache,
+		NegativeCache:         negativeCache,
+		CircuitBreaker:        cb,
+		CacheTTL:              cfg.CacheTTL,
+		FXCache:               fxCache,
+		QuoteCache:            quoteCache,
+		MaxConcurrentUpstream: cfg.MaxConcurrentUpstream,
+		HashCacheKeys:         cfg.CacheKeyHash,
+		Retries:               cfg.UpstreamRetries,
+		RetryBackoff:          cfg.UpstreamRetryBackoff,
+		BreakerCountsRetries:  cfg.CircuitBreakerCountRetries,
+		// Every attempt is bounded by API_TIMEOUT; leave room for each
+		// retry and the doubling backoff between them
+		FetchTimeout:     cfg.APITimeout*time.Duration(cfg.UpstreamRetries+1) + cfg.UpstreamRetryBackoff<<cfg.UpstreamRetries,
+		Degraded:         cfg.DegradedMode,
+		PricePrecision:   cfg.PricePrecision,
+		BlockedSymbols:   cfg.BlockedSymbols,
+		Registerer:       registry,
+		MetricsNamespace: cfg.MetricsNamespace,
+		LatencyBuckets:   cfg.LatencyBuckets,
+	})
+
+	// Keep hot symbols warm by refreshing them shortly before they expire;
+	// stockClient.Close stops the refresher
+	if cfg.BackgroundRefresh && cfg.CacheTTL > 0 {
+		// Jittered entries can expire up to CACHE_TTL_JITTER early
+		lead := cfg.CacheTTL/10 + time.Duration(cfg.CacheTTLJitter*float64(cfg.CacheTTL))
+		stockClient.StartRefresher(stock.RefreshOptions{
+			TTL:      cfg.CacheTTL,
+			Lead:     lead,
+			MinHits:  cfg.RefreshMinHits,
+			Interval: max(lead/2, time.Second),
+		})
+	}
 
 	// Create handler
-	handler := handlers.NewHandler(cfg, stockClient, logger, apiRequests, apiDuration)
+	handler := handlers.New(handlers.HandlerOptions{
+		Config:      cfg,
+		StockClient: stockClient,
+		Logger:      logger,
+		Registerer:  registry,
+	})
 
 	logger.Info("Starting Overly-Serious-Simple-Stock-Service",
 		zap.String("symbol", cfg.Symbol),
@@ -108,11 +208,27 @@ func main() {
 		zap.String("port", cfg.Port),
 	)
 
+	// Fill the cache before the server starts answering readiness checks
+	if len(cfg.WarmSymbols) > 0 {
+		warmCache(stockClient, cfg, logger)
+	}
+
+	// Validate has already rejected malformed entries
+	trustedProxies, _ := cfg.TrustedProxyPrefixes()
+
 	router := mux.NewRouter()
 
 	// Middleware
+	router.Use(httpMetrics.Recover(logger))
+	router.Use(tracing.Middleware)
+	router.Use(middleware.RequestID)
+	router.Use(middleware.ClientIP(trustedProxies))
+	router.Use(middleware.InFlight)
 	router.Use(middleware.Logging(logger))
-	router.Use(middleware.Metrics)
+	router.Use(httpMetrics.Metrics)
+	router.Use(middleware.RateLimit(cfg.RateLimitRPS, cfg.RateLimitBurst))
+	router.Use(middleware.Compression)
+	router.Use(middleware.Timeout(cfg.HandlerTimeout))
 
 	// Register routes
 	handler.RegisterRoutes(router)
@@ -135,13 +251,158 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	logger.Info("shutting down server...")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
+	shutdown(ctx, srv, handler, stockClient, shutdownTracing, logger)
+}
+
+// shutdown tears the service down in order, logging each step: readiness
+// starts failing, streams end, the server stops accepting connections and
+// drains in-flight requests, then the stock client closes its caches,
+// background goroutines and idle upstream connections, and buffered spans
+// are flushed. A failed step is logged and the rest still run. ctx bounds
+// the whole sequence; a step still running when it expires is abandoned.
+func shutdown(ctx context.Context, srv *http.Server, handler *handlers.Handler, stockClient *stock.Client, shutdownTracing func(context.Context) error, logger *zap.Logger) {
+	start := time.Now()
+	handler.StartDraining()
+	logger.Info("shutting down server...", zap.Int64("in_flight", middleware.InFlightRequests()))
+
+	// End websocket and event streams first; Shutdown would not close them
+	if err := handler.CloseStreams(ctx); err != nil {
+		logger.Warn("streams did not close in time", zap.Error(err))
+	} else {
+		logger.Info("streams closed")
+	}
 
 	if err := srv.Shutdown(ctx); err != nil {
-		logger.Fatal("server shutdown failed", zap.Error(err))
+		logger.Error("server shutdown failed", zap.Error(err), zap.Int64("in_flight", middleware.InFlightRequests()))
+	} else {
+		logger.Info("server stopped, in-flight requests drained")
 	}
-	logger.Info("server exited gracefully")
-}
\ No newline at end of file
+
+	// Close doesn't take a context, so wait for it only as long as ctx
+	// allows
+	closed := make(chan error, 1)
+	go func() { closed <- stockClient.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			logger.Warn("failed to close stock client", zap.Error(err))
+		} else {
+			logger.Info("stock client closed")
+		}
+	case <-ctx.Done():
+		logger.Warn("stock client did not close in time", zap.Error(ctx.Err()))
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Warn("failed to flush traces", zap.Error(err))
+	} else {
+		logger.Info("traces flushed")
+	}
+
+	logger.Info("shutdown complete", zap.Duration("duration", time.Since(start)))
+}
+
+// warmCache fetches cfg.WarmSymbols into the cache, giving up after
+// cfg.WarmTimeout so a slow or failing upstream can't hold up startup.
+func warmCache(stockClient *stock.Client, cfg *config.Config, logger *zap.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.WarmTimeout)
+	defer cancel()
+
+	start := time.Now()
+	var warmed, failed []string
+	for _, result := range stockClient.Warm(ctx, cfg.WarmSymbols, cfg.NDays, cfg.WarmConcurrency) {
+		if result.Err != nil {
+			failed = append(failed, result.Symbol)
+			logger.Warn("failed to warm symbol", zap.String("symbol", result.Symbol), zap.Error(result.Err))
+			continue
+		}
+		warmed = append(warmed, result.Symbol)
+	}
+	logger.Info("cache warmed",
+		zap.Strings("warmed", warmed),
+		zap.Strings("failed", failed),
+		zap.Duration("duration", time.Since(start)),
+	)
+}
+
+// newProviders returns the configured stock providers with cfg.Provider
+// first; the rest are used as fallbacks in order.
+func newProviders(cfg *config.Config, logger *zap.Logger) ([]stock.StockProvider, error) {
+	if cfg.MockMode {
+		logger.Warn("mock mode enabled: serving synthetic prices, no provider will be called")
+		return []stock.StockProvider{stock.NewMockProvider(logger)}, nil
+	}
+
+	// One pooled transport shared by every provider
+	transport := stock.NewTransport(stock.TransportOptions{
+		MaxIdleConns:        cfg.UpstreamMaxIdleConns,
+		MaxIdleConnsPerHost: cfg.UpstreamMaxIdleConnsPerHost,
+		IdleConnTimeout:     cfg.UpstreamIdleConnTimeout,
+		TLSHandshakeTimeout: cfg.UpstreamTLSHandshakeTimeout,
+	})
+
+	available := []stock.StockProvider{
+		stock.NewAlphaVantageProvider(cfg.APIKey, cfg.OutputSize, cfg.APITimeout, cfg.MaxUpstreamBodyBytes, transport, logger),
+	}
+	if cfg.FinnhubAPIKey != "" {
+		available = append(available, stock.NewFinnhubProvider(cfg.FinnhubAPIKey, cfg.APITimeout, cfg.MaxUpstreamBodyBytes, transport, logger))
+	}
+
+	providers := make([]stock.StockProvider, 0, len(available))
+	for _, p := range available {
+		if p.Name() == cfg.Provider {
+			providers = append(providers, p)
+		}
+	}
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+	}
+	for _, p := range available {
+		if p.Name() != cfg.Provider {
+			providers = append(providers, p)
+		}
+	}
+
+	return providers, nil
+}
+
+// newCache builds the configured cache backend, falling back to the
+// in-memory cache when Redis is unreachable at startup.
+func newCache(cfg *config.Config, logger *zap.Logger) cache.Cache {
+	if cfg.CacheBackend != "redis" {
+		return newMemoryCache(cfg)
+	}
+
+	redisCache := cache.NewRedisCache(
+		redis.NewClient(&redis.Options{Addr: cfg.RedisAddr}),
+		cfg.CacheTTL,
+		"stock-service:",
+		func() interface{} { return &stock.StockData{} },
+	)
+	redisCache.SetStaleTTL(cfg.StaleTTL)
+	redisCache.SetJitter(cfg.CacheTTLJitter)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := redisCache.Ping(ctx); err != nil {
+		logger.Warn("redis unreachable, falling back to in-memory cache",
+			zap.String("addr", cfg.RedisAddr),
+			zap.Error(err),
+		)
+		return newMemoryCache(cfg)
+	}
+
+	logger.Info("using redis cache", zap.String("addr", cfg.RedisAddr))
+	return redisCache
+}
+
+func newMemoryCache(cfg *config.Config) *cache.MemoryCache {
+	memoryCache := cache.NewCacheWithCleanup(cfg.CacheTTL, cfg.CacheCleanupInterval)
+	memoryCache.SetStaleTTL(cfg.StaleTTL)
+	memoryCache.SetJitter(cfg.CacheTTLJitter)
+	return memoryCache
+}
@@ -1,57 +1,464 @@
 package cache
 
 import (
+	"math/rand/v2"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/clock"
 )
 
 type CacheItem struct {
 	Value      interface{}
 	Expiration int64
+	// Created is when the value was stored, in Unix nanoseconds.
+	Created int64
+	// lastAccess is when the value was last read, or Created if it never
+	// was. Reads only hold the read lock, so it is updated atomically.
+	lastAccess *atomic.Int64
+}
+
+// newItem returns an entry for value stored at now.
+func newItem(value interface{}, now time.Time, expiration int64) CacheItem {
+	item := CacheItem{
+		Value:      value,
+		Expiration: expiration,
+		Created:    now.UnixNano(),
+		lastAccess: new(atomic.Int64),
+	}
+	item.lastAccess.Store(item.Created)
+	return item
 }
 
-type Cache struct {
+// Reasons an entry is removed from a MemoryCache, reported in Removal.
+const (
+	// RemovalExpired is an expired entry removed by the janitor or
+	// Delete, or replaced by a new value.
+	RemovalExpired = "expired"
+	// RemovalEvicted is a live entry removed by Delete, or any entry
+	// removed by Clear.
+	RemovalEvicted = "evicted"
+)
+
+// Removal describes an entry leaving a MemoryCache, passed to the
+// callback set with SetOnRemove.
+type Removal struct {
+	Key    string
+	Reason string
+	// Age is how long the entry was stored; Idle is how long since it was
+	// last read, or since it was stored if it never was.
+	Age  time.Duration
+	Idle time.Duration
+}
+
+func newRemoval(key string, item CacheItem, reason string, now time.Time) Removal {
+	return Removal{
+		Key:    key,
+		Reason: reason,
+		Age:    time.Duration(now.UnixNano() - item.Created),
+		Idle:   time.Duration(now.UnixNano() - item.lastAccess.Load()),
+	}
+}
+
+// Cache is the storage used by the stock client. MemoryCache keeps entries
+// in-process; RedisCache shares them across replicas.
+type Cache interface {
+	Get(key string) (interface{}, bool)
+	// GetWithTTL is Get that also returns how long the entry has left
+	// before it expires.
+	GetWithTTL(key string) (value interface{}, remaining time.Duration, found bool)
+	// GetStale returns an entry that has expired but is still within the
+	// stale grace period. It does not affect the hit and miss counters.
+	GetStale(key string) (interface{}, bool)
+	// Set stores value under key for the cache's configured TTL, adjusted
+	// by any jitter. It replaces an existing entry and resets its expiry.
+	Set(key string, value interface{})
+	// SetWithTTL stores value under key for ttl instead of the configured
+	// TTL; no jitter is applied. A ttl of 0 or less updates the value of a
+	// live entry without changing when it expires, and otherwise behaves
+	// like Set.
+	SetWithTTL(key string, value interface{}, ttl time.Duration)
+	// SetDefaultTTL replaces the configured TTL used by later calls to
+	// Set, SetWithTTL and Touch, and returns the previous one. Entries
+	// already stored keep their expiry.
+	SetDefaultTTL(ttl time.Duration) (previous time.Duration)
+	// Touch resets the expiry of a live entry as if its value had just been
+	// Set, and reports whether there was one. Expired entries, including
+	// stale ones, are left alone.
+	Touch(key string) bool
+	Delete(key string)
+	Clear() int
+	Len() int
+	Stats() Stats
+	// Counts returns the hits and misses seen by Get and GetWithTTL. Unlike
+	// Stats it never touches the backend, so it is cheap enough to read on
+	// every metrics scrape.
+	Counts() (hits, misses uint64)
+	// Close releases the cache's background work and connections. It is
+	// safe to call more than once.
+	Close() error
+}
+
+// MemoryCache is an in-process TTL cache.
+type MemoryCache struct {
 	items map[string]CacheItem
 	mu    sync.RWMutex
 	ttl   time.Duration
+	// staleTTL is how long expired entries remain available to GetStale.
+	staleTTL time.Duration
+	// jitter spreads each entry's TTL by up to this fraction either way.
+	jitter float64
+	clock  clock.Clock
+	// onRemove, when set, is called for each entry that expires or is
+	// evicted, after the lock is released.
+	onRemove func(Removal)
+
+	// stop ends the janitor goroutine; done is closed once it has exited.
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
+}
+
+// Stats is a point-in-time snapshot of cache activity.
+type Stats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Size        int    `json:"size"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+}
+
+// DefaultCleanupInterval is how often a cache made by NewCache removes
+// expired entries.
+const DefaultCleanupInterval = time.Minute
+
+// NewCache returns a cache whose janitor removes expired entries every
+// DefaultCleanupInterval. Call Close to stop it.
+func NewCache(ttl time.Duration) *MemoryCache {
+	return NewCacheWithCleanup(ttl, DefaultCleanupInterval)
 }
 
-func NewCache(ttl time.Duration) *Cache {
-	return &Cache{
+// NewCacheWithCleanup returns a cache whose janitor goroutine removes
+// expired entries every interval, so entries that are never read again
+// don't hold memory. An interval of 0 or less disables the janitor and
+// expired entries stay until they are overwritten or deleted.
+func NewCacheWithCleanup(ttl, interval time.Duration) *MemoryCache {
+	c := &MemoryCache{
 		items: make(map[string]CacheItem),
 		ttl:   ttl,
+		clock: clock.Real,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
 	}
+	if interval > 0 {
+		go c.janitor(interval)
+	} else {
+		close(c.done)
+	}
+	return c
 }
 
-func (c *Cache) Set(key string, value interface{}) {
+// janitor calls deleteExpired every interval until Close.
+func (c *MemoryCache) janitor(interval time.Duration) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.deleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// deleteExpired removes entries that have expired and are past the stale
+// grace period, and returns how many it removed.
+func (c *MemoryCache) deleteExpired() int {
+	var removals []Removal
+	defer func() { c.notify(removals) }()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.items[key] = CacheItem{
-		Value:      value,
-		Expiration: time.Now().Add(c.ttl).UnixNano(),
+	now := c.clock.Now()
+	removed := 0
+	for key, item := range c.items {
+		if now.UnixNano() > item.Expiration+c.staleTTL.Nanoseconds() {
+			delete(c.items, key)
+			removed++
+			c.expirations.Add(1)
+			if c.onRemove != nil {
+				removals = append(removals, newRemoval(key, item, RemovalExpired, now))
+			}
+		}
+	}
+	return removed
+}
+
+// Close stops the janitor and waits for it to exit. It is safe to call
+// more than once. The cache remains usable afterwards, without cleanup.
+func (c *MemoryCache) Close() error {
+	c.closeOnce.Do(func() { close(c.stop) })
+	<-c.done
+	return nil
+}
+
+// SetClock makes the cache read the time from clk rather than the system
+// clock.
+func (c *MemoryCache) SetClock(clk clock.Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock = clk
+}
+
+// SetOnRemove calls fn for every entry that expires or is evicted, such as
+// to record how long entries live. fn runs without the cache's lock held.
+// Call it before the cache is shared between goroutines.
+func (c *MemoryCache) SetOnRemove(fn func(Removal)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onRemove = fn
+}
+
+// notify passes removals to the onRemove callback.
+func (c *MemoryCache) notify(removals []Removal) {
+	for _, r := range removals {
+		c.onRemove(r)
+	}
+}
+
+// replaceExpired counts key's current entry as expired if it has expired
+// and is about to be overwritten, returning its removal for onRemove.
+// Callers hold the lock.
+func (c *MemoryCache) replaceExpired(key string, now time.Time) []Removal {
+	item, found := c.items[key]
+	if !found || now.UnixNano() <= item.Expiration {
+		return nil
+	}
+	c.expirations.Add(1)
+	if c.onRemove == nil {
+		return nil
+	}
+	return []Removal{newRemoval(key, item, RemovalExpired, now)}
+}
+
+// SetStaleTTL keeps expired entries available to GetStale for d. Call it
+// before the cache is shared between goroutines.
+func (c *MemoryCache) SetStaleTTL(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.staleTTL = d
+}
+
+// SetJitter randomly lengthens or shortens each entry's TTL by up to
+// fraction of it, so entries cached in the same burst don't all expire at
+// once. Call it before the cache is shared between goroutines.
+func (c *MemoryCache) SetJitter(fraction float64) {
+	c.jitter = fraction
+}
+
+func (c *MemoryCache) Set(key string, value interface{}) {
+	var removals []Removal
+	defer func() { c.notify(removals) }()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	removals = c.replaceExpired(key, now)
+	c.items[key] = newItem(value, now, now.Add(jitteredTTL(c.ttl, c.jitter)).UnixNano())
+}
+
+func (c *MemoryCache) SetDefaultTTL(ttl time.Duration) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous := c.ttl
+	c.ttl = ttl
+	return previous
+}
+
+func (c *MemoryCache) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	var removals []Removal
+	defer func() { c.notify(removals) }()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	if ttl <= 0 {
+		// Updating a live entry in place keeps its age
+		if item, found := c.items[key]; found && now.UnixNano() <= item.Expiration {
+			item.Value = value
+			c.items[key] = item
+			return
+		}
+		ttl = jitteredTTL(c.ttl, c.jitter)
+	}
+
+	removals = c.replaceExpired(key, now)
+	c.items[key] = newItem(value, now, now.Add(ttl).UnixNano())
+}
+
+func (c *MemoryCache) Touch(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, found := c.items[key]
+	now := c.clock.Now()
+	if !found || now.UnixNano() > item.Expiration {
+		return false
 	}
+
+	item.Expiration = now.Add(jitteredTTL(c.ttl, c.jitter)).UnixNano()
+	c.items[key] = item
+	return true
+}
+
+// jitteredTTL returns ttl moved by a random amount within ±fraction of it.
+// The result is always positive for a positive ttl.
+func jitteredTTL(ttl time.Duration, fraction float64) time.Duration {
+	if ttl <= 0 || fraction <= 0 {
+		return ttl
+	}
+	jittered := ttl + time.Duration((rand.Float64()*2-1)*fraction*float64(ttl))
+	return max(jittered, time.Nanosecond)
 }
 
-func (c *Cache) Get(key string) (interface{}, bool) {
+func (c *MemoryCache) Get(key string) (interface{}, bool) {
+	value, _, found := c.GetWithTTL(key)
+	return value, found
+}
+
+func (c *MemoryCache) GetWithTTL(key string) (interface{}, time.Duration, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	item, found := c.items[key]
 	if !found {
-		return nil, false
+		c.misses.Add(1)
+		return nil, 0, false
 	}
 
-	if time.Now().UnixNano() > item.Expiration {
+	// Expired entries stay for GetStale until the janitor or a write
+	// removes them, which is when they count as expirations
+	remaining := time.Unix(0, item.Expiration).Sub(c.clock.Now())
+	if remaining < 0 {
+		c.misses.Add(1)
+		return nil, 0, false
+	}
+
+	c.hits.Add(1)
+	item.lastAccess.Store(c.clock.Now().UnixNano())
+	return item.Value, remaining, true
+}
+
+func (c *MemoryCache) GetStale(key string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, found := c.items[key]
+	if !found {
 		return nil, false
 	}
 
+	now := c.clock.Now().UnixNano()
+	if now <= item.Expiration || now > item.Expiration+c.staleTTL.Nanoseconds() {
+		return nil, false
+	}
+	item.lastAccess.Store(now)
 	return item.Value, true
 }
 
-func (c *Cache) Delete(key string) {
+// Delete removes key from the cache. Removing a live entry counts as an
+// eviction and removing an expired one as an expiration.
+func (c *MemoryCache) Delete(key string) {
+	var removals []Removal
+	defer func() { c.notify(removals) }()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	item, found := c.items[key]
+	if !found {
+		return
+	}
 	delete(c.items, key)
+
+	now := c.clock.Now()
+	reason := RemovalEvicted
+	if now.UnixNano() > item.Expiration {
+		reason = RemovalExpired
+		c.expirations.Add(1)
+	} else {
+		c.evictions.Add(1)
+	}
+	if c.onRemove != nil {
+		removals = append(removals, newRemoval(key, item, reason, now))
+	}
+}
+
+// Clear removes every entry and returns how many were removed. Cleared
+// entries count as evictions.
+func (c *MemoryCache) Clear() int {
+	var removals []Removal
+	defer func() { c.notify(removals) }()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.onRemove != nil {
+		now := c.clock.Now()
+		for key, item := range c.items {
+			removals = append(removals, newRemoval(key, item, RemovalEvicted, now))
+		}
+	}
+	n := len(c.items)
+	c.items = make(map[string]CacheItem)
+	c.evictions.Add(uint64(n))
+	return n
+}
+
+// Len returns the number of stored entries, including expired entries the
+// janitor has not yet removed.
+func (c *MemoryCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.items)
+}
+
+// Sizes splits Len into live entries and expired entries, stale or not,
+// that haven't been removed yet.
+func (c *MemoryCache) Sizes() (live, expired int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.clock.Now().UnixNano()
+	for _, item := range c.items {
+		if now > item.Expiration {
+			expired++
+		}
+	}
+	return len(c.items) - expired, expired
+}
+
+// Counts returns the hit and miss counters.
+func (c *MemoryCache) Counts() (hits, misses uint64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// Stats returns the current counters and number of stored entries. Size
+// includes expired entries the janitor has not yet removed.
+func (c *MemoryCache) Stats() Stats {
+	return Stats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Size:        c.Len(),
+		Evictions:   c.evictions.Load(),
+		Expirations: c.expirations.Load(),
+	}
 }
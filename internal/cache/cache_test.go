@@ -1,10 +1,35 @@
 package cache
 
 import (
+	"fmt"
+	"math"
+	"sync"
 	"testing"
 	"time"
 )
 
+// fakeClock is a clock that only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
 func TestCacheSetAndGet(t *testing.T) {
 	cache := NewCache(1 * time.Hour)
 	key := "test-key"
@@ -20,11 +45,19 @@ func TestCacheSetAndGet(t *testing.T) {
 }
 
 func TestCacheExpiration(t *testing.T) {
+	clk := newFakeClock()
 	cache := NewCache(100 * time.Millisecond)
+	cache.SetClock(clk)
 	key := "test-key"
 	value := "test-value"
 	cache.Set(key, value)
-	time.Sleep(150 * time.Millisecond) // Wait for expiration
+
+	clk.Advance(100 * time.Millisecond)
+	if _, found := cache.Get(key); !found {
+		t.Errorf("Expected key %s to live for its whole TTL", key)
+	}
+
+	clk.Advance(time.Nanosecond)
 	_, found := cache.Get(key)
 	if found {
 		t.Errorf("Expected key %s to be expired", key)
@@ -71,4 +104,470 @@ func TestCacheDelete(t *testing.T) {
 	if found {
 		t.Errorf("Expected key %s to be deleted", key)
 	}
-}
\ No newline at end of file
+}
+func TestCacheStats(t *testing.T) {
+	clk := newFakeClock()
+	cache := NewCache(100 * time.Millisecond)
+	cache.SetClock(clk)
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+
+	cache.Get("a")       // hit
+	cache.Get("missing") // miss
+	cache.Delete("b")    // eviction
+
+	clk.Advance(150 * time.Millisecond)
+	cache.Get("a") // expired
+	cache.Get("a") // still expired, not counted again
+
+	stats := cache.Stats()
+	if stats.Hits != 1 {
+		t.Errorf("Expected 1 hit, got %d", stats.Hits)
+	}
+	if stats.Misses != 3 {
+		t.Errorf("Expected 3 misses, got %d", stats.Misses)
+	}
+	if stats.Evictions != 1 {
+		t.Errorf("Expected 1 eviction, got %d", stats.Evictions)
+	}
+	if stats.Expirations != 0 {
+		t.Errorf("Expected no expirations before the entry is removed, got %d", stats.Expirations)
+	}
+	if stats.Size != 1 {
+		t.Errorf("Expected size 1, got %d", stats.Size)
+	}
+
+	// The expiration counts once, when the janitor removes the entry
+	cache.deleteExpired()
+	stats = cache.Stats()
+	if stats.Expirations != 1 {
+		t.Errorf("Expected 1 expiration, got %d", stats.Expirations)
+	}
+	if stats.Size != 0 {
+		t.Errorf("Expected size 0, got %d", stats.Size)
+	}
+
+	// So does replacing or deleting an expired entry
+	cache.Set("c", 3)
+	cache.Set("d", 4)
+	clk.Advance(150 * time.Millisecond)
+	cache.Set("c", 5)
+	cache.Delete("d")
+	stats = cache.Stats()
+	if stats.Expirations != 3 || stats.Evictions != 1 {
+		t.Errorf("Expected 3 expirations and 1 eviction, got %+v", stats)
+	}
+}
+
+func TestCacheCounts(t *testing.T) {
+	cache := NewCache(time.Minute)
+	cache.Set("a", 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Get("a")                // hit
+			cache.GetWithTTL("a")         // hit
+			cache.Get("missing")          // miss
+			cache.GetStale("missing-too") // not counted
+		}()
+	}
+	wg.Wait()
+
+	hits, misses := cache.Counts()
+	if hits != 100 || misses != 50 {
+		t.Errorf("expected 100 hits and 50 misses, got %d and %d", hits, misses)
+	}
+	if stats := cache.Stats(); stats.Hits != hits || stats.Misses != misses {
+		t.Errorf("expected Stats to agree with Counts, got %+v", stats)
+	}
+}
+
+func TestCacheClear(t *testing.T) {
+	cache := NewCache(1 * time.Hour)
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+
+	if n := cache.Clear(); n != 2 {
+		t.Errorf("Expected 2 entries cleared, got %d", n)
+	}
+
+	for _, key := range []string{"a", "b"} {
+		if _, found := cache.Get(key); found {
+			t.Errorf("Expected key %s to miss after Clear", key)
+		}
+	}
+	if stats := cache.Stats(); stats.Size != 0 {
+		t.Errorf("Expected size 0 after Clear, got %d", stats.Size)
+	}
+}
+
+func TestCacheLen(t *testing.T) {
+	clk := newFakeClock()
+	cache := NewCache(100 * time.Millisecond)
+	cache.SetClock(clk)
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Delete("a")
+
+	if n := cache.Len(); n != 1 {
+		t.Errorf("Expected length 1, got %d", n)
+	}
+
+	// Expired entries stay counted until they are removed
+	clk.Advance(150 * time.Millisecond)
+	if n := cache.Len(); n != 1 {
+		t.Errorf("Expected expired entry to still be counted, got %d", n)
+	}
+
+	cache.Set("c", 3)
+	if live, expired := cache.Sizes(); live != 1 || expired != 1 {
+		t.Errorf("Expected 1 live and 1 expired entry, got %d and %d", live, expired)
+	}
+}
+
+func TestCacheGetStale(t *testing.T) {
+	clk := newFakeClock()
+	cache := NewCache(50 * time.Millisecond)
+	cache.SetClock(clk)
+	cache.SetStaleTTL(100 * time.Millisecond)
+	cache.Set("a", 1)
+
+	if _, found := cache.GetStale("a"); found {
+		t.Error("Expected fresh entry not to be returned as stale")
+	}
+
+	clk.Advance(75 * time.Millisecond)
+	if _, found := cache.Get("a"); found {
+		t.Error("Expected Get to miss past the TTL")
+	}
+	if value, found := cache.GetStale("a"); !found || value != 1 {
+		t.Errorf("Expected stale entry within the grace period, got %v, %v", value, found)
+	}
+
+	clk.Advance(100 * time.Millisecond)
+	if _, found := cache.GetStale("a"); found {
+		t.Error("Expected entry to be gone after the grace period")
+	}
+}
+
+func TestCacheTTLJitterSpread(t *testing.T) {
+	const (
+		ttl     = time.Hour
+		spread  = ttl / 10
+		entries = 2000
+	)
+	cache := NewCache(ttl)
+	cache.SetJitter(0.1)
+
+	before := time.Now()
+	for i := 0; i < entries; i++ {
+		cache.Set(fmt.Sprintf("key-%d", i), i)
+	}
+	after := time.Now()
+
+	var sum, sumSquares float64
+	lowest, highest := time.Duration(math.MaxInt64), time.Duration(0)
+	for _, item := range cache.items {
+		// Measure against the Set window so timing doesn't skew the bounds
+		lifetime := time.Unix(0, item.Expiration).Sub(before)
+		if lifetime < ttl-spread || lifetime > ttl+spread+after.Sub(before) {
+			t.Fatalf("lifetime %s outside %s ± %s", lifetime, ttl, spread)
+		}
+		lowest, highest = min(lowest, lifetime), max(highest, lifetime)
+
+		offset := (lifetime - ttl).Seconds()
+		sum += offset
+		sumSquares += offset * offset
+	}
+
+	// A uniform spread over ±360s has mean 0 and standard deviation
+	// 360/sqrt(3) ≈ 208s
+	mean := sum / entries
+	stddev := math.Sqrt(sumSquares/entries - mean*mean)
+	if math.Abs(mean) > 20 {
+		t.Errorf("expected offsets centered on the TTL, got mean %.1fs", mean)
+	}
+	if stddev < 180 || stddev > 240 {
+		t.Errorf("expected standard deviation near 208s, got %.1fs", stddev)
+	}
+	if highest-lowest < spread {
+		t.Errorf("expected expiries spread over most of the range, got %s to %s", lowest, highest)
+	}
+}
+
+func TestJitteredTTLStaysPositive(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		if got := jitteredTTL(time.Nanosecond, 0.99); got <= 0 {
+			t.Fatalf("expected a positive TTL, got %s", got)
+		}
+	}
+	if got := jitteredTTL(time.Minute, 0); got != time.Minute {
+		t.Errorf("expected no jitter by default, got %s", got)
+	}
+}
+
+func TestCacheGetWithTTL(t *testing.T) {
+	clk := newFakeClock()
+	cache := NewCache(time.Hour)
+	cache.SetClock(clk)
+	cache.Set("a", 1)
+
+	value, first, found := cache.GetWithTTL("a")
+	if !found || value != 1 {
+		t.Fatalf("Expected to find a, got %v, %v", value, found)
+	}
+	if first != time.Hour {
+		t.Errorf("Expected the full hour remaining, got %s", first)
+	}
+
+	clk.Advance(10 * time.Minute)
+	if _, second, _ := cache.GetWithTTL("a"); second != 50*time.Minute {
+		t.Errorf("Expected 50m remaining after 10m, got %s", second)
+	}
+
+	if _, remaining, found := cache.GetWithTTL("missing"); found || remaining != 0 {
+		t.Errorf("Expected a miss with no TTL, got %s, %v", remaining, found)
+	}
+}
+
+func TestCacheJanitorRemovesExpired(t *testing.T) {
+	clk := newFakeClock()
+	cache := NewCacheWithCleanup(time.Minute, time.Millisecond)
+	defer cache.Close()
+	cache.SetClock(clk)
+
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	if n := cache.Len(); n != 2 {
+		t.Fatalf("Expected 2 entries, got %d", n)
+	}
+
+	// No Get: only the janitor can shrink the map
+	clk.Advance(2 * time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for cache.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the janitor to remove expired entries, %d left", cache.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCacheDeleteExpiredKeepsStaleEntries(t *testing.T) {
+	clk := newFakeClock()
+	cache := NewCacheWithCleanup(time.Minute, 0)
+	cache.SetClock(clk)
+	cache.SetStaleTTL(time.Minute)
+
+	cache.Set("old", 1)
+	clk.Advance(90 * time.Second)
+	cache.Set("fresh", 2)
+
+	if n := cache.deleteExpired(); n != 0 {
+		t.Errorf("Expected entries within the stale grace period to be kept, removed %d", n)
+	}
+	if _, found := cache.GetStale("old"); !found {
+		t.Error("Expected the stale entry to still be served")
+	}
+
+	clk.Advance(time.Minute + time.Second)
+	if n := cache.deleteExpired(); n != 1 {
+		t.Errorf("Expected 1 entry past the grace period to be removed, removed %d", n)
+	}
+	if _, found := cache.Get("fresh"); found {
+		t.Error("Expected fresh to have expired too")
+	}
+	if n := cache.Len(); n != 1 {
+		t.Errorf("Expected only fresh to remain, got %d entries", n)
+	}
+}
+
+func TestCacheCloseIsIdempotent(t *testing.T) {
+	cache := NewCacheWithCleanup(time.Minute, time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := cache.Close(); err != nil {
+			t.Fatalf("Close %d returned %v", i, err)
+		}
+	}
+
+	// Without a janitor Close returns immediately
+	if err := NewCacheWithCleanup(time.Minute, 0).Close(); err != nil {
+		t.Errorf("Expected Close without a janitor to succeed, got %v", err)
+	}
+
+	// The cache still works after Close
+	cache.Set("a", 1)
+	if _, found := cache.Get("a"); !found {
+		t.Error("Expected the cache to keep working after Close")
+	}
+}
+
+func TestCacheSetResetsExpiry(t *testing.T) {
+	clk := newFakeClock()
+	cache := NewCache(time.Hour)
+	cache.SetClock(clk)
+
+	cache.Set("a", 1)
+	clk.Advance(30 * time.Minute)
+	cache.Set("a", 2)
+
+	if value, remaining, _ := cache.GetWithTTL("a"); value != 2 || remaining != time.Hour {
+		t.Errorf("Expected the new value with a full hour remaining, got %v with %s", value, remaining)
+	}
+}
+
+func TestCacheSetWithTTL(t *testing.T) {
+	clk := newFakeClock()
+	cache := NewCache(time.Hour)
+	cache.SetClock(clk)
+
+	cache.SetWithTTL("a", 1, 5*time.Minute)
+	if _, remaining, _ := cache.GetWithTTL("a"); remaining != 5*time.Minute {
+		t.Errorf("Expected the explicit 5m TTL, got %s", remaining)
+	}
+
+	clk.Advance(6 * time.Minute)
+	if _, found := cache.Get("a"); found {
+		t.Error("Expected the entry to expire after its explicit TTL")
+	}
+}
+
+func TestCacheSetWithTTLUpdateWithoutExtend(t *testing.T) {
+	clk := newFakeClock()
+	cache := NewCache(time.Hour)
+	cache.SetClock(clk)
+
+	cache.Set("a", 1)
+	clk.Advance(40 * time.Minute)
+	cache.SetWithTTL("a", 2, 0)
+
+	value, remaining, found := cache.GetWithTTL("a")
+	if !found || value != 2 {
+		t.Fatalf("Expected the updated value, got %v, %v", value, found)
+	}
+	if remaining != 20*time.Minute {
+		t.Errorf("Expected the original expiry to be kept with 20m remaining, got %s", remaining)
+	}
+
+	// Without a live entry there's no expiry to keep
+	cache.SetWithTTL("b", 1, 0)
+	if _, remaining, _ := cache.GetWithTTL("b"); remaining != time.Hour {
+		t.Errorf("Expected a new entry to get the configured TTL, got %s", remaining)
+	}
+}
+
+func TestCacheTouch(t *testing.T) {
+	clk := newFakeClock()
+	cache := NewCache(time.Hour)
+	cache.SetClock(clk)
+	cache.SetStaleTTL(time.Hour)
+
+	cache.Set("a", 1)
+	clk.Advance(50 * time.Minute)
+	if !cache.Touch("a") {
+		t.Fatal("Expected Touch to find the live entry")
+	}
+	if value, remaining, _ := cache.GetWithTTL("a"); value != 1 || remaining != time.Hour {
+		t.Errorf("Expected Touch to extend the entry to a full hour, got %v with %s", value, remaining)
+	}
+
+	if cache.Touch("missing") {
+		t.Error("Expected Touch to miss an unknown key")
+	}
+
+	clk.Advance(2 * time.Hour)
+	if cache.Touch("a") {
+		t.Error("Expected Touch not to revive an expired entry")
+	}
+	if _, found := cache.Get("a"); found {
+		t.Error("Expected the expired entry to stay expired")
+	}
+}
+
+func TestCacheSetDefaultTTL(t *testing.T) {
+	clk := newFakeClock()
+	cache := NewCache(time.Hour)
+	cache.SetClock(clk)
+
+	cache.Set("old", 1)
+	if previous := cache.SetDefaultTTL(5 * time.Minute); previous != time.Hour {
+		t.Errorf("Expected the previous TTL of 1h, got %s", previous)
+	}
+	cache.Set("new", 2)
+
+	if _, remaining, _ := cache.GetWithTTL("old"); remaining != time.Hour {
+		t.Errorf("Expected the existing entry to keep its 1h expiry, got %s", remaining)
+	}
+	if _, remaining, _ := cache.GetWithTTL("new"); remaining != 5*time.Minute {
+		t.Errorf("Expected the new entry to get the 5m TTL, got %s", remaining)
+	}
+
+	clk.Advance(6 * time.Minute)
+	if _, found := cache.Get("new"); found {
+		t.Error("Expected the new entry to expire after the new TTL")
+	}
+	if _, found := cache.Get("old"); !found {
+		t.Error("Expected the existing entry to outlive the new TTL")
+	}
+}
+
+func TestCacheOnRemove(t *testing.T) {
+	clk := newFakeClock()
+	cache := NewCacheWithCleanup(time.Minute, 0)
+	cache.SetClock(clk)
+
+	var removals []Removal
+	cache.SetOnRemove(func(r Removal) { removals = append(removals, r) })
+
+	cache.Set("read", 1)
+	cache.Set("unread", 2)
+	clk.Advance(20 * time.Second)
+	cache.Get("read")
+	clk.Advance(50 * time.Second)
+
+	// Reading an expired entry misses without removing it or counting as
+	// a read
+	cache.Get("read")
+	if len(removals) != 0 {
+		t.Fatalf("Expected no removals before cleanup, got %+v", removals)
+	}
+
+	if n := cache.deleteExpired(); n != 2 {
+		t.Fatalf("Expected 2 expired entries, got %d", n)
+	}
+	got := make(map[string]Removal)
+	for _, r := range removals {
+		got[r.Key] = r
+	}
+	want := map[string]Removal{
+		"read":   {Key: "read", Reason: RemovalExpired, Age: 70 * time.Second, Idle: 50 * time.Second},
+		"unread": {Key: "unread", Reason: RemovalExpired, Age: 70 * time.Second, Idle: 70 * time.Second},
+	}
+	for key, w := range want {
+		if got[key] != w {
+			t.Errorf("Expected %+v, got %+v", w, got[key])
+		}
+	}
+
+	// Replacing an expired entry reports it; replacing a live one doesn't
+	removals = nil
+	cache.Set("a", 1)
+	cache.Set("a", 2)
+	clk.Advance(2 * time.Minute)
+	cache.Set("a", 3)
+	if len(removals) != 1 || removals[0].Reason != RemovalExpired || removals[0].Age != 2*time.Minute {
+		t.Errorf("Expected one expired removal of the replaced entry, got %+v", removals)
+	}
+
+	removals = nil
+	cache.Set("b", 1)
+	clk.Advance(time.Second)
+	cache.Delete("a")
+	cache.Clear()
+	if len(removals) != 2 || removals[0].Reason != RemovalEvicted || removals[1].Reason != RemovalEvicted {
+		t.Errorf("Expected Delete and Clear to report evictions, got %+v", removals)
+	}
+}
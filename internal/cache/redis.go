@@ -0,0 +1,259 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisOpTimeout bounds every Redis round trip so a slow Redis degrades
+// into cache misses instead of stalling requests.
+const redisOpTimeout = 500 * time.Millisecond
+
+// RedisCache stores JSON-encoded values in Redis so the cache is shared
+// between replicas. Keys are namespaced with prefix.
+type RedisCache struct {
+	client   *redis.Client
+	ttl      atomic.Int64 // nanoseconds, changed by SetDefaultTTL
+	staleTTL time.Duration
+	jitter   float64
+	prefix   string
+	newValue func() interface{}
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewRedisCache returns a Redis-backed cache. newValue must return a pointer
+// to a fresh value of the type stored in the cache; Get decodes into it.
+func NewRedisCache(client *redis.Client, ttl time.Duration, prefix string, newValue func() interface{}) *RedisCache {
+	c := &RedisCache{
+		client:   client,
+		prefix:   prefix,
+		newValue: newValue,
+	}
+	c.ttl.Store(int64(ttl))
+	return c
+}
+
+// redisEntry is the stored form of a value. Keys live in Redis for the TTL
+// plus the stale grace period, so the logical expiry is kept alongside.
+type redisEntry struct {
+	ExpiresAt int64           `json:"expiresAt"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// SetStaleTTL keeps expired entries available to GetStale for d. Call it
+// before the cache is shared between goroutines.
+func (c *RedisCache) SetStaleTTL(d time.Duration) {
+	c.staleTTL = d
+}
+
+// SetJitter spreads each entry's TTL by up to fraction of it either way,
+// as for MemoryCache.SetJitter.
+func (c *RedisCache) SetJitter(fraction float64) {
+	c.jitter = fraction
+}
+
+// Close closes the Redis client. Later calls return the first call's
+// result rather than the client's "already closed" error.
+func (c *RedisCache) Close() error {
+	c.closeOnce.Do(func() { c.closeErr = c.client.Close() })
+	return c.closeErr
+}
+
+// Ping checks that Redis is reachable.
+func (c *RedisCache) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+func (c *RedisCache) Set(key string, value interface{}) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	c.store(ctx, key, raw, time.Now().Add(jitteredTTL(c.defaultTTL(), c.jitter)))
+}
+
+// SetDefaultTTL is MemoryCache.SetDefaultTTL for Redis. The TTL belongs
+// to this client, so other replicas sharing the keys keep their own.
+func (c *RedisCache) SetDefaultTTL(ttl time.Duration) time.Duration {
+	return time.Duration(c.ttl.Swap(int64(ttl)))
+}
+
+func (c *RedisCache) defaultTTL() time.Duration {
+	return time.Duration(c.ttl.Load())
+}
+
+// SetWithTTL is MemoryCache.SetWithTTL for Redis. Keeping the expiry of a
+// live entry takes a read and a write, so a concurrent Set from another
+// replica in between can be overwritten.
+func (c *RedisCache) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	if ttl <= 0 {
+		expiresAt = now.Add(jitteredTTL(c.defaultTTL(), c.jitter))
+		if entry, ok := c.loadEntry(ctx, key); ok && now.UnixNano() <= entry.ExpiresAt {
+			expiresAt = time.Unix(0, entry.ExpiresAt)
+		}
+	}
+	c.store(ctx, key, raw, expiresAt)
+}
+
+// Touch is MemoryCache.Touch for Redis.
+func (c *RedisCache) Touch(key string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	entry, ok := c.loadEntry(ctx, key)
+	if !ok || time.Now().UnixNano() > entry.ExpiresAt {
+		return false
+	}
+	return c.store(ctx, key, entry.Value, time.Now().Add(jitteredTTL(c.defaultTTL(), c.jitter)))
+}
+
+// store writes an encoded value that logically expires at expiresAt. The
+// Redis key outlives it by the stale grace period. It reports whether the
+// write succeeded.
+func (c *RedisCache) store(ctx context.Context, key string, raw json.RawMessage, expiresAt time.Time) bool {
+	data, err := json.Marshal(redisEntry{
+		ExpiresAt: expiresAt.UnixNano(),
+		Value:     raw,
+	})
+	if err != nil {
+		return false
+	}
+	return c.client.Set(ctx, c.prefix+key, data, time.Until(expiresAt)+c.staleTTL).Err() == nil
+}
+
+func (c *RedisCache) Get(key string) (interface{}, bool) {
+	value, _, found := c.GetWithTTL(key)
+	return value, found
+}
+
+func (c *RedisCache) GetWithTTL(key string) (interface{}, time.Duration, bool) {
+	value, remaining, ok := c.load(key)
+	if !ok || remaining < 0 {
+		c.misses.Add(1)
+		return nil, 0, false
+	}
+
+	c.hits.Add(1)
+	return value, remaining, true
+}
+
+func (c *RedisCache) GetStale(key string) (interface{}, bool) {
+	value, remaining, ok := c.load(key)
+	if !ok || remaining >= 0 {
+		return nil, false
+	}
+	return value, true
+}
+
+// load fetches and decodes key along with the time left until its logical
+// expiry, which is negative once it is past its TTL.
+func (c *RedisCache) load(key string) (value interface{}, remaining time.Duration, ok bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	entry, ok := c.loadEntry(ctx, key)
+	if !ok {
+		return nil, 0, false
+	}
+	value = c.newValue()
+	if err := json.Unmarshal(entry.Value, value); err != nil {
+		return nil, 0, false
+	}
+
+	return value, time.Until(time.Unix(0, entry.ExpiresAt)), true
+}
+
+// loadEntry fetches the stored form of key without decoding its value.
+func (c *RedisCache) loadEntry(ctx context.Context, key string) (redisEntry, bool) {
+	var entry redisEntry
+	data, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, false
+	}
+	return entry, true
+}
+
+func (c *RedisCache) Delete(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	c.client.Del(ctx, c.prefix+key)
+}
+
+// Clear deletes the keys under prefix and returns how many were removed.
+// Other data in the same Redis database is left alone.
+func (c *RedisCache) Clear() int {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	var keys []string
+	iter := c.client.Scan(ctx, 0, c.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if len(keys) == 0 {
+		return 0
+	}
+
+	n, err := c.client.Del(ctx, keys...).Result()
+	if err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// Len is a best-effort count of the keys under prefix. Redis removes
+// expired keys itself, so they are not included.
+func (c *RedisCache) Len() int {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	size := 0
+	iter := c.client.Scan(ctx, 0, c.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		size++
+	}
+	return size
+}
+
+// Counts returns the hits and misses seen by this replica.
+func (c *RedisCache) Counts() (hits, misses uint64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// Stats reports the hits and misses seen by this replica and the current
+// Len; evictions and expirations are handled by Redis and not tracked.
+func (c *RedisCache) Stats() Stats {
+	return Stats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Size:   c.Len(),
+	}
+}
@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type testValue struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func newTestRedisCache(t *testing.T, ttl time.Duration) (*RedisCache, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return NewRedisCache(client, ttl, "test:", func() interface{} { return &testValue{} }), mr
+}
+
+func TestRedisCacheSetAndGet(t *testing.T) {
+	cache, _ := newTestRedisCache(t, time.Hour)
+
+	cache.Set("key", &testValue{Name: "a", Count: 3})
+
+	value, found := cache.Get("key")
+	if !found {
+		t.Fatal("Expected to find key")
+	}
+	got, ok := value.(*testValue)
+	if !ok {
+		t.Fatalf("Expected *testValue, got %T", value)
+	}
+	if got.Name != "a" || got.Count != 3 {
+		t.Errorf("Unexpected value %+v", got)
+	}
+}
+
+func TestRedisCacheExpiration(t *testing.T) {
+	cache, mr := newTestRedisCache(t, time.Minute)
+
+	cache.Set("key", &testValue{Name: "a"})
+	mr.FastForward(2 * time.Minute)
+
+	if _, found := cache.Get("key"); found {
+		t.Error("Expected key to be expired")
+	}
+}
+
+func TestRedisCacheDelete(t *testing.T) {
+	cache, mr := newTestRedisCache(t, time.Hour)
+
+	cache.Set("key", &testValue{Name: "a"})
+	if !mr.Exists("test:key") {
+		t.Fatal("Expected key to be stored under prefix")
+	}
+
+	cache.Delete("key")
+	if _, found := cache.Get("key"); found {
+		t.Error("Expected key to be deleted")
+	}
+}
+
+func TestRedisCacheClear(t *testing.T) {
+	cache, mr := newTestRedisCache(t, time.Hour)
+
+	cache.Set("a", &testValue{Name: "a"})
+	cache.Set("b", &testValue{Name: "b"})
+	mr.Set("other:key", "untouched")
+
+	if n := cache.Clear(); n != 2 {
+		t.Errorf("Expected 2 entries cleared, got %d", n)
+	}
+	if _, found := cache.Get("a"); found {
+		t.Error("Expected key to miss after Clear")
+	}
+	if !mr.Exists("other:key") {
+		t.Error("Expected keys outside the prefix to survive Clear")
+	}
+}
+
+func TestRedisCacheGetStale(t *testing.T) {
+	cache, mr := newTestRedisCache(t, time.Minute)
+	cache.SetStaleTTL(time.Minute)
+
+	cache.Set("key", &testValue{Name: "a"})
+	if _, found := cache.GetStale("key"); found {
+		t.Error("Expected fresh entry not to be returned as stale")
+	}
+
+	// Logical expiry is tracked in the entry; miniredis only moves its own
+	// clock, so rewrite the stored expiry to simulate time passing.
+	cache.SetDefaultTTL(-time.Second)
+	cache.Set("key", &testValue{Name: "a"})
+
+	if _, found := cache.Get("key"); found {
+		t.Error("Expected Get to miss past the TTL")
+	}
+	value, found := cache.GetStale("key")
+	if !found || value.(*testValue).Name != "a" {
+		t.Errorf("Expected stale entry within the grace period, got %v, %v", value, found)
+	}
+
+	mr.FastForward(2 * time.Minute)
+	if _, found := cache.GetStale("key"); found {
+		t.Error("Expected Redis to drop the entry after the grace period")
+	}
+}
+
+func TestRedisCacheGetWithTTL(t *testing.T) {
+	cache, _ := newTestRedisCache(t, time.Hour)
+	cache.Set("key", &testValue{Name: "a"})
+
+	_, first, found := cache.GetWithTTL("key")
+	if !found || first <= 0 || first > time.Hour {
+		t.Fatalf("Expected remaining TTL within (0, 1h], got %s, %v", first, found)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	_, second, _ := cache.GetWithTTL("key")
+	if second >= first {
+		t.Errorf("Expected remaining TTL to decrease, got %s then %s", first, second)
+	}
+}
+
+func TestRedisCacheCloseIsIdempotent(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cache := NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}), time.Hour, "test:", func() interface{} { return &testValue{} })
+
+	for i := 0; i < 3; i++ {
+		if err := cache.Close(); err != nil {
+			t.Fatalf("Close %d returned %v", i, err)
+		}
+	}
+}
+
+func TestRedisCacheSetWithTTL(t *testing.T) {
+	cache, mr := newTestRedisCache(t, time.Hour)
+
+	cache.SetWithTTL("key", &testValue{Name: "a"}, time.Minute)
+	_, remaining, found := cache.GetWithTTL("key")
+	if !found || remaining <= 0 || remaining > time.Minute {
+		t.Fatalf("Expected the explicit TTL of at most 1m, got %s, %v", remaining, found)
+	}
+
+	mr.FastForward(2 * time.Minute)
+	if _, found := cache.Get("key"); found {
+		t.Error("Expected Redis to drop the entry after its explicit TTL")
+	}
+}
+
+func TestRedisCacheSetWithTTLUpdateWithoutExtend(t *testing.T) {
+	cache, _ := newTestRedisCache(t, time.Hour)
+
+	cache.SetWithTTL("key", &testValue{Name: "a"}, time.Minute)
+	cache.SetWithTTL("key", &testValue{Name: "b"}, 0)
+
+	value, remaining, found := cache.GetWithTTL("key")
+	if !found || value.(*testValue).Name != "b" {
+		t.Fatalf("Expected the updated value, got %v, %v", value, found)
+	}
+	if remaining > time.Minute {
+		t.Errorf("Expected the original expiry to be kept, got %s remaining", remaining)
+	}
+}
+
+func TestRedisCacheTouch(t *testing.T) {
+	cache, _ := newTestRedisCache(t, time.Hour)
+
+	cache.SetWithTTL("key", &testValue{Name: "a"}, time.Minute)
+	if !cache.Touch("key") {
+		t.Fatal("Expected Touch to find the live entry")
+	}
+	value, remaining, found := cache.GetWithTTL("key")
+	if !found || value.(*testValue).Name != "a" || remaining <= time.Minute {
+		t.Errorf("Expected Touch to extend the entry to the configured hour, got %v with %s", value, remaining)
+	}
+
+	if cache.Touch("missing") {
+		t.Error("Expected Touch to miss an unknown key")
+	}
+}
+
+func TestRedisCacheSetDefaultTTL(t *testing.T) {
+	cache, _ := newTestRedisCache(t, time.Hour)
+
+	cache.Set("old", &testValue{Name: "a"})
+	if previous := cache.SetDefaultTTL(time.Minute); previous != time.Hour {
+		t.Errorf("Expected the previous TTL of 1h, got %s", previous)
+	}
+	cache.Set("new", &testValue{Name: "b"})
+
+	if _, remaining, _ := cache.GetWithTTL("old"); remaining <= time.Minute {
+		t.Errorf("Expected the existing entry to keep its 1h expiry, got %s", remaining)
+	}
+	if _, remaining, _ := cache.GetWithTTL("new"); remaining <= 0 || remaining > time.Minute {
+		t.Errorf("Expected the new entry to get the 1m TTL, got %s", remaining)
+	}
+}
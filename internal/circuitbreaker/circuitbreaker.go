@@ -4,11 +4,19 @@ import (
 	"errors"
 	"sync"
 	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/clock"
 )
 
 var (
 	ErrCircuitBreakerOpen = errors.New("circuit breaker is open")
 	ErrCircuitBreakerHalfOpen = errors.New("circuit breaker is half-open")
+	// ErrNoOutcome can be returned, or wrapped, by a function passed to
+	// Call whose result says nothing about the protected service, e.g.
+	// because its caller gave up. It is recorded as neither a success nor
+	// a failure, and a half-open probe that returns it frees the slot for
+	// the next caller.
+	ErrNoOutcome = errors.New("call has no outcome")
 )
 
 type State int
@@ -32,14 +40,33 @@ func (s State) String() string {
 	}
 }
 
+type transition struct {
+	from, to State
+}
+
 type CircuitBreaker struct {
+	// OnStateChange, if set, is called after every state transition made by
+	// Call. It runs outside the breaker's lock, so it may call back into the
+	// breaker. Set it before the breaker is shared between goroutines.
+	OnStateChange func(from, to State)
+
 	failureThreshold   int
 	successThreshold   int
 	timeout            time.Duration
+	// backoffMax caps openTimeout when backoff is enabled; zero keeps the
+	// open duration fixed at timeout.
+	backoffMax         time.Duration
+	// openTimeout is how long the breaker stays open this time. It doubles
+	// each time a half-open probe fails and returns to timeout on close.
+	openTimeout        time.Duration
 	failureCount       int
 	successCount       int
 	lastFailureTime    time.Time
 	state              State
+	probing            bool
+	generation         uint64 // incremented on every state change
+	transitions        []transition
+	clock              clock.Clock
 	mu                 sync.Mutex
 }
 
@@ -48,60 +75,150 @@ func NewCircuitBreaker(failureThreshold, successThreshold int, timeout time.Dura
 		failureThreshold: failureThreshold,
 		successThreshold: successThreshold,
 		timeout:          timeout,
+		openTimeout:      timeout,
 		state:            StateClosed,
+		clock:            clock.Real,
 	}
 }
 
+// SetClock makes the breaker read the time from clk rather than the system
+// clock. Call it before the breaker is shared between goroutines.
+func (cb *CircuitBreaker) SetClock(clk clock.Clock) {
+	cb.clock = clk
+}
+
+// SetBackoffMax enables exponential backoff of the open duration: each time
+// a half-open probe fails the breaker stays open twice as long as the last
+// time, up to max, and it goes back to the configured timeout once the
+// breaker closes. A max no greater than the timeout disables backoff. Call
+// it before the breaker is shared between goroutines.
+func (cb *CircuitBreaker) SetBackoffMax(max time.Duration) {
+	cb.backoffMax = max
+}
+
+// Call runs fn if the breaker allows it and records the outcome. The lock
+// is only held to check and update state, so calls run concurrently while
+// the breaker is closed.
 func (cb *CircuitBreaker) Call(fn func() error) error {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
 
 	// Check if we should transition from Open to Half-Open
-	if cb.state == StateOpen && time.Since(cb.lastFailureTime) > cb.timeout {
-		cb.state = StateHalfOpen
+	if cb.state == StateOpen && cb.clock.Now().Sub(cb.lastFailureTime) > cb.openTimeout {
+		cb.setState(StateHalfOpen)
 		cb.failureCount = 0
 		cb.successCount = 0
 	}
 
 	switch cb.state {
 	case StateOpen:
+		cb.unlockAndNotify()
 		return ErrCircuitBreakerOpen
 	case StateHalfOpen:
-		err := fn()
+		// Only one probe at a time; everyone else is turned away until it
+		// resolves so a struggling upstream isn't flooded.
+		if cb.probing {
+			cb.unlockAndNotify()
+			return ErrCircuitBreakerOpen
+		}
+		cb.probing = true
+	}
+	generation := cb.generation
+	cb.unlockAndNotify()
+
+	err := fn()
+
+	cb.mu.Lock()
+	// Outcomes of calls that started in an earlier state say nothing about
+	// the current one, e.g. a slow call admitted while closed finishing
+	// after the breaker has moved to half-open.
+	if cb.generation == generation {
+		cb.probing = false
+		if !errors.Is(err, ErrNoOutcome) {
+			cb.record(err)
+		}
+	}
+	cb.unlockAndNotify()
+
+	return err
+}
+
+// Reset forces the breaker closed and clears its counters, e.g. once an
+// operator knows the upstream has recovered. It is safe to call while other
+// goroutines are inside Call; the outcomes of calls that started before the
+// reset are ignored.
+func (cb *CircuitBreaker) Reset() {
+	cb.mu.Lock()
+	cb.setState(StateClosed)
+	cb.generation++
+	cb.probing = false
+	cb.failureCount = 0
+	cb.successCount = 0
+	cb.openTimeout = cb.timeout
+	cb.unlockAndNotify()
+}
+
+// unlockAndNotify releases cb.mu and then reports any transitions recorded
+// while it was held.
+func (cb *CircuitBreaker) unlockAndNotify() {
+	transitions := cb.transitions
+	cb.transitions = nil
+	cb.mu.Unlock()
+
+	if cb.OnStateChange != nil {
+		for _, t := range transitions {
+			cb.OnStateChange(t.from, t.to)
+		}
+	}
+}
+
+// record updates the counters with the outcome of a call made in the
+// current state. cb.mu must be held.
+func (cb *CircuitBreaker) record(err error) {
+	switch cb.state {
+	case StateHalfOpen:
 		if err != nil {
 			cb.failureCount++
 			if cb.failureCount >= cb.failureThreshold {
-				cb.state = StateOpen
-				cb.lastFailureTime = time.Now()
+				// The upstream is still failing; back off further
+				if cb.backoffMax > cb.timeout {
+					cb.openTimeout = min(cb.openTimeout*2, cb.backoffMax)
+				}
+				cb.setState(StateOpen)
+				cb.lastFailureTime = cb.clock.Now()
 			}
-			return err
 		} else {
 			cb.successCount++
 			if cb.successCount >= cb.successThreshold {
-				cb.state = StateClosed
+				cb.setState(StateClosed)
 				cb.failureCount = 0
 				cb.successCount = 0
+				cb.openTimeout = cb.timeout
 			}
-			return nil
 		}
 	case StateClosed:
-		err := fn()
 		if err != nil {
 			cb.failureCount++
 			cb.successCount = 0
 			if cb.failureCount >= cb.failureThreshold {
-				cb.state = StateOpen
-				cb.lastFailureTime = time.Now()
+				cb.setState(StateOpen)
+				cb.lastFailureTime = cb.clock.Now()
 			}
-			return err
 		} else {
 			cb.failureCount = 0
 			cb.successCount = 0
-			return nil
 		}
 	}
+}
 
-	return nil
+// setState records a transition for OnStateChange. cb.mu must be held.
+func (cb *CircuitBreaker) setState(to State) {
+	if cb.state == to {
+		return
+	}
+	cb.generation++
+	cb.probing = false
+	cb.transitions = append(cb.transitions, transition{from: cb.state, to: to})
+	cb.state = to
 }
 
 func (cb *CircuitBreaker) GetState() State {
@@ -114,4 +231,42 @@ func (cb *CircuitBreaker) GetMetrics() (state State, failureCount, successCount
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 	return cb.state, cb.failureCount, cb.successCount
-}
\ No newline at end of file
+}
+
+// Snapshot is a point-in-time copy of a breaker's state and counters.
+type Snapshot struct {
+	State        State
+	FailureCount int
+	SuccessCount int
+	// LastFailureTime is when the breaker last opened; zero if it never has.
+	LastFailureTime time.Time
+	// Timeout is how long the breaker stays open, including any backoff.
+	Timeout time.Duration
+	// At is when the snapshot was taken, by the breaker's clock.
+	At time.Time
+}
+
+// Snapshot returns the breaker's current state along with the details
+// GetMetrics leaves out.
+func (cb *CircuitBreaker) Snapshot() Snapshot {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return Snapshot{
+		State:           cb.state,
+		FailureCount:    cb.failureCount,
+		SuccessCount:    cb.successCount,
+		LastFailureTime: cb.lastFailureTime,
+		Timeout:         cb.openTimeout,
+		At:              cb.clock.Now(),
+	}
+}
+
+// HalfOpenAt returns when an open breaker will let a probe call through,
+// or the zero time if it isn't open. The transition itself happens lazily
+// on the first call after this time.
+func (s Snapshot) HalfOpenAt() time.Time {
+	if s.State != StateOpen {
+		return time.Time{}
+	}
+	return s.LastFailureTime.Add(s.Timeout)
+}
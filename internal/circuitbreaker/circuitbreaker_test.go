@@ -7,6 +7,28 @@ import (
 	"time"
 )
 
+// fakeClock is a clock that only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
 func TestCircuitBreakerClosedState(t *testing.T) {
 	cb := NewCircuitBreaker(3, 5, 30*time.Second)
 
@@ -40,7 +62,9 @@ func TestCircuitBreakerOpenState(t *testing.T) {
 }
 
 func TestCircuitBreakerHalfOpenState(t *testing.T) {
+	clk := newFakeClock()
 	cb := NewCircuitBreaker(2, 1, 100*time.Millisecond) // Use 1 for success threshold to close quickly
+	cb.SetClock(clk)
 
 	// Open the circuit
 	for i := 0; i < 2; i++ {
@@ -51,8 +75,14 @@ func TestCircuitBreakerHalfOpenState(t *testing.T) {
 		t.Error("Expected circuit breaker to be open")
 	}
 
-	// Wait for timeout to transition to Half-Open
-	time.Sleep(150 * time.Millisecond)
+	// Still open until the timeout has passed
+	clk.Advance(100 * time.Millisecond)
+	if err := cb.Call(func() error { return nil }); err != ErrCircuitBreakerOpen {
+		t.Errorf("Expected circuit breaker to stay open until the timeout passes, got %v", err)
+	}
+
+	// Past the timeout it moves to Half-Open
+	clk.Advance(time.Millisecond)
 
 	// Should allow one call in half-open state and close immediately (since successThreshold=1)
 	err := cb.Call(func() error { return nil })
@@ -65,8 +95,39 @@ func TestCircuitBreakerHalfOpenState(t *testing.T) {
 	}
 }
 
+func TestCircuitBreakerNoOutcome(t *testing.T) {
+	clk := newFakeClock()
+	cb := NewCircuitBreaker(2, 1, 100*time.Millisecond)
+	cb.SetClock(clk)
+
+	// A call without an outcome doesn't clear earlier failures
+	cb.Call(func() error { return errors.New("test error") })
+	if err := cb.Call(func() error { return ErrNoOutcome }); !errors.Is(err, ErrNoOutcome) {
+		t.Errorf("Expected the call's own error, got %v", err)
+	}
+	cb.Call(func() error { return errors.New("test error") })
+	if cb.GetState() != StateOpen {
+		t.Fatalf("Expected circuit breaker to open, got %s", cb.GetState())
+	}
+
+	// Nor does it close a half-open breaker, and it frees the probe slot
+	clk.Advance(150 * time.Millisecond)
+	cb.Call(func() error { return ErrNoOutcome })
+	if state, _, successes := cb.GetMetrics(); state != StateHalfOpen || successes != 0 {
+		t.Errorf("Expected half-open with no successes, got %s with %d", state, successes)
+	}
+	if err := cb.Call(func() error { return nil }); err != nil {
+		t.Errorf("Expected the next probe to be let through, got %v", err)
+	}
+	if cb.GetState() != StateClosed {
+		t.Errorf("Expected circuit breaker to close, got %s", cb.GetState())
+	}
+}
+
 func TestCircuitBreakerHalfOpenToOpen(t *testing.T) {
+	clk := newFakeClock()
 	cb := NewCircuitBreaker(1, 5, 100*time.Millisecond)
+	cb.SetClock(clk)
 
 	// Open the circuit
 	cb.Call(func() error { return errors.New("test error") })
@@ -75,8 +136,7 @@ func TestCircuitBreakerHalfOpenToOpen(t *testing.T) {
 		t.Error("Expected circuit breaker to be open")
 	}
 
-	// Wait for timeout
-	time.Sleep(150 * time.Millisecond)
+	clk.Advance(150 * time.Millisecond)
 
 	// Should fail and go back to open
 	err := cb.Call(func() error { return errors.New("test error") })
@@ -130,4 +190,257 @@ func TestCircuitBreakerConcurrentAccess(t *testing.T) {
 	if state != StateClosed && state != StateOpen {
 		t.Errorf("Expected circuit breaker to be in a valid state, got %s", state)
 	}
-}
\ No newline at end of file
+}
+func TestCircuitBreakerOnStateChange(t *testing.T) {
+	clk := newFakeClock()
+	cb := NewCircuitBreaker(1, 1, 100*time.Millisecond)
+	cb.SetClock(clk)
+
+	var transitions []string
+	cb.OnStateChange = func(from, to State) {
+		transitions = append(transitions, from.String()+"->"+to.String())
+	}
+
+	// Successes while closed are no-op transitions
+	cb.Call(func() error { return nil })
+	if len(transitions) != 0 {
+		t.Fatalf("Expected no transitions for no-op calls, got %v", transitions)
+	}
+
+	cb.Call(func() error { return errors.New("test error") })
+
+	// Rejected calls while open don't change state either
+	cb.Call(func() error { return nil })
+
+	clk.Advance(150 * time.Millisecond)
+	cb.Call(func() error { return nil })
+
+	expected := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(expected) {
+		t.Fatalf("Expected transitions %v, got %v", expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Errorf("Expected transition %d to be %s, got %s", i, expected[i], transitions[i])
+		}
+	}
+}
+
+func TestCircuitBreakerReset(t *testing.T) {
+	cb := NewCircuitBreaker(1, 5, 30*time.Second)
+
+	var transitions []string
+	cb.OnStateChange = func(from, to State) {
+		transitions = append(transitions, from.String()+"->"+to.String())
+	}
+
+	cb.Call(func() error { return errors.New("test error") })
+	if cb.GetState() != StateOpen {
+		t.Fatal("Expected circuit breaker to be open")
+	}
+
+	cb.Reset()
+
+	state, failures, successes := cb.GetMetrics()
+	if state != StateClosed || failures != 0 || successes != 0 {
+		t.Errorf("Expected closed breaker with zeroed counters, got %s (%d failures, %d successes)", state, failures, successes)
+	}
+
+	if err := cb.Call(func() error { return nil }); err != nil {
+		t.Errorf("Expected calls to be allowed after reset, got %v", err)
+	}
+
+	// Resetting an already-closed breaker is a no-op transition
+	cb.Reset()
+	if len(transitions) != 2 || transitions[1] != "open->closed" {
+		t.Errorf("Expected transitions [closed->open open->closed], got %v", transitions)
+	}
+}
+
+func TestCircuitBreakerSingleHalfOpenProbe(t *testing.T) {
+	clk := newFakeClock()
+	cb := NewCircuitBreaker(1, 1, 50*time.Millisecond)
+	cb.SetClock(clk)
+
+	cb.Call(func() error { return errors.New("test error") })
+	clk.Advance(100 * time.Millisecond)
+
+	// Hold the probe open while other callers arrive
+	release := make(chan struct{})
+	probeStarted := make(chan struct{})
+	probeDone := make(chan error)
+	go func() {
+		probeDone <- cb.Call(func() error {
+			close(probeStarted)
+			<-release
+			return nil
+		})
+	}()
+	<-probeStarted
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	rejected, called := 0, 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := cb.Call(func() error {
+				mu.Lock()
+				called++
+				mu.Unlock()
+				return nil
+			})
+			if err == ErrCircuitBreakerOpen {
+				mu.Lock()
+				rejected++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if called != 0 || rejected != 10 {
+		t.Errorf("Expected all concurrent calls to be rejected during the probe, got %d called and %d rejected", called, rejected)
+	}
+
+	close(release)
+	if err := <-probeDone; err != nil {
+		t.Fatalf("Expected probe to succeed, got %v", err)
+	}
+	if cb.GetState() != StateClosed {
+		t.Errorf("Expected breaker to close after the probe, got %s", cb.GetState())
+	}
+}
+
+func TestCircuitBreakerRunsCallsConcurrently(t *testing.T) {
+	cb := NewCircuitBreaker(5, 10, 30*time.Second)
+
+	const calls = 10
+	const delay = 100 * time.Millisecond
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cb.Call(func() error {
+				time.Sleep(delay)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	// Serialized calls would take calls*delay
+	if elapsed := time.Since(start); elapsed > 3*delay {
+		t.Errorf("Expected slow calls to run in parallel, took %s", elapsed)
+	}
+}
+
+func TestCircuitBreakerIgnoresStaleOutcomes(t *testing.T) {
+	cb := NewCircuitBreaker(1, 5, 30*time.Second)
+
+	// A slow call admitted while closed fails after a reset
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		cb.Call(func() error {
+			close(started)
+			<-release
+			return errors.New("test error")
+		})
+		close(done)
+	}()
+	<-started
+
+	cb.Reset()
+	close(release)
+	<-done
+
+	if cb.GetState() != StateClosed {
+		t.Errorf("Expected failure from before the reset to be ignored, got %s", cb.GetState())
+	}
+}
+
+func TestCircuitBreakerSnapshot(t *testing.T) {
+	cb := NewCircuitBreaker(2, 1, 30*time.Second)
+
+	snapshot := cb.Snapshot()
+	if snapshot.State != StateClosed || !snapshot.LastFailureTime.IsZero() || snapshot.Timeout != 30*time.Second {
+		t.Errorf("Expected a fresh closed breaker, got %+v", snapshot)
+	}
+	if !snapshot.HalfOpenAt().IsZero() {
+		t.Error("Expected no half-open time while closed")
+	}
+
+	cb.Call(func() error { return errors.New("test error") })
+	if snapshot := cb.Snapshot(); snapshot.FailureCount != 1 {
+		t.Errorf("Expected 1 failure, got %d", snapshot.FailureCount)
+	}
+
+	before := time.Now()
+	cb.Call(func() error { return errors.New("test error") })
+	snapshot = cb.Snapshot()
+	if snapshot.State != StateOpen || snapshot.LastFailureTime.Before(before) {
+		t.Fatalf("Expected an open breaker with a recent failure time, got %+v", snapshot)
+	}
+	if want := snapshot.LastFailureTime.Add(30 * time.Second); !snapshot.HalfOpenAt().Equal(want) {
+		t.Errorf("Expected half-open at %s, got %s", want, snapshot.HalfOpenAt())
+	}
+}
+
+func TestCircuitBreakerBackoff(t *testing.T) {
+	clk := newFakeClock()
+	cb := NewCircuitBreaker(1, 1, 100*time.Millisecond)
+	cb.SetClock(clk)
+	cb.SetBackoffMax(350 * time.Millisecond)
+	fail := func() error { return errors.New("test error") }
+
+	cb.Call(fail)
+
+	// Each failed probe keeps the breaker open twice as long, up to the max
+	for _, wait := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 350 * time.Millisecond, 350 * time.Millisecond} {
+		if got := cb.Snapshot().Timeout; got != wait {
+			t.Fatalf("expected to stay open for %s, got %s", wait, got)
+		}
+		clk.Advance(wait - 10*time.Millisecond)
+		if err := cb.Call(fail); !errors.Is(err, ErrCircuitBreakerOpen) {
+			t.Fatalf("expected the breaker to still be open before %s, got %v", wait, err)
+		}
+		clk.Advance(20 * time.Millisecond)
+		if err := cb.Call(fail); errors.Is(err, ErrCircuitBreakerOpen) {
+			t.Fatalf("expected a probe after %s", wait)
+		}
+	}
+
+	// Closing resets the backoff
+	clk.Advance(400 * time.Millisecond)
+	if err := cb.Call(func() error { return nil }); err != nil || cb.GetState() != StateClosed {
+		t.Fatalf("expected the probe to close the breaker, got %v in state %s", err, cb.GetState())
+	}
+	cb.Call(fail)
+	if got := cb.Snapshot().Timeout; got != 100*time.Millisecond {
+		t.Errorf("expected the timeout to be back to 100ms after closing, got %s", got)
+	}
+}
+
+func TestCircuitBreakerFixedTimeoutWithoutBackoff(t *testing.T) {
+	clk := newFakeClock()
+	cb := NewCircuitBreaker(1, 1, 100*time.Millisecond)
+	cb.SetClock(clk)
+	fail := func() error { return errors.New("test error") }
+
+	cb.Call(fail)
+	for i := 0; i < 3; i++ {
+		clk.Advance(110 * time.Millisecond)
+		if err := cb.Call(fail); errors.Is(err, ErrCircuitBreakerOpen) {
+			t.Fatalf("open %d: expected a probe after the fixed timeout", i)
+		}
+		if got := cb.Snapshot().Timeout; got != 100*time.Millisecond {
+			t.Errorf("open %d: expected a fixed 100ms timeout, got %s", i, got)
+		}
+	}
+}
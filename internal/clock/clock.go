@@ -0,0 +1,17 @@
+// Package clock lets time-dependent code take the current time from a
+// Clock, so tests can move time forward instead of sleeping.
+package clock
+
+import "time"
+
+// Clock reports the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
@@ -1,47 +1,520 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/ticker"
+	"go.yaml.in/yaml/v3"
 )
 
 type Config struct {
 	Port                      string
 	Symbol                    string
+	// Symbols are the symbols served by the home endpoint. Symbol is always
+	// the first of them.
+	Symbols                   []string
+	SymbolPattern             string
+	// FallbackSymbol is served instead of a symbol that isn't found when
+	// the request asks for it with ?fallback=true. Empty disables it.
+	FallbackSymbol            string
 	NDays                     int
+	MaxDays                   int
+	Provider                  string
+	// MockMode replaces the providers with a synthetic one, so the
+	// service runs without an API key.
+	MockMode                  bool
 	APIKey                    string
+	FinnhubAPIKey             string
+	OutputSize                string
+	// PricePrecision is the decimal places prices and statistics are
+	// rounded to in responses; zero leaves them unrounded.
+	PricePrecision            int
 	ServerReadTimeout         time.Duration
 	ServerWriteTimeout        time.Duration
 	APITimeout                time.Duration
+	// MaxUpstreamBodyBytes caps how much of a provider response is read.
+	MaxUpstreamBodyBytes      int64
+	HandlerTimeout            time.Duration
 	CacheTTL                  time.Duration
+	CacheTTLJitter            float64
+	NegativeCacheTTL          time.Duration
+	FXCacheTTL                time.Duration
+	QuoteCacheTTL             time.Duration
+	StaleTTL                  time.Duration
+	CacheCleanupInterval      time.Duration
+	BackgroundRefresh         bool
+	CacheKeyHash              bool
+	RefreshMinHits            int
 	CircuitBreakerTimeout     time.Duration
+	// CircuitBreakerBackoffMax, when above CircuitBreakerTimeout, doubles
+	// the open duration after each failed probe up to this limit.
+	CircuitBreakerBackoffMax  time.Duration
 	CircuitBreakerThreshold   int
 	CircuitBreakerSuccessThreshold int
+	CacheBackend              string
+	RedisAddr                 string
+	AdminToken                string
+	// MetricsAuthToken is the bearer token /metrics requires; empty
+	// leaves /metrics open.
+	MetricsAuthToken          string
+	// PprofEnabled serves net/http/pprof under /debug/pprof/, behind
+	// ADMIN_TOKEN.
+	PprofEnabled              bool
+	RateLimitRPS              float64
+	RateLimitBurst            int
+	// TrustedProxies are the addresses and CIDR ranges of the proxies
+	// whose X-Forwarded-For entries identify rate-limited clients; see
+	// TrustedProxyPrefixes.
+	TrustedProxies            []string
+	ReadyFreshness            time.Duration
+	ReadyTimeout              time.Duration
+	MetricsNamespace          string
+	// MetricsOpenMetrics serves /metrics in the OpenMetrics format to
+	// scrapers that ask for it.
+	MetricsOpenMetrics        bool
+	// LatencyBuckets are the upper bounds, in seconds, of the request and
+	// upstream call duration histograms.
+	LatencyBuckets            []float64
+	StreamInterval            time.Duration
+	StreamMaxConnections      int
+	MaxConcurrentUpstream     int
+	// BatchMaxConcurrency is how many symbols one /export fetches at once,
+	// within the MaxConcurrentUpstream limit shared by all requests.
+	BatchMaxConcurrency       int
+	UpstreamMaxIdleConns      int
+	UpstreamMaxIdleConnsPerHost int
+	UpstreamIdleConnTimeout   time.Duration
+	UpstreamTLSHandshakeTimeout time.Duration
+	UpstreamRetries           int
+	UpstreamRetryBackoff      time.Duration
+	CircuitBreakerCountRetries bool
+	// DegradedMode starts the service serving cached data only; it can be
+	// toggled at runtime through /admin/degraded.
+	DegradedMode              bool
+	OTelEnabled               bool
+	OTelEndpoint              string
+	LogLevel                  string
+	LogFormat                 string
+	WarmSymbols               []string
+	WarmConcurrency           int
+	WarmTimeout               time.Duration
+	// BlockedSymbols are refused with 403 before any cache or upstream
+	// access.
+	BlockedSymbols            []string
+}
+
+// Load reads the configuration from environment variables. When
+// CONFIG_FILE is set, the file is loaded first and environment variables
+// override its values.
+func Load() (*Config, error) {
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return LoadFile(path)
+	}
+	return build(getEnv), nil
+}
+
+// LoadFile reads a YAML or JSON config file (chosen by extension, YAML
+// unless it ends in .json). Keys are the lowercased environment variable
+// names, e.g. cache_ttl: 300. Environment variables take precedence over
+// file values and defaults apply to anything left unset.
+func LoadFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	var raw map[string]interface{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &raw)
+	} else {
+		err = yaml.Unmarshal(data, &raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	fileValues := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if value != nil {
+			fileValues[strings.ToLower(key)] = fmt.Sprint(value)
+		}
+	}
+
+	known := make(map[string]bool)
+	cfg := build(func(key, defaultValue string) string {
+		known[strings.ToLower(key)] = true
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+		if value, ok := fileValues[strings.ToLower(key)]; ok {
+			return value
+		}
+		return defaultValue
+	})
+
+	// Reject typos rather than silently falling back to a default
+	var unknown []string
+	for key := range raw {
+		if !known[strings.ToLower(key)] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown keys in config file %s: %s", path, strings.Join(unknown, ", "))
+	}
+
+	return cfg, nil
 }
 
-func Load() *Config {
-	ndays, _ := strconv.Atoi(getEnv("NDAYS", "7"))
-	cacheTTL, _ := strconv.Atoi(getEnv("CACHE_TTL", "300"))
-	circuitBreakerTimeout, _ := strconv.Atoi(getEnv("CIRCUIT_BREAKER_TIMEOUT", "30"))
-	circuitBreakerThreshold, _ := strconv.Atoi(getEnv("CIRCUIT_BREAKER_THRESHOLD", "5"))
-	circuitBreakerSuccessThreshold, _ := strconv.Atoi(getEnv("CIRCUIT_BREAKER_SUCCESS_THRESHOLD", "10"))
+// build assembles a Config, reading each setting through get.
+func build(get func(key, defaultValue string) string) *Config {
+	ndays, _ := strconv.Atoi(get("NDAYS", "7"))
+	maxDays, _ := strconv.Atoi(get("MAX_DAYS", "1000"))
+	cacheTTL, _ := strconv.Atoi(get("CACHE_TTL", "300"))
+	cacheTTLJitter, _ := strconv.ParseFloat(get("CACHE_TTL_JITTER", "0"), 64)
+	negativeCacheTTL, _ := strconv.Atoi(get("NEGATIVE_CACHE_TTL", "60"))
+	fxCacheTTL, _ := strconv.Atoi(get("FX_CACHE_TTL", "3600"))
+	quoteCacheTTL, _ := strconv.Atoi(get("QUOTE_CACHE_TTL", "60"))
+	staleTTL, _ := strconv.Atoi(get("STALE_TTL", "0"))
+	cacheCleanupInterval, _ := strconv.Atoi(get("CACHE_CLEANUP_INTERVAL", "60"))
+	backgroundRefresh, _ := strconv.ParseBool(get("BACKGROUND_REFRESH", "false"))
+	cacheKeyHash, _ := strconv.ParseBool(get("CACHE_KEY_HASH", "false"))
+	refreshMinHits, _ := strconv.Atoi(get("REFRESH_MIN_HITS", "5"))
+	circuitBreakerTimeout, _ := strconv.Atoi(get("CIRCUIT_BREAKER_TIMEOUT", "30"))
+	circuitBreakerBackoffMax, _ := strconv.Atoi(get("CB_BACKOFF_MAX", "0"))
+	circuitBreakerThreshold, _ := strconv.Atoi(get("CIRCUIT_BREAKER_THRESHOLD", "5"))
+	circuitBreakerSuccessThreshold, _ := strconv.Atoi(get("CIRCUIT_BREAKER_SUCCESS_THRESHOLD", "10"))
+	rateLimitRPS, _ := strconv.ParseFloat(get("RATE_LIMIT_RPS", "10"), 64)
+	rateLimitBurst, _ := strconv.Atoi(get("RATE_LIMIT_BURST", "20"))
+	readyFreshness, _ := strconv.Atoi(get("READY_FRESHNESS", "600"))
+	readyTimeout, _ := strconv.Atoi(get("READY_TIMEOUT", "2"))
+	apiTimeout, _ := strconv.Atoi(get("API_TIMEOUT", "10"))
+	maxUpstreamBodyBytes, _ := strconv.ParseInt(get("MAX_UPSTREAM_BODY_BYTES", "16777216"), 10, 64)
+	streamInterval, _ := strconv.Atoi(get("STREAM_INTERVAL", "5"))
+	streamMaxConnections, _ := strconv.Atoi(get("STREAM_MAX_CONNECTIONS", "100"))
+	maxConcurrentUpstream, _ := strconv.Atoi(get("MAX_CONCURRENT_UPSTREAM", "5"))
+	batchMaxConcurrency, _ := strconv.Atoi(get("BATCH_MAX_CONCURRENCY", "4"))
+	upstreamMaxIdleConns, _ := strconv.Atoi(get("UPSTREAM_MAX_IDLE_CONNS", "100"))
+	upstreamMaxIdleConnsPerHost, _ := strconv.Atoi(get("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", "10"))
+	upstreamIdleConnTimeout, _ := strconv.Atoi(get("UPSTREAM_IDLE_CONN_TIMEOUT", "90"))
+	upstreamTLSHandshakeTimeout, _ := strconv.Atoi(get("UPSTREAM_TLS_HANDSHAKE_TIMEOUT", "10"))
+	upstreamRetries, _ := strconv.Atoi(get("UPSTREAM_RETRIES", "0"))
+	upstreamRetryBackoffMs, _ := strconv.Atoi(get("UPSTREAM_RETRY_BACKOFF_MS", "250"))
+	circuitBreakerCountRetries, _ := strconv.ParseBool(get("CIRCUIT_BREAKER_COUNT_RETRIES", "false"))
+	degradedMode, _ := strconv.ParseBool(get("DEGRADED_MODE", "false"))
+	mockMode, _ := strconv.ParseBool(get("MOCK_MODE", "false"))
+	pricePrecision, _ := strconv.Atoi(get("PRICE_PRECISION", "2"))
+	warmConcurrency, _ := strconv.Atoi(get("WARM_CONCURRENCY", "4"))
+	warmTimeout, _ := strconv.Atoi(get("WARM_TIMEOUT", "30"))
+	handlerTimeout, _ := strconv.Atoi(get("HANDLER_TIMEOUT", "12"))
+	otelEnabled, _ := strconv.ParseBool(get("OTEL_ENABLED", "false"))
+	metricsOpenMetrics, _ := strconv.ParseBool(get("METRICS_OPENMETRICS", "true"))
+	pprofEnabled, _ := strconv.ParseBool(get("PPROF_ENABLED", "false"))
+	// From sub-millisecond cache hits to upstream calls near API_TIMEOUT
+	latencyBuckets := parseBuckets(get("LATENCY_BUCKETS", "0.0005,0.001,0.0025,0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5,10,30"))
+
+	// SYMBOLS takes precedence over SYMBOL when both are set
+	symbol := get("SYMBOL", "MSFT")
+	symbols := parseSymbolList(get("SYMBOLS", ""))
+	if len(symbols) > 0 {
+		symbol = symbols[0]
+	} else {
+		symbols = []string{symbol}
+	}
 	
 	return &Config{
-		Port:                      getEnv("PORT", "8080"),
-		Symbol:                    getEnv("SYMBOL", "MSFT"),
+		Port:                      get("PORT", "8080"),
+		Symbol:                    symbol,
+		Symbols:                   symbols,
+		SymbolPattern:             get("SYMBOL_PATTERN", ""),
+		FallbackSymbol:            strings.ToUpper(strings.TrimSpace(get("FALLBACK_SYMBOL", ""))),
 		NDays:                     ndays,
-		APIKey:                    getEnv("APIKEY", "demo"),
+		MaxDays:                   maxDays,
+		Provider:                  get("PROVIDER", "alphavantage"),
+		MockMode:                  mockMode,
+		APIKey:                    get("APIKEY", "demo"),
+		FinnhubAPIKey:             get("FINNHUB_API_KEY", ""),
+		OutputSize:                get("OUTPUT_SIZE", "auto"),
+		PricePrecision:            pricePrecision,
 		ServerReadTimeout:         15 * time.Second,
 		ServerWriteTimeout:        15 * time.Second,
-		APITimeout:                10 * time.Second,
+		APITimeout:                time.Duration(apiTimeout) * time.Second,
+		MaxUpstreamBodyBytes:      maxUpstreamBodyBytes,
+		HandlerTimeout:            time.Duration(handlerTimeout) * time.Second,
 		CacheTTL:                  time.Duration(cacheTTL) * time.Second,
+		CacheTTLJitter:            cacheTTLJitter,
+		NegativeCacheTTL:          time.Duration(negativeCacheTTL) * time.Second,
+		FXCacheTTL:                time.Duration(fxCacheTTL) * time.Second,
+		QuoteCacheTTL:             time.Duration(quoteCacheTTL) * time.Second,
+		StaleTTL:                  time.Duration(staleTTL) * time.Second,
+		CacheCleanupInterval:      time.Duration(cacheCleanupInterval) * time.Second,
+		BackgroundRefresh:         backgroundRefresh,
+		CacheKeyHash:              cacheKeyHash,
+		RefreshMinHits:            refreshMinHits,
 		CircuitBreakerTimeout:     time.Duration(circuitBreakerTimeout) * time.Second,
+		CircuitBreakerBackoffMax:  time.Duration(circuitBreakerBackoffMax) * time.Second,
 		CircuitBreakerThreshold:   circuitBreakerThreshold,
 		CircuitBreakerSuccessThreshold: circuitBreakerSuccessThreshold,
+		CacheBackend:              get("CACHE_BACKEND", "memory"),
+		RedisAddr:                 get("REDIS_ADDR", "localhost:6379"),
+		AdminToken:                get("ADMIN_TOKEN", ""),
+		MetricsAuthToken:          get("METRICS_AUTH_TOKEN", ""),
+		PprofEnabled:              pprofEnabled,
+		RateLimitRPS:              rateLimitRPS,
+		RateLimitBurst:            rateLimitBurst,
+		TrustedProxies:            parseList(get("TRUSTED_PROXIES", "")),
+		ReadyFreshness:            time.Duration(readyFreshness) * time.Second,
+		ReadyTimeout:              time.Duration(readyTimeout) * time.Second,
+		MetricsNamespace:          get("METRICS_NAMESPACE", "stock_api"),
+		MetricsOpenMetrics:        metricsOpenMetrics,
+		LatencyBuckets:            latencyBuckets,
+		StreamInterval:            time.Duration(streamInterval) * time.Second,
+		StreamMaxConnections:      streamMaxConnections,
+		MaxConcurrentUpstream:     maxConcurrentUpstream,
+		BatchMaxConcurrency:       batchMaxConcurrency,
+		UpstreamMaxIdleConns:      upstreamMaxIdleConns,
+		UpstreamMaxIdleConnsPerHost: upstreamMaxIdleConnsPerHost,
+		UpstreamIdleConnTimeout:   time.Duration(upstreamIdleConnTimeout) * time.Second,
+		UpstreamTLSHandshakeTimeout: time.Duration(upstreamTLSHandshakeTimeout) * time.Second,
+		UpstreamRetries:           upstreamRetries,
+		UpstreamRetryBackoff:      time.Duration(upstreamRetryBackoffMs) * time.Millisecond,
+		CircuitBreakerCountRetries: circuitBreakerCountRetries,
+		DegradedMode:              degradedMode,
+		OTelEnabled:               otelEnabled,
+		OTelEndpoint:              get("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		LogLevel:                  get("LOG_LEVEL", "info"),
+		LogFormat:                 get("LOG_FORMAT", "json"),
+		WarmSymbols:               parseSymbolList(get("WARM_SYMBOLS", "")),
+		WarmConcurrency:           warmConcurrency,
+		WarmTimeout:               time.Duration(warmTimeout) * time.Second,
+		BlockedSymbols:            parseSymbolList(get("BLOCKED_SYMBOLS", "")),
 	}
 }
 
+// Validate reports every invalid setting at once. Load ignores parse
+// errors, so a malformed number shows up here as a zero value.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.NDays < 1 {
+		errs = append(errs, fmt.Errorf("NDAYS must be a positive integer, got %d", c.NDays))
+	}
+	if c.MaxDays < 1 {
+		errs = append(errs, fmt.Errorf("MAX_DAYS must be a positive integer, got %d", c.MaxDays))
+	}
+
+	// An invalid SYMBOL_PATTERN falls back to the default, as it does in
+	// the handlers
+	validator, err := ticker.NewValidator(c.SymbolPattern)
+	if err != nil {
+		validator, _ = ticker.NewValidator("")
+	}
+	for _, symbol := range c.Symbols {
+		if err := validator.Validate(symbol); err != nil {
+			errs = append(errs, fmt.Errorf("SYMBOLS: %w", err))
+		}
+	}
+	if c.FallbackSymbol != "" {
+		if err := validator.Validate(c.FallbackSymbol); err != nil {
+			errs = append(errs, fmt.Errorf("FALLBACK_SYMBOL: %w", err))
+		}
+	}
+
+	// Mock mode makes no provider calls, so needs no keys
+	provider := c.Provider
+	if c.MockMode {
+		provider = ""
+	}
+	switch provider {
+	case "alphavantage":
+		if c.APIKey == "" {
+			errs = append(errs, errors.New("APIKEY is required for the alphavantage provider"))
+		}
+	case "finnhub":
+		if c.FinnhubAPIKey == "" {
+			errs = append(errs, errors.New("FINNHUB_API_KEY is required for the finnhub provider"))
+		}
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server read timeout", c.ServerReadTimeout},
+		{"server write timeout", c.ServerWriteTimeout},
+		{"API_TIMEOUT", c.APITimeout},
+		{"READY_TIMEOUT", c.ReadyTimeout},
+		{"HANDLER_TIMEOUT", c.HandlerTimeout},
+		{"CACHE_TTL", c.CacheTTL},
+		{"NEGATIVE_CACHE_TTL", c.NegativeCacheTTL},
+		{"FX_CACHE_TTL", c.FXCacheTTL},
+		{"QUOTE_CACHE_TTL", c.QuoteCacheTTL},
+		{"STALE_TTL", c.StaleTTL},
+		{"CACHE_CLEANUP_INTERVAL", c.CacheCleanupInterval},
+		{"CIRCUIT_BREAKER_TIMEOUT", c.CircuitBreakerTimeout},
+		{"CB_BACKOFF_MAX", c.CircuitBreakerBackoffMax},
+		{"UPSTREAM_IDLE_CONN_TIMEOUT", c.UpstreamIdleConnTimeout},
+		{"UPSTREAM_TLS_HANDSHAKE_TIMEOUT", c.UpstreamTLSHandshakeTimeout},
+		{"UPSTREAM_RETRY_BACKOFF_MS", c.UpstreamRetryBackoff},
+		{"WARM_TIMEOUT", c.WarmTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %s", d.name, d.value))
+		}
+	}
+
+	// A jitter of 1 or more could shrink a TTL to nothing
+	if c.CacheTTLJitter < 0 || c.CacheTTLJitter >= 1 {
+		errs = append(errs, fmt.Errorf("CACHE_TTL_JITTER must be at least 0 and below 1, got %g", c.CacheTTLJitter))
+	}
+
+	if c.CircuitBreakerThreshold < 1 {
+		errs = append(errs, fmt.Errorf("CIRCUIT_BREAKER_THRESHOLD must be at least 1, got %d", c.CircuitBreakerThreshold))
+	}
+	if c.CircuitBreakerSuccessThreshold < 1 {
+		errs = append(errs, fmt.Errorf("CIRCUIT_BREAKER_SUCCESS_THRESHOLD must be at least 1, got %d", c.CircuitBreakerSuccessThreshold))
+	}
+
+	if c.StreamInterval <= 0 {
+		errs = append(errs, fmt.Errorf("STREAM_INTERVAL must be a positive number of seconds, got %s", c.StreamInterval))
+	}
+	if c.StreamMaxConnections < 0 {
+		errs = append(errs, fmt.Errorf("STREAM_MAX_CONNECTIONS must not be negative, got %d", c.StreamMaxConnections))
+	}
+	if c.MaxConcurrentUpstream < 0 {
+		errs = append(errs, fmt.Errorf("MAX_CONCURRENT_UPSTREAM must not be negative, got %d", c.MaxConcurrentUpstream))
+	}
+	if c.BatchMaxConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("BATCH_MAX_CONCURRENCY must be at least 1, got %d", c.BatchMaxConcurrency))
+	}
+	if len(c.WarmSymbols) > 0 && c.WarmConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("WARM_CONCURRENCY must be at least 1, got %d", c.WarmConcurrency))
+	}
+	if c.MaxUpstreamBodyBytes < 1 {
+		errs = append(errs, fmt.Errorf("MAX_UPSTREAM_BODY_BYTES must be at least 1, got %d", c.MaxUpstreamBodyBytes))
+	}
+	if c.PricePrecision < 0 || c.PricePrecision > 10 {
+		errs = append(errs, fmt.Errorf("PRICE_PRECISION must be between 0 and 10, got %d", c.PricePrecision))
+	}
+	if c.UpstreamRetries < 0 {
+		errs = append(errs, fmt.Errorf("UPSTREAM_RETRIES must not be negative, got %d", c.UpstreamRetries))
+	}
+	if c.UpstreamMaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("UPSTREAM_MAX_IDLE_CONNS must not be negative, got %d", c.UpstreamMaxIdleConns))
+	}
+	if c.UpstreamMaxIdleConnsPerHost < 0 {
+		errs = append(errs, fmt.Errorf("UPSTREAM_MAX_IDLE_CONNS_PER_HOST must not be negative, got %d", c.UpstreamMaxIdleConnsPerHost))
+	}
+
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", c.LogLevel))
+	}
+	if c.LogFormat != "json" && c.LogFormat != "console" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be json or console, got %q", c.LogFormat))
+	}
+
+	if _, err := c.TrustedProxyPrefixes(); err != nil {
+		errs = append(errs, fmt.Errorf("TRUSTED_PROXIES: %w", err))
+	}
+
+	if !validBuckets(c.LatencyBuckets) {
+		errs = append(errs, fmt.Errorf("LATENCY_BUCKETS must be comma-separated positive numbers of seconds in ascending order, got %v", c.LatencyBuckets))
+	}
+
+	if c.MetricsNamespace != "" && !metricsNamespacePattern.MatchString(c.MetricsNamespace) {
+		errs = append(errs, fmt.Errorf("METRICS_NAMESPACE must contain only letters, digits and underscores and not start with a digit, got %q", c.MetricsNamespace))
+	}
+
+	return errors.Join(errs...)
+}
+
+// parseSymbolList splits a comma-separated list of symbols, trimming and
+// uppercasing each one and dropping empty entries.
+func parseSymbolList(s string) []string {
+	var symbols []string
+	for _, symbol := range strings.Split(s, ",") {
+		if symbol = strings.ToUpper(strings.TrimSpace(symbol)); symbol != "" {
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
+
+// TrustedProxyPrefixes parses TrustedProxies, reading a bare address as a
+// range holding just that address.
+func (c *Config) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range c.TrustedProxies {
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an IP address nor a CIDR range", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// parseList splits a comma-separated list, trimming each entry and
+// dropping empty ones.
+func parseList(s string) []string {
+	var entries []string
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// parseBuckets parses a comma-separated list of histogram bucket bounds.
+// It returns nil if any entry isn't a number, which Validate reports.
+func parseBuckets(s string) []float64 {
+	var buckets []float64
+	for _, field := range strings.Split(s, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil
+		}
+		buckets = append(buckets, bound)
+	}
+	return buckets
+}
+
+// validBuckets reports whether buckets is a non-empty list of positive
+// bounds in strictly ascending order, as Prometheus requires.
+func validBuckets(buckets []float64) bool {
+	if len(buckets) == 0 || buckets[0] <= 0 {
+		return false
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// metricsNamespacePattern matches valid Prometheus metric name prefixes.
+var metricsNamespacePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
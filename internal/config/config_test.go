@@ -0,0 +1,318 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateDefaults(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected default configuration to be valid, got %v", err)
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg, _ := Load()
+	cfg.NDays = 0
+	cfg.Provider = "finnhub"
+	cfg.FinnhubAPIKey = ""
+	cfg.APITimeout = -time.Second
+	cfg.CircuitBreakerThreshold = 0
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+
+	for _, want := range []string{"NDAYS", "FINNHUB_API_KEY", "API_TIMEOUT", "CIRCUIT_BREAKER_THRESHOLD"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %s, got %v", want, err)
+		}
+	}
+}
+
+func TestValidateMalformedNumber(t *testing.T) {
+	t.Setenv("NDAYS", "abc")
+
+	cfg, _ := Load()
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "NDAYS") {
+		t.Errorf("expected malformed NDAYS to fail validation, got %v", err)
+	}
+}
+
+func TestValidateMetricsNamespace(t *testing.T) {
+	for namespace, valid := range map[string]bool{
+		"stock_api": true,
+		"":          true,
+		"_internal": true,
+		"stock-api": false,
+		"1stock":    false,
+		"stock.api": false,
+	} {
+		cfg, _ := Load()
+		cfg.MetricsNamespace = namespace
+		if err := cfg.Validate(); (err == nil) != valid {
+			t.Errorf("METRICS_NAMESPACE %q: expected valid=%v, got %v", namespace, valid, err)
+		}
+	}
+}
+
+func TestValidateCacheTTLJitter(t *testing.T) {
+	for jitter, valid := range map[float64]bool{0: true, 0.1: true, 0.99: true, 1: false, -0.1: false} {
+		cfg, _ := Load()
+		cfg.CacheTTLJitter = jitter
+		if err := cfg.Validate(); (err == nil) != valid {
+			t.Errorf("CACHE_TTL_JITTER %g: expected valid=%v, got %v", jitter, valid, err)
+		}
+	}
+}
+
+func TestValidateMaxUpstreamBodyBytes(t *testing.T) {
+	t.Setenv("MAX_UPSTREAM_BODY_BYTES", "1048576")
+
+	cfg, _ := Load()
+	if cfg.MaxUpstreamBodyBytes != 1<<20 {
+		t.Errorf("expected 1048576, got %d", cfg.MaxUpstreamBodyBytes)
+	}
+
+	cfg.MaxUpstreamBodyBytes = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "MAX_UPSTREAM_BODY_BYTES") {
+		t.Errorf("expected a zero limit to fail validation, got %v", err)
+	}
+}
+
+func TestValidateMockModeNeedsNoKey(t *testing.T) {
+	t.Setenv("MOCK_MODE", "true")
+
+	cfg, _ := Load()
+	if !cfg.MockMode {
+		t.Fatal("expected MOCK_MODE to enable mock mode")
+	}
+	cfg.Provider = "finnhub"
+	cfg.FinnhubAPIKey = ""
+	cfg.APIKey = ""
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected mock mode to need no API key, got %v", err)
+	}
+}
+
+func TestValidatePricePrecision(t *testing.T) {
+	cfg, _ := Load()
+	if cfg.PricePrecision != 2 {
+		t.Errorf("expected PRICE_PRECISION to default to 2, got %d", cfg.PricePrecision)
+	}
+	for precision, valid := range map[int]bool{0: true, 4: true, 10: true, -1: false, 11: false} {
+		cfg.PricePrecision = precision
+		if err := cfg.Validate(); (err == nil) != valid {
+			t.Errorf("PRICE_PRECISION %d: expected valid=%v, got %v", precision, valid, err)
+		}
+	}
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFileOnly(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "symbol: AAPL\nndays: 30\ncache_ttl: 60\nbackground_refresh: true\n")
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Symbol != "AAPL" || cfg.NDays != 30 || cfg.CacheTTL != time.Minute || !cfg.BackgroundRefresh {
+		t.Errorf("expected file values, got symbol=%s ndays=%d ttl=%s refresh=%v", cfg.Symbol, cfg.NDays, cfg.CacheTTL, cfg.BackgroundRefresh)
+	}
+	// Unset keys keep their defaults
+	if cfg.Port != "8080" {
+		t.Errorf("expected default port, got %s", cfg.Port)
+	}
+}
+
+func TestLoadEnvOnly(t *testing.T) {
+	t.Setenv("SYMBOL", "GOOG")
+	t.Setenv("NDAYS", "14")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Symbol != "GOOG" || cfg.NDays != 14 {
+		t.Errorf("expected env values, got symbol=%s ndays=%d", cfg.Symbol, cfg.NDays)
+	}
+}
+
+func TestLoadWarmSymbols(t *testing.T) {
+	t.Setenv("WARM_SYMBOLS", " aapl, MSFT,,goog ")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(cfg.WarmSymbols, ",") != "AAPL,MSFT,GOOG" {
+		t.Errorf("expected [AAPL MSFT GOOG], got %v", cfg.WarmSymbols)
+	}
+
+	cfg.WarmConcurrency = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "WARM_CONCURRENCY") {
+		t.Errorf("expected WARM_CONCURRENCY to be rejected, got %v", err)
+	}
+}
+
+func TestTrustedProxyPrefixes(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", " 10.0.0.0/8, 192.0.2.1 ,2001:db8::/32")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefixes, err := cfg.TrustedProxyPrefixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, prefix := range prefixes {
+		got = append(got, prefix.String())
+	}
+	if strings.Join(got, ",") != "10.0.0.0/8,192.0.2.1/32,2001:db8::/32" {
+		t.Errorf("expected the ranges and the address as a /32, got %v", got)
+	}
+
+	cfg.TrustedProxies = []string{"10.0.0.0/33"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "TRUSTED_PROXIES") {
+		t.Errorf("expected an invalid range to be rejected, got %v", err)
+	}
+}
+
+func TestLoadBlockedSymbols(t *testing.T) {
+	t.Setenv("BLOCKED_SYMBOLS", "tsla, brk.b,")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(cfg.BlockedSymbols, ",") != "TSLA,BRK.B" {
+		t.Errorf("expected [TSLA BRK.B], got %v", cfg.BlockedSymbols)
+	}
+}
+
+func TestLoadSymbols(t *testing.T) {
+	t.Setenv("SYMBOL", "IBM")
+	t.Setenv("SYMBOLS", "aapl, MSFT,goog")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(cfg.Symbols, ",") != "AAPL,MSFT,GOOG" || cfg.Symbol != "AAPL" {
+		t.Errorf("expected SYMBOLS to take precedence, got symbol=%s symbols=%v", cfg.Symbol, cfg.Symbols)
+	}
+
+	t.Setenv("SYMBOLS", "")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(cfg.Symbols, ",") != "IBM" {
+		t.Errorf("expected SYMBOL alone to be the only default, got %v", cfg.Symbols)
+	}
+
+	t.Setenv("SYMBOLS", "AAPL,not-a-symbol")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "NOT-A-SYMBOL") {
+		t.Errorf("expected the invalid symbol to be rejected, got %v", err)
+	}
+}
+
+func TestLoadLatencyBuckets(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected the default buckets to be valid, got %v", err)
+	}
+	if cfg.LatencyBuckets[0] >= 0.001 {
+		t.Errorf("expected the default buckets to resolve sub-millisecond cache hits, got %v", cfg.LatencyBuckets)
+	}
+
+	t.Setenv("LATENCY_BUCKETS", "0.01, 0.1,1")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.LatencyBuckets) != 3 || cfg.LatencyBuckets[1] != 0.1 {
+		t.Errorf("expected [0.01 0.1 1], got %v", cfg.LatencyBuckets)
+	}
+
+	for _, buckets := range []string{"1,0.5", "0.1,0.1", "0.1,fast", "0,1"} {
+		t.Setenv("LATENCY_BUCKETS", buckets)
+		cfg, err := Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "LATENCY_BUCKETS") {
+			t.Errorf("%q: expected LATENCY_BUCKETS to be rejected, got %v", buckets, err)
+		}
+	}
+}
+
+func TestLoadFallbackSymbol(t *testing.T) {
+	t.Setenv("FALLBACK_SYMBOL", " ibm ")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.FallbackSymbol != "IBM" {
+		t.Errorf("expected IBM, got %q", cfg.FallbackSymbol)
+	}
+
+	cfg.FallbackSymbol = "not a symbol"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "FALLBACK_SYMBOL") {
+		t.Errorf("expected FALLBACK_SYMBOL to be rejected, got %v", err)
+	}
+}
+
+func TestLoadEnvOverridesFile(t *testing.T) {
+	path := writeConfigFile(t, "config.json", `{"symbol": "AAPL", "ndays": 30, "rate_limit_rps": 2.5}`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("SYMBOL", "GOOG")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Symbol != "GOOG" {
+		t.Errorf("expected env to override file symbol, got %s", cfg.Symbol)
+	}
+	if cfg.NDays != 30 || cfg.RateLimitRPS != 2.5 {
+		t.Errorf("expected file values where env is unset, got ndays=%d rps=%v", cfg.NDays, cfg.RateLimitRPS)
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected error for missing file")
+	}
+
+	path := writeConfigFile(t, "config.yaml", "symbl: AAPL\n")
+	if _, err := LoadFile(path); err == nil || !strings.Contains(err.Error(), "symbl") {
+		t.Errorf("expected unknown key to be reported, got %v", err)
+	}
+}
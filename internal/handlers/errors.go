@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/stock"
+)
+
+// Machine-readable error codes returned in ErrorResponse.Code.
+const (
+	CodeInternalError    = "INTERNAL_ERROR"
+	CodeUpstreamError    = "UPSTREAM_ERROR"
+	CodeCircuitOpen      = "CIRCUIT_OPEN"
+	CodeDegradedMode     = "DEGRADED_MODE"
+	CodeSymbolNotFound   = "SYMBOL_NOT_FOUND"
+	CodeSymbolBlocked    = "SYMBOL_BLOCKED"
+	CodeNotEntitled      = "NOT_ENTITLED"
+	CodePremiumRequired  = "PREMIUM_REQUIRED"
+	CodeDemoKey          = "DEMO_KEY"
+	CodeRateLimited      = "RATE_LIMITED"
+	CodeInvalidSymbol    = "INVALID_SYMBOL"
+	CodeInvalidDays      = "INVALID_DAYS"
+	CodeInvalidPeriod    = "INVALID_PERIOD"
+	CodeInvalidIndicator = "INVALID_INDICATOR"
+	CodeInsufficientData = "INSUFFICIENT_DATA"
+	CodeInvalidOrder     = "INVALID_ORDER"
+	CodeInvalidPage      = "INVALID_PAGE"
+	CodeInvalidCurrency  = "INVALID_CURRENCY"
+	CodeInvalidLimit     = "INVALID_LIMIT"
+	CodeInvalidPath      = "INVALID_PATH"
+	CodeInvalidEnabled   = "INVALID_ENABLED"
+	CodeInvalidFields    = "INVALID_FIELDS"
+	CodeInvalidTTL       = "INVALID_TTL"
+	CodeNotFound         = "NOT_FOUND"
+	CodeTooManyStreams   = "TOO_MANY_STREAMS"
+)
+
+// ErrorResponse is the JSON body of every error returned by the stock
+// endpoints. Symbol and Days describe the request that failed; RequestID
+// matches the X-Request-ID header so errors can be traced to log lines.
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	Details   string `json:"details,omitempty"`
+	Code      string `json:"code"`
+	Symbol    string `json:"symbol,omitempty"`
+	Days      int    `json:"days,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// classifyFetchError maps an error from GetStockData to an HTTP status and
+// error code.
+func classifyFetchError(err error) (int, string) {
+	switch {
+	case errors.Is(err, circuitbreaker.ErrCircuitBreakerOpen):
+		return http.StatusServiceUnavailable, CodeCircuitOpen
+	case errors.Is(err, stock.ErrDegradedModeMiss):
+		return http.StatusServiceUnavailable, CodeDegradedMode
+	case errors.Is(err, stock.ErrDemoKey):
+		return http.StatusBadRequest, CodeDemoKey
+	case errors.Is(err, stock.ErrSymbolNotFound):
+		return http.StatusNotFound, CodeSymbolNotFound
+	case errors.Is(err, stock.ErrSymbolBlocked):
+		return http.StatusForbidden, CodeSymbolBlocked
+	case errors.Is(err, stock.ErrPremiumRequired):
+		return http.StatusPaymentRequired, CodePremiumRequired
+	case errors.Is(err, stock.ErrNotEntitled):
+		return http.StatusForbidden, CodeNotEntitled
+	case errors.Is(err, stock.ErrRateLimited):
+		return http.StatusTooManyRequests, CodeRateLimited
+	case errors.Is(err, stock.ErrUpstream):
+		return http.StatusBadGateway, CodeUpstreamError
+	default:
+		return http.StatusInternalServerError, CodeInternalError
+	}
+}
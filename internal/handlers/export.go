@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/requestid"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/stock"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxExportSymbols caps how many symbols one export may request.
+	maxExportSymbols = 100
+	// exportConcurrency is how many symbols an export fetches at once
+	// when BATCH_MAX_CONCURRENCY isn't set.
+	exportConcurrency = 4
+)
+
+// ExportSummary is the last line of an export, counting the symbols that
+// were written as data and as errors.
+type ExportSummary struct {
+	Symbols   int `json:"symbols"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+}
+
+// exportSummaryLine wraps ExportSummary so it can't be mistaken for a
+// StockData or ErrorResponse line.
+type exportSummaryLine struct {
+	Summary ExportSummary `json:"summary"`
+}
+
+// exportResult is one symbol's outcome, passed from the fetchers to the
+// writer.
+type exportResult struct {
+	symbol  string
+	data    *stock.StockData
+	err     error
+	elapsed time.Duration
+}
+
+// exportDataLine and exportErrorLine add a symbol's fetch time to its
+// StockData or ErrorResponse line.
+type exportDataLine struct {
+	*stock.StockData
+	ElapsedMs int64 `json:"elapsedMs"`
+}
+
+type exportErrorLine struct {
+	ErrorResponse
+	ElapsedMs int64 `json:"elapsedMs"`
+}
+
+// Export endpoint - streams newline-delimited JSON, one StockData per
+// symbol in the order the fetches complete, or an ErrorResponse for a
+// symbol that failed. A summary line ends the stream. If the client goes
+// away, fetches still outstanding are cancelled.
+func (h *Handler) exportHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	outcome := outcomeError
+	defer h.observe("/export", start, &outcome)
+
+	query := r.URL.Query()
+	symbols, err := h.parseExportSymbols(query.Get("symbols"))
+	if err != nil {
+		h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid symbol",
+			Details: err.Error(),
+			Code:    CodeInvalidSymbol,
+		})
+		return
+	}
+
+	days := h.config.NDays
+	if daysStr := query.Get("days"); daysStr != "" {
+		days, err = h.parseDays(daysStr)
+		if err != nil {
+			h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid days",
+				Details: err.Error(),
+				Code:    CodeInvalidDays,
+			})
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.sendError(w, r, http.StatusInternalServerError, ErrorResponse{
+			Error: "Streaming not supported",
+			Code:  CodeInternalError,
+		})
+		return
+	}
+
+	// Large exports can outlast the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		h.logger.Warn("unable to clear write deadline for export", zap.Error(err))
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	h.logger.Info("export started", zap.Int("symbols", len(symbols)), zap.Int("ndays", days))
+	results := h.fetchExport(ctx, symbols, days)
+
+	encoder := json.NewEncoder(w)
+	summary := ExportSummary{Symbols: len(symbols)}
+	for res := range results {
+		elapsedMs := res.elapsed.Milliseconds()
+		var line interface{} = exportDataLine{StockData: res.data, ElapsedMs: elapsedMs}
+		if res.err != nil {
+			summary.Failed++
+			_, code := classifyFetchError(res.err)
+			line = exportErrorLine{
+				ErrorResponse: ErrorResponse{
+					Error:     "Failed to fetch stock data",
+					Details:   res.err.Error(),
+					Code:      code,
+					Symbol:    res.symbol,
+					Days:      days,
+					RequestID: requestid.FromContext(ctx),
+				},
+				ElapsedMs: elapsedMs,
+			}
+		} else {
+			summary.Succeeded++
+		}
+
+		if err := encoder.Encode(line); err != nil {
+			// The client is gone; stop the remaining fetches and let the
+			// fetchers drain
+			h.logger.Warn("export aborted", zap.Error(err))
+			cancel()
+			for range results {
+			}
+			return
+		}
+		flusher.Flush()
+	}
+
+	if ctx.Err() != nil {
+		h.logger.Info("export cancelled by client", zap.Int("completed", summary.Succeeded+summary.Failed))
+		return
+	}
+	if err := encoder.Encode(exportSummaryLine{Summary: summary}); err != nil {
+		h.logger.Warn("failed to write export summary", zap.Error(err))
+		return
+	}
+	flusher.Flush()
+
+	outcome = outcomeOK
+	h.logger.Info("export finished",
+		zap.Int("succeeded", summary.Succeeded),
+		zap.Int("failed", summary.Failed))
+}
+
+// fetchExport fetches symbols with at most BATCH_MAX_CONCURRENCY in
+// flight and sends each result as it completes. The fetches also wait for
+// the stock client's upstream slots, so an export can't take more than
+// MAX_CONCURRENT_UPSTREAM allows. The channel is closed once every fetch
+// has finished; symbols not yet started when ctx is done are skipped.
+func (h *Handler) fetchExport(ctx context.Context, symbols []string, days int) <-chan exportResult {
+	concurrency := h.config.BatchMaxConcurrency
+	if concurrency < 1 {
+		concurrency = exportConcurrency
+	}
+
+	results := make(chan exportResult)
+	slots := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	go func() {
+		defer close(results)
+		for _, symbol := range symbols {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				start := time.Now()
+				data, err := h.stockClient.GetStockData(ctx, symbol, days, stock.PeriodDaily, nil)
+				results <- exportResult{symbol: symbol, data: data, err: err, elapsed: time.Since(start)}
+			}()
+		}
+		wg.Wait()
+	}()
+	return results
+}
+
+// parseExportSymbols splits a comma-separated symbols parameter and
+// validates each symbol. Duplicates are dropped.
+func (h *Handler) parseExportSymbols(raw string) ([]string, error) {
+	var symbols []string
+	seen := make(map[string]bool)
+	for _, symbol := range strings.Split(raw, ",") {
+		symbol = strings.TrimSpace(symbol)
+		if symbol == "" || seen[symbol] {
+			continue
+		}
+		if err := h.symbolValidator.Validate(symbol); err != nil {
+			return nil, err
+		}
+		seen[symbol] = true
+		symbols = append(symbols, symbol)
+	}
+
+	switch {
+	case len(symbols) == 0:
+		return nil, errors.New("symbols is required, e.g. symbols=AAPL,MSFT")
+	case len(symbols) > maxExportSymbols:
+		return nil, fmt.Errorf("at most %d symbols can be exported at once", maxExportSymbols)
+	}
+	return symbols, nil
+}
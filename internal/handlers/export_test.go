@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/cache"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/config"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/stock"
+	"github.com/gorilla/mux"
+)
+
+func newExportRouter(provider stock.StockProvider) *mux.Router {
+	return newExportRouterWithLimits(provider, 0, 0)
+}
+
+// newExportRouterWithLimits is newExportRouter with BATCH_MAX_CONCURRENCY
+// and MAX_CONCURRENT_UPSTREAM set; zero leaves either at its default.
+func newExportRouterWithLimits(provider stock.StockProvider, batch, upstream int) *mux.Router {
+	handler := New(HandlerOptions{
+		Config: &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000, BatchMaxConcurrency: batch},
+		StockClient: stock.NewClient(stock.ClientOptions{
+			Providers: []stock.StockProvider{provider},
+			Cache:     cache.NewCache(time.Minute),
+			// Lenient, so one failing symbol doesn't fail the rest
+			CircuitBreaker:        circuitbreaker.NewCircuitBreaker(100, 1, time.Minute),
+			MaxConcurrentUpstream: upstream,
+		}),
+	})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+	return router
+}
+
+func TestExportHandler(t *testing.T) {
+	provider := &symbolErrProvider{errs: map[string]error{"ZZZZ": stock.ErrSymbolNotFound}}
+	router := newExportRouter(provider)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/export?symbols=AAPL,ZZZZ,MSFT,AAPL&days=3", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected application/x-ndjson, got %q", ct)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 4 {
+		t.Fatalf("expected 3 symbol lines and a summary, got %d:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+
+	got := make(map[string]string)
+	for _, line := range lines[:3] {
+		var entry struct {
+			Symbol    string `json:"symbol"`
+			NDays     int    `json:"ndays"`
+			Code      string `json:"code"`
+			ElapsedMs *int64 `json:"elapsedMs"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid line %q: %v", line, err)
+		}
+		if entry.ElapsedMs == nil {
+			t.Errorf("%s: expected elapsedMs on every symbol line", entry.Symbol)
+		}
+		got[entry.Symbol] = entry.Code
+		if entry.Code == "" && entry.NDays != 3 {
+			t.Errorf("%s: expected 3 days, got %d", entry.Symbol, entry.NDays)
+		}
+	}
+	want := map[string]string{"AAPL": "", "MSFT": "", "ZZZZ": CodeSymbolNotFound}
+	for symbol, code := range want {
+		if c, ok := got[symbol]; !ok || c != code {
+			t.Errorf("%s: expected code %q, got %q (present %v)", symbol, code, c, ok)
+		}
+	}
+
+	var summary exportSummaryLine
+	if err := json.Unmarshal([]byte(lines[3]), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Summary != (ExportSummary{Symbols: 3, Succeeded: 2, Failed: 1}) {
+		t.Errorf("unexpected summary %+v", summary.Summary)
+	}
+}
+
+func TestExportHandlerRejectsInvalidSymbols(t *testing.T) {
+	router := newExportRouter(&symbolErrProvider{})
+
+	tooMany := strings.Repeat("A,", maxExportSymbols) + "B"
+	var unique []string
+	for i := 0; i <= maxExportSymbols; i++ {
+		unique = append(unique, fmt.Sprintf("%c%c%c", 'A'+i/26/26%26, 'A'+i/26%26, 'A'+i%26))
+	}
+
+	for _, query := range []string{"", "?symbols=", "?symbols=AAPL,not-valid", "?symbols=" + strings.Join(unique, ",")} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/export"+query, nil))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeInvalidSymbol) {
+			t.Errorf("%q: expected 400 %s, got %d: %s", query, CodeInvalidSymbol, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Duplicates don't count towards the limit
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/export?symbols="+tooMany, nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected duplicates to be collapsed, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// blockingProvider holds every fetch until its context is done, recording
+// how many run at once and how many were cancelled.
+type blockingProvider struct {
+	started   chan struct{}
+	current   atomic.Int32
+	peak      atomic.Int32
+	cancelled atomic.Int32
+}
+
+func (p *blockingProvider) Name() string { return "blocking" }
+
+func (p *blockingProvider) FetchDailySeries(ctx context.Context, symbol string, ndays int, period stock.Period) (*stock.StockData, error) {
+	n := p.current.Add(1)
+	defer p.current.Add(-1)
+	for {
+		peak := p.peak.Load()
+		if n <= peak || p.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	p.started <- struct{}{}
+
+	<-ctx.Done()
+	p.cancelled.Add(1)
+	return nil, ctx.Err()
+}
+
+func TestExportHandlerClientDisconnect(t *testing.T) {
+	provider := &blockingProvider{started: make(chan struct{}, maxExportSymbols)}
+	router := newExportRouter(provider)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/export?symbols=AAA,BBB,CCC,DDD,EEE,FFF", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	for i := 0; i < exportConcurrency; i++ {
+		select {
+		case <-provider.started:
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d fetches to start, got %d", exportConcurrency, i)
+		}
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the export to end when the client went away")
+	}
+	if peak := provider.peak.Load(); peak != exportConcurrency {
+		t.Errorf("expected at most %d concurrent fetches, got %d", exportConcurrency, peak)
+	}
+	if n := provider.cancelled.Load(); n != exportConcurrency {
+		t.Errorf("expected the %d outstanding fetches to be cancelled, got %d", exportConcurrency, n)
+	}
+}
+
+// peakProvider takes a little while over each fetch and records the most
+// fetches it saw running at once.
+type peakProvider struct {
+	current atomic.Int32
+	peak    atomic.Int32
+}
+
+func (p *peakProvider) Name() string { return "peak" }
+
+func (p *peakProvider) FetchDailySeries(ctx context.Context, symbol string, ndays int, period stock.Period) (*stock.StockData, error) {
+	n := p.current.Add(1)
+	defer p.current.Add(-1)
+	for {
+		peak := p.peak.Load()
+		if n <= peak || p.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return &stock.StockData{Symbol: symbol, NDays: ndays}, nil
+}
+
+func TestExportHandlerBatchConcurrency(t *testing.T) {
+	var symbols []string
+	for i := 0; i < 20; i++ {
+		symbols = append(symbols, fmt.Sprintf("S%c", 'A'+i))
+	}
+	query := "/export?symbols=" + strings.Join(symbols, ",")
+
+	tests := []struct {
+		name            string
+		batch, upstream int
+		wantPeak        int32
+	}{
+		{"batch limit", 3, 0, 3},
+		{"upstream limit is still respected", 5, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &peakProvider{}
+			router := newExportRouterWithLimits(provider, tt.batch, tt.upstream)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", query, nil))
+			if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"succeeded":20`) {
+				t.Fatalf("expected all 20 symbols to be exported, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if peak := provider.peak.Load(); peak > tt.wantPeak {
+				t.Errorf("expected at most %d concurrent fetches, got %d", tt.wantPeak, peak)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/stock"
+)
+
+// stockDataFields are the top-level JSON field names of StockData that
+// ?fields may select.
+var stockDataFields = jsonFieldNames(reflect.TypeOf(stock.StockData{}))
+
+// jsonFieldNames returns the JSON names of t's exported fields.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+		names[name] = true
+	}
+	return names
+}
+
+// parseFields parses ?fields, a comma-separated list of StockData JSON
+// field names such as symbol,average,changePercent. Duplicates are
+// dropped. An empty value returns nil, meaning every field.
+func parseFields(raw string) ([]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	var fields []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || seen[field] {
+			continue
+		}
+		if !stockDataFields[field] {
+			known := make([]string, 0, len(stockDataFields))
+			for name := range stockDataFields {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown field %q, must be one of %s", field, strings.Join(known, ", "))
+		}
+		seen[field] = true
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("fields must name at least one field, e.g. fields=symbol,average")
+	}
+	return fields, nil
+}
+
+// projectFields returns stockData restricted to fields for encoding as
+// JSON, or stockData itself when fields is nil. Fields that are omitted
+// when empty, such as stale, stay omitted.
+func projectFields(stockData *stock.StockData, fields []string) (interface{}, error) {
+	if fields == nil {
+		return stockData, nil
+	}
+
+	body, err := json.Marshal(stockData)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(body, &all); err != nil {
+		return nil, err
+	}
+
+	projected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			projected[field] = value
+		}
+	}
+	return projected, nil
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/config"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/stock"
+	"github.com/gorilla/mux"
+)
+
+func TestParseFields(t *testing.T) {
+	fields, err := parseFields(" symbol,average,,symbol ,changePercent")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(fields, ",") != "symbol,average,changePercent" {
+		t.Errorf("expected trimmed, deduplicated fields, got %v", fields)
+	}
+
+	if fields, err := parseFields(""); fields != nil || err != nil {
+		t.Errorf("expected no projection for an empty value, got %v, %v", fields, err)
+	}
+	for _, raw := range []string{"symbol,bogus", "Symbol", ",", "prices.date"} {
+		if _, err := parseFields(raw); err == nil {
+			t.Errorf("%q: expected an error", raw)
+		}
+	}
+}
+
+func TestStockSymbolHandlerFields(t *testing.T) {
+	provider := &stubProvider{data: &stock.StockData{
+		Symbol:        "AAPL",
+		NDays:         2,
+		Prices:        []stock.PricePoint{{Date: "2024-01-02", Close: 101}, {Date: "2024-01-01", Close: 100}},
+		Average:       100.5,
+		ChangePercent: 1,
+	}}
+	handler := New(HandlerOptions{
+		Config:      &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000},
+		StockClient: newTestClient(provider),
+	})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/AAPL?fields=symbol,average,changePercent", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"symbol": "AAPL", "average": 100.5, "changePercent": 1.0}
+	if len(got) != len(want) {
+		t.Errorf("expected only %v, got %v", want, got)
+	}
+	for field, value := range want {
+		if got[field] != value {
+			t.Errorf("%s: expected %v, got %v", field, value, got[field])
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/AAPL/2?fields=symbol,bogus", nil))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeInvalidFields) {
+		t.Errorf("expected 400 %s for an unknown field, got %d: %s", CodeInvalidFields, rr.Code, rr.Body.String())
+	}
+	if provider.calls != 1 {
+		t.Errorf("expected invalid fields to be rejected before fetching, got %d calls", provider.calls)
+	}
+
+	// CSV downloads always carry the full series
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/AAPL/2?fields=symbol&format=csv", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "2024-01-01") {
+		t.Errorf("expected fields to be ignored for CSV, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
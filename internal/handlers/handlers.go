@@ -1,13 +1,29 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/config"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/middleware"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/requestid"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/stock"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/ticker"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -15,34 +31,112 @@ import (
 )
 
 type Handler struct {
-	config      *config.Config
-	stockClient *stock.Client
-	logger      *zap.Logger
+	config          *config.Config
+	stockClient     *stock.Client
+	logger          *zap.Logger
+	symbolValidator *ticker.Validator
+
+	// draining is set once shutdown starts so /ready fails and the load
+	// balancer stops routing new requests here.
+	draining atomic.Bool
+
+	// streams tracks open websocket and SSE streams so they can be capped
+	// and closed on shutdown; see stream.go.
+	streams streamTracker
 
 	// Metrics
+	metricsHandler http.Handler
 	apiRequests  prometheus.Counter
-	apiDuration  prometheus.Histogram
+	apiDuration  *prometheus.HistogramVec
 }
 
-func NewHandler(cfg *config.Config, stockClient *stock.Client, logger *zap.Logger, apiRequests prometheus.Counter, apiDuration prometheus.Histogram) *Handler {
-	return &Handler{
-		config:      cfg,
-		stockClient: stockClient,
-		logger:      logger,
-		apiRequests: apiRequests,
-		apiDuration: apiDuration,
+// HandlerOptions bundles the dependencies of a Handler. Config and
+// StockClient are required.
+type HandlerOptions struct {
+	Config      *config.Config
+	StockClient *stock.Client
+	Logger      *zap.Logger
+
+	// Registerer receives the handler metrics, named under
+	// Config.MetricsNamespace. When nil the metrics are kept but not
+	// exported.
+	Registerer prometheus.Registerer
+	// Gatherer is served on /metrics; nil means the default registry.
+	Gatherer prometheus.Gatherer
+}
+
+// New returns a Handler built from opts, creating its request metrics and
+// registering them with opts.Registerer.
+func New(opts HandlerOptions) *Handler {
+	cfg := opts.Config
+	logger := opts.Logger
+	if logger == nil {
+		logger = zap.NewNop()
 	}
+
+	symbolValidator, err := ticker.NewValidator(cfg.SymbolPattern)
+	if err != nil {
+		logger.Error("invalid SYMBOL_PATTERN, using default", zap.Error(err))
+		symbolValidator, _ = ticker.NewValidator("")
+	}
+
+	// promhttp gzips the exposition itself when the scraper accepts it,
+	// which is why the Compression middleware leaves /metrics alone
+	metricsOpts := promhttp.HandlerOpts{EnableOpenMetrics: cfg.MetricsOpenMetrics}
+	metricsHandler := promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, metricsOpts),
+	)
+	if opts.Gatherer != nil {
+		metricsHandler = promhttp.HandlerFor(opts.Gatherer, metricsOpts)
+	}
+
+	h := &Handler{
+		config:          cfg,
+		metricsHandler:  metricsHandler,
+		stockClient:     opts.StockClient,
+		logger:          logger,
+		symbolValidator: symbolValidator,
+		apiRequests: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: cfg.MetricsNamespace,
+			Name:      "api_requests_total",
+			Help:      "Total number of API requests",
+		}),
+		apiDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: cfg.MetricsNamespace,
+				Name:      "api_request_duration_seconds",
+				Help:      "Duration of API requests in seconds, by route template and outcome (hit, miss, error or ok)",
+				Buckets:   cfg.LatencyBuckets,
+			},
+			[]string{"route", "outcome"},
+		),
+	}
+
+	if opts.Registerer != nil {
+		opts.Registerer.MustRegister(h.apiRequests, h.apiDuration)
+	}
+
+	return h
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// Match paths as sent rather than redirecting //30 to /30, so empty
+	// segments are rejected below instead of silently changing meaning
+	router.SkipClean(true)
+	router.NotFoundHandler = http.HandlerFunc(h.notFoundHandler)
+
 	// Health check endpoint
 	router.HandleFunc("/health", h.healthHandler).Methods("GET")
+
+	// Health check with optional per-component checks (?deep=true)
+	router.HandleFunc("/healthz", h.healthzHandler).Methods("GET")
 	
 	// Readiness check endpoint  
 	router.HandleFunc("/ready", h.readyHandler).Methods("GET")
 	
-	// Metrics endpoint
-	router.Handle("/metrics", promhttp.Handler())
+	// Metrics endpoint, authenticated with METRICS_AUTH_TOKEN when set
+	router.Handle("/metrics", middleware.MetricsAuth(h.config.MetricsAuthToken)(h.metricsHandler))
 
 	// Documentation
 	router.HandleFunc("/docs", func(w http.ResponseWriter, r *http.Request) {
@@ -52,6 +146,55 @@ func (h *Handler) RegisterRoutes(router *mux.Router) {
 		http.ServeFile(w, r, "./docs/swagger.yaml")
 	})
 
+	// Cache statistics
+	router.HandleFunc("/cache/stats", h.cacheStatsHandler).Methods("GET")
+
+	// Admin endpoints, authenticated with ADMIN_TOKEN
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.AdminAuth(h.config.AdminToken))
+	admin.HandleFunc("/circuitbreaker", h.circuitBreakerHandler).Methods("GET")
+	admin.HandleFunc("/circuitbreaker/reset", h.resetCircuitBreakerHandler).Methods("POST")
+	admin.HandleFunc("/cache/flush", h.flushCacheHandler).Methods("POST")
+	admin.HandleFunc("/cache/ttl", h.cacheTTLHandler).Methods("POST")
+	admin.HandleFunc("/degraded", h.degradedHandler).Methods("POST")
+
+	// Go runtime profiles, also authenticated with ADMIN_TOKEN. Without
+	// PPROF_ENABLED the paths aren't registered at all.
+	if h.config.PprofEnabled {
+		debug := router.PathPrefix("/debug/pprof").Subrouter()
+		debug.Use(middleware.AdminAuth(h.config.AdminToken))
+		debug.HandleFunc("/cmdline", pprof.Cmdline)
+		debug.HandleFunc("/profile", pprof.Profile)
+		debug.HandleFunc("/symbol", pprof.Symbol)
+		debug.HandleFunc("/trace", pprof.Trace)
+		// Index serves the named profiles, such as /debug/pprof/goroutine
+		debug.PathPrefix("/").HandlerFunc(pprof.Index)
+	}
+
+	// Most requested symbols; registered before /{symbol}
+	router.HandleFunc("/symbols", h.symbolsHandler).Methods("GET")
+
+	// Side-by-side comparison of two symbols; registered before /{symbol}
+	router.HandleFunc("/compare", h.compareHandler).Methods("GET")
+
+	// Newline-delimited JSON export of several symbols; registered before
+	// /{symbol}
+	router.HandleFunc("/export", h.exportHandler).Methods("GET")
+
+	// Latest quote; registered before /{symbol}/{days}, which it would
+	// otherwise match
+	router.HandleFunc("/quote/{symbol}", h.quoteHandler).Methods("GET")
+
+	// Websocket stream of price updates
+	router.HandleFunc("/stream/{symbol}", h.streamHandler).Methods("GET")
+
+	// Server-Sent Events stream of price updates
+	router.HandleFunc("/sse/{symbol}", h.sseHandler).Methods("GET")
+
+	// Paths with an empty or blank segment, e.g. //30, / /30 or /AAPL/;
+	// registered before the stock routes so they never match as a symbol
+	router.MatcherFunc(hasEmptySegment).HandlerFunc(h.emptySegmentHandler)
+
 	// Main stock endpoint
 	router.HandleFunc("/", h.stockHandler).Methods("GET")
 	
@@ -62,13 +205,58 @@ func (h *Handler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/{symbol}/{days}", h.stockSymbolDaysHandler).Methods("GET")
 }
 
+// hasEmptySegment reports whether any segment of the request path other
+// than the root itself is empty or only whitespace.
+func hasEmptySegment(r *http.Request, _ *mux.RouteMatch) bool {
+	if r.URL.Path == "/" {
+		return false
+	}
+	for _, segment := range strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/") {
+		if strings.TrimSpace(segment) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) emptySegmentHandler(w http.ResponseWriter, r *http.Request) {
+	h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+		Error:   "Empty path segment",
+		Details: fmt.Sprintf("path %q has an empty segment; use /{symbol} or /{symbol}/{days}", r.URL.Path),
+		Code:    CodeInvalidPath,
+	})
+}
+
+func (h *Handler) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	h.sendError(w, r, http.StatusNotFound, ErrorResponse{
+		Error:   "Not found",
+		Details: fmt.Sprintf("no route matches %s %s", r.Method, r.URL.Path),
+		Code:    CodeNotFound,
+	})
+}
+
+// Outcome labels for apiDuration. Stock endpoints report whether the data
+// came from the cache; the rest report ok unless they failed.
+const (
+	outcomeHit   = string(stock.CacheHit)
+	outcomeMiss  = string(stock.CacheMiss)
+	outcomeError = "error"
+	outcomeOK    = "ok"
+)
+
+// observe records a finished request under its route template rather than
+// the raw path, keeping symbols out of the label values. Handlers defer it
+// with a pointer to their outcome so the final value is recorded.
+func (h *Handler) observe(route string, start time.Time, outcome *string) {
+	h.apiDuration.WithLabelValues(route, *outcome).Observe(time.Since(start).Seconds())
+	h.apiRequests.Inc()
+}
+
 // Health check endpoint
 func (h *Handler) healthHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	defer func() {
-		h.apiDuration.Observe(time.Since(start).Seconds())
-		h.apiRequests.Inc()
-	}()
+	outcome := outcomeOK
+	defer h.observe("/health", start, &outcome)
 
 	response := map[string]interface{}{
 		"status":    "healthy",
@@ -79,17 +267,105 @@ func (h *Handler) healthHandler(w http.ResponseWriter, r *http.Request) {
 	h.sendJSON(w, http.StatusOK, response)
 }
 
+// Component health statuses reported by /healthz?deep=true. Only down
+// fails the check; a degraded component is reported but still serves.
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+)
+
+// HealthCheck is the result of one component check.
+type HealthCheck struct {
+	Status  string `json:"status"`
+	Details string `json:"details,omitempty"`
+}
+
+// DeepHealthResponse is returned by /healthz?deep=true. Status is the
+// worst status among Checks.
+type DeepHealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// Health check endpoint with an optional deep mode. Without ?deep=true it
+// answers like /health; with it the cache, upstream and circuit breaker are
+// checked and 503 is returned if any of them is down.
+func (h *Handler) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	outcome := outcomeOK
+	defer h.observe("/healthz", start, &outcome)
+
+	if deep, _ := strconv.ParseBool(r.URL.Query().Get("deep")); !deep {
+		h.sendJSON(w, http.StatusOK, map[string]interface{}{
+			"status":    "healthy",
+			"service":   "stock-service",
+			"timestamp": time.Now().Unix(),
+		})
+		return
+	}
+
+	response := h.deepHealth(r.Context())
+	if response.Status == healthDown {
+		outcome = outcomeError
+		h.sendJSON(w, http.StatusServiceUnavailable, response)
+		return
+	}
+	h.sendJSON(w, http.StatusOK, response)
+}
+
+// deepHealth runs the component checks. The upstream check only looks at
+// the last successful fetch, so it never calls the provider itself.
+func (h *Handler) deepHealth(ctx context.Context) DeepHealthResponse {
+	checks := make(map[string]HealthCheck, 3)
+
+	pingCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := h.stockClient.PingCache(pingCtx); err != nil {
+		checks["cache"] = HealthCheck{Status: healthDown, Details: err.Error()}
+	} else {
+		checks["cache"] = HealthCheck{Status: healthOK}
+	}
+
+	switch state := h.stockClient.CircuitBreakerState(); state {
+	case circuitbreaker.StateOpen:
+		checks["breaker"] = HealthCheck{Status: healthDown, Details: "circuit breaker is open"}
+	case circuitbreaker.StateHalfOpen:
+		checks["breaker"] = HealthCheck{Status: healthDegraded, Details: "circuit breaker is half-open"}
+	default:
+		checks["breaker"] = HealthCheck{Status: healthOK}
+	}
+
+	// A quiet period without fetches doesn't mean the provider is down
+	last := h.stockClient.LastSuccessAt()
+	switch {
+	case last.IsZero():
+		checks["upstream"] = HealthCheck{Status: healthDegraded, Details: "no successful fetch yet"}
+	case time.Since(last) > h.config.ReadyFreshness:
+		checks["upstream"] = HealthCheck{Status: healthDegraded, Details: fmt.Sprintf("last successful fetch %s ago", time.Since(last).Round(time.Second))}
+	default:
+		checks["upstream"] = HealthCheck{Status: healthOK}
+	}
+
+	status := healthOK
+	for _, check := range checks {
+		if check.Status == healthDown {
+			status = healthDown
+		} else if check.Status == healthDegraded && status == healthOK {
+			status = healthDegraded
+		}
+	}
+	return DeepHealthResponse{Status: status, Checks: checks}
+}
+
 // Readiness check endpoint
 func (h *Handler) readyHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	defer func() {
-		h.apiDuration.Observe(time.Since(start).Seconds())
-		h.apiRequests.Inc()
-	}()
+	outcome := outcomeOK
+	defer h.observe("/ready", start, &outcome)
 
-	// Check if we can get basic stock data (using default symbol)
-	_, err := h.stockClient.GetStockData(h.config.Symbol, 1, nil)
-	if err != nil {
+	if err := h.checkReady(r); err != nil {
+		outcome = outcomeError
 		h.logger.Warn("readiness check failed", zap.Error(err))
 		h.sendJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
 			"status":  "not ready",
@@ -108,85 +384,1027 @@ func (h *Handler) readyHandler(w http.ResponseWriter, r *http.Request) {
 	h.sendJSON(w, http.StatusOK, response)
 }
 
+// StartDraining makes /ready fail from now on. Call it when shutdown
+// begins, before the server stops accepting connections.
+func (h *Handler) StartDraining() {
+	h.draining.Store(true)
+}
+
+// checkReady reports whether the service can serve stock data. Probes run
+// every few seconds on every replica, so a recent successful fetch is taken
+// as proof; only when there is none is the default symbol fetched (usually
+// from the cache).
+func (h *Handler) checkReady(r *http.Request) error {
+	if h.draining.Load() {
+		return errors.New("server is shutting down")
+	}
+
+	if h.stockClient.CircuitBreakerState() == circuitbreaker.StateOpen {
+		return circuitbreaker.ErrCircuitBreakerOpen
+	}
+
+	if last := h.stockClient.LastSuccessAt(); !last.IsZero() && time.Since(last) <= h.config.ReadyFreshness {
+		return nil
+	}
+
+	// A probe must answer before the orchestrator gives up on it, however
+	// slow the upstream is
+	ctx := stock.WithCallTimeout(r.Context(), h.config.ReadyTimeout)
+	_, err := h.stockClient.GetStockData(ctx, h.config.Symbol, 1, stock.PeriodDaily, nil)
+	return err
+}
+
+// Cache statistics endpoint
+func (h *Handler) cacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	outcome := outcomeOK
+	defer h.observe("/cache/stats", start, &outcome)
+
+	h.sendJSON(w, http.StatusOK, h.stockClient.CacheStats())
+}
+
+// CircuitBreakerResponse is returned by /admin/circuitbreaker. A single
+// breaker guards every symbol. HalfOpenIn is only set while the breaker is
+// open and is zero once a probe call would be let through.
+type CircuitBreakerResponse struct {
+	State           string     `json:"state"`
+	FailureCount    int        `json:"failureCount"`
+	SuccessCount    int        `json:"successCount"`
+	LastFailureTime *time.Time `json:"lastFailureTime,omitempty"`
+	Timeout         string     `json:"timeout"`
+	HalfOpenIn      string     `json:"halfOpenIn,omitempty"`
+}
+
+// Default and maximum ?limit for /symbols.
+const (
+	defaultSymbolsLimit = 20
+	maxSymbolsLimit     = 100
+)
+
+// SymbolsResponse is returned by /symbols.
+type SymbolsResponse struct {
+	Symbols []stock.SymbolStats `json:"symbols"`
+}
+
+// Popularity endpoint - the most requested symbols and when they were last
+// requested
+func (h *Handler) symbolsHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	outcome := outcomeError
+	defer h.observe("/symbols", start, &outcome)
+
+	limit := defaultSymbolsLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > maxSymbolsLimit {
+			h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid limit",
+				Details: fmt.Sprintf("limit must be an integer between 1 and %d, got %q", maxSymbolsLimit, limitStr),
+				Code:    CodeInvalidLimit,
+			})
+			return
+		}
+		limit = parsed
+	}
+
+	outcome = outcomeOK
+	h.sendJSON(w, http.StatusOK, SymbolsResponse{Symbols: h.stockClient.TopSymbols(limit)})
+}
+
+// Admin endpoint - reports the circuit breaker state without touching it
+func (h *Handler) circuitBreakerHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	outcome := outcomeOK
+	defer h.observe("/admin/circuitbreaker", start, &outcome)
+
+	h.sendJSON(w, http.StatusOK, circuitBreakerResponse(h.stockClient.CircuitBreakerSnapshot(), time.Now()))
+}
+
+func circuitBreakerResponse(snapshot circuitbreaker.Snapshot, now time.Time) CircuitBreakerResponse {
+	resp := CircuitBreakerResponse{
+		State:        snapshot.State.String(),
+		FailureCount: snapshot.FailureCount,
+		SuccessCount: snapshot.SuccessCount,
+		Timeout:      snapshot.Timeout.String(),
+	}
+	if !snapshot.LastFailureTime.IsZero() {
+		resp.LastFailureTime = &snapshot.LastFailureTime
+	}
+	if halfOpenAt := snapshot.HalfOpenAt(); !halfOpenAt.IsZero() {
+		resp.HalfOpenIn = max(halfOpenAt.Sub(now), 0).Round(time.Second).String()
+	}
+	return resp
+}
+
+// Admin endpoint - closes the circuit breaker without waiting for the timeout
+func (h *Handler) resetCircuitBreakerHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	outcome := outcomeOK
+	defer h.observe("/admin/circuitbreaker/reset", start, &outcome)
+
+	h.stockClient.ResetCircuitBreaker()
+
+	h.sendJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "reset",
+		"state":  "closed",
+	})
+}
+
+// Admin endpoint - drops all cached stock data so the next requests refetch
+func (h *Handler) flushCacheHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	outcome := outcomeOK
+	defer h.observe("/admin/cache/flush", start, &outcome)
+
+	cleared := h.stockClient.ClearCache()
+
+	h.sendJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "flushed",
+		"cleared": cleared,
+	})
+}
+
+// maxTTLRequestBytes caps the body accepted by /admin/cache/ttl.
+const maxTTLRequestBytes = 1 << 10
+
+// Admin endpoint - sets the TTL of stock data cached from now on from a
+// {"ttl_seconds": N} body and reports the previous and new TTL. Entries
+// already cached keep their expiry.
+func (h *Handler) cacheTTLHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	outcome := outcomeError
+	defer h.observe("/admin/cache/ttl", start, &outcome)
+
+	var body struct {
+		TTLSeconds *int `json:"ttl_seconds"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTTLRequestBytes)).Decode(&body)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("body must be JSON such as {\"ttl_seconds\": 300}: %w", err)
+	case body.TTLSeconds == nil:
+		err = errors.New("ttl_seconds is required")
+	case *body.TTLSeconds < 1:
+		err = fmt.Errorf("ttl_seconds must be a positive integer, got %d", *body.TTLSeconds)
+	}
+	if err != nil {
+		h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid TTL",
+			Details: err.Error(),
+			Code:    CodeInvalidTTL,
+		})
+		return
+	}
+
+	ttl := time.Duration(*body.TTLSeconds) * time.Second
+	previous := h.stockClient.SetCacheTTL(ttl)
+
+	outcome = outcomeOK
+	h.sendJSON(w, http.StatusOK, map[string]interface{}{
+		"previous_ttl_seconds": int(previous.Seconds()),
+		"ttl_seconds":          int(ttl.Seconds()),
+	})
+}
+
+// Admin endpoint - flips degraded mode, or sets it from ?enabled, and
+// reports the new state
+func (h *Handler) degradedHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	outcome := outcomeError
+	defer h.observe("/admin/degraded", start, &outcome)
+
+	degraded := !h.stockClient.Degraded()
+	if enabled := r.URL.Query().Get("enabled"); enabled != "" {
+		var err error
+		degraded, err = strconv.ParseBool(enabled)
+		if err != nil {
+			h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid enabled",
+				Details: fmt.Sprintf("enabled must be true or false, got %q", enabled),
+				Code:    CodeInvalidEnabled,
+			})
+			return
+		}
+	}
+	h.stockClient.SetDegraded(degraded)
+
+	outcome = outcomeOK
+	h.sendJSON(w, http.StatusOK, map[string]interface{}{
+		"degraded": degraded,
+	})
+}
+
 // Main stock endpoint - uses default symbol from config
 func (h *Handler) stockHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	defer func() {
-		h.apiDuration.Observe(time.Since(start).Seconds())
-		h.apiRequests.Inc()
-	}()
+	outcome := outcomeError
+	defer h.observe("/", start, &outcome)
+
+	opts, err := parseQueryOptions(r)
+	if err != nil {
+		h.sendQueryOptionError(w, r, err, h.config.Symbol, h.config.NDays)
+		return
+	}
+
+	if len(h.config.Symbols) > 1 {
+		outcome = h.sendDefaultSymbols(w, r, opts)
+		return
+	}
 
 	h.logger.Info("fetching stock data",
 		zap.String("symbol", h.config.Symbol),
 		zap.Int("ndays", h.config.NDays))
 	
-	stockData, err := h.stockClient.GetStockData(h.config.Symbol, h.config.NDays, nil)
+	var info stock.ResultInfo
+	stockData, err := h.stockClient.GetStockData(stock.WithResultInfo(r.Context(), &info), h.config.Symbol, h.config.NDays, opts.period, nil)
 	if err != nil {
 		h.logger.Error("failed to get stock data", zap.Error(err))
-		h.sendError(w, http.StatusInternalServerError, "Failed to fetch stock data", err.Error())
+		h.sendFetchError(w, r, err, h.config.Symbol, h.config.NDays)
 		return
 	}
+	outcome = string(info.Cache)
+	setCacheHeaders(w, info)
+	stockData = h.convertCurrency(r, stockData, opts.currency)
 	
-	h.sendJSON(w, http.StatusOK, stockData)
+	h.sendStockData(w, r, orderPrices(stockData, opts.order))
+}
+
+// sendDefaultSymbols fetches every configured default symbol concurrently
+// and responds with their data as an array, in configuration order. It
+// returns the outcome to record for the request.
+func (h *Handler) sendDefaultSymbols(w http.ResponseWriter, r *http.Request, opts queryOptions) string {
+	symbols := h.config.Symbols
+	h.logger.Info("fetching stock data",
+		zap.Strings("symbols", symbols),
+		zap.Int("ndays", h.config.NDays))
+
+	var wg sync.WaitGroup
+	results := make([]*stock.StockData, len(symbols))
+	errs := make([]error, len(symbols))
+	infos := make([]stock.ResultInfo, len(symbols))
+	for i, symbol := range symbols {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := stock.WithResultInfo(r.Context(), &infos[i])
+			results[i], errs[i] = h.stockClient.GetStockData(ctx, symbol, h.config.NDays, opts.period, nil)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			h.logger.Error("failed to get stock data", zap.String("symbol", symbols[i]), zap.Error(err))
+			h.sendFetchError(w, r, err, symbols[i], h.config.NDays)
+			return outcomeError
+		}
+	}
+
+	outcome := outcomeHit
+	for _, info := range infos {
+		if info.Cache == stock.CacheMiss {
+			outcome = outcomeMiss
+		}
+	}
+	setCacheHeaders(w, infos...)
+
+	projected := make([]interface{}, len(results))
+	for i, stockData := range results {
+		var err error
+		projected[i], err = projectFields(orderPrices(h.convertCurrency(r, stockData, opts.currency), opts.order), opts.fields)
+		if err != nil {
+			h.logger.Error("failed to encode stock data", zap.Error(err))
+			h.sendError(w, r, http.StatusInternalServerError, ErrorResponse{
+				Error: "Failed to encode stock data",
+				Code:  CodeInternalError,
+			})
+			return outcomeError
+		}
+	}
+	h.sendJSON(w, http.StatusOK, projected)
+	return outcome
 }
 
 // Stock symbol endpoint - allows dynamic symbol selection
 func (h *Handler) stockSymbolHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	defer func() {
-		h.apiDuration.Observe(time.Since(start).Seconds())
-		h.apiRequests.Inc()
-	}()
+	outcome := outcomeError
+	defer h.observe("/{symbol}", start, &outcome)
 
 	vars := mux.Vars(r)
 	symbol := vars["symbol"]
-	
+
+	if err := h.symbolValidator.Validate(symbol); err != nil {
+		h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid symbol",
+			Details: err.Error(),
+			Code:    CodeInvalidSymbol,
+			Symbol:  symbol,
+		})
+		return
+	}
+
+	opts, err := parseQueryOptions(r)
+	if err != nil {
+		h.sendQueryOptionError(w, r, err, symbol, h.config.NDays)
+		return
+	}
+
 	h.logger.Info("fetching stock data for symbol",
 		zap.String("symbol", symbol),
 		zap.Int("ndays", h.config.NDays))
 	
-	stockData, err := h.stockClient.GetStockData(symbol, h.config.NDays, nil)
+	var info stock.ResultInfo
+	stockData, err := h.getStockData(r, &info, symbol, h.config.NDays, opts.period)
 	if err != nil {
 		h.logger.Error("failed to get stock data", zap.Error(err))
-		h.sendError(w, http.StatusInternalServerError, "Failed to fetch stock data", err.Error())
+		h.sendFetchError(w, r, err, symbol, h.config.NDays)
 		return
 	}
+	outcome = string(info.Cache)
+	setCacheHeaders(w, info)
+	stockData = h.convertCurrency(r, stockData, opts.currency)
 	
-	h.sendJSON(w, http.StatusOK, stockData)
+	h.sendStockData(w, r, orderPrices(stockData, opts.order))
+}
+
+// getStockData fetches symbol for a request, recording the result in info.
+// When the request sets ?fallback=true and the symbol isn't found, it
+// serves FALLBACK_SYMBOL instead, marked as a fallback. Any other error,
+// such as an open circuit breaker, is returned as is, and so is the
+// original error if the fallback fails too.
+func (h *Handler) getStockData(r *http.Request, info *stock.ResultInfo, symbol string, days int, period stock.Period) (*stock.StockData, error) {
+	ctx := stock.WithResultInfo(r.Context(), info)
+	stockData, err := h.stockClient.GetStockData(ctx, symbol, days, period, nil)
+
+	fallback := h.config.FallbackSymbol
+	wanted, _ := strconv.ParseBool(r.URL.Query().Get("fallback"))
+	if !errors.Is(err, stock.ErrSymbolNotFound) || !wanted || fallback == "" || fallback == ticker.Normalize(symbol) {
+		return stockData, err
+	}
+
+	h.logger.Info("symbol not found, serving fallback",
+		zap.String("symbol", symbol),
+		zap.String("fallback", fallback))
+	fallbackData, fallbackErr := h.stockClient.GetStockData(ctx, fallback, days, period, nil)
+	if fallbackErr != nil {
+		h.logger.Warn("fallback symbol failed", zap.String("fallback", fallback), zap.Error(fallbackErr))
+		return nil, err
+	}
+
+	marked := *fallbackData
+	marked.Fallback = true
+	return &marked, nil
+}
+
+// Quote endpoint - the symbol's latest price without fetching a series
+func (h *Handler) quoteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	outcome := outcomeError
+	defer h.observe("/quote/{symbol}", start, &outcome)
+
+	symbol := mux.Vars(r)["symbol"]
+	if err := h.symbolValidator.Validate(symbol); err != nil {
+		h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid symbol",
+			Details: err.Error(),
+			Code:    CodeInvalidSymbol,
+			Symbol:  symbol,
+		})
+		return
+	}
+
+	quote, err := h.stockClient.GetQuote(r.Context(), symbol)
+	if err != nil {
+		h.logger.Error("failed to get quote", zap.String("symbol", symbol), zap.Error(err))
+		h.sendFetchError(w, r, err, symbol, 0)
+		return
+	}
+
+	outcome = outcomeOK
+	h.sendJSON(w, http.StatusOK, quote)
 }
 
 // Stock symbol with days endpoint - allows both dynamic symbol and days
 func (h *Handler) stockSymbolDaysHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	defer func() {
-		h.apiDuration.Observe(time.Since(start).Seconds())
-		h.apiRequests.Inc()
-	}()
+	outcome := outcomeError
+	defer h.observe("/{symbol}/{days}", start, &outcome)
 
 	vars := mux.Vars(r)
 	symbol := vars["symbol"]
 	daysStr := vars["days"]
-	
+
+	if err := h.symbolValidator.Validate(symbol); err != nil {
+		h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid symbol",
+			Details: err.Error(),
+			Code:    CodeInvalidSymbol,
+			Symbol:  symbol,
+		})
+		return
+	}
+
 	// Parse days
-	days := h.config.NDays // default to config value
-	if daysStr != "" {
-		if parsedDays, err := strconv.Atoi(daysStr); err == nil {
-			days = parsedDays
-		}
+	days, err := h.parseDays(daysStr)
+	if err != nil {
+		h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid days",
+			Details: err.Error(),
+			Code:    CodeInvalidDays,
+			Symbol:  symbol,
+		})
+		return
 	}
 	
+	opts, err := parseQueryOptions(r)
+	if err != nil {
+		h.sendQueryOptionError(w, r, err, symbol, days)
+		return
+	}
+
+	indicator := r.URL.Query().Get("indicator")
+	window := defaultIndicatorWindow
+	if indicator != "" {
+		window, err = parseIndicatorParams(indicator, r.URL.Query().Get("window"), days)
+		if err != nil {
+			h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid indicator",
+				Details: err.Error(),
+				Code:    CodeInvalidIndicator,
+				Symbol:  symbol,
+				Days:    days,
+			})
+			return
+		}
+	}
+
+	page, err := parsePage(r.URL.Query(), days)
+	if err == nil && page != nil && indicator != "" {
+		err = fmt.Errorf("limit and offset can't be combined with indicator")
+	}
+	if err != nil {
+		h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid page",
+			Details: err.Error(),
+			Code:    CodeInvalidPage,
+			Symbol:  symbol,
+			Days:    days,
+		})
+		return
+	}
+
 	h.logger.Info("fetching stock data for symbol with days",
 		zap.String("symbol", symbol),
 		zap.Int("ndays", days))
 	
-	stockData, err := h.stockClient.GetStockData(symbol, days, nil)
+	var info stock.ResultInfo
+	stockData, err := h.getStockData(r, &info, symbol, days, opts.period)
 	if err != nil {
 		h.logger.Error("failed to get stock data", zap.Error(err))
-		h.sendError(w, http.StatusInternalServerError, "Failed to fetch stock data", err.Error())
+		h.sendFetchError(w, r, err, symbol, days)
 		return
 	}
-	
-	h.sendJSON(w, http.StatusOK, stockData)
+	outcome = string(info.Cache)
+	setCacheHeaders(w, info)
+	stockData = h.convertCurrency(r, stockData, opts.currency)
+
+	if indicator != "" {
+		// days bounds the window, but the provider can return fewer
+		// points than requested, e.g. over weekends and holidays
+		if window > len(stockData.Prices) {
+			h.sendError(w, r, http.StatusUnprocessableEntity, ErrorResponse{
+				Error:   "Not enough data",
+				Details: fmt.Sprintf("window %d needs at least %d prices, but only %d were returned", window, window, len(stockData.Prices)),
+				Code:    CodeInsufficientData,
+				Symbol:  symbol,
+				Days:    days,
+			})
+			return
+		}
+		values, err := stock.ComputeIndicator(indicator, stockData.Prices, window)
+		if err != nil {
+			h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid indicator",
+				Details: err.Error(),
+				Code:    CodeInvalidIndicator,
+				Symbol:  symbol,
+				Days:    days,
+			})
+			return
+		}
+		h.sendJSON(w, http.StatusOK, IndicatorResponse{
+			Symbol:    stockData.Symbol,
+			Indicator: indicator,
+			Window:    window,
+			Values:    values,
+		})
+		return
+	}
+
+	stockData = orderPrices(stockData, opts.order)
+	if page != nil {
+		stockData, err = paginatePrices(stockData, *page)
+		if err != nil {
+			h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid page",
+				Details: err.Error(),
+				Code:    CodeInvalidPage,
+				Symbol:  symbol,
+				Days:    days,
+			})
+			return
+		}
+	}
+
+	h.sendStockData(w, r, stockData)
+}
+
+// CompareResponse is returned by /compare. The deltas are A minus B.
+type CompareResponse struct {
+	A                  *stock.StockData `json:"a"`
+	B                  *stock.StockData `json:"b"`
+	AvgDelta           float64          `json:"avgDelta"`
+	ChangeDeltaPercent float64          `json:"changeDeltaPercent"`
+}
+
+// Compare endpoint - fetches two symbols over the same window concurrently
+func (h *Handler) compareHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	outcome := outcomeError
+	defer h.observe("/compare", start, &outcome)
+
+	query := r.URL.Query()
+	symbols := []string{query.Get("a"), query.Get("b")}
+	for _, symbol := range symbols {
+		if err := h.symbolValidator.Validate(symbol); err != nil {
+			h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid symbol",
+				Details: err.Error(),
+				Code:    CodeInvalidSymbol,
+				Symbol:  symbol,
+			})
+			return
+		}
+	}
+
+	days := h.config.NDays
+	if daysStr := query.Get("days"); daysStr != "" {
+		var err error
+		days, err = h.parseDays(daysStr)
+		if err != nil {
+			h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid days",
+				Details: err.Error(),
+				Code:    CodeInvalidDays,
+			})
+			return
+		}
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*stock.StockData, len(symbols))
+	errs := make([]error, len(symbols))
+	infos := make([]stock.ResultInfo, len(symbols))
+	for i, symbol := range symbols {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := stock.WithResultInfo(r.Context(), &infos[i])
+			results[i], errs[i] = h.stockClient.GetStockData(ctx, symbol, days, stock.PeriodDaily, nil)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			h.logger.Error("failed to get stock data", zap.String("symbol", symbols[i]), zap.Error(err))
+			h.sendFetchError(w, r, err, symbols[i], days)
+			return
+		}
+	}
+
+	outcome = outcomeHit
+	for _, info := range infos {
+		if info.Cache == stock.CacheMiss {
+			outcome = outcomeMiss
+		}
+	}
+	setCacheHeaders(w, infos...)
+
+	h.sendJSON(w, http.StatusOK, compareStockData(results[0], results[1]))
+}
+
+// compareStockData describes how a's average and percentage move differ
+// from b's.
+func compareStockData(a, b *stock.StockData) CompareResponse {
+	return CompareResponse{
+		A:                  a,
+		B:                  b,
+		AvgDelta:           a.Average - b.Average,
+		ChangeDeltaPercent: a.ChangePercent - b.ChangePercent,
+	}
+}
+
+// queryOptions are the query parameters shared by the stock endpoints.
+type queryOptions struct {
+	period   stock.Period
+	order    string
+	currency string
+	fields   []string
+}
+
+// queryOptionError is returned by parseQueryOptions for an invalid
+// parameter, with the message and code to respond with.
+type queryOptionError struct {
+	message string
+	code    string
+	err     error
+}
+
+func (e *queryOptionError) Error() string { return e.err.Error() }
+
+func (e *queryOptionError) Unwrap() error { return e.err }
+
+// parseQueryOptions reads ?period, ?order, ?currency and ?fields. An
+// invalid parameter is reported as a *queryOptionError.
+func parseQueryOptions(r *http.Request) (queryOptions, error) {
+	query := r.URL.Query()
+	var opts queryOptions
+	var err error
+
+	if opts.period, err = parsePeriod(query); err != nil {
+		return queryOptions{}, &queryOptionError{message: "Invalid period", code: CodeInvalidPeriod, err: err}
+	}
+	if opts.order, err = parseOrder(query.Get("order")); err != nil {
+		return queryOptions{}, &queryOptionError{message: "Invalid order", code: CodeInvalidOrder, err: err}
+	}
+	if opts.currency, err = parseCurrency(query.Get("currency")); err != nil {
+		return queryOptions{}, &queryOptionError{message: "Invalid currency", code: CodeInvalidCurrency, err: err}
+	}
+	if opts.fields, err = parseFields(query.Get("fields")); err != nil {
+		return queryOptions{}, &queryOptionError{message: "Invalid fields", code: CodeInvalidFields, err: err}
+	}
+	return opts, nil
+}
+
+// sendQueryOptionError responds with a 400 for an error returned by
+// parseQueryOptions.
+func (h *Handler) sendQueryOptionError(w http.ResponseWriter, r *http.Request, err error, symbol string, days int) {
+	var optErr *queryOptionError
+	errors.As(err, &optErr)
+	h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+		Error:   optErr.message,
+		Details: optErr.Error(),
+		Code:    optErr.code,
+		Symbol:  symbol,
+		Days:    days,
+	})
+}
+
+// parsePeriod reads the series period from ?period or, for intraday data,
+// ?interval. The two are mutually exclusive. ?adjusted=true selects
+// adjusted closes and is only available for the daily series.
+func parsePeriod(query url.Values) (stock.Period, error) {
+	period, err := parseBasePeriod(query)
+	if err != nil {
+		return "", err
+	}
+
+	adjusted := query.Get("adjusted")
+	if adjusted == "" {
+		return period, nil
+	}
+	wantAdjusted, err := strconv.ParseBool(adjusted)
+	if err != nil {
+		return "", fmt.Errorf("adjusted must be true or false, got %q", adjusted)
+	}
+	if !wantAdjusted {
+		return period, nil
+	}
+	if period != stock.PeriodDaily {
+		return "", fmt.Errorf("adjusted is only supported for the daily series")
+	}
+	return stock.PeriodDailyAdjusted, nil
+}
+
+func parseBasePeriod(query url.Values) (stock.Period, error) {
+	interval := query.Get("interval")
+	if interval == "" {
+		return stock.ParsePeriod(query.Get("period"))
+	}
+	if query.Get("period") != "" {
+		return "", fmt.Errorf("period and interval can't be combined")
+	}
+	return stock.ParseInterval(interval)
+}
+
+// defaultIndicatorWindow is the moving-average window used when the
+// request doesn't specify one.
+const defaultIndicatorWindow = 20
+
+// IndicatorResponse is returned by /{symbol}/{days}?indicator=sma|ema.
+// Values are newest first, like StockData.Prices.
+type IndicatorResponse struct {
+	Symbol    string                 `json:"symbol"`
+	Indicator string                 `json:"indicator"`
+	Window    int                    `json:"window"`
+	Values    []stock.IndicatorPoint `json:"values"`
+}
+
+// parseIndicatorParams validates the indicator name and window for a
+// request covering days.
+func parseIndicatorParams(indicator, windowStr string, days int) (int, error) {
+	if indicator != stock.IndicatorSMA && indicator != stock.IndicatorEMA {
+		return 0, fmt.Errorf("indicator must be %s or %s, got %q", stock.IndicatorSMA, stock.IndicatorEMA, indicator)
+	}
+
+	window := defaultIndicatorWindow
+	if windowStr != "" {
+		parsed, err := strconv.Atoi(windowStr)
+		if err != nil {
+			return 0, fmt.Errorf("window must be an integer, got %q", windowStr)
+		}
+		window = parsed
+	}
+	if window < 1 || window > days {
+		return 0, fmt.Errorf("window must be between 1 and days (%d), got %d", days, window)
+	}
+
+	return window, nil
+}
+
+// parseDays validates the days path parameter against 1..config.MaxDays.
+func (h *Handler) parseDays(daysStr string) (int, error) {
+	days, err := strconv.Atoi(daysStr)
+	if err != nil {
+		return 0, fmt.Errorf("days must be an integer, got %q", daysStr)
+	}
+	if days < 1 || days > h.config.MaxDays {
+		return 0, fmt.Errorf("days must be between 1 and %d, got %d", h.config.MaxDays, days)
+	}
+	return days, nil
+}
+
+// Orders accepted by ?order. Prices are newest first unless asc is asked for.
+const (
+	orderAsc  = "asc"
+	orderDesc = "desc"
+)
+
+// parseOrder validates the order query parameter; empty means desc.
+func parseOrder(order string) (string, error) {
+	switch order {
+	case "", orderDesc:
+		return orderDesc, nil
+	case orderAsc:
+		return orderAsc, nil
+	default:
+		return "", fmt.Errorf("order must be %s or %s, got %q", orderAsc, orderDesc, order)
+	}
+}
+
+// orderPrices returns stockData with its prices in order. Results are shared
+// with the cache, so ascending order is returned as a copy; the summary
+// statistics are computed chronologically and don't change.
+func orderPrices(stockData *stock.StockData, order string) *stock.StockData {
+	if order != orderAsc {
+		return stockData
+	}
+
+	ordered := *stockData
+	ordered.Prices = make([]stock.PricePoint, len(stockData.Prices))
+	for i, p := range stockData.Prices {
+		ordered.Prices[len(stockData.Prices)-1-i] = p
+	}
+	return &ordered
+}
+
+// parseCurrency validates the currency query parameter, an ISO 4217 code
+// such as EUR. Empty means BaseCurrency.
+func parseCurrency(currency string) (string, error) {
+	if currency == "" {
+		return stock.BaseCurrency, nil
+	}
+	currency = strings.ToUpper(currency)
+	if len(currency) != 3 || strings.Trim(currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", fmt.Errorf("currency must be a three-letter code such as EUR, got %q", currency)
+	}
+	return currency, nil
+}
+
+// convertCurrency converts stockData into currency, rounding the result
+// to PRICE_PRECISION again. If the exchange rate can't be looked up the
+// data is served unconverted, in BaseCurrency, and the failure only
+// logged.
+func (h *Handler) convertCurrency(r *http.Request, stockData *stock.StockData, currency string) *stock.StockData {
+	if currency == stock.BaseCurrency {
+		return stockData
+	}
+
+	rate, err := h.stockClient.GetExchangeRate(r.Context(), currency)
+	if err != nil {
+		h.logger.Warn("currency conversion failed, serving base currency",
+			zap.String("request_id", requestid.FromContext(r.Context())),
+			zap.String("currency", currency),
+			zap.Error(err))
+		return stockData
+	}
+	converted := stock.ConvertCurrency(stockData, currency, rate)
+	if h.config.PricePrecision > 0 {
+		converted = stock.RoundPrices(converted, h.config.PricePrecision)
+	}
+	return converted
+}
+
+// pageParams is a page of prices requested with ?limit and ?offset.
+type pageParams struct {
+	limit  int
+	offset int
+}
+
+// parsePage reads ?limit and ?offset, returning nil when neither is set.
+// limit defaults to the rest of the window, and both are checked against
+// days since the series can't be longer than that.
+func parsePage(query url.Values, days int) (*pageParams, error) {
+	limitStr, offsetStr := query.Get("limit"), query.Get("offset")
+	if limitStr == "" && offsetStr == "" {
+		return nil, nil
+	}
+
+	page := &pageParams{}
+	if offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil {
+			return nil, fmt.Errorf("offset must be an integer, got %q", offsetStr)
+		}
+		if offset < 0 || offset >= days {
+			return nil, fmt.Errorf("offset must be between 0 and %d, got %d", days-1, offset)
+		}
+		page.offset = offset
+	}
+
+	page.limit = days - page.offset
+	if limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			return nil, fmt.Errorf("limit must be an integer, got %q", limitStr)
+		}
+		if limit < 1 || limit > days {
+			return nil, fmt.Errorf("limit must be between 1 and days (%d), got %d", days, limit)
+		}
+		page.limit = limit
+	}
+
+	return page, nil
+}
+
+// paginatePrices returns a copy of stockData holding only the requested
+// page of prices. The summary statistics are left as computed over the
+// whole window. An offset past the end of the series is an error, except
+// for the first page of an empty one.
+func paginatePrices(stockData *stock.StockData, page pageParams) (*stock.StockData, error) {
+	total := len(stockData.Prices)
+	if page.offset > 0 && page.offset >= total {
+		return nil, fmt.Errorf("offset %d is beyond the %d available prices", page.offset, total)
+	}
+
+	end := min(page.offset+page.limit, total)
+	paged := *stockData
+	paged.Prices = stockData.Prices[page.offset:end]
+	paged.Pagination = &stock.Pagination{
+		Total:  total,
+		Limit:  page.limit,
+		Offset: page.offset,
+	}
+	if end < total {
+		paged.Pagination.NextOffset = &end
+	}
+	return &paged, nil
+}
+
+// sendStockData writes stockData as CSV when the client asks for it via
+// ?format=csv or an Accept header, and as JSON otherwise. JSON is limited
+// to the fields listed in ?fields, if any.
+//
+// The body is buffered to derive a weak ETag from it, so identical data
+// gets the same tag across replicas and a matching If-None-Match is
+// answered with 304. Source is left out of the tag: it only says whether
+// this copy came from the cache or a provider, so the cache hits that
+// follow a miss must match the miss's tag.
+func (h *Handler) sendStockData(w http.ResponseWriter, r *http.Request, stockData *stock.StockData) {
+	body, err := encodeStockData(r, stockData)
+	var etag string
+	if err == nil {
+		unsourced := *stockData
+		unsourced.Source = ""
+		var tagged []byte
+		tagged, err = encodeStockData(r, &unsourced)
+		etag = computeETag(tagged)
+	}
+	if err != nil {
+		h.logger.Error("failed to encode stock data", zap.Error(err))
+		h.sendError(w, r, http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to encode stock data",
+			Code:  CodeInternalError,
+		})
+		return
+	}
+
+	if wantsCSV(r) {
+		filename := fmt.Sprintf("%s-%dd.csv", stockData.Symbol, stockData.NDays)
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Disposition")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// encodeStockData renders stockData as CSV when the client asks for it
+// and as JSON limited to ?fields otherwise.
+func encodeStockData(r *http.Request, stockData *stock.StockData) ([]byte, error) {
+	if wantsCSV(r) {
+		return encodeCSV(stockData)
+	}
+
+	// Handlers have already rejected invalid fields
+	fields, _ := parseFields(r.URL.Query().Get("fields"))
+	payload, err := projectFields(stockData, fields)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return append(body, '\n'), nil
+}
+
+// computeETag returns a weak entity tag for body. It is weak because
+// bodies with the same tag may still differ in Source.
+func computeETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+// Weak comparison is used, as RFC 9110 requires for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func wantsCSV(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return strings.EqualFold(format, "csv")
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/csv")
+}
+
+// encodeCSV renders the price series followed by an average row.
+func encodeCSV(stockData *stock.StockData) ([]byte, error) {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write([]string{"date", "open", "high", "low", "close", "volume"})
+	for _, p := range stockData.Prices {
+		cw.Write([]string{
+			p.Date,
+			formatPrice(p.Open),
+			formatPrice(p.High),
+			formatPrice(p.Low),
+			formatPrice(p.Close),
+			strconv.FormatInt(p.Volume, 10),
+		})
+	}
+	cw.Write([]string{"average", "", "", "", formatPrice(stockData.Average), ""})
+	cw.Flush()
+
+	return buf.Bytes(), cw.Error()
+}
+
+func formatPrice(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
 }
 
 func (h *Handler) sendJSON(w http.ResponseWriter, statusCode int, data interface{}) {
@@ -198,13 +1416,85 @@ func (h *Handler) sendJSON(w http.ResponseWriter, statusCode int, data interface
 	}
 }
 
-func (h *Handler) sendError(w http.ResponseWriter, statusCode int, message, details string) {
-	errorResponse := map[string]interface{}{
-		"error":   message,
-		"details": details,
-		"symbol":  h.config.Symbol,
-		"ndays":   h.config.NDays,
+// sendError writes errorResponse with statusCode. The Error summary is
+// translated for the request's Accept-Language when the catalog has it;
+// Code never is.
+func (h *Handler) sendError(w http.ResponseWriter, r *http.Request, statusCode int, errorResponse ErrorResponse) {
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Del("Age")
+	w.Header().Add("Vary", "Accept-Language")
+	if lang := negotiateLanguage(r.Header.Get("Accept-Language")); lang != "" {
+		if localized := localizeMessage(lang, errorResponse.Error); localized != errorResponse.Error {
+			errorResponse.Error = localized
+			w.Header().Set("Content-Language", lang)
+		}
 	}
-	
+	errorResponse.RequestID = requestid.FromContext(r.Context())
 	h.sendJSON(w, statusCode, errorResponse)
+}
+
+// setCacheHeaders tells HTTP caches how long a response built from results
+// stays fresh: until the first of them expires from our cache. Age is only
+// sent when a result came from the cache, and stale data is never cached.
+func setCacheHeaders(w http.ResponseWriter, results ...stock.ResultInfo) {
+	var ttl, age time.Duration
+	hit := false
+	for i, info := range results {
+		if i == 0 || info.TTL < ttl {
+			ttl = info.TTL
+		}
+		if info.Cache == stock.CacheHit {
+			hit = true
+			age = max(age, info.Age)
+		}
+	}
+
+	if ttl <= 0 {
+		w.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(ttl.Seconds())))
+	if hit {
+		w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+	}
+}
+
+// upstreamRateLimitWindow is how long clients are told to wait after the
+// provider throttled us; Alpha Vantage quotas are per minute.
+const upstreamRateLimitWindow = time.Minute
+
+// circuitRetryAfter returns the seconds, rounded up, until the open
+// circuit breaker lets a probe through, which backoff can stretch well
+// past CircuitBreakerTimeout. A half-open breaker is already probing, so
+// clients are told to wait the base timeout.
+func (h *Handler) circuitRetryAfter() int {
+	snapshot := h.stockClient.CircuitBreakerSnapshot()
+	halfOpenAt := snapshot.HalfOpenAt()
+	if halfOpenAt.IsZero() {
+		return int(h.config.CircuitBreakerTimeout.Seconds())
+	}
+	wait := halfOpenAt.Sub(snapshot.At)
+	return max(int((wait+time.Second-1)/time.Second), 1)
+}
+
+// sendFetchError reports a failed GetStockData call for symbol and days.
+func (h *Handler) sendFetchError(w http.ResponseWriter, r *http.Request, err error, symbol string, days int) {
+	statusCode, code := classifyFetchError(err)
+	message := "Failed to fetch stock data"
+	switch code {
+	case CodeCircuitOpen:
+		w.Header().Set("Retry-After", strconv.Itoa(h.circuitRetryAfter()))
+	case CodeRateLimited:
+		w.Header().Set("Retry-After", strconv.Itoa(int(upstreamRateLimitWindow.Seconds())))
+	case CodeSymbolBlocked:
+		// Nothing was fetched, so say why instead
+		message = "Symbol not available"
+	}
+	h.sendError(w, r, statusCode, ErrorResponse{
+		Error:   message,
+		Details: err.Error(),
+		Code:    code,
+		Symbol:  symbol,
+		Days:    days,
+	})
 }
\ No newline at end of file
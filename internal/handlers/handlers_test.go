@@ -1,16 +1,31 @@
 package handlers
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/cache"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/config"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/middleware"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/requestid"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/stock"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
@@ -23,10 +38,11 @@ var (
 func setupTestHandler() (*Handler, *config.Config) {
 	once.Do(func() {
 		cfg := &config.Config{
-			Port:   "8080",
-			Symbol: "MSFT",
-			NDays:  7,
-			APIKey: "test-key",
+			Port:    "8080",
+			Symbol:  "MSFT",
+			NDays:   7,
+			MaxDays: 1000,
+			APIKey:  "test-key",
 		}
 		
 		logger := zap.NewNop()
@@ -34,17 +50,11 @@ func setupTestHandler() (*Handler, *config.Config) {
 		// Create a mock stock client that doesn't panic
 		stockClient := &stock.Client{}
 		
-		// Create test metrics
-		apiRequests := prometheus.NewCounter(prometheus.CounterOpts{
-			Name: "test_api_requests_total",
-			Help: "Test API requests",
+		testHandler = New(HandlerOptions{
+			Config:      cfg,
+			StockClient: stockClient,
+			Logger:      logger,
 		})
-		apiDuration := prometheus.NewHistogram(prometheus.HistogramOpts{
-			Name: "test_api_duration_seconds",
-			Help: "Test API duration",
-		})
-		
-		testHandler = NewHandler(cfg, stockClient, logger, apiRequests, apiDuration)
 		testConfig = cfg
 	})
 	
@@ -79,4 +89,1822 @@ func TestHealthHandler(t *testing.T) {
 	if status, ok := response["status"].(string); !ok || status != "healthy" {
 		t.Errorf("Expected status 'healthy', got %v", response["status"])
 	}
-}
\ No newline at end of file
+}
+
+func TestSendStockDataCSV(t *testing.T) {
+	handler, _ := setupTestHandler()
+
+	stockData := &stock.StockData{
+		Symbol: "AAPL",
+		NDays:  2,
+		Prices: []stock.PricePoint{
+			{Date: "2024-01-19", Open: 189.33, High: 191.95, Low: 188.82, Close: 191.56, Volume: 68741000},
+			{Date: "2024-01-18", Open: 186.09, High: 189.14, Low: 185.83, Close: 188.63, Volume: 78722000},
+		},
+		Average: 190.095,
+	}
+
+	for name, req := range map[string]*http.Request{
+		"query":  httptest.NewRequest("GET", "/AAPL/2?format=csv", nil),
+		"accept": httptest.NewRequest("GET", "/AAPL/2", nil),
+	} {
+		if name == "accept" {
+			req.Header.Set("Accept", "text/csv")
+		}
+
+		rr := httptest.NewRecorder()
+		handler.sendStockData(rr, req, stockData)
+
+		if ct := rr.Header().Get("Content-Type"); ct != "text/csv" {
+			t.Errorf("%s: expected Content-Type text/csv, got %q", name, ct)
+		}
+		if cd := rr.Header().Get("Content-Disposition"); cd != `attachment; filename="AAPL-2d.csv"` {
+			t.Errorf("%s: unexpected Content-Disposition %q", name, cd)
+		}
+
+		expected := "date,open,high,low,close,volume\n" +
+			"2024-01-19,189.33,191.95,188.82,191.56,68741000\n" +
+			"2024-01-18,186.09,189.14,185.83,188.63,78722000\n" +
+			"average,,,,190.095,\n"
+		if body := rr.Body.String(); body != expected {
+			t.Errorf("%s: unexpected CSV body:\n%s", name, body)
+		}
+	}
+}
+
+func TestSendStockDataDefaultsToJSON(t *testing.T) {
+	handler, _ := setupTestHandler()
+
+	req := httptest.NewRequest("GET", "/AAPL/2", nil)
+	rr := httptest.NewRecorder()
+	handler.sendStockData(rr, req, &stock.StockData{Symbol: "AAPL"})
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got %q", ct)
+	}
+}
+
+func TestStockSymbolDaysHandlerInvalidDays(t *testing.T) {
+	handler, _ := setupTestHandler()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{symbol}/{days}", handler.stockSymbolDaysHandler)
+
+	for _, path := range []string{"/AAPL/abc", "/AAPL/invalid", "/AAPL/0", "/AAPL/-5", "/AAPL/1001", "/AAPL/2.5"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusBadRequest, rr.Code)
+			continue
+		}
+
+		var response ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != CodeInvalidDays {
+			t.Errorf("%s: expected code %s, got %s", path, CodeInvalidDays, response.Code)
+		}
+		if response.Symbol != "AAPL" {
+			t.Errorf("%s: expected error to reference requested symbol AAPL, got %q", path, response.Symbol)
+		}
+	}
+}
+
+func TestStockHandlersRejectInvalidSymbol(t *testing.T) {
+	handler, _ := setupTestHandler()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{symbol}", handler.stockSymbolHandler)
+	router.HandleFunc("/{symbol}/{days}", handler.stockSymbolDaysHandler)
+
+	for _, path := range []string{"/INVALID_SYMBOL_12345", "/INVALID_SYMBOL_12345/30"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusBadRequest, rr.Code)
+			continue
+		}
+
+		var response ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != CodeInvalidSymbol {
+			t.Errorf("%s: expected code %s, got %s", path, CodeInvalidSymbol, response.Code)
+		}
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	handler, _ := setupTestHandler()
+
+	for _, input := range []string{"1", "30", "1000"} {
+		if _, err := handler.parseDays(input); err != nil {
+			t.Errorf("parseDays(%q): unexpected error: %v", input, err)
+		}
+	}
+}
+
+func TestClassifyFetchError(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{circuitbreaker.ErrCircuitBreakerOpen, http.StatusServiceUnavailable, CodeCircuitOpen},
+		{fmt.Errorf("wrapped: %w", circuitbreaker.ErrCircuitBreakerOpen), http.StatusServiceUnavailable, CodeCircuitOpen},
+		{fmt.Errorf("%w: Alpha Vantage API returned status 500", stock.ErrUpstream), http.StatusBadGateway, CodeUpstreamError},
+		{fmt.Errorf("%w: Alpha Vantage API error: Invalid API call", stock.ErrSymbolNotFound), http.StatusNotFound, CodeSymbolNotFound},
+		{fmt.Errorf("%w: adjusted close requires a premium Alpha Vantage key", stock.ErrNotEntitled), http.StatusForbidden, CodeNotEntitled},
+		{fmt.Errorf("%w: This is a premium endpoint", stock.ErrPremiumRequired), http.StatusPaymentRequired, CodePremiumRequired},
+		{fmt.Errorf("%w: AAPL for 7 days", stock.ErrDegradedModeMiss), http.StatusServiceUnavailable, CodeDegradedMode},
+		{fmt.Errorf("%w: AAPL is restricted and can't be served", stock.ErrSymbolBlocked), http.StatusForbidden, CodeSymbolBlocked},
+		{fmt.Errorf("%w: The **demo** API key is for demo purposes only", stock.ErrDemoKey), http.StatusBadRequest, CodeDemoKey},
+		{errors.New("unexpected"), http.StatusInternalServerError, CodeInternalError},
+	}
+
+	for _, tt := range tests {
+		status, code := classifyFetchError(tt.err)
+		if status != tt.wantStatus || code != tt.wantCode {
+			t.Errorf("classifyFetchError(%v) = %d %s, want %d %s", tt.err, status, code, tt.wantStatus, tt.wantCode)
+		}
+	}
+}
+
+// fakeClock is a clock.Clock that only moves when advanced.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestSendFetchErrorCircuitOpenRetryAfter(t *testing.T) {
+	clk := &fakeClock{now: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)}
+	cb := circuitbreaker.NewCircuitBreaker(1, 1, 30*time.Second)
+	cb.SetClock(clk)
+	cb.SetBackoffMax(5 * time.Minute)
+	stockClient := stock.NewClient(stock.ClientOptions{
+		Providers:      []stock.StockProvider{&stubProvider{}},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: cb,
+	})
+	cfg := &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000, CircuitBreakerTimeout: 30 * time.Second}
+	handler := New(HandlerOptions{Config: cfg, StockClient: stockClient})
+
+	retryAfter := func() string {
+		rr := httptest.NewRecorder()
+		handler.sendFetchError(rr, httptest.NewRequest("GET", "/AAPL/7", nil), circuitbreaker.ErrCircuitBreakerOpen, "AAPL", 7)
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+		}
+		return rr.Header().Get("Retry-After")
+	}
+	fail := func() error { return errors.New("upstream down") }
+
+	cb.Call(fail)
+	if got := retryAfter(); got != "30" {
+		t.Errorf("expected Retry-After 30 after the first open, got %q", got)
+	}
+	clk.Advance(10 * time.Second)
+	if got := retryAfter(); got != "20" {
+		t.Errorf("expected Retry-After to count down to 20, got %q", got)
+	}
+
+	// A failed probe doubles the open duration
+	clk.Advance(21 * time.Second)
+	cb.Call(fail)
+	if got := retryAfter(); got != "60" {
+		t.Errorf("expected Retry-After 60 after the second open, got %q", got)
+	}
+}
+
+func TestSendFetchErrorRateLimited(t *testing.T) {
+	handler, _ := setupTestHandler()
+
+	rr := httptest.NewRecorder()
+	err := fmt.Errorf("%w: Alpha Vantage API: call frequency exceeded", stock.ErrRateLimited)
+	handler.sendFetchError(rr, httptest.NewRequest("GET", "/AAPL/7", nil), err, "AAPL", 7)
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if retryAfter := rr.Header().Get("Retry-After"); retryAfter != "60" {
+		t.Errorf("expected Retry-After 60, got %q", retryAfter)
+	}
+
+	var resp ErrorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Code != CodeRateLimited {
+		t.Errorf("expected code %s, got %s", CodeRateLimited, resp.Code)
+	}
+}
+
+func TestParseIndicatorParams(t *testing.T) {
+	tests := []struct {
+		indicator, window string
+		days              int
+		want              int
+		wantErr           bool
+	}{
+		{"sma", "10", 50, 10, false},
+		{"ema", "", 50, defaultIndicatorWindow, false},
+		{"sma", "50", 50, 50, false},
+		{"sma", "51", 50, 0, true},
+		{"ema", "", 10, 0, true},
+		{"sma", "0", 50, 0, true},
+		{"sma", "abc", 50, 0, true},
+		{"macd", "10", 50, 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseIndicatorParams(tt.indicator, tt.window, tt.days)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseIndicatorParams(%q, %q, %d): unexpected error state: %v", tt.indicator, tt.window, tt.days, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseIndicatorParams(%q, %q, %d) = %d, want %d", tt.indicator, tt.window, tt.days, got, tt.want)
+		}
+	}
+}
+
+func TestCompareHandlerRejectsInvalidSymbols(t *testing.T) {
+	handler, _ := setupTestHandler()
+
+	for _, query := range []string{"", "?a=AAPL", "?b=MSFT", "?a=AAPL&b=INVALID_SYMBOL_12345"} {
+		rr := httptest.NewRecorder()
+		handler.compareHandler(rr, httptest.NewRequest("GET", "/compare"+query, nil))
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status %d, got %d", query, http.StatusBadRequest, rr.Code)
+			continue
+		}
+
+		var response ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != CodeInvalidSymbol {
+			t.Errorf("%q: expected code %s, got %s", query, CodeInvalidSymbol, response.Code)
+		}
+	}
+}
+
+func TestCompareStockData(t *testing.T) {
+	a := &stock.StockData{Symbol: "AAPL", Average: 190.5, ChangePercent: 4}
+	b := &stock.StockData{Symbol: "MSFT", Average: 370.25, ChangePercent: -1.5}
+
+	result := compareStockData(a, b)
+
+	if result.A != a || result.B != b {
+		t.Error("expected both series in the response")
+	}
+	if result.AvgDelta != -179.75 {
+		t.Errorf("expected avgDelta -179.75, got %f", result.AvgDelta)
+	}
+	if result.ChangeDeltaPercent != 5.5 {
+		t.Errorf("expected changeDeltaPercent 5.5, got %f", result.ChangeDeltaPercent)
+	}
+}
+
+func TestSendErrorIncludesRequestID(t *testing.T) {
+	handler, _ := setupTestHandler()
+
+	req := httptest.NewRequest("GET", "/AAPL", nil)
+	req = req.WithContext(requestid.NewContext(req.Context(), "req-42"))
+	rr := httptest.NewRecorder()
+	handler.sendError(rr, req, http.StatusBadRequest, ErrorResponse{Error: "Invalid symbol", Code: CodeInvalidSymbol})
+
+	var response ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.RequestID != "req-42" {
+		t.Errorf("expected request_id req-42, got %q", response.RequestID)
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    stock.Period
+		wantErr bool
+	}{
+		{query: "", want: stock.PeriodDaily},
+		{query: "period=weekly", want: stock.PeriodWeekly},
+		{query: "interval=5min", want: stock.PeriodIntraday5Min},
+		{query: "interval=2min", wantErr: true},
+		{query: "period=daily&interval=5min", wantErr: true},
+		{query: "adjusted=true", want: stock.PeriodDailyAdjusted},
+		{query: "adjusted=false&period=weekly", want: stock.PeriodWeekly},
+		{query: "adjusted=true&period=weekly", wantErr: true},
+		{query: "adjusted=yes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		got, err := parsePeriod(query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: expected error %v, got %v", tt.query, tt.wantErr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.query, tt.want, got)
+		}
+	}
+}
+
+func TestParseQueryOptions(t *testing.T) {
+	req := httptest.NewRequest("GET", "/AAPL?period=weekly&order=asc&currency=eur&fields=symbol,average", nil)
+	opts, err := parseQueryOptions(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.period != stock.PeriodWeekly || opts.order != orderAsc || opts.currency != "EUR" || strings.Join(opts.fields, ",") != "symbol,average" {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+
+	opts, err = parseQueryOptions(httptest.NewRequest("GET", "/AAPL", nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.period != stock.PeriodDaily || opts.order != orderDesc || opts.currency != stock.BaseCurrency || opts.fields != nil {
+		t.Errorf("unexpected defaults: %+v", opts)
+	}
+
+	tests := []struct {
+		query       string
+		wantMessage string
+		wantCode    string
+	}{
+		{query: "period=hourly", wantMessage: "Invalid period", wantCode: CodeInvalidPeriod},
+		{query: "order=sideways", wantMessage: "Invalid order", wantCode: CodeInvalidOrder},
+		{query: "currency=EURO", wantMessage: "Invalid currency", wantCode: CodeInvalidCurrency},
+		{query: "fields=bogus", wantMessage: "Invalid fields", wantCode: CodeInvalidFields},
+	}
+	for _, tt := range tests {
+		_, err := parseQueryOptions(httptest.NewRequest("GET", "/AAPL?"+tt.query, nil))
+		var optErr *queryOptionError
+		if !errors.As(err, &optErr) {
+			t.Errorf("%q: expected a queryOptionError, got %v", tt.query, err)
+			continue
+		}
+		if optErr.message != tt.wantMessage || optErr.code != tt.wantCode {
+			t.Errorf("%q: expected %q/%s, got %q/%s", tt.query, tt.wantMessage, tt.wantCode, optErr.message, optErr.code)
+		}
+	}
+}
+
+// stubProvider serves fixed data and counts upstream calls.
+type stubProvider struct {
+	data  *stock.StockData
+	err   error
+	calls int
+}
+
+func (p *stubProvider) Name() string { return "stub" }
+
+func (p *stubProvider) FetchDailySeries(ctx context.Context, symbol string, ndays int, period stock.Period) (*stock.StockData, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.data != nil {
+		return p.data, nil
+	}
+	return &stock.StockData{Symbol: symbol, NDays: ndays}, nil
+}
+
+// newTestClient returns a stock client backed by provider with throwaway
+// cache, breaker and metrics.
+func newTestClient(provider stock.StockProvider) *stock.Client {
+	return stock.NewClient(stock.ClientOptions{
+		Providers:      []stock.StockProvider{provider},
+		Cache:          cache.NewCache(time.Millisecond),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(1, 1, time.Minute),
+	})
+}
+
+func newTestAPIDuration() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test_api_duration_seconds"}, []string{"route", "outcome"})
+}
+
+// observedOutcomes returns the number of observations per route and
+// outcome, keyed "route outcome".
+func observedOutcomes(t *testing.T, vec *prometheus.HistogramVec) map[string]uint64 {
+	t.Helper()
+
+	ch := make(chan prometheus.Metric, 16)
+	vec.Collect(ch)
+	close(ch)
+
+	counts := make(map[string]uint64)
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatalf("failed to read metric: %v", err)
+		}
+		labels := make(map[string]string)
+		for _, pair := range m.GetLabel() {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		counts[labels["route"]+" "+labels["outcome"]] = m.GetHistogram().GetSampleCount()
+	}
+	return counts
+}
+
+func TestAPIDurationOutcomes(t *testing.T) {
+	provider := &stubProvider{data: &stock.StockData{Symbol: "AAPL", NDays: 3}}
+	stockClient := stock.NewClient(stock.ClientOptions{
+		Providers:      []stock.StockProvider{provider},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(1, 1, time.Minute),
+	})
+	cfg := &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000}
+	handler := New(HandlerOptions{Config: cfg, StockClient: stockClient})
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	for _, path := range []string{"/AAPL/3", "/AAPL/3", "/AAPL/0", "/health"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	got := observedOutcomes(t, handler.apiDuration)
+	want := map[string]uint64{
+		"/{symbol}/{days} miss":  1,
+		"/{symbol}/{days} hit":   1,
+		"/{symbol}/{days} error": 1,
+		"/health ok":             1,
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected series %v, got %v", want, got)
+	}
+	for key, count := range want {
+		if got[key] != count {
+			t.Errorf("%s: expected %d observations, got %d", key, count, got[key])
+		}
+	}
+}
+
+func TestCacheHeaders(t *testing.T) {
+	provider := &stubProvider{data: &stock.StockData{Symbol: "AAPL", NDays: 3}}
+	stockClient := stock.NewClient(stock.ClientOptions{
+		Providers:      []stock.StockProvider{provider},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(1, 1, time.Minute),
+		CacheTTL:       time.Minute,
+	})
+	cfg := &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000}
+	handler := New(HandlerOptions{Config: cfg, StockClient: stockClient})
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	miss := httptest.NewRecorder()
+	router.ServeHTTP(miss, httptest.NewRequest("GET", "/AAPL/3", nil))
+	if cc := miss.Header().Get("Cache-Control"); cc != "max-age=60" {
+		t.Errorf("miss: expected Cache-Control max-age=60, got %q", cc)
+	}
+	if age := miss.Header().Get("Age"); age != "" {
+		t.Errorf("miss: expected no Age header, got %q", age)
+	}
+
+	hit := httptest.NewRecorder()
+	router.ServeHTTP(hit, httptest.NewRequest("GET", "/AAPL/3", nil))
+	var maxAge int
+	if _, err := fmt.Sscanf(hit.Header().Get("Cache-Control"), "max-age=%d", &maxAge); err != nil || maxAge <= 0 || maxAge > 60 {
+		t.Errorf("hit: expected Cache-Control max-age within the TTL, got %q", hit.Header().Get("Cache-Control"))
+	}
+	if age := hit.Header().Get("Age"); age != "0" {
+		t.Errorf("hit: expected Age 0, got %q", age)
+	}
+
+	failed := httptest.NewRecorder()
+	router.ServeHTTP(failed, httptest.NewRequest("GET", "/AAPL/0", nil))
+	if cc := failed.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("error: expected Cache-Control no-cache, got %q", cc)
+	}
+}
+
+func TestSetCacheHeadersAge(t *testing.T) {
+	rr := httptest.NewRecorder()
+	setCacheHeaders(rr,
+		stock.ResultInfo{Cache: stock.CacheHit, TTL: 40 * time.Second, Age: 20 * time.Second},
+		stock.ResultInfo{Cache: stock.CacheMiss, TTL: time.Minute},
+	)
+	if cc := rr.Header().Get("Cache-Control"); cc != "max-age=40" {
+		t.Errorf("expected Cache-Control max-age=40, got %q", cc)
+	}
+	if age := rr.Header().Get("Age"); age != "20" {
+		t.Errorf("expected Age 20, got %q", age)
+	}
+
+	stale := httptest.NewRecorder()
+	setCacheHeaders(stale, stock.ResultInfo{Cache: stock.CacheHit})
+	if cc := stale.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("stale: expected Cache-Control no-cache, got %q", cc)
+	}
+}
+
+func TestNewRegistersMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	handler := New(HandlerOptions{
+		Config:      &config.Config{Symbol: "MSFT", MetricsNamespace: "test"},
+		StockClient: newTestClient(&stubProvider{}),
+		Registerer:  reg,
+		Gatherer:    reg,
+	})
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+
+	// /metrics serves the injected registry rather than the default one
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{"test_api_requests_total 1", "test_api_request_duration_seconds_count"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected /metrics to contain %q, got:\n%s", want, rr.Body.String())
+		}
+	}
+	if strings.Contains(rr.Body.String(), "go_goroutines") {
+		t.Error("expected /metrics not to serve the default registry")
+	}
+}
+
+func TestMetricsAuthToken(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	handler := New(HandlerOptions{
+		Config:      &config.Config{Symbol: "MSFT", MetricsNamespace: "test", AdminToken: "admin", MetricsAuthToken: "scrape"},
+		StockClient: newTestClient(&stubProvider{}),
+		Registerer:  reg,
+		Gatherer:    reg,
+	})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "admin": http.StatusUnauthorized, "scrape": http.StatusOK} {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("token %q: expected %d, got %d", token, want, rr.Code)
+		}
+		if want == http.StatusOK && !strings.Contains(rr.Body.String(), "test_api_requests_total") {
+			t.Errorf("expected an authorized scrape to get the metrics, got:\n%s", rr.Body.String())
+		}
+	}
+
+	// The metrics token grants nothing else
+	req := httptest.NewRequest("GET", "/admin/circuitbreaker", nil)
+	req.Header.Set("Authorization", "Bearer scrape")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the metrics token to be rejected by /admin, got %d", rr.Code)
+	}
+}
+
+func TestMetricsOpenMetricsGzip(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	handler := New(HandlerOptions{
+		Config:      &config.Config{Symbol: "MSFT", MetricsNamespace: "test", MetricsOpenMetrics: true},
+		StockClient: newTestClient(&stubProvider{}),
+		Registerer:  reg,
+		Gatherer:    reg,
+	})
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+	router.Use(middleware.Compression)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("expected OpenMetrics content type, got %q", ct)
+	}
+	if enc := rr.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("expected gzip Content-Encoding, got %q", enc)
+	}
+
+	// A single gunzip must yield the plain exposition
+	gr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("failed to open gzip body: %v", err)
+	}
+	body, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("failed to decode gzip body: %v", err)
+	}
+	if !strings.HasSuffix(string(body), "# EOF\n") {
+		t.Errorf("expected an OpenMetrics exposition ending in # EOF, got:\n%s", body)
+	}
+}
+
+func TestReadyHandlerUsesRecentSuccess(t *testing.T) {
+	provider := &stubProvider{}
+	cfg := &config.Config{Symbol: "MSFT", ReadyFreshness: time.Minute}
+	handler := &Handler{
+		config:      cfg,
+		stockClient: newTestClient(provider),
+		logger:      zap.NewNop(),
+		apiRequests: prometheus.NewCounter(prometheus.CounterOpts{Name: "test_api_requests_total"}),
+		apiDuration: newTestAPIDuration(),
+	}
+
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		handler.readyHandler(rr, httptest.NewRequest("GET", "/ready", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("probe %d: expected 200, got %d", i, rr.Code)
+		}
+	}
+
+	// The first probe fetches; later ones rely on it being recent
+	if provider.calls != 1 {
+		t.Errorf("expected a single upstream call across probes, got %d", provider.calls)
+	}
+}
+
+func TestReadyHandlerCircuitOpen(t *testing.T) {
+	provider := &stubProvider{err: stock.ErrUpstream}
+	cfg := &config.Config{Symbol: "MSFT", ReadyFreshness: time.Minute}
+	handler := &Handler{
+		config:      cfg,
+		stockClient: newTestClient(provider),
+		logger:      zap.NewNop(),
+		apiRequests: prometheus.NewCounter(prometheus.CounterOpts{Name: "test_api_requests_total"}),
+		apiDuration: newTestAPIDuration(),
+	}
+
+	// The failed live fetch opens the breaker (threshold 1)
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		handler.readyHandler(rr, httptest.NewRequest("GET", "/ready", nil))
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Fatalf("probe %d: expected 503, got %d", i, rr.Code)
+		}
+	}
+	if provider.calls != 1 {
+		t.Errorf("expected the open breaker to stop further fetches, got %d calls", provider.calls)
+	}
+}
+
+// slowStubProvider answers after delay unless ctx ends first.
+type slowStubProvider struct {
+	delay time.Duration
+}
+
+func (p *slowStubProvider) Name() string { return "slow" }
+
+func (p *slowStubProvider) FetchDailySeries(ctx context.Context, symbol string, ndays int, period stock.Period) (*stock.StockData, error) {
+	select {
+	case <-time.After(p.delay):
+		return &stock.StockData{Symbol: symbol, NDays: ndays}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestReadyHandlerTimeout(t *testing.T) {
+	cfg := &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000, ReadyFreshness: time.Minute, ReadyTimeout: 10 * time.Millisecond}
+	// The probe's abandoned fetch fails once its deadline passes; a lenient
+	// breaker keeps that from turning the user request away
+	stockClient := stock.NewClient(stock.ClientOptions{
+		Providers:      []stock.StockProvider{&slowStubProvider{delay: 100 * time.Millisecond}},
+		Cache:          cache.NewCache(time.Millisecond),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(10, 1, time.Minute),
+	})
+	handler := New(HandlerOptions{Config: cfg, StockClient: stockClient})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/ready", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the probe to fail at READY_TIMEOUT, got %d", rr.Code)
+	}
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Errorf("expected the probe to answer before the upstream did, took %s", elapsed)
+	}
+
+	// User requests aren't held to the probe's deadline
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/AAPL", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected the user request to wait for the upstream, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHealthzShallow(t *testing.T) {
+	provider := &stubProvider{}
+	handler := New(HandlerOptions{Config: &config.Config{Symbol: "MSFT"}, StockClient: newTestClient(provider)})
+
+	rr := httptest.NewRecorder()
+	handler.healthzHandler(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rr.Code)
+	}
+	if provider.calls != 0 {
+		t.Errorf("expected no upstream call, got %d", provider.calls)
+	}
+}
+
+func TestHealthzDeep(t *testing.T) {
+	provider := &stubProvider{}
+	stockClient := newTestClient(provider)
+	handler := New(HandlerOptions{
+		Config:      &config.Config{Symbol: "MSFT", ReadyFreshness: time.Minute},
+		StockClient: stockClient,
+	})
+
+	deepHealth := func() (int, DeepHealthResponse) {
+		rr := httptest.NewRecorder()
+		handler.healthzHandler(rr, httptest.NewRequest("GET", "/healthz?deep=true", nil))
+		var resp DeepHealthResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return rr.Code, resp
+	}
+
+	// No fetch yet: upstream is degraded but the service is still up
+	code, resp := deepHealth()
+	if code != http.StatusOK || resp.Status != healthDegraded || resp.Checks["upstream"].Status != healthDegraded {
+		t.Errorf("before any fetch: got %d %+v", code, resp)
+	}
+
+	stockClient.GetStockData(context.Background(), "MSFT", 1, stock.PeriodDaily, nil)
+	code, resp = deepHealth()
+	if code != http.StatusOK || resp.Status != healthOK {
+		t.Errorf("after a fetch: got %d %+v", code, resp)
+	}
+	for _, name := range []string{"cache", "upstream", "breaker"} {
+		if resp.Checks[name].Status != healthOK {
+			t.Errorf("expected %s ok, got %+v", name, resp.Checks[name])
+		}
+	}
+
+	// A failed fetch opens the breaker (threshold 1)
+	provider.err = stock.ErrUpstream
+	stockClient.GetStockData(context.Background(), "AAPL", 1, stock.PeriodDaily, nil)
+	code, resp = deepHealth()
+	if code != http.StatusServiceUnavailable || resp.Status != healthDown || resp.Checks["breaker"].Status != healthDown {
+		t.Errorf("with the breaker open: got %d %+v", code, resp)
+	}
+	if provider.calls != 2 {
+		t.Errorf("expected deep checks not to call upstream, got %d calls", provider.calls)
+	}
+}
+
+func TestHealthzDeepRedisDown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisCache := cache.NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}), time.Minute, "stock:", func() interface{} { return &stock.StockData{} })
+	handler := New(HandlerOptions{
+		Config: &config.Config{Symbol: "MSFT", ReadyFreshness: time.Minute},
+		StockClient: stock.NewClient(stock.ClientOptions{
+			Providers:      []stock.StockProvider{&stubProvider{}},
+			Cache:          redisCache,
+			CircuitBreaker: circuitbreaker.NewCircuitBreaker(1, 1, time.Minute),
+		}),
+	})
+	mr.Close()
+
+	rr := httptest.NewRecorder()
+	handler.healthzHandler(rr, httptest.NewRequest("GET", "/healthz?deep=true", nil))
+
+	var resp DeepHealthResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if rr.Code != http.StatusServiceUnavailable || resp.Checks["cache"].Status != healthDown {
+		t.Errorf("expected 503 with the cache down, got %d %+v", rr.Code, resp)
+	}
+}
+
+func TestReadyHandlerDraining(t *testing.T) {
+	provider := &stubProvider{}
+	handler := &Handler{
+		config:      &config.Config{Symbol: "MSFT", ReadyFreshness: time.Minute},
+		stockClient: newTestClient(provider),
+		logger:      zap.NewNop(),
+		apiRequests: prometheus.NewCounter(prometheus.CounterOpts{Name: "test_api_requests_total"}),
+		apiDuration: newTestAPIDuration(),
+	}
+
+	handler.StartDraining()
+
+	rr := httptest.NewRecorder()
+	handler.readyHandler(rr, httptest.NewRequest("GET", "/ready", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while draining, got %d", rr.Code)
+	}
+	if provider.calls != 0 {
+		t.Errorf("expected no upstream call while draining, got %d", provider.calls)
+	}
+}
+
+func TestStockSymbolDaysHandlerOrder(t *testing.T) {
+	provider := &stubProvider{data: &stock.StockData{
+		Symbol: "AAPL",
+		NDays:  3,
+		Prices: []stock.PricePoint{
+			{Date: "2024-01-04", Close: 103},
+			{Date: "2024-01-03", Close: 102},
+			{Date: "2024-01-02", Close: 101},
+		},
+		Change:        2,
+		ChangePercent: 1.98,
+	}}
+	handler, _ := setupTestHandler()
+	handler = &Handler{
+		config:          handler.config,
+		stockClient:     newTestClient(provider),
+		logger:          zap.NewNop(),
+		symbolValidator: handler.symbolValidator,
+		apiRequests:     handler.apiRequests,
+		apiDuration:     handler.apiDuration,
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{symbol}/{days}", handler.stockSymbolDaysHandler)
+
+	tests := []struct {
+		query      string
+		firstDate  string
+		wantStatus int
+	}{
+		{query: "", firstDate: "2024-01-04", wantStatus: http.StatusOK},
+		{query: "?order=desc", firstDate: "2024-01-04", wantStatus: http.StatusOK},
+		{query: "?order=asc", firstDate: "2024-01-02", wantStatus: http.StatusOK},
+		{query: "?order=random", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/AAPL/3"+tt.query, nil))
+
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.wantStatus, rr.Code)
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+
+		var response stock.StockData
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Prices[0].Date != tt.firstDate {
+			t.Errorf("%q: expected first date %s, got %s", tt.query, tt.firstDate, response.Prices[0].Date)
+		}
+		if response.Change != 2 || response.ChangePercent != 1.98 {
+			t.Errorf("%q: expected change to be independent of order, got %v (%v%%)", tt.query, response.Change, response.ChangePercent)
+		}
+	}
+
+	// Reordering must not touch the shared result
+	if provider.data.Prices[0].Date != "2024-01-04" {
+		t.Error("expected the provider's result to stay newest first")
+	}
+}
+
+func TestStockSymbolDaysHandlerPagination(t *testing.T) {
+	provider := &stubProvider{data: &stock.StockData{
+		Symbol: "AAPL",
+		NDays:  3,
+		Prices: []stock.PricePoint{
+			{Date: "2024-01-04", Close: 103},
+			{Date: "2024-01-03", Close: 102},
+			{Date: "2024-01-02", Close: 101},
+		},
+		Average: 102,
+	}}
+	handler, _ := setupTestHandler()
+	handler = &Handler{
+		config:          handler.config,
+		stockClient:     newTestClient(provider),
+		logger:          zap.NewNop(),
+		symbolValidator: handler.symbolValidator,
+		apiRequests:     handler.apiRequests,
+		apiDuration:     handler.apiDuration,
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{symbol}/{days}", handler.stockSymbolDaysHandler)
+
+	next := func(n int) *int { return &n }
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantDates  []string
+		wantPage   *stock.Pagination
+	}{
+		{query: "", wantStatus: http.StatusOK, wantDates: []string{"2024-01-04", "2024-01-03", "2024-01-02"}},
+		{query: "?limit=2", wantStatus: http.StatusOK, wantDates: []string{"2024-01-04", "2024-01-03"},
+			wantPage: &stock.Pagination{Total: 3, Limit: 2, Offset: 0, NextOffset: next(2)}},
+		{query: "?limit=2&offset=2", wantStatus: http.StatusOK, wantDates: []string{"2024-01-02"},
+			wantPage: &stock.Pagination{Total: 3, Limit: 2, Offset: 2}},
+		{query: "?offset=1&order=asc", wantStatus: http.StatusOK, wantDates: []string{"2024-01-03", "2024-01-04"},
+			wantPage: &stock.Pagination{Total: 3, Limit: 2, Offset: 1}},
+		{query: "?limit=0", wantStatus: http.StatusBadRequest},
+		{query: "?limit=4", wantStatus: http.StatusBadRequest},
+		{query: "?offset=-1", wantStatus: http.StatusBadRequest},
+		{query: "?offset=3", wantStatus: http.StatusBadRequest},
+		{query: "?limit=x", wantStatus: http.StatusBadRequest},
+		{query: "?limit=2&indicator=sma&window=2", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/AAPL/3"+tt.query, nil))
+
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.wantStatus, rr.Code)
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+
+		var response stock.StockData
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		var dates []string
+		for _, p := range response.Prices {
+			dates = append(dates, p.Date)
+		}
+		if strings.Join(dates, ",") != strings.Join(tt.wantDates, ",") {
+			t.Errorf("%q: expected dates %v, got %v", tt.query, tt.wantDates, dates)
+		}
+		if response.Average != 102 {
+			t.Errorf("%q: expected average over the whole window, got %v", tt.query, response.Average)
+		}
+
+		got, want := response.Pagination, tt.wantPage
+		switch {
+		case want == nil && got != nil:
+			t.Errorf("%q: expected no pagination, got %+v", tt.query, *got)
+		case want != nil && got == nil:
+			t.Errorf("%q: expected pagination %+v, got none", tt.query, *want)
+		case want != nil:
+			if got.Total != want.Total || got.Limit != want.Limit || got.Offset != want.Offset {
+				t.Errorf("%q: expected pagination %+v, got %+v", tt.query, *want, *got)
+			}
+			if (got.NextOffset == nil) != (want.NextOffset == nil) || (got.NextOffset != nil && *got.NextOffset != *want.NextOffset) {
+				t.Errorf("%q: expected next offset %v, got %v", tt.query, want.NextOffset, got.NextOffset)
+			}
+		}
+	}
+
+	// Paging must not touch the shared result
+	if len(provider.data.Prices) != 3 || provider.data.Pagination != nil {
+		t.Error("expected the provider's result to be left whole")
+	}
+}
+
+// fxStubProvider is a stubProvider that also quotes exchange rates.
+type fxStubProvider struct {
+	*stubProvider
+	rate float64
+	err  error
+}
+
+func (p *fxStubProvider) FetchExchangeRate(ctx context.Context, from, to string) (float64, error) {
+	return p.rate, p.err
+}
+
+func TestStockSymbolHandlerCurrency(t *testing.T) {
+	data := &stock.StockData{
+		Symbol:   "AAPL",
+		NDays:    1,
+		Prices:   []stock.PricePoint{{Date: "2024-01-02", Close: 100}},
+		Average:  100,
+		Min:      100,
+		Max:      100,
+		Currency: stock.BaseCurrency,
+	}
+
+	tests := []struct {
+		name         string
+		query        string
+		fxErr        error
+		wantStatus   int
+		wantCurrency string
+		wantAverage  float64
+	}{
+		{name: "default", query: "", wantStatus: http.StatusOK, wantCurrency: "USD", wantAverage: 100},
+		{name: "converted", query: "?currency=eur", wantStatus: http.StatusOK, wantCurrency: "EUR", wantAverage: 90},
+		{name: "lookup fails", query: "?currency=EUR", fxErr: errors.New("boom"), wantStatus: http.StatusOK, wantCurrency: "USD", wantAverage: 100},
+		{name: "invalid", query: "?currency=EURO", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fxStubProvider{stubProvider: &stubProvider{data: data}, rate: 0.9, err: tt.fxErr}
+			cfg := &config.Config{Symbol: "MSFT", NDays: 1, MaxDays: 1000}
+			handler := New(HandlerOptions{Config: cfg, StockClient: newTestClient(provider)})
+
+			router := mux.NewRouter()
+			handler.RegisterRoutes(router)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/AAPL"+tt.query, nil))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response stock.StockData
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Currency != tt.wantCurrency {
+				t.Errorf("expected currency %s, got %s", tt.wantCurrency, response.Currency)
+			}
+			if response.Average != tt.wantAverage || response.Prices[0].Close != tt.wantAverage {
+				t.Errorf("expected average and close %v, got %v and %v", tt.wantAverage, response.Average, response.Prices[0].Close)
+			}
+		})
+	}
+
+	if data.Average != 100 || data.Currency != stock.BaseCurrency {
+		t.Error("expected the provider's result to stay in the base currency")
+	}
+}
+
+// Converted prices are rounded to PRICE_PRECISION again
+func TestStockSymbolHandlerCurrencyPrecision(t *testing.T) {
+	data := &stock.StockData{Symbol: "AAPL", NDays: 1, Prices: []stock.PricePoint{{Date: "2024-01-02", Close: 100}}, Average: 100}
+	provider := &fxStubProvider{stubProvider: &stubProvider{data: data}, rate: 0.91234}
+	cfg := &config.Config{Symbol: "MSFT", NDays: 1, MaxDays: 1000, PricePrecision: 2}
+	handler := New(HandlerOptions{Config: cfg, StockClient: newTestClient(provider)})
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/AAPL?currency=EUR", nil))
+	if !strings.Contains(rr.Body.String(), `"close":91.23,`) || !strings.Contains(rr.Body.String(), `"average":91.23,`) {
+		t.Errorf("expected converted prices rounded to 2 places, got %s", rr.Body.String())
+	}
+}
+
+func TestStockSymbolHandlerBlockedSymbol(t *testing.T) {
+	provider := &stubProvider{data: &stock.StockData{Symbol: "AAPL", NDays: 1}}
+	stockClient := stock.NewClient(stock.ClientOptions{
+		Providers:      []stock.StockProvider{provider},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(1, 1, time.Minute),
+		BlockedSymbols: []string{"TSLA"},
+	})
+	cfg := &config.Config{Symbol: "MSFT", NDays: 1, MaxDays: 1000}
+	handler := New(HandlerOptions{Config: cfg, StockClient: stockClient})
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	for _, path := range []string{"/tsla", "/TSLA", "/quote/tsla"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%s: expected status 403, got %d", path, rr.Code)
+		}
+		var resp ErrorResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Code != CodeSymbolBlocked || resp.Error != "Symbol not available" || !strings.Contains(resp.Details, "TSLA") {
+			t.Errorf("%s: unexpected error response %+v", path, resp)
+		}
+	}
+	if provider.calls != 0 {
+		t.Errorf("expected no upstream calls for a blocked symbol, got %d", provider.calls)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/aapl", nil))
+	if rr.Code != http.StatusOK || provider.calls != 1 {
+		t.Errorf("expected an allowed symbol to be served, got status %d with %d upstream calls", rr.Code, provider.calls)
+	}
+}
+
+func TestStockSymbolDaysHandlerIndicatorWindow(t *testing.T) {
+	// Five days were asked for, but the provider only has two
+	data := &stock.StockData{Symbol: "AAPL", NDays: 5, Prices: []stock.PricePoint{{Date: "2024-01-19", Close: 12}, {Date: "2024-01-18", Close: 10}}}
+	cfg := &config.Config{Symbol: "MSFT", NDays: 5, MaxDays: 1000}
+	handler := New(HandlerOptions{Config: cfg, StockClient: newTestClient(&stubProvider{data: data})})
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/AAPL/5?indicator=sma&window=3", nil))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a window longer than the data, got %d", rr.Code)
+	}
+	var errResp ErrorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&errResp); err != nil {
+		t.Fatal(err)
+	}
+	if errResp.Code != CodeInsufficientData || !strings.Contains(errResp.Details, "only 2") {
+		t.Errorf("unexpected error response %+v", errResp)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/AAPL/5?indicator=sma&window=2", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a window the data covers, got %d", rr.Code)
+	}
+	var resp IndicatorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Values) != 1 || resp.Values[0].Value != 11 {
+		t.Errorf("expected one average of 11, got %+v", resp.Values)
+	}
+}
+
+func TestCircuitBreakerHandler(t *testing.T) {
+	cb := circuitbreaker.NewCircuitBreaker(1, 1, time.Minute)
+	stockClient := stock.NewClient(stock.ClientOptions{
+		Providers:      []stock.StockProvider{&stubProvider{err: stock.ErrUpstream}},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: cb,
+	})
+	cfg := &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000, AdminToken: "secret"}
+	handler := New(HandlerOptions{Config: cfg, StockClient: stockClient})
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	get := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/admin/circuitbreaker", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := get(""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", rr.Code)
+	}
+
+	var closed CircuitBreakerResponse
+	rr := get("secret")
+	if err := json.Unmarshal(rr.Body.Bytes(), &closed); err != nil {
+		t.Fatal(err)
+	}
+	if closed.State != "closed" || closed.Timeout != "1m0s" || closed.LastFailureTime != nil || closed.HalfOpenIn != "" {
+		t.Errorf("unexpected closed breaker response %+v", closed)
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/AAPL", nil))
+
+	var open CircuitBreakerResponse
+	rr = get("secret")
+	if err := json.Unmarshal(rr.Body.Bytes(), &open); err != nil {
+		t.Fatal(err)
+	}
+	if open.State != "open" || open.LastFailureTime == nil || open.HalfOpenIn != "1m0s" {
+		t.Errorf("unexpected open breaker response %+v", open)
+	}
+}
+
+func TestSymbolsHandler(t *testing.T) {
+	handler := New(HandlerOptions{
+		Config:      &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000},
+		StockClient: newTestClient(&stubProvider{data: &stock.StockData{}}),
+	})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	for _, path := range []string{"/AAPL", "/MSFT", "/MSFT/3"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/symbols?limit=1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp SymbolsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Symbols) != 1 || resp.Symbols[0].Symbol != "MSFT" || resp.Symbols[0].Requests != 2 {
+		t.Errorf("expected MSFT with 2 requests, got %+v", resp.Symbols)
+	}
+
+	for _, limit := range []string{"0", "101", "abc"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/symbols?limit="+limit, nil))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeInvalidLimit) {
+			t.Errorf("limit=%s: expected 400 %s, got %d: %s", limit, CodeInvalidLimit, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestRoutingPathShapes(t *testing.T) {
+	handler := New(HandlerOptions{
+		Config:      &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000},
+		StockClient: newTestClient(&stubProvider{}),
+	})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	tests := []struct {
+		path   string
+		status int
+		code   string
+		symbol string
+	}{
+		{path: "/", status: http.StatusOK, symbol: "MSFT"},
+		{path: "/AAPL", status: http.StatusOK, symbol: "AAPL"},
+		{path: "/AAPL/30", status: http.StatusOK, symbol: "AAPL"},
+		{path: "//30", status: http.StatusBadRequest, code: CodeInvalidPath},
+		{path: "/%20/30", status: http.StatusBadRequest, code: CodeInvalidPath},
+		{path: "/AAPL/", status: http.StatusBadRequest, code: CodeInvalidPath},
+		{path: "/AAPL//", status: http.StatusBadRequest, code: CodeInvalidPath},
+		{path: "//", status: http.StatusBadRequest, code: CodeInvalidPath},
+		{path: "/AAPL/30/extra", status: http.StatusNotFound, code: CodeNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", tt.path, nil))
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+			if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("expected a JSON response, got Content-Type %q", ct)
+			}
+
+			if tt.code != "" {
+				var errResp ErrorResponse
+				if err := json.Unmarshal(rr.Body.Bytes(), &errResp); err != nil {
+					t.Fatal(err)
+				}
+				if errResp.Code != tt.code {
+					t.Errorf("expected code %s, got %+v", tt.code, errResp)
+				}
+				return
+			}
+
+			var data stock.StockData
+			if err := json.Unmarshal(rr.Body.Bytes(), &data); err != nil {
+				t.Fatal(err)
+			}
+			if data.Symbol != tt.symbol {
+				t.Errorf("expected symbol %s, got %s", tt.symbol, data.Symbol)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerResponseHalfOpenDue(t *testing.T) {
+	failed := time.Now().Add(-2 * time.Minute)
+	resp := circuitBreakerResponse(circuitbreaker.Snapshot{
+		State:           circuitbreaker.StateOpen,
+		LastFailureTime: failed,
+		Timeout:         time.Minute,
+	}, time.Now())
+	if resp.HalfOpenIn != "0s" {
+		t.Errorf("expected a due probe to report 0s, got %q", resp.HalfOpenIn)
+	}
+}
+
+func TestSendStockDataETag(t *testing.T) {
+	handler, _ := setupTestHandler()
+
+	stockData := &stock.StockData{
+		Symbol: "AAPL",
+		NDays:  2,
+		Prices: []stock.PricePoint{{Date: "2024-01-19", Close: 191.56}, {Date: "2024-01-18", Close: 188.63}},
+	}
+
+	rr := httptest.NewRecorder()
+	handler.sendStockData(rr, httptest.NewRequest("GET", "/AAPL/2", nil), stockData)
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag on stock responses")
+	}
+
+	// Same data, same tag
+	rr = httptest.NewRecorder()
+	handler.sendStockData(rr, httptest.NewRequest("GET", "/AAPL/2", nil), stockData)
+	if got := rr.Header().Get("ETag"); got != etag {
+		t.Errorf("expected a stable ETag, got %s and %s", etag, got)
+	}
+
+	// A matching If-None-Match gets an empty 304
+	for _, header := range []string{etag, `"other", ` + etag, strings.TrimPrefix(etag, "W/"), "*"} {
+		req := httptest.NewRequest("GET", "/AAPL/2", nil)
+		req.Header.Set("If-None-Match", header)
+		rr = httptest.NewRecorder()
+		handler.sendStockData(rr, req, stockData)
+
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("If-None-Match %s: expected 304, got %d", header, rr.Code)
+		}
+		if rr.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: expected empty body, got %q", header, rr.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/AAPL/2", nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	rr = httptest.NewRecorder()
+	handler.sendStockData(rr, req, stockData)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 for a stale ETag, got %d", rr.Code)
+	}
+
+	// A different window is a different representation
+	shorter := *stockData
+	shorter.NDays = 1
+	shorter.Prices = stockData.Prices[:1]
+	rr = httptest.NewRecorder()
+	handler.sendStockData(rr, httptest.NewRequest("GET", "/AAPL/1", nil), &shorter)
+	if rr.Header().Get("ETag") == etag {
+		t.Error("expected the ETag to change with days")
+	}
+
+	// CSV and JSON of the same data are different representations
+	rr = httptest.NewRecorder()
+	handler.sendStockData(rr, httptest.NewRequest("GET", "/AAPL/2?format=csv", nil), stockData)
+	if rr.Header().Get("ETag") == etag {
+		t.Error("expected CSV and JSON to have different ETags")
+	}
+}
+
+func TestStockSymbolHandlerETagSurvivesCacheHit(t *testing.T) {
+	provider := &stubProvider{data: &stock.StockData{
+		Symbol: "AAPL",
+		NDays:  2,
+		Prices: []stock.PricePoint{{Date: "2024-01-19", Close: 191.56}, {Date: "2024-01-18", Close: 188.63}},
+	}}
+	stockClient := stock.NewClient(stock.ClientOptions{
+		Providers:      []stock.StockProvider{provider},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(1, 1, time.Minute),
+	})
+	cfg := &config.Config{Symbol: "MSFT", NDays: 2, MaxDays: 1000}
+	handler := New(HandlerOptions{Config: cfg, StockClient: stockClient})
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/AAPL/2", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	miss := get("")
+	if !strings.Contains(miss.Body.String(), `"source":"stub"`) {
+		t.Fatalf("expected the first request to be fetched, got %s", miss.Body.String())
+	}
+	etag := miss.Header().Get("ETag")
+
+	hit := get("")
+	if !strings.Contains(hit.Body.String(), `"source":"cache"`) {
+		t.Fatalf("expected the second request to be a cache hit, got %s", hit.Body.String())
+	}
+	if got := hit.Header().Get("ETag"); got != etag {
+		t.Errorf("expected the cache hit to keep the ETag %s, got %s", etag, got)
+	}
+
+	if rr := get(etag); rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for the miss's ETag, got %d", rr.Code)
+	}
+	if provider.calls != 1 {
+		t.Errorf("expected 1 upstream call, got %d", provider.calls)
+	}
+}
+
+// symbolErrProvider fails for the symbols in errs and returns empty data
+// for the rest. It is safe for concurrent use.
+type symbolErrProvider struct {
+	errs map[string]error
+}
+
+func (p *symbolErrProvider) Name() string { return "stub" }
+
+func (p *symbolErrProvider) FetchDailySeries(ctx context.Context, symbol string, ndays int, period stock.Period) (*stock.StockData, error) {
+	if err := p.errs[symbol]; err != nil {
+		return nil, err
+	}
+	return &stock.StockData{Symbol: symbol, NDays: ndays}, nil
+}
+
+func TestStockHandlerMultipleSymbols(t *testing.T) {
+	cfg := &config.Config{Symbol: "AAPL", Symbols: []string{"AAPL", "MSFT", "GOOG"}, NDays: 7, MaxDays: 1000}
+	handler := New(HandlerOptions{Config: cfg, StockClient: newTestClient(&symbolErrProvider{})})
+
+	rr := httptest.NewRecorder()
+	handler.stockHandler(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp []stock.StockData
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(resp))
+	}
+	for i, symbol := range cfg.Symbols {
+		if resp[i].Symbol != symbol || resp[i].NDays != 7 {
+			t.Errorf("result %d: expected %s for 7 days, got %s for %d", i, symbol, resp[i].Symbol, resp[i].NDays)
+		}
+	}
+
+	// One failing symbol fails the whole request. The breaker is lenient so
+	// the failure doesn't also turn away the concurrent fetches.
+	handler = New(HandlerOptions{
+		Config: cfg,
+		StockClient: stock.NewClient(stock.ClientOptions{
+			Providers:      []stock.StockProvider{&symbolErrProvider{errs: map[string]error{"MSFT": stock.ErrSymbolNotFound}}},
+			Cache:          cache.NewCache(time.Millisecond),
+			CircuitBreaker: circuitbreaker.NewCircuitBreaker(10, 1, time.Minute),
+		}),
+	})
+	rr = httptest.NewRecorder()
+	handler.stockHandler(rr, httptest.NewRequest("GET", "/", nil))
+	var errResp ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &errResp); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusNotFound || errResp.Symbol != "MSFT" {
+		t.Errorf("expected 404 for MSFT, got %d for %q", rr.Code, errResp.Symbol)
+	}
+}
+
+func TestStockHandlerSingleSymbol(t *testing.T) {
+	cfg := &config.Config{Symbol: "AAPL", Symbols: []string{"AAPL"}, NDays: 7, MaxDays: 1000}
+	handler := New(HandlerOptions{Config: cfg, StockClient: newTestClient(&symbolErrProvider{})})
+
+	rr := httptest.NewRecorder()
+	handler.stockHandler(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp stock.StockData
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("expected a single object: %v", err)
+	}
+	if resp.Symbol != "AAPL" {
+		t.Errorf("expected AAPL, got %s", resp.Symbol)
+	}
+}
+
+func TestStockSymbolHandlerFallback(t *testing.T) {
+	cfg := &config.Config{Symbol: "MSFT", FallbackSymbol: "IBM", NDays: 7, MaxDays: 1000}
+	provider := &symbolErrProvider{errs: map[string]error{
+		"ZZZZ":  stock.ErrSymbolNotFound,
+		"FLAKY": fmt.Errorf("%w: status 500", stock.ErrUpstream),
+	}}
+	// A lenient breaker, so the not-found responses don't open it
+	stockClient := stock.NewClient(stock.ClientOptions{
+		Providers:      []stock.StockProvider{provider},
+		Cache:          cache.NewCache(time.Millisecond),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(10, 1, time.Minute),
+	})
+	handler := New(HandlerOptions{Config: cfg, StockClient: stockClient})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/ZZZZ?fallback=true", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp stock.StockData
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Symbol != "IBM" || !resp.Fallback {
+		t.Errorf("expected IBM marked as a fallback, got %s fallback=%v", resp.Symbol, resp.Fallback)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/ZZZZ", http.StatusNotFound},
+		{"/ZZZZ/3?fallback=false", http.StatusNotFound},
+		{"/FLAKY?fallback=true", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", tt.path, nil))
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d: %s", tt.path, tt.status, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestStockSymbolHandlerFallbackSameSymbol(t *testing.T) {
+	cfg := &config.Config{Symbol: "MSFT", FallbackSymbol: "MSFT", NDays: 7, MaxDays: 1000}
+	provider := &stubProvider{err: fmt.Errorf("%w: MSFT", stock.ErrSymbolNotFound)}
+	handler := New(HandlerOptions{Config: cfg, StockClient: newTestClient(provider)})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	// The fallback is the symbol that wasn't found, whatever its case
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/msft?fallback=true", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if provider.calls != 1 {
+		t.Errorf("expected no second lookup of the same symbol, got %d calls", provider.calls)
+	}
+}
+
+func TestStockSymbolHandlerFallbackCircuitOpen(t *testing.T) {
+	cfg := &config.Config{Symbol: "MSFT", FallbackSymbol: "IBM", NDays: 7, MaxDays: 1000}
+	provider := &symbolErrProvider{errs: map[string]error{"FLAKY": fmt.Errorf("%w: status 500", stock.ErrUpstream)}}
+	handler := New(HandlerOptions{Config: cfg, StockClient: newTestClient(provider)})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	// The test client's breaker opens after one failure
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/FLAKY", nil))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/AAPL?fallback=true", nil))
+	if rr.Code != http.StatusServiceUnavailable || strings.Contains(rr.Body.String(), `"fallback"`) {
+		t.Errorf("expected 503 without a fallback, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// quoteStubProvider serves a fixed quote, or err for every symbol.
+type quoteStubProvider struct {
+	stubProvider
+	quote *stock.Quote
+	err   error
+}
+
+func (p *quoteStubProvider) FetchQuote(ctx context.Context, symbol string) (*stock.Quote, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	quote := *p.quote
+	quote.Symbol = symbol
+	return &quote, nil
+}
+
+func TestQuoteHandler(t *testing.T) {
+	provider := &quoteStubProvider{quote: &stock.Quote{Price: 167.15, Change: 0.88, LatestTradingDay: "2024-05-10"}}
+	handler := New(HandlerOptions{
+		Config:      &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000},
+		StockClient: newTestClient(provider),
+	})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/quote/IBM", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var quote stock.Quote
+	if err := json.Unmarshal(rr.Body.Bytes(), &quote); err != nil {
+		t.Fatal(err)
+	}
+	if quote.Symbol != "IBM" || quote.Price != 167.15 || quote.LatestTradingDay != "2024-05-10" {
+		t.Errorf("unexpected quote %+v", quote)
+	}
+	if provider.calls != 0 {
+		t.Errorf("expected no series fetch for a quote, got %d", provider.calls)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/quote/not-valid", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid symbol, got %d", rr.Code)
+	}
+
+	provider.err = fmt.Errorf("%w: no quote for ZZZZ", stock.ErrSymbolNotFound)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/quote/ZZZZ", nil))
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), CodeSymbolNotFound) {
+		t.Errorf("expected 404 %s for an empty quote, got %d: %s", CodeSymbolNotFound, rr.Code, rr.Body.String())
+	}
+}
+
+func TestStockSymbolHandlerNormalizesSymbol(t *testing.T) {
+	provider := &stubProvider{}
+	handler := New(HandlerOptions{
+		Config: &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000},
+		StockClient: stock.NewClient(stock.ClientOptions{
+			Providers:      []stock.StockProvider{provider},
+			Cache:          cache.NewCache(time.Minute),
+			CircuitBreaker: circuitbreaker.NewCircuitBreaker(1, 1, time.Minute),
+		}),
+	})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	var responses []stock.StockData
+	for _, path := range []string{"/aapl", "/AAPL"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+		var data stock.StockData
+		if err := json.Unmarshal(rr.Body.Bytes(), &data); err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, data)
+	}
+
+	if provider.calls != 1 {
+		t.Errorf("expected /aapl and /AAPL to share a cache entry, got %d upstream calls", provider.calls)
+	}
+	if responses[0].Symbol != "AAPL" || responses[1].Symbol != "AAPL" {
+		t.Errorf("expected the normalized symbol in both responses, got %q and %q", responses[0].Symbol, responses[1].Symbol)
+	}
+	if responses[0].NDays != responses[1].NDays || responses[0].Average != responses[1].Average {
+		t.Errorf("expected identical output, got %+v and %+v", responses[0], responses[1])
+	}
+}
+
+func TestDegradedHandler(t *testing.T) {
+	provider := &stubProvider{}
+	stockClient := stock.NewClient(stock.ClientOptions{
+		Providers:      []stock.StockProvider{provider},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(1, 1, time.Minute),
+	})
+	cfg := &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000, AdminToken: "secret"}
+	handler := New(HandlerOptions{Config: cfg, StockClient: stockClient})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	post := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/admin/degraded"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	get("/AAPL")
+	if rr := post(""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"degraded":true`) {
+		t.Fatalf("expected degraded mode to be toggled on, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := get("/AAPL"); rr.Code != http.StatusOK {
+		t.Errorf("expected a cached symbol to be served in degraded mode, got %d", rr.Code)
+	}
+	if rr := get("/IBM"); rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), CodeDegradedMode) {
+		t.Errorf("expected 503 %s for a miss, got %d: %s", CodeDegradedMode, rr.Code, rr.Body.String())
+	}
+	if provider.calls != 1 {
+		t.Errorf("expected no upstream calls in degraded mode, got %d calls in total", provider.calls)
+	}
+
+	if rr := post("?enabled=maybe"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid enabled, got %d", rr.Code)
+	}
+	if rr := post("?enabled=false"); !strings.Contains(rr.Body.String(), `"degraded":false`) || stockClient.Degraded() {
+		t.Errorf("expected degraded mode to be turned off, got %s", rr.Body.String())
+	}
+	if rr := get("/IBM"); rr.Code != http.StatusOK {
+		t.Errorf("expected misses to be fetched once degraded mode is off, got %d", rr.Code)
+	}
+}
+
+func TestCacheTTLHandler(t *testing.T) {
+	stockClient := stock.NewClient(stock.ClientOptions{
+		Providers:      []stock.StockProvider{&stubProvider{}},
+		Cache:          cache.NewCache(time.Hour),
+		CacheTTL:       time.Hour,
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(1, 1, time.Minute),
+	})
+	cfg := &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000, AdminToken: "secret"}
+	handler := New(HandlerOptions{Config: cfg, StockClient: stockClient})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/admin/cache/ttl", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	maxAge := func(path string) string {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr.Header().Get("Cache-Control")
+	}
+
+	if cc := maxAge("/AAPL"); cc != "max-age=3600" {
+		t.Fatalf("expected the configured TTL before the change, got %q", cc)
+	}
+
+	rr := post(`{"ttl_seconds": 60}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response map[string]int
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response["previous_ttl_seconds"] != 3600 || response["ttl_seconds"] != 60 {
+		t.Errorf("expected the previous and new TTL, got %v", response)
+	}
+
+	// Entries set after the change use the new TTL; existing ones keep theirs
+	if cc := maxAge("/IBM"); cc != "max-age=60" {
+		t.Errorf("expected a new entry to get the new TTL, got %q", cc)
+	}
+	if cc := maxAge("/IBM"); cc != "max-age=59" && cc != "max-age=60" {
+		t.Errorf("expected the new entry to be cached for the new TTL, got %q", cc)
+	}
+	if cc := maxAge("/AAPL"); cc != "max-age=3599" && cc != "max-age=3600" {
+		t.Errorf("expected the existing entry to keep its expiry, got %q", cc)
+	}
+
+	for _, body := range []string{"", "not json", `{}`, `{"ttl_seconds": 0}`, `{"ttl_seconds": -5}`, `{"ttl_seconds": "60"}`} {
+		if rr := post(body); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeInvalidTTL) {
+			t.Errorf("%q: expected 400 %s, got %d: %s", body, CodeInvalidTTL, rr.Code, rr.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/admin/cache/ttl", strings.NewReader(`{"ttl_seconds": 60}`))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the admin token to be required, got %d", rr.Code)
+	}
+}
+
+func TestPprofEndpoints(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		cfg := &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000, AdminToken: "secret", PprofEnabled: enabled}
+		handler := New(HandlerOptions{Config: cfg, StockClient: newTestClient(&stubProvider{})})
+		router := mux.NewRouter()
+		handler.RegisterRoutes(router)
+
+		for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1", "/debug/pprof/cmdline"} {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			// Disabled, the paths fall through to the other routes and
+			// are rejected as not found or as an invalid symbol
+			if served := rr.Code == http.StatusOK; served != enabled {
+				t.Errorf("PPROF_ENABLED=%v %s: expected served=%v, got %d", enabled, path, enabled, rr.Code)
+			}
+		}
+
+		if enabled {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/debug/pprof/goroutine", nil))
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("expected 401 without the admin token, got %d", rr.Code)
+			}
+		}
+	}
+}
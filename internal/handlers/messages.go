@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errorMessages translates the English summaries sent in
+// ErrorResponse.Error, by language. A message missing from a language is
+// sent in English. Codes are never translated, and Details, which often
+// carries an upstream or validation error, stays in English.
+var errorMessages = map[string]map[string]string{
+	"de": {
+		"Invalid symbol":              "Ungültiges Symbol",
+		"Invalid days":                "Ungültige Anzahl von Tagen",
+		"Invalid period":              "Ungültiger Zeitraum",
+		"Invalid order":               "Ungültige Sortierung",
+		"Invalid fields":              "Ungültige Felder",
+		"Invalid currency":            "Ungültige Währung",
+		"Invalid page":                "Ungültige Seite",
+		"Invalid limit":               "Ungültiges Limit",
+		"Invalid indicator":           "Ungültiger Indikator",
+		"Invalid enabled":             "Ungültiger Wert für enabled",
+		"Invalid TTL":                 "Ungültige TTL",
+		"Empty path segment":          "Leeres Pfadsegment",
+		"Not found":                   "Nicht gefunden",
+		"Not enough data":             "Nicht genügend Daten",
+		"Symbol not available":        "Symbol nicht verfügbar",
+		"Failed to fetch stock data":  "Aktiendaten konnten nicht abgerufen werden",
+		"Failed to encode stock data": "Aktiendaten konnten nicht kodiert werden",
+		"Streaming not supported":     "Streaming wird nicht unterstützt",
+		"Too many open streams":       "Zu viele offene Streams",
+	},
+	"es": {
+		"Invalid symbol":              "Símbolo no válido",
+		"Invalid days":                "Número de días no válido",
+		"Invalid period":              "Periodo no válido",
+		"Invalid order":               "Orden no válido",
+		"Invalid fields":              "Campos no válidos",
+		"Invalid currency":            "Moneda no válida",
+		"Invalid page":                "Página no válida",
+		"Invalid limit":               "Límite no válido",
+		"Invalid indicator":           "Indicador no válido",
+		"Invalid enabled":             "Valor de enabled no válido",
+		"Invalid TTL":                 "TTL no válido",
+		"Empty path segment":          "Segmento de ruta vacío",
+		"Not found":                   "No encontrado",
+		"Not enough data":             "Datos insuficientes",
+		"Symbol not available":        "Símbolo no disponible",
+		"Failed to fetch stock data":  "No se pudieron obtener los datos bursátiles",
+		"Failed to encode stock data": "No se pudieron codificar los datos bursátiles",
+		"Streaming not supported":     "Streaming no admitido",
+		"Too many open streams":       "Demasiados streams abiertos",
+	},
+	"fr": {
+		"Invalid symbol":              "Symbole invalide",
+		"Invalid days":                "Nombre de jours invalide",
+		"Invalid period":              "Période invalide",
+		"Invalid order":               "Ordre invalide",
+		"Invalid fields":              "Champs invalides",
+		"Invalid currency":            "Devise invalide",
+		"Invalid page":                "Page invalide",
+		"Invalid limit":               "Limite invalide",
+		"Invalid indicator":           "Indicateur invalide",
+		"Invalid enabled":             "Valeur de enabled invalide",
+		"Invalid TTL":                 "TTL invalide",
+		"Empty path segment":          "Segment de chemin vide",
+		"Not found":                   "Introuvable",
+		"Not enough data":             "Données insuffisantes",
+		"Symbol not available":        "Symbole non disponible",
+		"Failed to fetch stock data":  "Impossible de récupérer les données boursières",
+		"Failed to encode stock data": "Impossible d'encoder les données boursières",
+		"Streaming not supported":     "Streaming non pris en charge",
+		"Too many open streams":       "Trop de flux ouverts",
+	},
+}
+
+// negotiateLanguage picks the most preferred language in an
+// Accept-Language header that errorMessages has, ignoring regions, so
+// de-CH selects de. It returns "" for English, which needs no catalog,
+// and when nothing in the header is supported.
+func negotiateLanguage(acceptLanguage string) string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+
+	var langs []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		primary, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+		langs = append(langs, weighted{lang: strings.ToLower(primary), q: q})
+	}
+
+	// Equal weights keep the header's order
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+	for _, l := range langs {
+		if l.lang == "en" {
+			return ""
+		}
+		if _, ok := errorMessages[l.lang]; ok {
+			return l.lang
+		}
+	}
+	return ""
+}
+
+// localizeMessage returns message in lang, or message itself when lang is
+// English or the catalog lacks it.
+func localizeMessage(lang, message string) string {
+	if translated, ok := errorMessages[lang][message]; ok {
+		return translated
+	}
+	return message
+}
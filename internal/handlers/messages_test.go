@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/config"
+	"github.com/gorilla/mux"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	tests := map[string]string{
+		"":                          "",
+		"de":                        "de",
+		"de-CH, fr;q=0.8":           "de",
+		"FR-ca":                     "fr",
+		"ja, es;q=0.5":              "es",
+		"en-US, de;q=0.9":           "",
+		"fr;q=0.2, es;q=0.7":        "es",
+		"de;q=0, fr;q=0.1":          "fr",
+		"de;q=abc, ja":              "",
+		"*":                         "",
+		"ja, zh-Hans;q=0.9, ko;q=1": "",
+	}
+	for header, want := range tests {
+		if got := negotiateLanguage(header); got != want {
+			t.Errorf("negotiateLanguage(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestErrorLocalization(t *testing.T) {
+	cfg := &config.Config{Symbol: "MSFT", NDays: 7, MaxDays: 1000}
+	handler := New(HandlerOptions{Config: cfg, StockClient: newTestClient(&stubProvider{})})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	tests := []struct {
+		name         string
+		language     string
+		wantError    string
+		wantLanguage string
+	}{
+		{"supported", "de-DE,de;q=0.9,en;q=0.8", "Ungültiges Symbol", "de"},
+		{"unsupported", "ja-JP", "Invalid symbol", ""},
+		{"none", "", "Invalid symbol", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/not-a-symbol!", nil)
+			if tt.language != "" {
+				req.Header.Set("Accept-Language", tt.language)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+			}
+			var response ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Error != tt.wantError {
+				t.Errorf("expected error %q, got %q", tt.wantError, response.Error)
+			}
+			if response.Code != CodeInvalidSymbol {
+				t.Errorf("expected the code to stay %s, got %s", CodeInvalidSymbol, response.Code)
+			}
+			if got := rr.Header().Get("Content-Language"); got != tt.wantLanguage {
+				t.Errorf("expected Content-Language %q, got %q", tt.wantLanguage, got)
+			}
+			if rr.Header().Get("Vary") != "Accept-Language" {
+				t.Errorf("expected Vary: Accept-Language, got %q", rr.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+// Every language translates the same messages
+func TestErrorMessagesCatalog(t *testing.T) {
+	reference := errorMessages["de"]
+	for lang, messages := range errorMessages {
+		if len(messages) != len(reference) {
+			t.Errorf("%s: expected %d messages, got %d", lang, len(reference), len(messages))
+		}
+		for message := range messages {
+			if _, ok := reference[message]; !ok {
+				t.Errorf("%s: %q is missing from de", lang, message)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/requestid"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/stock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// sseHeartbeatInterval is how often a comment line is sent so proxies
+// don't close an idle event stream.
+const sseHeartbeatInterval = 15 * time.Second
+
+// SSE endpoint - a lighter alternative to /stream for browsers. Sends an
+// "update" event with the symbol's data every STREAM_INTERVAL, or an
+// "error" event when the fetch fails. Shares the stream limit with the
+// websocket endpoint and ends when the client goes away or the server
+// shuts down.
+func (h *Handler) sseHandler(w http.ResponseWriter, r *http.Request) {
+	symbol := mux.Vars(r)["symbol"]
+	if err := h.symbolValidator.Validate(symbol); err != nil {
+		h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid symbol",
+			Details: err.Error(),
+			Code:    CodeInvalidSymbol,
+			Symbol:  symbol,
+		})
+		return
+	}
+
+	days := h.config.NDays
+	if daysStr := r.URL.Query().Get("days"); daysStr != "" {
+		var err error
+		days, err = h.parseDays(daysStr)
+		if err != nil {
+			h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid days",
+				Details: err.Error(),
+				Code:    CodeInvalidDays,
+				Symbol:  symbol,
+			})
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.sendError(w, r, http.StatusInternalServerError, ErrorResponse{
+			Error:  "Streaming not supported",
+			Code:   CodeInternalError,
+			Symbol: symbol,
+		})
+		return
+	}
+
+	if !h.streams.acquire(h.config.StreamMaxConnections) {
+		h.sendError(w, r, http.StatusServiceUnavailable, ErrorResponse{
+			Error:  "Too many open streams",
+			Code:   CodeTooManyStreams,
+			Symbol: symbol,
+		})
+		return
+	}
+	defer h.streams.release()
+
+	// The server's write timeout would otherwise end the stream
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		h.logger.Warn("unable to clear write deadline for event stream", zap.Error(err))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	h.logger.Info("event stream opened", zap.String("symbol", symbol), zap.Int("ndays", days))
+	defer h.logger.Info("event stream closed", zap.String("symbol", symbol))
+
+	ctx := r.Context()
+	updates := time.NewTicker(h.config.StreamInterval)
+	defer updates.Stop()
+	heartbeats := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeats.Stop()
+
+	if err := h.sendSSEUpdate(ctx, w, symbol, days); err != nil {
+		return
+	}
+	flusher.Flush()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.streams.shutdown():
+			return
+		case <-heartbeats.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-updates.C:
+			if err := h.sendSSEUpdate(ctx, w, symbol, days); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// sendSSEUpdate writes the latest data as an update event, or an
+// ErrorResponse as an error event. Only write errors are returned.
+func (h *Handler) sendSSEUpdate(ctx context.Context, w http.ResponseWriter, symbol string, days int) error {
+	event := "update"
+	var message interface{}
+	data, err := h.stockClient.GetStockData(ctx, symbol, days, stock.PeriodDaily, nil)
+	if err != nil {
+		_, code := classifyFetchError(err)
+		event = "error"
+		message = ErrorResponse{
+			Error:     "Failed to fetch stock data",
+			Details:   err.Error(),
+			Code:      code,
+			Symbol:    symbol,
+			Days:      days,
+			RequestID: requestid.FromContext(ctx),
+		}
+	} else {
+		message = data
+	}
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
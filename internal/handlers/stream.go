@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/requestid"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/stock"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+const (
+	// streamWriteWait bounds each write to a stream client.
+	streamWriteWait = 10 * time.Second
+	// streamPongWait is how long a client may go without answering a ping
+	// before it is considered gone; pings are sent a little more often.
+	streamPongWait   = 60 * time.Second
+	streamPingPeriod = streamPongWait * 9 / 10
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// streamTracker counts open streams and closes them on shutdown. The zero
+// value is ready to use.
+type streamTracker struct {
+	mu      sync.Mutex
+	open    int
+	closing bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// acquire reserves a slot for a new stream, failing when max streams are
+// already open or shutdown has started.
+func (t *streamTracker) acquire(max int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closing || t.open >= max {
+		return false
+	}
+	if t.done == nil {
+		t.done = make(chan struct{})
+	}
+	t.open++
+	t.wg.Add(1)
+	return true
+}
+
+func (t *streamTracker) release() {
+	t.mu.Lock()
+	t.open--
+	t.mu.Unlock()
+	t.wg.Done()
+}
+
+// shutdown returns a channel that is closed once CloseStreams is called.
+func (t *streamTracker) shutdown() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.done
+}
+
+// CloseStreams ends every open stream, sending websocket clients a close
+// frame, refuses new ones and waits for them to finish or ctx to expire.
+// Call it before Server.Shutdown, which doesn't track hijacked websocket
+// connections and would otherwise wait for event streams to time out.
+func (h *Handler) CloseStreams(ctx context.Context) error {
+	t := &h.streams
+	t.mu.Lock()
+	if !t.closing {
+		t.closing = true
+		if t.done == nil {
+			t.done = make(chan struct{})
+		}
+		close(t.done)
+	}
+	t.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stream endpoint - upgrades to a websocket and pushes the symbol's data
+// every STREAM_INTERVAL. Updates go through the cache like any other
+// request, so many clients watching one symbol cost one upstream call per
+// cache TTL.
+func (h *Handler) streamHandler(w http.ResponseWriter, r *http.Request) {
+	symbol := mux.Vars(r)["symbol"]
+	if err := h.symbolValidator.Validate(symbol); err != nil {
+		h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid symbol",
+			Details: err.Error(),
+			Code:    CodeInvalidSymbol,
+			Symbol:  symbol,
+		})
+		return
+	}
+
+	days := h.config.NDays
+	if daysStr := r.URL.Query().Get("days"); daysStr != "" {
+		var err error
+		days, err = h.parseDays(daysStr)
+		if err != nil {
+			h.sendError(w, r, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid days",
+				Details: err.Error(),
+				Code:    CodeInvalidDays,
+				Symbol:  symbol,
+			})
+			return
+		}
+	}
+
+	if !h.streams.acquire(h.config.StreamMaxConnections) {
+		h.sendError(w, r, http.StatusServiceUnavailable, ErrorResponse{
+			Error:  "Too many open streams",
+			Code:   CodeTooManyStreams,
+			Symbol: symbol,
+		})
+		return
+	}
+	defer h.streams.release()
+
+	// Upgrade replies with an HTTP error itself on failure
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.logger.Warn("websocket upgrade failed", zap.String("symbol", symbol), zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	h.serveStream(r.Context(), conn, symbol, days)
+}
+
+// serveStream pushes updates until the client disconnects, stops
+// answering pings, or the server shuts down.
+func (h *Handler) serveStream(ctx context.Context, conn *websocket.Conn, symbol string, days int) {
+	// Clients only send control frames; reading is needed to process pongs
+	// and to notice a disconnect.
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	})
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	updates := time.NewTicker(h.config.StreamInterval)
+	defer updates.Stop()
+	pings := time.NewTicker(streamPingPeriod)
+	defer pings.Stop()
+
+	h.logger.Info("stream opened", zap.String("symbol", symbol), zap.Int("ndays", days))
+	defer h.logger.Info("stream closed", zap.String("symbol", symbol))
+
+	if err := h.sendStreamUpdate(ctx, conn, symbol, days); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-disconnected:
+			return
+		case <-h.streams.shutdown():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+				time.Now().Add(streamWriteWait))
+			// Give the client a moment to answer the close handshake
+			select {
+			case <-disconnected:
+			case <-time.After(time.Second):
+			}
+			return
+		case <-pings.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait)); err != nil {
+				return
+			}
+		case <-updates.C:
+			if err := h.sendStreamUpdate(ctx, conn, symbol, days); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sendStreamUpdate writes the latest data, or an ErrorResponse if it
+// couldn't be fetched. Fetch errors keep the stream open; only write
+// errors are returned.
+func (h *Handler) sendStreamUpdate(ctx context.Context, conn *websocket.Conn, symbol string, days int) error {
+	var message interface{}
+	data, err := h.stockClient.GetStockData(ctx, symbol, days, stock.PeriodDaily, nil)
+	if err != nil {
+		_, code := classifyFetchError(err)
+		message = ErrorResponse{
+			Error:     "Failed to fetch stock data",
+			Details:   err.Error(),
+			Code:      code,
+			Symbol:    symbol,
+			Days:      days,
+			RequestID: requestid.FromContext(ctx),
+		}
+	} else {
+		message = data
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+	return conn.WriteJSON(message)
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/config"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/middleware"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/stock"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// newStreamServer serves handler's routes behind the response-wrapping
+// middleware, which must let the websocket upgrade through.
+func newStreamServer(t *testing.T, handler *Handler) string {
+	t.Helper()
+
+	router := mux.NewRouter()
+	router.Use(middleware.NewHTTPMetrics(nil, "test", nil).Metrics)
+	router.Use(middleware.Compression)
+	handler.RegisterRoutes(router)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func newStreamHandler(provider *stubProvider, maxConnections int) *Handler {
+	return New(HandlerOptions{
+		Config: &config.Config{
+			Symbol:               "MSFT",
+			NDays:                7,
+			MaxDays:              1000,
+			StreamInterval:       10 * time.Millisecond,
+			StreamMaxConnections: maxConnections,
+		},
+		StockClient: newTestClient(provider),
+		Logger:      zap.NewNop(),
+	})
+}
+
+func TestStreamPushesUpdates(t *testing.T) {
+	handler := newStreamHandler(&stubProvider{}, 10)
+	url := newStreamServer(t, handler)
+
+	header := http.Header{"Accept-Encoding": {"gzip"}}
+	conn, _, err := websocket.DefaultDialer.Dial(url+"/stream/AAPL?days=3", header)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 2; i++ {
+		var data stock.StockData
+		if err := conn.ReadJSON(&data); err != nil {
+			t.Fatalf("update %d: %v", i, err)
+		}
+		if data.Symbol != "AAPL" || data.NDays != 3 {
+			t.Errorf("update %d: unexpected data %+v", i, data)
+		}
+	}
+}
+
+func TestStreamReportsFetchErrors(t *testing.T) {
+	handler := newStreamHandler(&stubProvider{err: stock.ErrSymbolNotFound}, 10)
+	url := newStreamServer(t, handler)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"/stream/NOPE", nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	var resp ErrorResponse
+	if err := conn.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != CodeSymbolNotFound {
+		t.Errorf("expected code %s, got %+v", CodeSymbolNotFound, resp)
+	}
+}
+
+func TestStreamRejectsInvalidRequests(t *testing.T) {
+	handler := newStreamHandler(&stubProvider{}, 1)
+	url := newStreamServer(t, handler)
+
+	_, resp, err := websocket.DefaultDialer.Dial(url+"/stream/bad$symbol", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid symbol, got %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"/stream/AAPL", nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	_, resp, err = websocket.DefaultDialer.Dial(url+"/stream/MSFT", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 beyond the connection limit, got %v", err)
+	}
+}
+
+func TestCloseStreams(t *testing.T) {
+	handler := newStreamHandler(&stubProvider{}, 10)
+	url := newStreamServer(t, handler)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"/stream/AAPL", nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Read in the background so the close frame is processed
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := handler.CloseStreams(ctx); err != nil {
+		t.Fatalf("CloseStreams: %v", err)
+	}
+
+	if err := <-closed; !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("expected a going-away close frame, got %v", err)
+	}
+
+	// New streams are refused once shutdown has started
+	_, resp, err := websocket.DefaultDialer.Dial(url+"/stream/AAPL", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 after CloseStreams, got %v", err)
+	}
+}
+
+func TestSSE(t *testing.T) {
+	handler := newStreamHandler(&stubProvider{}, 10)
+	url := strings.Replace(newStreamServer(t, handler), "ws", "http", 1)
+
+	req, _ := http.NewRequest("GET", url+"/sse/AAPL?days=3", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected text/event-stream, got %q", ct)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for i := 0; i < 2; i++ {
+		var event, data string
+		for scanner.Scan() && scanner.Text() != "" {
+			line := scanner.Text()
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				event = name
+			} else if payload, ok := strings.CutPrefix(line, "data: "); ok {
+				data = payload
+			}
+		}
+		if event != "update" {
+			t.Fatalf("event %d: expected update, got %q", i, event)
+		}
+		var stockData stock.StockData
+		if err := json.Unmarshal([]byte(data), &stockData); err != nil || stockData.Symbol != "AAPL" || stockData.NDays != 3 {
+			t.Errorf("event %d: unexpected data %q (%v)", i, data, err)
+		}
+	}
+
+	// Shutdown ends the stream so Server.Shutdown isn't held up
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := handler.CloseStreams(ctx); err != nil {
+		t.Fatalf("CloseStreams: %v", err)
+	}
+	for scanner.Scan() {
+	}
+}
+
+func TestSSERejectsInvalidSymbol(t *testing.T) {
+	handler := newStreamHandler(&stubProvider{}, 10)
+
+	rr := httptest.NewRecorder()
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/sse/bad$symbol", nil), map[string]string{"symbol": "bad$symbol"})
+	handler.sseHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected a JSON error before the stream opens, got %q", ct)
+	}
+}
@@ -0,0 +1,47 @@
+// Package logging builds the service's zap logger from configuration.
+package logging
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewConfig returns a zap production config logging at level ("debug",
+// "info", "warn" or "error") in format ("json" or "console"). Repeated
+// messages are sampled, except at debug level where every line is wanted.
+func NewConfig(level, format string) (zap.Config, error) {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return zap.Config{}, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	cfg := zap.NewProductionConfig()
+	cfg.Level = zap.NewAtomicLevelAt(lvl)
+	switch format {
+	case "json":
+	case "console":
+		cfg.Encoding = "console"
+		cfg.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	default:
+		return zap.Config{}, fmt.Errorf("invalid log format %q: must be json or console", format)
+	}
+
+	// Per second, log the first 100 of each message and every 100th after
+	if lvl == zapcore.DebugLevel {
+		cfg.Sampling = nil
+	} else {
+		cfg.Sampling = &zap.SamplingConfig{Initial: 100, Thereafter: 100}
+	}
+	return cfg, nil
+}
+
+// New builds a logger writing to stderr as described by NewConfig.
+func New(level, format string) (*zap.Logger, error) {
+	cfg, err := NewConfig(level, format)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Build()
+}
@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestNewConfigSuppressesDebugAtInfo(t *testing.T) {
+	cfg, err := NewConfig("info", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "log")
+	cfg.OutputPaths = []string{path}
+
+	logger, err := cfg.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("hidden debug line")
+	logger.Info("visible info line")
+	logger.Sync()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hidden debug line") {
+		t.Errorf("expected debug logs to be suppressed at info, got %s", data)
+	}
+	if !strings.Contains(string(data), "visible info line") {
+		t.Errorf("expected info logs to be written, got %s", data)
+	}
+}
+
+func TestNewConfigLevelsAndSampling(t *testing.T) {
+	debug, err := NewConfig("debug", "console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !debug.Level.Enabled(zap.DebugLevel) || debug.Sampling != nil || debug.Encoding != "console" {
+		t.Errorf("expected unsampled console debug logging, got %+v", debug)
+	}
+
+	warn, err := NewConfig("warn", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warn.Level.Enabled(zap.InfoLevel) || !warn.Level.Enabled(zap.WarnLevel) || warn.Sampling == nil {
+		t.Errorf("expected sampled warn logging, got %+v", warn)
+	}
+}
+
+func TestNewConfigRejectsInvalidSettings(t *testing.T) {
+	if _, err := NewConfig("verbose", "json"); err == nil {
+		t.Error("expected an invalid level to be rejected")
+	}
+	if _, err := NewConfig("info", "xml"); err == nil {
+		t.Error("expected an invalid format to be rejected")
+	}
+}
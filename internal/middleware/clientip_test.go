@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.1/32")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		want       string
+	}{
+		{"no proxy", "203.0.113.7:51234", nil, "203.0.113.7"},
+		{"untrusted peer's header is ignored", "203.0.113.7:51234", []string{"198.51.100.2"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.5:443", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"spoofed entries left of the client are ignored", "10.0.0.5:443", []string{"198.51.100.2, 203.0.113.7"}, "203.0.113.7"},
+		{"chained trusted proxies are skipped", "10.0.0.5:443", []string{"203.0.113.7, 192.0.2.1, 10.1.2.3"}, "203.0.113.7"},
+		{"repeated headers are read as one list", "10.0.0.5:443", []string{"198.51.100.2", "203.0.113.7"}, "203.0.113.7"},
+		{"garbage stops at the last checked hop", "10.0.0.5:443", []string{"not-an-ip, 10.1.2.3"}, "10.1.2.3"},
+		{"all hops trusted", "10.0.0.5:443", []string{"10.1.2.3"}, "10.1.2.3"},
+		{"trusted proxy without a header", "10.0.0.5:443", nil, "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		var got string
+		handler := ClientIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = ClientIPFromContext(r.Context())
+		}))
+
+		req := httptest.NewRequest("GET", "/AAPL", nil)
+		req.RemoteAddr = tt.remoteAddr
+		for _, v := range tt.xff {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// compressionMinSize is the smallest response worth compressing; anything
+// below roughly one TCP segment gains nothing from gzip.
+const compressionMinSize = 1400
+
+// Compression gzips responses for clients that accept it. Small responses,
+// the Prometheus /metrics endpoint and protocol upgrades such as websockets
+// are passed through untouched; /metrics negotiates its own compression and
+// format, so wrapping it would encode the body twice.
+func Compression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metrics" || r.Header.Get("Upgrade") != "" || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		defer gw.Close()
+
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response and only switches to
+// gzip once it exceeds compressionMinSize. The status code is held back
+// until that decision is made, then passed to the wrapped writer so outer
+// middleware still records it.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	buf         bytes.Buffer
+	statusCode  int
+	wroteHeader bool
+	passthrough bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.statusCode = code
+	g.wroteHeader = true
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	g.wroteHeader = true
+
+	switch {
+	case g.gz != nil:
+		return g.gz.Write(p)
+	case g.passthrough:
+		return g.ResponseWriter.Write(p)
+	}
+
+	g.buf.Write(p)
+	if g.buf.Len() >= compressionMinSize {
+		if err := g.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends buffered data immediately, compressing it if the response
+// is eligible.
+func (g *gzipResponseWriter) Flush() {
+	if g.gz == nil && !g.passthrough {
+		if err := g.startGzip(); err != nil {
+			return
+		}
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the wrapped writer to http.ResponseController.
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// Close writes out whatever is still buffered and finishes the gzip stream.
+func (g *gzipResponseWriter) Close() error {
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	if g.passthrough {
+		return nil
+	}
+
+	// Never reached the threshold: send the response as-is
+	g.passthrough = true
+	g.ResponseWriter.WriteHeader(g.statusCode)
+	_, err := g.ResponseWriter.Write(g.buf.Bytes())
+	return err
+}
+
+func (g *gzipResponseWriter) startGzip() error {
+	h := g.ResponseWriter.Header()
+	// Event streams are flushed per event, which gzip handles poorly
+	eventStream := strings.HasPrefix(h.Get("Content-Type"), "text/event-stream")
+	if h.Get("Content-Encoding") != "" || eventStream || g.statusCode == http.StatusNoContent || g.statusCode == http.StatusNotModified {
+		g.passthrough = true
+		g.ResponseWriter.WriteHeader(g.statusCode)
+		_, err := g.ResponseWriter.Write(g.buf.Bytes())
+		return err
+	}
+
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	g.ResponseWriter.WriteHeader(g.statusCode)
+
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf.Bytes())
+	g.buf.Reset()
+	return err
+}
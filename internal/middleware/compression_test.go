@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressionLargeResponse(t *testing.T) {
+	body := strings.Repeat("a", 4*compressionMinSize)
+	handler := Compression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, body)
+	}))
+
+	req := httptest.NewRequest("GET", "/AAPL/30", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("expected status %d, got %d", http.StatusAccepted, rr.Code)
+	}
+	if enc := rr.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("expected gzip Content-Encoding, got %q", enc)
+	}
+
+	gr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("failed to open gzip body: %v", err)
+	}
+	decoded, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("failed to decode gzip body: %v", err)
+	}
+	if string(decoded) != body {
+		t.Error("decoded body does not match original")
+	}
+}
+
+func TestCompressionSkipsSmallResponses(t *testing.T) {
+	handler := Compression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"error":"not found"}`)
+	}))
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+	if enc := rr.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("expected no Content-Encoding, got %q", enc)
+	}
+	if rr.Body.String() != `{"error":"not found"}` {
+		t.Errorf("unexpected body %q", rr.Body.String())
+	}
+}
+
+func TestCompressionSkipsMetricsEndpoint(t *testing.T) {
+	handler := Compression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("m", 4*compressionMinSize))
+	}))
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if enc := rr.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("expected /metrics to be left uncompressed, got %q", enc)
+	}
+}
+
+func TestCompressionSkipsEventStreams(t *testing.T) {
+	handler := Compression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "event: update\ndata: {}\n\n")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, strings.Repeat("e", 4*compressionMinSize))
+	}))
+
+	req := httptest.NewRequest("GET", "/sse/AAPL", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if enc := rr.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("expected event stream to be left uncompressed, got %q", enc)
+	}
+	if !strings.HasPrefix(rr.Body.String(), "event: update") {
+		t.Errorf("unexpected body %q", rr.Body.String()[:20])
+	}
+}
+
+func TestCompressionPreservesStatusForMetrics(t *testing.T) {
+	var recorded int
+	inner := Compression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, strings.Repeat("x", 4*compressionMinSize))
+	}))
+	outer := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lrw := &loggingResponseWriter{ResponseWriter: w}
+		inner.ServeHTTP(lrw, r)
+		recorded = lrw.statusCode
+	})
+
+	req := httptest.NewRequest("GET", "/AAPL", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	outer.ServeHTTP(httptest.NewRecorder(), req)
+
+	if recorded != http.StatusServiceUnavailable {
+		t.Errorf("expected wrapped writer to record %d, got %d", http.StatusServiceUnavailable, recorded)
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+var inFlight atomic.Int64
+
+// InFlight counts the requests currently being served, for the
+// requests_in_flight gauge and InFlightRequests.
+func InFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// InFlightRequests returns the number of requests currently inside the
+// InFlight middleware.
+func InFlightRequests() int64 {
+	return inFlight.Load()
+}
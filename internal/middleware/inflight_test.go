@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestInFlight(t *testing.T) {
+	release := make(chan struct{})
+	var entered sync.WaitGroup
+	handler := InFlight(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered.Done()
+		<-release
+	}))
+
+	before := InFlightRequests()
+
+	const requests = 3
+	var done sync.WaitGroup
+	entered.Add(requests)
+	done.Add(requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			defer done.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/AAPL", nil))
+		}()
+	}
+	entered.Wait()
+
+	if got := InFlightRequests() - before; got != requests {
+		t.Errorf("Expected %d requests in flight, got %d", requests, got)
+	}
+
+	close(release)
+	done.Wait()
+
+	if got := InFlightRequests(); got != before {
+		t.Errorf("Expected in-flight count to return to %d, got %d", before, got)
+	}
+}
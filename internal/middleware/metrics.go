@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// DefaultMetricsNamespace prefixes the package-level middleware metrics
+// until RegisterMetrics is called with the configured namespace.
+const DefaultMetricsNamespace = "stock_api"
+
+// HTTPMetrics holds the request metrics recorded by the Metrics and
+// Recover middleware. Each server can use its own set, registered with its
+// own registry, so several can run in one process.
+type HTTPMetrics struct {
+	requestDuration  *prometheus.HistogramVec
+	requestCount     *prometheus.CounterVec
+	requestsInFlight prometheus.GaugeFunc
+	panicsTotal      prometheus.Counter
+}
+
+// defaultMetrics backs the package-level Metrics and Recover middleware.
+var defaultMetrics = NewHTTPMetrics(nil, DefaultMetricsNamespace, nil)
+
+// NewHTTPMetrics creates the middleware metrics under namespace and
+// registers them with reg. A nil reg leaves them unregistered. buckets are
+// the request duration histogram's bounds in seconds; nil means
+// prometheus.DefBuckets.
+func NewHTTPMetrics(reg prometheus.Registerer, namespace string, buckets []float64) *HTTPMetrics {
+	m := &HTTPMetrics{
+		requestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "request_duration_seconds",
+				Help:      "Duration of HTTP requests in seconds",
+				Buckets:   buckets,
+			},
+			[]string{"method", "endpoint", "code"},
+		),
+		requestCount: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "requests_total",
+				Help:      "Total number of HTTP requests",
+			},
+			[]string{"method", "endpoint", "code"},
+		),
+		requestsInFlight: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "requests_in_flight",
+			Help:      "Number of HTTP requests currently being served",
+		}, func() float64 {
+			return float64(inFlight.Load())
+		}),
+		panicsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "panics_total",
+			Help:      "Total number of panics recovered from HTTP handlers",
+		}),
+	}
+
+	if reg != nil {
+		reg.MustRegister(m.requestDuration, m.requestCount, m.requestsInFlight, m.panicsTotal)
+	}
+	return m
+}
+
+// RegisterMetrics recreates the package-level middleware metrics under
+// namespace and registers them with the default registry. Call it once at
+// startup, before the middleware serves any requests.
+func RegisterMetrics(namespace string) {
+	defaultMetrics = NewHTTPMetrics(prometheus.DefaultRegisterer, namespace, nil)
+}
+
+// Metrics records request counts and durations in the package-level
+// metrics.
+func Metrics(next http.Handler) http.Handler {
+	return defaultMetrics.Metrics(next)
+}
+
+// Recover recovers panics, counting them in the package-level metrics.
+func Recover(logger *zap.Logger) mux.MiddlewareFunc {
+	return defaultMetrics.Recover(logger)
+}
+
+// Metrics records the count and duration of each request, labeled by
+// method, route template and status code.
+func (m *HTTPMetrics) Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lrw := wrapResponseWriter(w)
+		next.ServeHTTP(lrw, r)
+
+		route := mux.CurrentRoute(r)
+		path, _ := route.GetPathTemplate()
+
+		duration := time.Since(start).Seconds()
+		code := strconv.Itoa(lrw.statusCode)
+
+		m.requestDuration.WithLabelValues(r.Method, path, code).Observe(duration)
+		m.requestCount.WithLabelValues(r.Method, path, code).Inc()
+	})
+}
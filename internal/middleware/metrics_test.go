@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHTTPMetricsPerRegistry(t *testing.T) {
+	// Two servers in one process must not collide on registration
+	regA, regB := prometheus.NewRegistry(), prometheus.NewRegistry()
+	metricsA := NewHTTPMetrics(regA, "test", nil)
+	NewHTTPMetrics(regB, "test", nil)
+
+	router := mux.NewRouter()
+	router.Use(metricsA.Metrics)
+	router.HandleFunc("/{symbol}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/AAPL", nil))
+
+	if got := testutil.ToFloat64(metricsA.requestCount.WithLabelValues("GET", "/{symbol}", "200")); got != 1 {
+		t.Errorf("expected 1 request recorded, got %v", got)
+	}
+	if got := testutil.CollectAndCount(regB, "test_requests_total"); got != 0 {
+		t.Errorf("expected no requests recorded in the other registry, got %d series", got)
+	}
+}
+
+func TestHTTPMetricsBuckets(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics := NewHTTPMetrics(reg, "test", []float64{0.001, 0.1, 5})
+
+	router := mux.NewRouter()
+	router.Use(metrics.Metrics)
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "test_request_duration_seconds" {
+			continue
+		}
+		var bounds []float64
+		for _, bucket := range family.GetMetric()[0].GetHistogram().GetBucket() {
+			bounds = append(bounds, bucket.GetUpperBound())
+		}
+		if len(bounds) != 3 || bounds[0] != 0.001 || bounds[2] != 5 {
+			t.Errorf("expected buckets [0.001 0.1 5], got %v", bounds)
+		}
+		return
+	}
+	t.Error("expected the request duration histogram to be registered")
+}
@@ -1,75 +1,143 @@
 package middleware
 
 import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/requestid"
 	"github.com/gorilla/mux"
-	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
-var (
-	requestDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name: "ping_service_request_duration_seconds",
-			Help: "Duration of HTTP requests in seconds",
-		},
-		[]string{"method", "endpoint", "code"},
-	)
-
-	requestCount = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "ping_service_requests_total",
-			Help: "Total number of HTTP requests",
-		},
-		[]string{"method", "endpoint", "code"},
-	)
-)
-
-func init() {
-	prometheus.MustRegister(requestDuration)
-	prometheus.MustRegister(requestCount)
-}
-
+// Logging writes an access log line for every request once it has been
+// served, including the response status and size.
 func Logging(logger *zap.Logger) mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
-			next.ServeHTTP(w, r)
+			lrw := wrapResponseWriter(w)
+			next.ServeHTTP(lrw, r)
 			logger.Info("request processed",
+				zap.String("request_id", requestid.FromContext(r.Context())),
 				zap.String("method", r.Method),
 				zap.String("path", r.URL.Path),
+				zap.Int("status", lrw.statusCode),
+				zap.Int64("bytes", lrw.bytesWritten),
+				zap.String("client_ip", clientIP(r)),
+				zap.String("user_agent", r.UserAgent()),
 				zap.Duration("duration", time.Since(start)),
 			)
 		})
 	}
 }
 
-func Metrics(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		lrw := &loggingResponseWriter{ResponseWriter: w}
-		next.ServeHTTP(lrw, r)
+// AdminAuth guards administrative endpoints with a static bearer token.
+// When token is empty the endpoints are disabled entirely.
+func AdminAuth(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				writeJSONError(w, http.StatusForbidden, "admin endpoints are disabled")
+				return
+			}
 
-		route := mux.CurrentRoute(r)
-		path, _ := route.GetPathTemplate()
+			if !hasBearerToken(r, token) {
+				writeJSONError(w, http.StatusUnauthorized, "invalid or missing admin token")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MetricsAuth guards the metrics endpoint with its own static bearer
+// token, so scrapers don't need the admin token. Unlike AdminAuth, an
+// empty token leaves the endpoint open.
+func MetricsAuth(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if token == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasBearerToken(r, token) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeJSONError(w, http.StatusUnauthorized, "invalid or missing metrics token")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-		duration := time.Since(start).Seconds()
-		code := strconv.Itoa(lrw.statusCode)
+// hasBearerToken reports whether r's Authorization header carries token,
+// comparing in constant time.
+func hasBearerToken(r *http.Request, token string) bool {
+	provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
 
-		requestDuration.WithLabelValues(r.Method, path, code).Observe(duration)
-		requestCount.WithLabelValues(r.Method, path, code).Inc()
+func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": message,
 	})
 }
 
+// loggingResponseWriter records the status and size of a response for the
+// logging and metrics middleware, which share a single instance.
 type loggingResponseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode   int
+	bytesWritten int64
+}
+
+// wrapResponseWriter returns w as a loggingResponseWriter, reusing w itself
+// when an outer middleware has already wrapped it.
+func wrapResponseWriter(w http.ResponseWriter) *loggingResponseWriter {
+	if lrw, ok := w.(*loggingResponseWriter); ok {
+		return lrw
+	}
+	return &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 }
 
 func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.statusCode = code
 	lrw.ResponseWriter.WriteHeader(code)
 }
+
+func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
+	n, err := lrw.ResponseWriter.Write(b)
+	lrw.bytesWritten += int64(n)
+	return n, err
+}
+
+func (lrw *loggingResponseWriter) Flush() {
+	if f, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the wrapped writer to http.ResponseController.
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
+// Hijack lets websocket handlers take over the connection; the request is
+// recorded as 101 Switching Protocols.
+func (lrw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := lrw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	lrw.statusCode = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
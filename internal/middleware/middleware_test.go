@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLoggingRecordsResponse(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	handler := Logging(zap.New(core))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, "upstream failed")
+	}))
+	// Logging reads the client resolved by ClientIP
+	handler = ClientIP([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})(handler)
+
+	req := httptest.NewRequest("GET", "/AAPL", nil)
+	req.RemoteAddr = "10.0.0.5:443"
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := logs.FilterMessage("request processed").All()
+	if len(entries) != 1 {
+		t.Fatalf("expected one access log entry, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["status"] != int64(http.StatusBadGateway) {
+		t.Errorf("expected status 502, got %v", fields["status"])
+	}
+	if fields["bytes"] != int64(len("upstream failed")) {
+		t.Errorf("expected %d bytes, got %v", len("upstream failed"), fields["bytes"])
+	}
+	if fields["client_ip"] != "203.0.113.7" {
+		t.Errorf("expected client IP 203.0.113.7, got %v", fields["client_ip"])
+	}
+	if fields["user_agent"] != "test-agent" {
+		t.Errorf("expected user agent test-agent, got %v", fields["user_agent"])
+	}
+}
+
+func TestLoggingDefaultsToOK(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	handler := Logging(zap.New(core))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/AAPL", nil))
+
+	if status := logs.All()[0].ContextMap()["status"]; status != int64(http.StatusOK) {
+		t.Errorf("expected an implicit 200 to be logged, got %v", status)
+	}
+}
+
+func TestLoggingAndMetricsShareWriter(t *testing.T) {
+	metrics := NewHTTPMetrics(prometheus.NewRegistry(), "test", nil)
+
+	var inner http.ResponseWriter
+	router := mux.NewRouter()
+	router.Use(Logging(zap.NewNop()))
+	router.Use(metrics.Metrics)
+	router.HandleFunc("/{symbol}", func(w http.ResponseWriter, r *http.Request) {
+		inner = w
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/AAPL", nil))
+
+	lrw, ok := inner.(*loggingResponseWriter)
+	if !ok {
+		t.Fatalf("expected handlers to get a loggingResponseWriter, got %T", inner)
+	}
+	if _, nested := lrw.ResponseWriter.(*loggingResponseWriter); nested {
+		t.Error("expected logging and metrics to share one wrapper")
+	}
+	if got := testutil.ToFloat64(metrics.requestCount.WithLabelValues("GET", "/{symbol}", "200")); got != 1 {
+		t.Errorf("expected an implicit 200 to be counted, got %v", got)
+	}
+}
+
+func TestMetricsAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		want          int
+	}{
+		{"open without a token", "", "", http.StatusOK},
+		{"authorized", "scrape", "Bearer scrape", http.StatusOK},
+		{"missing token", "scrape", "", http.StatusUnauthorized},
+		{"wrong token", "scrape", "Bearer admin", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			MetricsAuth(tt.token)(ok).ServeHTTP(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, rr.Code)
+			}
+			if tt.want == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Error("expected a WWW-Authenticate challenge")
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+)
+
+// limiterIdleTimeout is how long a client's bucket is kept after its last
+// request before it is discarded.
+const limiterIdleTimeout = 10 * time.Minute
+
+// maxLimitedClients caps how many clients' buckets are kept. Past it the
+// least recently seen client is forgotten, so a flood of new addresses
+// can't grow memory without bound.
+const maxLimitedClients = 10000
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type ipRateLimiter struct {
+	rps       rate.Limit
+	burst     int
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+// RateLimit applies a token bucket per client IP, as resolved by ClientIP,
+// refilled at rps tokens per second up to burst. Requests over the limit get a 429 with a
+// Retry-After header. Probe and metrics endpoints are never limited, and
+// a non-positive rps disables limiting.
+func RateLimit(rps float64, burst int) mux.MiddlewareFunc {
+	rl := &ipRateLimiter{
+		rps:       rate.Limit(rps),
+		burst:     burst,
+		clients:   make(map[string]*clientLimiter),
+		lastSweep: time.Now(),
+	}
+
+	return func(next http.Handler) http.Handler {
+		if rps <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/health", "/ready", "/metrics":
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reservation := rl.limiter(clientIP(r)).Reserve()
+			if delay := reservation.Delay(); delay > 0 {
+				reservation.Cancel()
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (rl *ipRateLimiter) limiter(ip string) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.lastSweep) > time.Minute {
+		for key, c := range rl.clients {
+			if now.Sub(c.lastSeen) > limiterIdleTimeout {
+				delete(rl.clients, key)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	c, found := rl.clients[ip]
+	if !found {
+		if len(rl.clients) >= maxLimitedClients {
+			rl.evictOldest()
+		}
+		c = &clientLimiter{limiter: rate.NewLimiter(rl.rps, rl.burst)}
+		rl.clients[ip] = c
+	}
+	c.lastSeen = now
+
+	return c.limiter
+}
+
+// evictOldest forgets the least recently seen client. rl.mu must be held.
+func (rl *ipRateLimiter) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, c := range rl.clients {
+		if oldestKey == "" || c.lastSeen.Before(oldest) {
+			oldestKey, oldest = key, c.lastSeen
+		}
+	}
+	delete(rl.clients, oldestKey)
+}
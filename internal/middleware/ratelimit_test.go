@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitBurstAndRecovery(t *testing.T) {
+	handler := RateLimit(10, 2)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	do := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/AAPL", nil)
+		req.RemoteAddr = ip + ":51234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := do("203.0.113.7"); rr.Code != http.StatusOK {
+			t.Fatalf("request %d within burst: expected 200, got %d", i, rr.Code)
+		}
+	}
+
+	rr := do("203.0.113.7")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 past the burst, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header on 429")
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON error body, got Content-Type %q", ct)
+	}
+
+	// Other clients have their own bucket
+	if rr := do("198.51.100.2"); rr.Code != http.StatusOK {
+		t.Errorf("expected a different client to be allowed, got %d", rr.Code)
+	}
+
+	// One token refills every 100ms at 10 rps
+	time.Sleep(150 * time.Millisecond)
+	if rr := do("203.0.113.7"); rr.Code != http.StatusOK {
+		t.Errorf("expected client to recover after the window, got %d", rr.Code)
+	}
+}
+
+func TestRateLimitIgnoresUntrustedForwardedFor(t *testing.T) {
+	handler := RateLimit(1, 1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Rotating X-Forwarded-For doesn't give a client new buckets
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/AAPL", nil)
+		req.RemoteAddr = "203.0.113.7:51234"
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if i > 0 && rr.Code != http.StatusTooManyRequests {
+			t.Errorf("request %d: expected 429, got %d", i, rr.Code)
+		}
+	}
+}
+
+func TestRateLimiterCapsClients(t *testing.T) {
+	rl := &ipRateLimiter{rps: 1, burst: 1, clients: make(map[string]*clientLimiter), lastSweep: time.Now()}
+
+	first := rl.limiter("192.0.2.0")
+	for i := 1; i <= maxLimitedClients; i++ {
+		rl.limiter(fmt.Sprintf("10.%d.%d.%d", i>>16, (i>>8)&0xff, i&0xff))
+	}
+	if n := len(rl.clients); n != maxLimitedClients {
+		t.Errorf("expected the client map to be capped at %d, got %d", maxLimitedClients, n)
+	}
+	if _, found := rl.clients["192.0.2.0"]; found {
+		t.Error("expected the least recently seen client to be evicted")
+	}
+	if rl.limiter("192.0.2.0") == first {
+		t.Error("expected an evicted client to get a fresh bucket")
+	}
+}
+
+func TestRateLimitExemptsProbes(t *testing.T) {
+	handler := RateLimit(1, 1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 5; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected /health to bypass the limiter, got %d", rr.Code)
+		}
+	}
+}
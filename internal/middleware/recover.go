@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Recover turns a panic in a later handler into a 500 JSON response so the
+// client gets a structured error instead of a dropped connection.
+func (m *HTTPMetrics) Recover(logger *zap.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				// net/http uses this sentinel to abort a response on purpose
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+
+				m.panicsTotal.Inc()
+				// Recover runs before RequestID, so the ID is only
+				// available from the response header it set.
+				logger.Error("recovered from panic",
+					zap.String("request_id", w.Header().Get(RequestIDHeader)),
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path),
+					zap.Any("panic", rec),
+					zap.ByteString("stack", debug.Stack()),
+				)
+				writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestRecover(t *testing.T) {
+	metrics := NewHTTPMetrics(nil, "test", nil)
+	handler := metrics.Recover(zap.NewNop())(RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["boom"]++ // nil map write
+	})))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/AAPL", nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON error body, got Content-Type %q", ct)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected valid JSON body: %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.panicsTotal); got != 1 {
+		t.Errorf("expected panics counter to increase by 1, got %v", got)
+	}
+}
+
+func TestRecoverPassesThrough(t *testing.T) {
+	handler := Recover(zap.NewNop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/AAPL", nil))
+
+	if rr.Code != http.StatusTeapot {
+		t.Errorf("expected handler status to pass through, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		reuse    bool
+	}{
+		{name: "generated when missing", incoming: "", reuse: false},
+		{name: "incoming reused", incoming: "abc-123", reuse: true},
+		{name: "invalid incoming replaced", incoming: "bad\nid", reuse: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/AAPL", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			echoed := rr.Header().Get(RequestIDHeader)
+			if echoed == "" || echoed != seen {
+				t.Fatalf("expected context ID %q to be echoed, got %q", seen, echoed)
+			}
+			if tt.reuse != (echoed == tt.incoming) {
+				t.Errorf("incoming %q: unexpected ID %q", tt.incoming, echoed)
+			}
+		})
+	}
+}
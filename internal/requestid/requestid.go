@@ -0,0 +1,20 @@
+// Package requestid carries a request's correlation ID in its context, so
+// code below the HTTP layer can tag log lines with it without depending
+// on the middleware that sets it.
+package requestid
+
+import "context"
+
+type key struct{}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// FromContext returns the request ID carried by ctx, or an empty string
+// if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
@@ -0,0 +1,508 @@
+package stock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/tracing"
+	"go.uber.org/zap"
+)
+
+// ProviderAlphaVantage is the name of the Alpha Vantage provider.
+const ProviderAlphaVantage = "alphavantage"
+
+// compactOutputSize is the number of data points Alpha Vantage returns
+// without outputsize=full.
+const compactOutputSize = 100
+
+// DefaultMaxBodyBytes is the response size providers read when no limit
+// is configured. A full daily series is well under it.
+const DefaultMaxBodyBytes = 16 << 20
+
+// Output size modes for the Alpha Vantage outputsize parameter.
+const (
+	OutputSizeAuto    = "auto"
+	OutputSizeCompact = "compact"
+	OutputSizeFull    = "full"
+)
+
+type AlphaVantageResponse struct {
+	TimeSeriesDaily   map[string]DailyData `json:"Time Series (Daily)"`
+	TimeSeriesWeekly  map[string]DailyData `json:"Weekly Time Series"`
+	TimeSeriesMonthly map[string]DailyData `json:"Monthly Time Series"`
+	Note              string               `json:"Note"`
+	Information       string               `json:"Information"`
+	ErrorMessage      string               `json:"Error Message"`
+}
+
+// alphaVantageExchangeRateResponse is the CURRENCY_EXCHANGE_RATE payload.
+type alphaVantageExchangeRateResponse struct {
+	ExchangeRate struct {
+		Rate string `json:"5. Exchange Rate"`
+	} `json:"Realtime Currency Exchange Rate"`
+	Note         string `json:"Note"`
+	ErrorMessage string `json:"Error Message"`
+}
+
+// alphaVantageQuoteResponse is the GLOBAL_QUOTE payload. Its keys are
+// numbered, and unknown symbols get an empty "Global Quote" object.
+type alphaVantageQuoteResponse struct {
+	Quote struct {
+		Symbol           string `json:"01. symbol"`
+		Price            string `json:"05. price"`
+		Volume           string `json:"06. volume"`
+		LatestTradingDay string `json:"07. latest trading day"`
+		Change           string `json:"09. change"`
+		ChangePercent    string `json:"10. change percent"`
+	} `json:"Global Quote"`
+	Note         string `json:"Note"`
+	Information  string `json:"Information"`
+	ErrorMessage string `json:"Error Message"`
+}
+
+// alphaVantageAdjustedData is a point in TIME_SERIES_DAILY_ADJUSTED, which
+// renumbers the fields after the adjusted close.
+type alphaVantageAdjustedData struct {
+	Open          string `json:"1. open"`
+	High          string `json:"2. high"`
+	Low           string `json:"3. low"`
+	Close         string `json:"4. close"`
+	AdjustedClose string `json:"5. adjusted close"`
+	Volume        string `json:"6. volume"`
+}
+
+// AlphaVantageProvider fetches time series from the Alpha Vantage API.
+type AlphaVantageProvider struct {
+	httpClient   *http.Client
+	apiKey       string
+	apiURL       string
+	outputSize   string
+	maxBodyBytes int64
+	logger       *zap.Logger
+}
+
+// NewAlphaVantageProvider returns a provider that sends requests through
+// transport, or http.DefaultTransport when it is nil. Responses larger
+// than maxBodyBytes are rejected; zero means DefaultMaxBodyBytes.
+func NewAlphaVantageProvider(apiKey, outputSize string, timeout time.Duration, maxBodyBytes int64, transport http.RoundTripper, logger *zap.Logger) *AlphaVantageProvider {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = DefaultMaxBodyBytes
+	}
+	return &AlphaVantageProvider{
+		httpClient: &http.Client{
+			Timeout:   timeout,
+			Transport: tracing.Transport(transport),
+		},
+		apiKey:       apiKey,
+		apiURL:       "https://www.alphavantage.co/query",
+		outputSize:   outputSize,
+		maxBodyBytes: maxBodyBytes,
+		logger:       logger,
+	}
+}
+
+// CloseIdleConnections closes connections left open by earlier requests.
+func (p *AlphaVantageProvider) CloseIdleConnections() {
+	p.httpClient.CloseIdleConnections()
+}
+
+func (p *AlphaVantageProvider) Name() string {
+	return ProviderAlphaVantage
+}
+
+func (p *AlphaVantageProvider) FetchDailySeries(ctx context.Context, symbol string, ndays int, period Period) (*StockData, error) {
+	url := fmt.Sprintf("%s?function=%s&symbol=%s&outputsize=%s&apikey=%s", p.apiURL, alphaVantageFunction(period), symbol, p.outputSizeFor(ndays), p.apiKey)
+	if period.Intraday() {
+		url += "&interval=" + string(period)
+	}
+
+	p.logger.Info("calling Alpha Vantage API", zap.String("url", url))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Alpha Vantage request: %w", err)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		p.logger.Error("failed to call Alpha Vantage API", zap.Error(err))
+		return nil, fmt.Errorf("%w: failed to call Alpha Vantage API: %w", ErrUpstream, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		p.logger.Error("Alpha Vantage API returned non-200 status", zap.Int("status", resp.StatusCode))
+		return nil, fmt.Errorf("%w: Alpha Vantage API returned status %d", ErrUpstream, resp.StatusCode)
+	}
+
+	body, err := readBody(resp.Body, p.maxBodyBytes)
+	if err != nil {
+		p.logger.Error("failed to read response body", zap.Error(err))
+		return nil, err
+	}
+
+	// Proxies and outages can answer with an HTML page or nothing at all,
+	// which would otherwise unmarshal into an empty response
+	if contentType := resp.Header.Get("Content-Type"); !isJSONContentType(contentType) {
+		p.logger.Error("Alpha Vantage API returned non-JSON content", zap.String("content_type", contentType), zap.String("body", bodySnippet(body)))
+		return nil, fmt.Errorf("%w: Alpha Vantage API returned %s instead of JSON: %q", ErrUpstream, contentType, bodySnippet(body))
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		p.logger.Error("Alpha Vantage API returned an empty body")
+		return nil, fmt.Errorf("%w: Alpha Vantage API returned an empty body", ErrUpstream)
+	}
+
+	var alphaVantageResp AlphaVantageResponse
+	if err := json.Unmarshal(body, &alphaVantageResp); err != nil {
+		p.logger.Error("failed to unmarshal response", zap.Error(err), zap.String("body", bodySnippet(body)))
+		return nil, fmt.Errorf("%w: failed to unmarshal response: %w: %q", ErrUpstream, err, bodySnippet(body))
+	}
+
+	if alphaVantageResp.ErrorMessage != "" {
+		p.logger.Error("Alpha Vantage API error", zap.String("error", alphaVantageResp.ErrorMessage))
+		return nil, fmt.Errorf("%w: Alpha Vantage API error: %s", ErrSymbolNotFound, alphaVantageResp.ErrorMessage)
+	}
+
+	// The demo key only serves a few symbols such as IBM and answers the
+	// rest with an Information message about the demo key.
+	if isDemoKeyLimitation(alphaVantageResp.Information) {
+		p.logger.Error("Alpha Vantage demo key limitation", zap.String("symbol", symbol), zap.String("information", alphaVantageResp.Information))
+		return nil, fmt.Errorf("%w: %s", ErrDemoKey, alphaVantageResp.Information)
+	}
+
+	// Premium-only functions answer a free key with an Information message
+	// and no data.
+	if isPremiumEndpointMessage(alphaVantageResp.Information) {
+		p.logger.Error("Alpha Vantage premium endpoint", zap.String("symbol", symbol), zap.String("information", alphaVantageResp.Information))
+		return nil, fmt.Errorf("%w: %s", ErrPremiumRequired, alphaVantageResp.Information)
+	}
+	if alphaVantageResp.Information != "" && period == PeriodDailyAdjusted {
+		p.logger.Error("Alpha Vantage API information", zap.String("information", alphaVantageResp.Information))
+		return nil, fmt.Errorf("%w: adjusted close requires a premium Alpha Vantage key: %s", ErrNotEntitled, alphaVantageResp.Information)
+	}
+
+	if alphaVantageResp.Note != "" {
+		p.logger.Warn("Alpha Vantage API note", zap.String("note", alphaVantageResp.Note))
+	}
+
+	timeSeries := alphaVantageSeries(period, &alphaVantageResp)
+	if period == PeriodDailyAdjusted {
+		timeSeries, err = alphaVantageAdjustedSeries(body)
+		if err != nil {
+			p.logger.Error("failed to unmarshal adjusted series", zap.Error(err))
+			return nil, fmt.Errorf("%w: failed to unmarshal adjusted series: %w", ErrUpstream, err)
+		}
+	}
+	if period.Intraday() {
+		timeSeries, err = alphaVantageIntradaySeries(period, body)
+		if err != nil {
+			p.logger.Error("failed to unmarshal intraday series", zap.Error(err))
+			return nil, fmt.Errorf("%w: failed to unmarshal intraday series: %w", ErrUpstream, err)
+		}
+	}
+	if len(timeSeries) == 0 && alphaVantageResp.Note != "" {
+		// Throttled responses carry only a Note such as "our standard API
+		// call frequency is 5 calls per minute"
+		return nil, fmt.Errorf("%w: Alpha Vantage API: %s", ErrRateLimited, alphaVantageResp.Note)
+	}
+	if len(timeSeries) == 0 && alphaVantageResp.Information != "" {
+		// Newer throttling responses and other notices arrive as
+		// Information rather than Note
+		p.logger.Error("Alpha Vantage API information", zap.String("information", alphaVantageResp.Information))
+		if isRateLimitMessage(alphaVantageResp.Information) {
+			return nil, fmt.Errorf("%w: Alpha Vantage API: %s", ErrRateLimited, alphaVantageResp.Information)
+		}
+		return nil, fmt.Errorf("%w: Alpha Vantage API information: %s", ErrUpstream, alphaVantageResp.Information)
+	}
+	if len(timeSeries) == 0 {
+		p.logger.Error("no time series data returned", zap.String("body", bodySnippet(body)))
+		return nil, fmt.Errorf("%w: no time series data returned: %q", ErrUpstream, bodySnippet(body))
+	}
+
+	return processTimeSeries(p.logger, symbol, ndays, timeSeries)
+}
+
+// isJSONContentType reports whether a Content-Type header allows a JSON
+// body. A missing header and text/plain, which some proxies use for JSON,
+// are accepted; the body is still checked when it is unmarshalled.
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") || mediaType == "text/plain"
+}
+
+// isRateLimitMessage reports whether an Alpha Vantage Information message
+// is about the request quota, e.g. "Our standard API rate limit is 25
+// requests per day".
+func isRateLimitMessage(information string) bool {
+	information = strings.ToLower(information)
+	return strings.Contains(information, "rate limit") || strings.Contains(information, "call frequency")
+}
+
+// isPremiumEndpointMessage reports whether an Information message is Alpha
+// Vantage refusing a free key, e.g. "Thank you for using Alpha Vantage!
+// This is a premium endpoint. You may subscribe to any of the premium
+// plans ...". Rate limit messages also mention the premium plans, so only
+// the endpoint wording is matched.
+func isPremiumEndpointMessage(information string) bool {
+	return strings.Contains(strings.ToLower(information), "premium endpoint")
+}
+
+// maxBodySnippet is how much of an unexpected response body is kept in
+// errors and logs.
+const maxBodySnippet = 200
+
+// bodySnippet returns the start of body for error messages, trimmed and
+// cut to maxBodySnippet bytes without splitting a UTF-8 character.
+func bodySnippet(body []byte) string {
+	body = bytes.TrimSpace(body)
+	if len(body) <= maxBodySnippet {
+		return string(body)
+	}
+	cut := maxBodySnippet
+	for cut > 0 && !utf8.RuneStart(body[cut]) {
+		cut--
+	}
+	return string(body[:cut]) + "..."
+}
+
+// readBody reads a response body of at most limit bytes. A longer body
+// fails with ErrResponseTooLarge rather than being buffered in full.
+func readBody(r io.Reader, limit int64) ([]byte, error) {
+	// Read one byte past the limit to tell a body of exactly limit bytes
+	// from a longer one
+	body, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read response body: %w", ErrUpstream, err)
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("%w: exceeded %d bytes", ErrResponseTooLarge, limit)
+	}
+	return body, nil
+}
+
+// isDemoKeyLimitation reports whether an Information message is Alpha
+// Vantage turning away the demo API key, e.g. "The **demo** API key is for
+// demo purposes only. Please claim your free API key at ...".
+func isDemoKeyLimitation(information string) bool {
+	information = strings.ToLower(information)
+	return strings.Contains(information, "demo") && strings.Contains(information, "api key")
+}
+
+// FetchExchangeRate implements ExchangeRateProvider using the
+// CURRENCY_EXCHANGE_RATE function.
+func (p *AlphaVantageProvider) FetchExchangeRate(ctx context.Context, from, to string) (float64, error) {
+	url := fmt.Sprintf("%s?function=CURRENCY_EXCHANGE_RATE&from_currency=%s&to_currency=%s&apikey=%s", p.apiURL, from, to, p.apiKey)
+
+	p.logger.Info("calling Alpha Vantage exchange rate API", zap.String("from", from), zap.String("to", to))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build Alpha Vantage request: %w", err)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to call Alpha Vantage API: %w", ErrUpstream, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%w: Alpha Vantage API returned status %d", ErrUpstream, resp.StatusCode)
+	}
+
+	body, err := readBody(resp.Body, p.maxBodyBytes)
+	if err != nil {
+		return 0, err
+	}
+
+	var rateResp alphaVantageExchangeRateResponse
+	if err := json.Unmarshal(body, &rateResp); err != nil {
+		return 0, fmt.Errorf("%w: failed to unmarshal exchange rate: %w", ErrUpstream, err)
+	}
+
+	switch {
+	case rateResp.ErrorMessage != "":
+		return 0, fmt.Errorf("%w: Alpha Vantage API error: %s", ErrUpstream, rateResp.ErrorMessage)
+	case rateResp.ExchangeRate.Rate == "" && rateResp.Note != "":
+		return 0, fmt.Errorf("%w: Alpha Vantage API: %s", ErrRateLimited, rateResp.Note)
+	}
+
+	rate, err := parseFloat(rateResp.ExchangeRate.Rate)
+	if err != nil || rate <= 0 {
+		return 0, fmt.Errorf("%w: invalid exchange rate %q from %s to %s", ErrUpstream, rateResp.ExchangeRate.Rate, from, to)
+	}
+	return rate, nil
+}
+
+// FetchQuote implements QuoteProvider using the GLOBAL_QUOTE function.
+func (p *AlphaVantageProvider) FetchQuote(ctx context.Context, symbol string) (*Quote, error) {
+	url := fmt.Sprintf("%s?function=GLOBAL_QUOTE&symbol=%s&apikey=%s", p.apiURL, symbol, p.apiKey)
+
+	p.logger.Info("calling Alpha Vantage quote API", zap.String("symbol", symbol))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Alpha Vantage request: %w", err)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to call Alpha Vantage API: %w", ErrUpstream, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: Alpha Vantage API returned status %d", ErrUpstream, resp.StatusCode)
+	}
+
+	body, err := readBody(resp.Body, p.maxBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var quoteResp alphaVantageQuoteResponse
+	if err := json.Unmarshal(body, &quoteResp); err != nil {
+		return nil, fmt.Errorf("%w: failed to unmarshal quote: %w", ErrUpstream, err)
+	}
+
+	raw := quoteResp.Quote
+	info := quoteResp.Information
+	switch {
+	case quoteResp.ErrorMessage != "":
+		return nil, fmt.Errorf("%w: Alpha Vantage API error: %s", ErrSymbolNotFound, quoteResp.ErrorMessage)
+	case isDemoKeyLimitation(info):
+		return nil, fmt.Errorf("%w: %s", ErrDemoKey, info)
+	case isPremiumEndpointMessage(info):
+		return nil, fmt.Errorf("%w: %s", ErrPremiumRequired, info)
+	case raw.Price == "" && quoteResp.Note != "":
+		return nil, fmt.Errorf("%w: Alpha Vantage API: %s", ErrRateLimited, quoteResp.Note)
+	case raw.Price == "" && isRateLimitMessage(info):
+		return nil, fmt.Errorf("%w: Alpha Vantage API: %s", ErrRateLimited, info)
+	case raw.Price == "" && info != "":
+		return nil, fmt.Errorf("%w: Alpha Vantage API information: %s", ErrUpstream, info)
+	case raw.Symbol == "" && raw.Price == "":
+		// Unknown symbols get an empty quote rather than an error
+		return nil, fmt.Errorf("%w: no quote for %s", ErrSymbolNotFound, symbol)
+	}
+
+	price, err := parseFloat(raw.Price)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid quote price %q for %s", ErrUpstream, raw.Price, symbol)
+	}
+	quote := &Quote{
+		Symbol:           symbol,
+		Price:            price,
+		LatestTradingDay: raw.LatestTradingDay,
+	}
+	// The remaining fields are informational; a malformed one is left at
+	// zero rather than failing the quote
+	quote.Change, _ = parseFloat(raw.Change)
+	quote.ChangePercent, _ = parseFloat(strings.TrimSuffix(raw.ChangePercent, "%"))
+	quote.Volume, _ = strconv.ParseInt(raw.Volume, 10, 64)
+	return quote, nil
+}
+
+// outputSizeFor picks the Alpha Vantage outputsize for a request. Full
+// payloads cover 20+ years and are large, so in auto mode they are only
+// requested when the compact window can't satisfy ndays.
+func (p *AlphaVantageProvider) outputSizeFor(ndays int) string {
+	switch p.outputSize {
+	case OutputSizeCompact, OutputSizeFull:
+		return p.outputSize
+	default:
+		if ndays > compactOutputSize {
+			return OutputSizeFull
+		}
+		return OutputSizeCompact
+	}
+}
+
+// alphaVantageFunction returns the Alpha Vantage function name for period.
+func alphaVantageFunction(period Period) string {
+	switch period {
+	case PeriodWeekly:
+		return "TIME_SERIES_WEEKLY"
+	case PeriodMonthly:
+		return "TIME_SERIES_MONTHLY"
+	case PeriodDailyAdjusted:
+		return "TIME_SERIES_DAILY_ADJUSTED"
+	case PeriodIntraday1Min, PeriodIntraday5Min, PeriodIntraday15Min, PeriodIntraday30Min, PeriodIntraday60Min:
+		return "TIME_SERIES_INTRADAY"
+	default:
+		return "TIME_SERIES_DAILY"
+	}
+}
+
+// alphaVantageSeries returns the time series in resp matching period.
+func alphaVantageSeries(period Period, resp *AlphaVantageResponse) map[string]DailyData {
+	switch period {
+	case PeriodWeekly:
+		return resp.TimeSeriesWeekly
+	case PeriodMonthly:
+		return resp.TimeSeriesMonthly
+	default:
+		return resp.TimeSeriesDaily
+	}
+}
+
+// alphaVantageIntradaySeries extracts the intraday series from body. Its key
+// depends on the interval, e.g. "Time Series (5min)", so it can't be a
+// field of AlphaVantageResponse.
+func alphaVantageIntradaySeries(period Period, body []byte) (map[string]DailyData, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+
+	series, ok := raw[fmt.Sprintf("Time Series (%s)", period)]
+	if !ok {
+		return nil, nil
+	}
+
+	var timeSeries map[string]DailyData
+	if err := json.Unmarshal(series, &timeSeries); err != nil {
+		return nil, err
+	}
+	return timeSeries, nil
+}
+
+// alphaVantageAdjustedSeries extracts the daily adjusted series from body.
+func alphaVantageAdjustedSeries(body []byte) (map[string]DailyData, error) {
+	var resp struct {
+		TimeSeries map[string]alphaVantageAdjustedData `json:"Time Series (Daily)"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	timeSeries := make(map[string]DailyData, len(resp.TimeSeries))
+	for date, d := range resp.TimeSeries {
+		timeSeries[date] = DailyData{
+			Open:          d.Open,
+			High:          d.High,
+			Low:           d.Low,
+			Close:         d.Close,
+			Volume:        d.Volume,
+			AdjustedClose: d.AdjustedClose,
+		}
+	}
+	return timeSeries, nil
+}
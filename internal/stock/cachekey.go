@@ -0,0 +1,42 @@
+package stock
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// cacheParams are the request dimensions that select a cached result.
+// Every dimension that changes what GetStockData returns must be here.
+type cacheParams struct {
+	Symbol string
+	NDays  int
+	Period Period
+}
+
+// cacheKeyEscaper escapes the separator, and the escape character itself,
+// inside key components.
+var cacheKeyEscaper = strings.NewReplacer("%", "%25", "_", "%5F")
+
+// cacheKey builds the cache key for p. Components are joined with "_" and
+// escaped so that no two parameter sets share a key, while plain symbols
+// keep readable keys such as "MSFT_7_daily".
+func cacheKey(p cacheParams) string {
+	return strings.Join([]string{
+		cacheKeyEscaper.Replace(p.Symbol),
+		strconv.Itoa(p.NDays),
+		cacheKeyEscaper.Replace(string(p.Period)),
+	}, "_")
+}
+
+// cacheKeyFor returns the key c caches p under: cacheKey(p), or its
+// SHA-256 when the client hashes keys to keep them short and opaque.
+func (c *Client) cacheKeyFor(p cacheParams) string {
+	key := cacheKey(p)
+	if !c.hashCacheKeys {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
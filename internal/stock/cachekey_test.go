@@ -0,0 +1,43 @@
+package stock
+
+import "testing"
+
+func TestCacheKeyNoCollisions(t *testing.T) {
+	tests := []struct {
+		a, b cacheParams
+	}{
+		{cacheParams{Symbol: "A_1", NDays: 0, Period: PeriodDaily}, cacheParams{Symbol: "A", NDays: 1, Period: PeriodDaily}},
+		{cacheParams{Symbol: "A", NDays: 1, Period: "x_daily"}, cacheParams{Symbol: "A_1_x", NDays: 0, Period: PeriodDaily}},
+		{cacheParams{Symbol: "A%5F", NDays: 1, Period: PeriodDaily}, cacheParams{Symbol: "A_", NDays: 1, Period: PeriodDaily}},
+		{cacheParams{Symbol: "A", NDays: 1, Period: PeriodDaily}, cacheParams{Symbol: "A", NDays: 1, Period: PeriodWeekly}},
+	}
+
+	for _, tt := range tests {
+		if ka, kb := cacheKey(tt.a), cacheKey(tt.b); ka == kb {
+			t.Errorf("%+v and %+v share the key %q", tt.a, tt.b, ka)
+		}
+	}
+}
+
+func TestCacheKeyReadable(t *testing.T) {
+	if got := cacheKey(cacheParams{Symbol: "MSFT", NDays: 7, Period: PeriodDaily}); got != "MSFT_7_daily" {
+		t.Errorf("expected MSFT_7_daily, got %q", got)
+	}
+}
+
+func TestCacheKeyForHashing(t *testing.T) {
+	p := cacheParams{Symbol: "MSFT", NDays: 7, Period: PeriodDaily}
+
+	client := &Client{hashCacheKeys: true}
+	hashed := client.cacheKeyFor(p)
+	if hashed == cacheKey(p) || len(hashed) != 64 {
+		t.Errorf("expected a hex SHA-256 key, got %q", hashed)
+	}
+	if again := client.cacheKeyFor(p); again != hashed {
+		t.Errorf("expected hashing to be deterministic, got %q and %q", hashed, again)
+	}
+
+	if plain := (&Client{}).cacheKeyFor(p); plain != "MSFT_7_daily" {
+		t.Errorf("expected the readable key without hashing, got %q", plain)
+	}
+}
@@ -1,188 +1,975 @@
 package stock
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"math"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/cache"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/requestid"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/ticker"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
 type Client struct {
-	httpClient          *http.Client
-	apiKey              string
-	apiURL              string
+	providers           []StockProvider
 	logger              *zap.Logger
 	circuitBreaker      *circuitbreaker.CircuitBreaker
-	cache               *cache.Cache
-	cacheHits           prometheus.Counter
-	cacheMisses         prometheus.Counter
+	cache               cache.Cache
+	// negativeCache remembers symbols the providers reported as unknown so
+	// repeated lookups fail fast. Nil disables negative caching.
+	negativeCache       cache.Cache
+	// cacheTTL is the configured entry lifetime in nanoseconds, used to
+	// estimate the age of cache hits; zero when unknown. SetCacheTTL can
+	// change it at any time.
+	cacheTTL            atomic.Int64
+	// fxCache holds exchange rates; nil disables caching them.
+	fxCache             cache.Cache
+	// quoteCache holds latest quotes; nil disables caching them.
+	quoteCache          cache.Cache
+	// hashCacheKeys stores entries under a hash of their key.
+	hashCacheKeys       bool
+	// retries, retryBackoff and breakerCountsRetries configure
+	// callUpstream.
+	retries             int
+	retryBackoff        time.Duration
+	breakerCountsRetries bool
+	// fetchTimeout bounds a fetch shared through inflight, which outlives
+	// the requests waiting on it.
+	fetchTimeout        time.Duration
+	// pricePrecision is the decimal places GetStockData rounds to; zero
+	// leaves prices unrounded.
+	pricePrecision      int
+	// blockedSymbols holds the normalized symbols that are never served.
+	blockedSymbols      map[string]struct{}
+	// cacheHits and cacheMisses export the cache's own counters.
+	cacheHits           prometheus.CounterFunc
+	cacheMisses         prometheus.CounterFunc
+	// coalescedRequests counts lookups that waited on another caller's
+	// in-flight fetch instead of starting their own.
+	coalescedRequests   prometheus.Counter
 	externalCalls       prometheus.Counter
 	externalCallDuration prometheus.Histogram
-	circuitBreakerState prometheus.Gauge
 	externalApiLatency  *prometheus.HistogramVec
+	upstreamInFlight    prometheus.Gauge
+
+	// upstreamSlots bounds concurrent upstream fetches; each call holds a
+	// token while it runs. Nil means unlimited.
+	upstreamSlots chan struct{}
+
+	// inflight coalesces concurrent cache misses for the same key so only
+	// one goroutine calls the upstream API.
+	inflight singleflight.Group
+	// fetches are the shared fetches callers are waiting on, keyed like
+	// inflight.
+	fetchMu sync.Mutex
+	fetches map[string]*sharedFetch
+
+	// hotKeys tracks request counts for the background refresher; nil
+	// unless StartRefresher has been called.
+	hotMu   sync.Mutex
+	hotKeys map[string]*keyActivity
+	// refresherStops stop the refreshers started by StartRefresher.
+	refresherStops []func()
+
+	// symbols counts requests per symbol for TopSymbols.
+	symbols *symbolTracker
+
+	// lastSuccess is the UnixNano time of the last successful upstream
+	// fetch, or zero if there hasn't been one.
+	lastSuccess atomic.Int64
+
+	// degraded stops all upstream calls, serving only cached data.
+	degraded atomic.Bool
+
+	// now returns the current time; tests replace it.
+	now func() time.Time
+
+	closeOnce sync.Once
+	closeErr  error
 }
 
 type StockData struct {
-	Symbol  string       `json:"symbol"`
-	NDays   int          `json:"ndays"`
-	Prices  []PricePoint `json:"prices"`
-	Average float64      `json:"average"`
+	Symbol        string       `json:"symbol"`
+	NDays         int          `json:"ndays"`
+	Prices        []PricePoint `json:"prices"`
+	Average       float64      `json:"average"`
+	Change        float64      `json:"change"`
+	ChangePercent float64      `json:"changePercent"`
+	Volatility    float64      `json:"volatility"`
+	Min           float64      `json:"min"`
+	Max           float64      `json:"max"`
+	// Currency is the currency the prices are quoted in, BaseCurrency
+	// unless converted with ConvertCurrency.
+	Currency string `json:"currency,omitempty"`
+	// Stale is set when the data is past its TTL and is being served while
+	// a refresh runs in the background.
+	Stale bool `json:"stale,omitempty"`
+	// Fallback is set when the requested symbol had no data and this is
+	// the configured fallback symbol's data instead.
+	Fallback bool `json:"fallback,omitempty"`
+	// Source names the provider the data came from, or SourceCache when it
+	// was served from the cache.
+	Source string `json:"source,omitempty"`
+	// FetchedAt is when the data was fetched from the provider. Cached
+	// responses keep the time of the original fetch.
+	FetchedAt time.Time `json:"fetchedAt,omitzero"`
+	// Pagination is set when Prices holds a single page of the window; the
+	// summary statistics still cover the whole window.
+	Pagination *Pagination `json:"pagination,omitempty"`
+	// LatestTradingDay is the date, YYYY-MM-DD, of the newest price.
+	LatestTradingDay string `json:"latestTradingDay,omitempty"`
+	// MarketLikelyClosed is set when LatestTradingDay isn't today in the
+	// market's time zone, e.g. over a weekend or holiday, so the latest
+	// close isn't a live price. It is worked out when the data is served.
+	MarketLikelyClosed bool `json:"marketLikelyClosed"`
+	// SkippedDays counts the days in the window dropped because their
+	// prices couldn't be parsed; NDays and the statistics exclude them.
+	// Warnings says which dates were dropped and why.
+	SkippedDays int      `json:"skippedDays,omitempty"`
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+// SourceCache is the StockData.Source of responses served from the cache.
+const SourceCache = "cache"
+
+// Pagination describes which slice of the full price series a page holds.
+// NextOffset is nil on the last page.
+type Pagination struct {
+	Total      int  `json:"total"`
+	Limit      int  `json:"limit"`
+	Offset     int  `json:"offset"`
+	NextOffset *int `json:"next_offset"`
 }
 
 type PricePoint struct {
-	Date  string  `json:"date"`
-	Close float64 `json:"close"`
+	Date   string  `json:"date"`
+	Open   float64 `json:"open"`
+	High   float64 `json:"high"`
+	Low    float64 `json:"low"`
+	Close  float64 `json:"close"`
+	Volume int64   `json:"volume"`
+	// AdjustedClose accounts for splits and dividends; only set for
+	// PeriodDailyAdjusted.
+	AdjustedClose float64 `json:"adjustedClose,omitempty"`
+}
+
+// Period selects the aggregation of the time series.
+type Period string
+
+const (
+	PeriodDaily   Period = "daily"
+	PeriodWeekly  Period = "weekly"
+	PeriodMonthly Period = "monthly"
+	// PeriodDailyAdjusted is a daily series with split- and
+	// dividend-adjusted closes, selected with adjusted=true.
+	PeriodDailyAdjusted Period = "daily_adjusted"
+)
+
+// Intraday periods, selected with the interval query parameter. Each point
+// in an intraday series covers one interval rather than one day.
+const (
+	PeriodIntraday1Min  Period = "1min"
+	PeriodIntraday5Min  Period = "5min"
+	PeriodIntraday15Min Period = "15min"
+	PeriodIntraday30Min Period = "30min"
+	PeriodIntraday60Min Period = "60min"
+)
+
+// Layouts of the keys in a time series.
+const (
+	dateLayout     = "2006-01-02"
+	dateTimeLayout = "2006-01-02 15:04:05"
+)
+
+// ParsePeriod converts a query parameter into a Period. An empty string
+// selects PeriodDaily.
+func ParsePeriod(s string) (Period, error) {
+	switch Period(s) {
+	case "", PeriodDaily:
+		return PeriodDaily, nil
+	case PeriodWeekly, PeriodMonthly:
+		return Period(s), nil
+	default:
+		return "", fmt.Errorf("invalid period %q: must be daily, weekly or monthly", s)
+	}
+}
+
+// ParseInterval converts an intraday interval query parameter into a Period.
+func ParseInterval(s string) (Period, error) {
+	switch Period(s) {
+	case PeriodIntraday1Min, PeriodIntraday5Min, PeriodIntraday15Min, PeriodIntraday30Min, PeriodIntraday60Min:
+		return Period(s), nil
+	default:
+		return "", fmt.Errorf("invalid interval %q: must be 1min, 5min, 15min, 30min or 60min", s)
+	}
 }
 
-type AlphaVantageResponse struct {
-	TimeSeriesDaily map[string]DailyData `json:"Time Series (Daily)"`
-	Note            string               `json:"Note"`
-	ErrorMessage    string               `json:"Error Message"`
+// Intraday reports whether p is one of the intraday intervals.
+func (p Period) Intraday() bool {
+	_, err := ParseInterval(string(p))
+	return err == nil
 }
 
+// DailyData is a single raw data point keyed by date in a time series.
+// The JSON tags follow the Alpha Vantage field names.
 type DailyData struct {
-	Close string `json:"4. close"`
-}
-
-func NewClient(
-	apiKey string,
-	timeout time.Duration,
-	logger *zap.Logger,
-	cache *cache.Cache,
-	circuitBreaker *circuitbreaker.CircuitBreaker,
-	cacheHits prometheus.Counter,
-	cacheMisses prometheus.Counter,
-	externalCalls prometheus.Counter,
-	externalCallDuration prometheus.Histogram,
-	circuitBreakerState prometheus.Gauge,
-	externalApiLatency *prometheus.HistogramVec,
-) *Client {
-	return &Client{
-		httpClient: &http.Client{
-			Timeout: timeout,
-		},
-		apiKey:              apiKey,
-		apiURL:              "https://www.alphavantage.co/query",
-		logger:              logger,
-		circuitBreaker:      circuitBreaker,
-		cache:               cache,
-		cacheHits:           cacheHits,
-		cacheMisses:         cacheMisses,
-		externalCalls:       externalCalls,
-		externalCallDuration: externalCallDuration,
-		circuitBreakerState: circuitBreakerState,
-		externalApiLatency:  externalApiLatency,
-	}
-}
-
-func (c *Client) GetStockData(symbol string, ndays int, apiDurationHist prometheus.Histogram) (*StockData, error) {
-	c.logger.Info("fetching stock data", zap.String("symbol", symbol), zap.Int("ndays", ndays))
+	Open   string `json:"1. open"`
+	High   string `json:"2. high"`
+	Low    string `json:"3. low"`
+	Close  string `json:"4. close"`
+	Volume string `json:"5. volume"`
+	// AdjustedClose is empty unless the series is adjusted; summary
+	// statistics use it instead of Close when set.
+	AdjustedClose string `json:"-"`
+}
+
+// ClientOptions bundles the dependencies of a Client. Providers, Cache and
+// CircuitBreaker are required.
+type ClientOptions struct {
+	// Providers are tried in order, falling over to the next one when a
+	// provider fails.
+	Providers      []StockProvider
+	Logger         *zap.Logger
+	Cache          cache.Cache
+	// NegativeCache remembers unknown symbols; nil disables it.
+	NegativeCache  cache.Cache
+	CircuitBreaker *circuitbreaker.CircuitBreaker
+	// CacheTTL is the lifetime entries are cached for. It is used to work
+	// out how old a cached result is; zero leaves the age unknown.
+	CacheTTL time.Duration
+	// FXCache remembers exchange rates looked up for currency conversion;
+	// nil disables caching them.
+	FXCache cache.Cache
+	// QuoteCache remembers latest quotes, usually for much less time than
+	// Cache keeps series; nil disables caching them.
+	QuoteCache cache.Cache
+	// MaxConcurrentUpstream caps concurrent upstream fetches, making
+	// further callers wait for a free slot; zero means unlimited.
+	MaxConcurrentUpstream int
+	// HashCacheKeys stores entries under a SHA-256 of their key instead of
+	// the readable key.
+	HashCacheKeys bool
+	// Retries is how many times a transient upstream failure is retried,
+	// waiting RetryBackoff before the first retry and doubling after each.
+	Retries      int
+	RetryBackoff time.Duration
+	// BreakerCountsRetries feeds every attempt to the circuit breaker
+	// rather than only the outcome of the call after its retries.
+	BreakerCountsRetries bool
+	// FetchTimeout bounds an upstream fetch, retries included. Fetches are
+	// shared between concurrent requests and don't end when one of them
+	// is cancelled. Zero selects DefaultFetchTimeout.
+	FetchTimeout time.Duration
+	// Degraded starts the client in degraded mode, serving only cached
+	// data; see SetDegraded.
+	Degraded bool
+	// PricePrecision rounds the prices and statistics GetStockData returns
+	// to this many decimal places; zero leaves them unrounded.
+	PricePrecision int
+	// BlockedSymbols are never served: requests for them fail with
+	// ErrSymbolBlocked before reaching the cache or a provider.
+	BlockedSymbols []string
+
+	// Registerer receives the client's metrics, named under
+	// MetricsNamespace. When nil the metrics are kept but not exported.
+	Registerer       prometheus.Registerer
+	MetricsNamespace string
+	// LatencyBuckets are the upstream call duration histograms' bounds in
+	// seconds; nil means prometheus.DefBuckets.
+	LatencyBuckets []float64
+}
+
+// NewClient returns a client built from opts, creating its cache and
+// upstream call metrics and registering them with opts.Registerer.
+func NewClient(opts ClientOptions) *Client {
+	logger := opts.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	c := &Client{
+		providers:      opts.Providers,
+		logger:         logger,
+		circuitBreaker: opts.CircuitBreaker,
+		cache:          opts.Cache,
+		negativeCache:  opts.NegativeCache,
+		fxCache:        opts.FXCache,
+		quoteCache:     opts.QuoteCache,
+		hashCacheKeys:  opts.HashCacheKeys,
+		retries:        opts.Retries,
+		retryBackoff:   opts.RetryBackoff,
+		breakerCountsRetries: opts.BreakerCountsRetries,
+		fetchTimeout:   opts.FetchTimeout,
+		pricePrecision: opts.PricePrecision,
+		blockedSymbols: make(map[string]struct{}, len(opts.BlockedSymbols)),
+		symbols:        newSymbolTracker(maxTrackedSymbols),
+		now:            time.Now,
+		coalescedRequests: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: opts.MetricsNamespace,
+			Name:      "coalesced_requests_total",
+			Help:      "Total number of requests that waited on an in-flight upstream fetch instead of starting their own",
+		}),
+		externalCalls: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: opts.MetricsNamespace,
+			Name:      "external_calls_total",
+			Help:      "Total number of external API calls",
+		}),
+		externalCallDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: opts.MetricsNamespace,
+			Name:      "external_call_duration_seconds",
+			Help:      "Duration of external API calls in seconds",
+			Buckets:   opts.LatencyBuckets,
+		}),
+		externalApiLatency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: opts.MetricsNamespace,
+				Name:      "external_call_latency_seconds",
+				Help:      "Latency of external API calls to the stock service.",
+				Buckets:   opts.LatencyBuckets,
+			},
+			[]string{"endpoint"},
+		),
+		upstreamInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: opts.MetricsNamespace,
+			Name:      "upstream_in_flight",
+			Help:      "Number of upstream API fetches currently running",
+		}),
+	}
+	if opts.MaxConcurrentUpstream > 0 {
+		c.upstreamSlots = make(chan struct{}, opts.MaxConcurrentUpstream)
+	}
+	c.degraded.Store(opts.Degraded)
+	c.cacheTTL.Store(int64(opts.CacheTTL))
+
+	// Read at scrape time, so a cache shared with other callers reports
+	// their lookups too
+	c.cacheHits = prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: opts.MetricsNamespace,
+		Name:      "cache_hits_total",
+		Help:      "Total number of cache hits",
+	}, func() float64 {
+		hits, _ := c.cache.Counts()
+		return float64(hits)
+	})
+	c.cacheMisses = prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: opts.MetricsNamespace,
+		Name:      "cache_misses_total",
+		Help:      "Total number of cache misses",
+	}, func() float64 {
+		_, misses := c.cache.Counts()
+		return float64(misses)
+	})
+
+	if c.fetchTimeout <= 0 {
+		c.fetchTimeout = DefaultFetchTimeout
+	}
+
+	for _, symbol := range opts.BlockedSymbols {
+		c.blockedSymbols[ticker.Normalize(symbol)] = struct{}{}
+	}
+
+	if opts.Registerer != nil {
+		opts.Registerer.MustRegister(
+			c.cacheHits,
+			c.cacheMisses,
+			c.coalescedRequests,
+			c.externalCalls,
+			c.externalCallDuration,
+			c.externalApiLatency,
+			c.upstreamInFlight,
+		)
+	}
+
+	return c
+}
+
+// idleConnCloser is implemented by providers that keep HTTP connections
+// open between requests.
+type idleConnCloser interface {
+	CloseIdleConnections()
+}
+
+// Close stops the background refreshers, closes the providers' idle
+// connections and closes the caches. It is safe to call more than once;
+// later calls return the first call's error.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		c.hotMu.Lock()
+		stops := c.refresherStops
+		c.refresherStops = nil
+		c.hotMu.Unlock()
+		for _, stop := range stops {
+			stop()
+		}
+
+		for _, provider := range c.providers {
+			if closer, ok := provider.(idleConnCloser); ok {
+				closer.CloseIdleConnections()
+			}
+		}
+
+		var errs []error
+		for _, ch := range []cache.Cache{c.cache, c.negativeCache, c.fxCache, c.quoteCache} {
+			if ch == nil {
+				continue
+			}
+			if err := ch.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		c.closeErr = errors.Join(errs...)
+	})
+	return c.closeErr
+}
+
+// DefaultFetchTimeout bounds upstream fetches when
+// ClientOptions.FetchTimeout is zero.
+const DefaultFetchTimeout = 30 * time.Second
+
+// CacheStatus says whether GetStockData was answered from the cache.
+type CacheStatus string
+
+const (
+	CacheHit  CacheStatus = "hit"
+	CacheMiss CacheStatus = "miss"
+)
+
+// ResultInfo describes how GetStockData produced its result.
+type ResultInfo struct {
+	// Cache says whether the result was served from the cache. Stale
+	// entries count as hits and callers that waited on another request's
+	// fetch as misses.
+	Cache CacheStatus
+	// TTL is how much longer the result stays cached: what remains of the
+	// entry's lifetime on a hit, the configured cache TTL on a miss and
+	// zero for stale data.
+	TTL time.Duration
+	// Age estimates how long ago a cached result was fetched. It is zero on
+	// a miss or when the client's cache TTL is unknown.
+	Age time.Duration
+}
+
+type resultInfoKey struct{}
+
+// WithResultInfo returns a context that makes GetStockData describe its
+// result in info.
+func WithResultInfo(ctx context.Context, info *ResultInfo) context.Context {
+	return context.WithValue(ctx, resultInfoKey{}, info)
+}
+
+func setResultInfo(ctx context.Context, info ResultInfo) {
+	if p, ok := ctx.Value(resultInfoKey{}).(*ResultInfo); ok {
+		*p = info
+	}
+}
+
+type callTimeoutKey struct{}
+
+// WithCallTimeout returns a context that makes GetStockData give up after
+// d, e.g. so a readiness probe fails fast on a slow upstream while user
+// requests keep the providers' own timeout.
+func WithCallTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, callTimeoutKey{}, d)
+}
+
+// tracer is resolved through the global provider, which is a no-op unless
+// tracing is enabled.
+var tracer = otel.Tracer("github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/stock")
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (c *Client) GetStockData(ctx context.Context, symbol string, ndays int, period Period, apiDurationHist prometheus.Histogram) (*StockData, error) {
+	symbol = ticker.Normalize(symbol)
+	ctx, span := tracer.Start(ctx, "stock.GetStockData", trace.WithAttributes(
+		attribute.String("stock.symbol", symbol),
+		attribute.Int("stock.ndays", ndays),
+		attribute.String("stock.period", string(period)),
+	))
+	if timeout, ok := ctx.Value(callTimeoutKey{}).(time.Duration); ok && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// Blocked symbols are refused before the cache is consulted
+	var stockData *StockData
+	err := c.checkBlocked(symbol)
+	if err == nil {
+		stockData, err = c.getStockData(ctx, symbol, ndays, period, apiDurationHist)
+	}
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// Results may be shared with the cache, so annotate a copy. The
+	// cached data stays unrounded; rounding only what is returned keeps
+	// the statistics free of rounding error.
+	annotated := *stockData
+	if c.pricePrecision > 0 {
+		annotated = *RoundPrices(stockData, c.pricePrecision)
+	}
+	annotated.MarketLikelyClosed = marketLikelyClosed(annotated.LatestTradingDay, c.now())
+	return &annotated, nil
+}
+
+// checkBlocked returns ErrSymbolBlocked if the normalized symbol is one
+// the client was configured never to serve.
+func (c *Client) checkBlocked(symbol string) error {
+	if _, blocked := c.blockedSymbols[symbol]; blocked {
+		return fmt.Errorf("%w: %s is restricted and can't be served", ErrSymbolBlocked, symbol)
+	}
+	return nil
+}
+
+func (c *Client) getStockData(ctx context.Context, symbol string, ndays int, period Period, apiDurationHist prometheus.Histogram) (*StockData, error) {
+	span := trace.SpanFromContext(ctx)
+	logger := c.loggerFor(ctx)
+	logger.Info("fetching stock data", zap.String("symbol", symbol), zap.Int("ndays", ndays), zap.String("period", string(period)))
 
 	// Create cache key
-	cacheKey := fmt.Sprintf("%s_%d", symbol, ndays)
+	cacheKey := c.cacheKeyFor(cacheParams{Symbol: symbol, NDays: ndays, Period: period})
+	c.recordAccess(cacheKey, symbol)
 	
 	// Check cache first
-	if cached, found := c.cache.Get(cacheKey); found {
-		c.logger.Info("cache hit", zap.String("symbol", symbol), zap.Int("ndays", ndays))
-		c.cacheHits.Inc()
-		if stockData, ok := cached.(*StockData); ok {
-			return stockData, nil
+	_, lookup := tracer.Start(ctx, "cache.Get")
+	stockData, remaining, found := c.getCached(logger, cacheKey)
+	lookup.SetAttributes(attribute.Bool("cache.hit", found))
+	lookup.End()
+	span.SetAttributes(attribute.Bool("cache.hit", found))
+	if found {
+		logger.Info("cache hit", zap.String("symbol", symbol), zap.Int("ndays", ndays))
+		info := ResultInfo{Cache: CacheHit, TTL: remaining}
+		if cacheTTL := time.Duration(c.cacheTTL.Load()); cacheTTL > remaining {
+			// With TTL jitter, or a TTL changed since, the entry may have
+			// been stored for less than cacheTTL, so this can overstate
+			// its age
+			info.Age = cacheTTL - remaining
 		}
+		setResultInfo(ctx, info)
+		cached := *stockData
+		cached.Source = SourceCache
+		return &cached, nil
 	}
 
-	c.logger.Info("cache miss", zap.String("symbol", symbol), zap.Int("ndays", ndays))
-	c.cacheMisses.Inc()
+	if c.negativeCache != nil {
+		if cached, found := c.negativeCache.Get(symbol); found {
+			if err, ok := cached.(error); ok {
+				logger.Info("negative cache hit", zap.String("symbol", symbol))
+				return nil, err
+			}
+		}
+	}
 
-	var result *StockData
-	var err error
+	// Serve an expired entry still within its grace period right away and
+	// refresh it in the background. If the refresh fails the stale value
+	// keeps being served until the grace period ends.
+	if stale, found := c.getStale(logger, cacheKey); found {
+		logger.Info("serving stale data", zap.String("symbol", symbol), zap.Int("ndays", ndays))
+		if !c.Degraded() {
+			c.inflight.DoChan(cacheKey, func() (interface{}, error) {
+				refreshCtx, cancel := c.detach(ctx)
+				defer cancel()
+				return c.fetchAndCache(refreshCtx, logger, cacheKey, symbol, ndays, period, apiDurationHist)
+			})
+		}
+		span.SetAttributes(attribute.Bool("cache.hit", true), attribute.Bool("cache.stale", true))
+		setResultInfo(ctx, ResultInfo{Cache: CacheHit})
+		return stale, nil
+	}
 
-	cbErr := c.circuitBreaker.Call(func() error {
-		result, err = c.fetchStockData(symbol, ndays, apiDurationHist)
-		return err
+	if c.Degraded() {
+		logger.Info("cache miss in degraded mode", zap.String("symbol", symbol), zap.Int("ndays", ndays))
+		return nil, fmt.Errorf("%w: %s for %d days", ErrDegradedModeMiss, symbol, ndays)
+	}
+
+	setResultInfo(ctx, ResultInfo{Cache: CacheMiss, TTL: time.Duration(c.cacheTTL.Load())})
+	res, err := c.doShared(ctx, cacheKey, func(fetchCtx context.Context) (interface{}, error) {
+		return c.fetchAndCache(fetchCtx, logger, cacheKey, symbol, ndays, period, apiDurationHist)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*StockData), nil
+}
+
+// doShared runs fn once for concurrent callers with the same key. The
+// fetch is shared, so fn's context only ends once the last caller waiting
+// on it gives up rather than the one that started it, while each caller
+// returns as soon as its own ctx ends.
+func (c *Client) doShared(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	fetchCtx, release := c.joinFetch(ctx, key)
+	defer release()
+
+	// Only the caller that starts the fetch runs fn, so leader tells the
+	// others apart for the coalesced requests counter
+	var leader atomic.Bool
+	ch := c.inflight.DoChan(key, func() (interface{}, error) {
+		leader.Store(true)
+		return fn(fetchCtx)
 	})
 
-	if cbErr != nil {
-		c.logger.Error("circuit breaker error", zap.Error(cbErr))
-		return nil, cbErr
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Shared && !leader.Load() {
+			c.coalescedRequests.Inc()
+		}
+		return res.Val, res.Err
+	}
+}
+
+// detach returns a context for a fetch shared through inflight. It keeps
+// ctx's values, such as the trace and request ID, but not its cancellation,
+// so one caller giving up doesn't fail the others waiting on the fetch,
+// and is bounded by c.fetchTimeout instead.
+func (c *Client) detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), c.fetchTimeout)
+}
+
+// sharedFetch is the context of a fetch shared through inflight and the
+// number of callers still waiting on it.
+type sharedFetch struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// joinFetch registers a caller waiting on the shared fetch for key,
+// creating its context from ctx if there is none yet. It returns that
+// context and a function to call once the caller stops waiting; when the
+// last caller does, the fetch is cancelled and forgotten, so later callers
+// start a fresh one rather than joining the cancelled one.
+func (c *Client) joinFetch(ctx context.Context, key string) (context.Context, func()) {
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	fetch, ok := c.fetches[key]
+	if !ok {
+		if c.fetches == nil {
+			c.fetches = make(map[string]*sharedFetch)
+		}
+		fetchCtx, cancel := c.detach(ctx)
+		fetch = &sharedFetch{ctx: fetchCtx, cancel: cancel}
+		c.fetches[key] = fetch
+	}
+	fetch.waiters++
+
+	return fetch.ctx, func() {
+		c.fetchMu.Lock()
+		defer c.fetchMu.Unlock()
+
+		fetch.waiters--
+		if fetch.waiters > 0 {
+			return
+		}
+		fetch.cancel()
+		if c.fetches[key] == fetch {
+			delete(c.fetches, key)
+			c.inflight.Forget(key)
+		}
+	}
+}
+
+// fetchAndCache fetches through the circuit breaker and caches a
+// successful result. It runs once per key inside c.inflight.
+func (c *Client) fetchAndCache(ctx context.Context, logger *zap.Logger, cacheKey, symbol string, ndays int, period Period, apiDurationHist prometheus.Histogram) (interface{}, error) {
+	logger.Info("cache miss", zap.String("symbol", symbol), zap.Int("ndays", ndays))
+
+	ctx, span := tracer.Start(ctx, "circuitbreaker.Call", trace.WithAttributes(
+		attribute.String("circuit_breaker.state", c.circuitBreaker.GetState().String()),
+	))
+	result, err := c.callUpstream(ctx, symbol, ndays, period, apiDurationHist)
+	endSpan(span, err)
+
+	if err != nil {
+		logger.Error("circuit breaker error", zap.Error(err))
+		if c.negativeCache != nil && isSymbolNotFound(err) {
+			c.negativeCache.Set(symbol, err)
+		}
+		return nil, err
 	}
 
 	// Cache the successful result
-	if err == nil && result != nil {
+	if result != nil {
 		c.cache.Set(cacheKey, result)
-		c.logger.Info("cached stock data", zap.String("symbol", symbol), zap.Int("ndays", ndays))
+		c.recordFetch(cacheKey, symbol, ndays, period)
+		c.lastSuccess.Store(c.now().UnixNano())
+		logger.Info("cached stock data", zap.String("symbol", symbol), zap.Int("ndays", ndays))
 	}
 
 	return result, err
 }
 
-func (c *Client) fetchStockData(symbol string, ndays int, apiDurationHist prometheus.Histogram) (*StockData, error) {
-	start := time.Now()
-	defer func() {
-		c.externalCallDuration.Observe(time.Since(start).Seconds())
-		c.externalCalls.Inc()
-		c.externalApiLatency.WithLabelValues("alphavantage").Observe(time.Since(start).Seconds())
-	}()
+// getStale returns a copy of an expired entry still within the cache's
+// stale grace period, flagged as stale.
+func (c *Client) getStale(logger *zap.Logger, key string) (*StockData, bool) {
+	cached, found := c.cache.GetStale(key)
+	if !found {
+		return nil, false
+	}
 
-	url := fmt.Sprintf("%s?function=TIME_SERIES_DAILY&symbol=%s&apikey=%s", c.apiURL, symbol, c.apiKey)
-	
-	c.logger.Info("calling Alpha Vantage API", zap.String("url", url))
-	
-	resp, err := c.httpClient.Get(url)
-	if err != nil {
-		c.logger.Error("failed to call Alpha Vantage API", zap.Error(err))
-		return nil, fmt.Errorf("failed to call Alpha Vantage API: %w", err)
+	stockData, ok := cached.(*StockData)
+	if !ok {
+		logger.Error("unexpected type in stock cache",
+			zap.String("key", key),
+			zap.String("type", fmt.Sprintf("%T", cached)),
+		)
+		return nil, false
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		c.logger.Error("Alpha Vantage API returned non-200 status", zap.Int("status", resp.StatusCode))
-		return nil, fmt.Errorf("Alpha Vantage API returned status %d", resp.StatusCode)
+	stale := *stockData
+	stale.Stale = true
+	stale.Source = SourceCache
+	return &stale, true
+}
+
+// getCached returns the stock data cached under key and how long it has
+// left to live. A value of any other type is a bug, so it is logged and
+// dropped rather than silently refetched over.
+func (c *Client) getCached(logger *zap.Logger, key string) (*StockData, time.Duration, bool) {
+	cached, remaining, found := c.cache.GetWithTTL(key)
+	if !found {
+		return nil, 0, false
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		c.logger.Error("failed to read response body", zap.Error(err))
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+	stockData, ok := cached.(*StockData)
+	if !ok {
+		logger.Error("unexpected type in stock cache",
+			zap.String("key", key),
+			zap.String("type", fmt.Sprintf("%T", cached)),
+		)
+		c.cache.Delete(key)
+		return nil, 0, false
 	}
+	return stockData, remaining, true
+}
 
-	var alphaVantageResp AlphaVantageResponse
-	if err := json.Unmarshal(body, &alphaVantageResp); err != nil {
-		c.logger.Error("failed to unmarshal response", zap.Error(err))
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+// loggerFor tags the client's logger with the request ID carried by ctx, if
+// any, so log lines can be correlated with the originating request.
+func (c *Client) loggerFor(ctx context.Context) *zap.Logger {
+	if id := requestid.FromContext(ctx); id != "" {
+		return c.logger.With(zap.String("request_id", id))
 	}
+	return c.logger
+}
 
-	if alphaVantageResp.ErrorMessage != "" {
-		c.logger.Error("Alpha Vantage API error", zap.String("error", alphaVantageResp.ErrorMessage))
-		return nil, fmt.Errorf("Alpha Vantage API error: %s", alphaVantageResp.ErrorMessage)
+// LastSuccessAt returns when data was last fetched from a provider
+// successfully, or the zero time if it never has been.
+func (c *Client) LastSuccessAt() time.Time {
+	nanos := c.lastSuccess.Load()
+	if nanos == 0 {
+		return time.Time{}
 	}
+	return time.Unix(0, nanos)
+}
+
+// CircuitBreakerState returns the state of the upstream circuit breaker.
+func (c *Client) CircuitBreakerState() circuitbreaker.State {
+	return c.circuitBreaker.GetState()
+}
+
+// CircuitBreakerSnapshot returns the state and counters of the upstream
+// circuit breaker.
+func (c *Client) CircuitBreakerSnapshot() circuitbreaker.Snapshot {
+	return c.circuitBreaker.Snapshot()
+}
+
+// ResetCircuitBreaker forces the upstream circuit breaker closed. A single
+// breaker guards all symbols, so this re-enables upstream calls for every
+// symbol at once.
+func (c *Client) ResetCircuitBreaker() {
+	c.circuitBreaker.Reset()
+	c.logger.Info("circuit breaker reset")
+}
 
-	if alphaVantageResp.Note != "" {
-		c.logger.Warn("Alpha Vantage API note", zap.String("note", alphaVantageResp.Note))
+// SetDegraded turns degraded mode on or off. In degraded mode the client
+// makes no upstream calls: cached data is served as usual and anything
+// else fails with ErrDegradedModeMiss.
+func (c *Client) SetDegraded(degraded bool) {
+	if c.degraded.Swap(degraded) != degraded {
+		c.logger.Warn("degraded mode changed", zap.Bool("degraded", degraded))
 	}
+}
+
+// Degraded reports whether the client is in degraded mode.
+func (c *Client) Degraded() bool {
+	return c.degraded.Load()
+}
 
-	if len(alphaVantageResp.TimeSeriesDaily) == 0 {
-		c.logger.Error("no time series data returned")
-		return nil, fmt.Errorf("no time series data returned")
+// ClearCache drops every cached result, including remembered unknown
+// symbols, and returns the number of entries removed.
+func (c *Client) ClearCache() int {
+	n := c.cache.Clear()
+	if c.negativeCache != nil {
+		n += c.negativeCache.Clear()
 	}
+	c.logger.Info("cache cleared", zap.Int("entries", n))
+	return n
+}
+
+// SetCacheTTL changes how long stock data fetched from now on stays
+// cached and returns the previous TTL. Entries already cached keep their
+// expiry.
+func (c *Client) SetCacheTTL(ttl time.Duration) time.Duration {
+	previous := c.cache.SetDefaultTTL(ttl)
+	c.cacheTTL.Store(int64(ttl))
+	c.logger.Info("cache TTL changed", zap.Duration("previous", previous), zap.Duration("ttl", ttl))
+	return previous
+}
 
-	return c.processTimeSeries(symbol, ndays, alphaVantageResp.TimeSeriesDaily)
+// CacheStats reports the activity of the client's stock data cache.
+func (c *Client) CacheStats() cache.Stats {
+	return c.cache.Stats()
 }
 
-func (c *Client) processTimeSeries(symbol string, ndays int, timeSeries map[string]DailyData) (*StockData, error) {
-	var dates []string
-	for date := range timeSeries {
-		dates = append(dates, date)
+// PingCache checks that the cache backend is reachable. Backends without a
+// network dependency, like the in-memory cache, always succeed.
+func (c *Client) PingCache(ctx context.Context) error {
+	if pinger, ok := c.cache.(interface{ Ping(context.Context) error }); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// fetchStockData tries each provider in order and returns the first
+// successful result. If every provider fails, the errors are joined.
+func (c *Client) fetchStockData(ctx context.Context, symbol string, ndays int, period Period, apiDurationHist prometheus.Histogram) (*StockData, error) {
+	if err := c.acquireUpstream(ctx); err != nil {
+		return nil, err
+	}
+	defer c.releaseUpstream()
+
+	logger := c.loggerFor(ctx)
+	var errs []error
+	for _, provider := range c.providers {
+		result, err := c.fetchFromProvider(ctx, provider, symbol, ndays, period)
+		if err == nil {
+			// Stamp a copy; providers may hand out shared values
+			stamped := *result
+			stamped.Source = provider.Name()
+			stamped.FetchedAt = c.now().UTC()
+			return &stamped, nil
+		}
+
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+		logger.Warn("provider failed",
+			zap.String("provider", provider.Name()),
+			zap.String("symbol", symbol),
+			zap.Error(err),
+		)
+	}
+
+	if len(errs) == 1 {
+		return nil, errs[0]
+	}
+	return nil, errors.Join(errs...)
+}
+
+// acquireUpstream waits for a free upstream slot, giving up when ctx is
+// done. Every successful call must be paired with releaseUpstream.
+func (c *Client) acquireUpstream(ctx context.Context) error {
+	if c.upstreamSlots != nil {
+		select {
+		case c.upstreamSlots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	c.upstreamInFlight.Inc()
+	return nil
+}
+
+func (c *Client) releaseUpstream() {
+	c.upstreamInFlight.Dec()
+	if c.upstreamSlots != nil {
+		<-c.upstreamSlots
+	}
+}
+
+// isSymbolNotFound reports whether err definitively says the symbol does not
+// exist. When several providers failed, all of them must agree; a single
+// timeout or 5xx among them means the symbol may still resolve later.
+func isSymbolNotFound(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			if !isSymbolNotFound(e) {
+				return false
+			}
+		}
+		return true
+	}
+	return errors.Is(err, ErrSymbolNotFound)
+}
+
+func (c *Client) fetchFromProvider(ctx context.Context, provider StockProvider, symbol string, ndays int, period Period) (*StockData, error) {
+	start := time.Now()
+	defer func() {
+		c.externalCallDuration.Observe(time.Since(start).Seconds())
+		c.externalCalls.Inc()
+		c.externalApiLatency.WithLabelValues(provider.Name()).Observe(time.Since(start).Seconds())
+	}()
+
+	return provider.FetchDailySeries(ctx, symbol, ndays, period)
+}
+
+// processTimeSeries keeps the latest ndays valid points of timeSeries and
+// computes the summary statistics. Providers share it so every source is
+// truncated and averaged the same way.
+func processTimeSeries(logger *zap.Logger, symbol string, ndays int, timeSeries map[string]DailyData) (*StockData, error) {
+	type datedKey struct {
+		key  string
+		date time.Time
+	}
+
+	var parsed []datedKey
+	for key := range timeSeries {
+		date, err := time.Parse(dateLayout, key)
+		if err != nil {
+			// Intraday series are keyed by timestamp
+			date, err = time.Parse(dateTimeLayout, key)
+		}
+		if err != nil {
+			logger.Warn("skipping unparseable date", zap.String("date", key), zap.Error(err))
+			continue
+		}
+		parsed = append(parsed, datedKey{key: key, date: date})
+	}
+
+	// Newest first, so the first ndays entries are the latest window
+	sort.Slice(parsed, func(i, j int) bool {
+		return parsed[i].date.After(parsed[j].date)
+	})
+
+	dates := make([]string, len(parsed))
+	for i, d := range parsed {
+		dates[i] = d.key
 	}
-	
-	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
 	
 	if len(dates) < ndays {
 		ndays = len(dates)
@@ -190,6 +977,7 @@ func (c *Client) processTimeSeries(symbol string, ndays int, timeSeries map[stri
 	
 	var prices []PricePoint
 	var sum float64
+	var warnings []string
 	
 	for i := 0; i < ndays; i++ {
 		date := dates[i]
@@ -197,15 +985,38 @@ func (c *Client) processTimeSeries(symbol string, ndays int, timeSeries map[stri
 		
 		close, err := parseFloat(dailyData.Close)
 		if err != nil {
-			c.logger.Error("failed to parse close price", zap.String("date", date), zap.Error(err))
+			logger.Error("failed to parse close price", zap.String("date", date), zap.Error(err))
+			warnings = append(warnings, fmt.Sprintf("%s: skipped, invalid close %q", date, dailyData.Close))
 			continue
 		}
 		
+		// Only the close is required; the other fields are informational
+		// and stay zero when missing or malformed.
+		open, _ := parseFloat(dailyData.Open)
+		high, _ := parseFloat(dailyData.High)
+		low, _ := parseFloat(dailyData.Low)
+		volume, _ := strconv.ParseInt(dailyData.Volume, 10, 64)
+
+		var adjustedClose float64
+		if dailyData.AdjustedClose != "" {
+			adjustedClose, err = parseFloat(dailyData.AdjustedClose)
+			if err != nil {
+				logger.Error("failed to parse adjusted close", zap.String("date", date), zap.Error(err))
+				warnings = append(warnings, fmt.Sprintf("%s: skipped, invalid adjusted close %q", date, dailyData.AdjustedClose))
+				continue
+			}
+		}
+
 		prices = append(prices, PricePoint{
-			Date:  date,
-			Close: close,
+			Date:          date,
+			Open:          open,
+			High:          high,
+			Low:           low,
+			Close:         close,
+			AdjustedClose: adjustedClose,
+			Volume:        volume,
 		})
-		sum += close
+		sum += prices[len(prices)-1].statClose()
 	}
 	
 	if len(prices) == 0 {
@@ -213,15 +1024,57 @@ func (c *Client) processTimeSeries(symbol string, ndays int, timeSeries map[stri
 	}
 	
 	average := sum / float64(len(prices))
+
+	// Population standard deviation of the closes; zero for a single point
+	minClose, maxClose := prices[0].statClose(), prices[0].statClose()
+	var squaredDiffs float64
+	for _, p := range prices {
+		squaredDiffs += (p.statClose() - average) * (p.statClose() - average)
+		minClose = math.Min(minClose, p.statClose())
+		maxClose = math.Max(maxClose, p.statClose())
+	}
+	volatility := math.Sqrt(squaredDiffs / float64(len(prices)))
+
+	// Prices are newest first, so the window opens at the last element
+	var change, changePercent float64
+	if len(prices) > 1 {
+		latest := prices[0].statClose()
+		oldest := prices[len(prices)-1].statClose()
+		change = latest - oldest
+		if oldest != 0 {
+			changePercent = change / oldest * 100
+		}
+	}
 	
+	// Intraday dates carry a time after the day
+	latestDay, _, _ := strings.Cut(prices[0].Date, " ")
+
 	return &StockData{
-		Symbol:  symbol,
-		NDays:   len(prices),
-		Prices:  prices,
-		Average: average,
+		Symbol:           symbol,
+		NDays:            len(prices),
+		Prices:           prices,
+		Average:          average,
+		Change:           change,
+		ChangePercent:    changePercent,
+		Volatility:       volatility,
+		Min:              minClose,
+		Max:              maxClose,
+		Currency:         BaseCurrency,
+		LatestTradingDay: latestDay,
+		SkippedDays:      len(warnings),
+		Warnings:         warnings,
 	}, nil
 }
 
+// statClose is the close used for summary statistics: the adjusted close
+// when the series has one, the raw close otherwise.
+func (p PricePoint) statClose() float64 {
+	if p.AdjustedClose != 0 {
+		return p.AdjustedClose
+	}
+	return p.Close
+}
+
 func parseFloat(s string) (float64, error) {
 	return strconv.ParseFloat(s, 64)
 }
\ No newline at end of file
@@ -1,17 +1,31 @@
 package stock
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/cache"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func createTestClient() *Client {
@@ -23,46 +37,63 @@ func createTestClient() *Client {
 	// Create test circuit breaker
 	cb := circuitbreaker.NewCircuitBreaker(5, 10, 30*time.Second)
 
-	// Create test metrics
-	cacheHits := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "test_cache_hits_total",
-		Help: "Test cache hits",
-	})
-	cacheMisses := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "test_cache_misses_total",
-		Help: "Test cache misses",
-	})
-	externalCalls := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "test_external_calls_total",
-		Help: "Test external calls",
+	provider := NewAlphaVantageProvider("test-api-key", OutputSizeAuto, 10*time.Second, 0, nil, logger)
+
+	return NewClient(ClientOptions{
+		Providers:      []StockProvider{provider},
+		Logger:         logger,
+		Cache:          stockCache,
+		NegativeCache:  cache.NewCache(time.Minute),
+		CircuitBreaker: cb,
 	})
-	externalCallDuration := prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "test_external_call_duration_seconds",
-		Help:    "Test external call duration",
-		Buckets: prometheus.DefBuckets,
+}
+
+func TestNewClientRegistersMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	NewClient(ClientOptions{
+		Providers:        []StockProvider{NewAlphaVantageProvider("test-api-key", OutputSizeAuto, time.Second, 0, nil, zap.NewNop())},
+		Cache:            cache.NewCache(time.Minute),
+		CircuitBreaker:   circuitbreaker.NewCircuitBreaker(5, 10, 30*time.Second),
+		Registerer:       reg,
+		MetricsNamespace: "test",
 	})
-	circuitBreakerState := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "test_circuit_breaker_state",
-		Help: "Test circuit breaker state",
+
+	// Vectors without observations aren't gathered, so count collectors
+	if got := testutil.CollectAndCount(reg, "test_cache_hits_total", "test_cache_misses_total", "test_coalesced_requests_total", "test_external_calls_total", "test_external_call_duration_seconds", "test_upstream_in_flight"); got != 6 {
+		t.Errorf("expected 6 registered metrics, got %d", got)
+	}
+}
+
+func TestCacheMetricsReflectCacheCounts(t *testing.T) {
+	stockCache := cache.NewCache(time.Minute)
+	client := NewClient(ClientOptions{
+		Providers:      []StockProvider{&fakeProvider{name: "primary", data: &StockData{Symbol: "AAPL", NDays: 7}}},
+		Cache:          stockCache,
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(5, 1, time.Minute),
 	})
 
-	return NewClient(
-		"test-api-key",
-		10*time.Second,
-		logger,
-		stockCache,
-		cb,
-		cacheHits,
-		cacheMisses,
-		externalCalls,
-		externalCallDuration,
-		circuitBreakerState,
-	)
+	for i := 0; i < 3; i++ {
+		if _, err := client.GetStockData(context.Background(), "AAPL", 7, PeriodDaily, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Lookups by other users of the cache count as well
+	stockCache.Get("unrelated")
+
+	if hits := testutil.ToFloat64(client.cacheHits); hits != 2 {
+		t.Errorf("expected 2 hits, got %g", hits)
+	}
+	if misses := testutil.ToFloat64(client.cacheMisses); misses != 2 {
+		t.Errorf("expected 2 misses, got %g", misses)
+	}
 }
 
-func TestProcessTimeSeries(t *testing.T) {
-	client := createTestClient()
+// setAPIURL points the client's Alpha Vantage provider at a test server.
+func setAPIURL(client *Client, url string) {
+	client.providers[0].(*AlphaVantageProvider).apiURL = url
+}
 
+func TestProcessTimeSeries(t *testing.T) {
 	timeSeries := map[string]DailyData{
 		"2024-01-15": {Close: "415.26"},
 		"2024-01-16": {Close: "418.45"},
@@ -71,7 +102,7 @@ func TestProcessTimeSeries(t *testing.T) {
 		"2024-01-19": {Close: "416.85"},
 	}
 
-	result, err := client.processTimeSeries("MSFT", 3, timeSeries)
+	result, err := processTimeSeries(zap.NewNop(), "MSFT", 3, timeSeries)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -93,40 +124,123 @@ func TestProcessTimeSeries(t *testing.T) {
 	if math.Abs(result.Average-expectedAverage) > 0.01 {
 		t.Errorf("expected average %.2f, got %.2f", expectedAverage, result.Average)
 	}
+
+	// Change runs from the oldest close in the window (2024-01-17) to the latest
+	expectedChange := 416.85 - 412.89
+	if math.Abs(result.Change-expectedChange) > 0.0001 {
+		t.Errorf("expected change %.4f, got %.4f", expectedChange, result.Change)
+	}
+	expectedChangePercent := expectedChange / 412.89 * 100
+	if math.Abs(result.ChangePercent-expectedChangePercent) > 0.0001 {
+		t.Errorf("expected change percent %.4f, got %.4f", expectedChangePercent, result.ChangePercent)
+	}
+
+	// Population standard deviation of 416.85, 420.12 and 412.89
+	expectedVolatility := 2.9561
+	if math.Abs(result.Volatility-expectedVolatility) > 0.0001 {
+		t.Errorf("expected volatility %.4f, got %.4f", expectedVolatility, result.Volatility)
+	}
+	if result.Min != 412.89 || result.Max != 420.12 {
+		t.Errorf("expected min 412.89 and max 420.12, got %.2f and %.2f", result.Min, result.Max)
+	}
 }
 
-func TestProcessTimeSeriesInsufficientData(t *testing.T) {
-	client := createTestClient()
+func TestProcessTimeSeriesOHLCV(t *testing.T) {
+	timeSeries := map[string]DailyData{
+		"2024-01-18": {Open: "bad", High: "", Low: "410.00", Close: "420.12", Volume: "n/a"},
+		"2024-01-19": {Open: "415.00", High: "418.20", Low: "414.10", Close: "416.85", Volume: "23456789"},
+	}
+
+	result, err := processTimeSeries(zap.NewNop(), "MSFT", 2, timeSeries)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Prices) != 2 {
+		t.Fatalf("expected 2 prices, got %d", len(result.Prices))
+	}
+
+	latest := result.Prices[0]
+	if latest.Open != 415.00 || latest.High != 418.20 || latest.Low != 414.10 || latest.Volume != 23456789 {
+		t.Errorf("unexpected OHLCV for latest day: %+v", latest)
+	}
+
+	// Malformed optional fields are zeroed rather than dropping the day
+	previous := result.Prices[1]
+	if previous.Open != 0 || previous.High != 0 || previous.Volume != 0 {
+		t.Errorf("expected malformed fields to be zero, got %+v", previous)
+	}
+	if previous.Low != 410.00 || previous.Close != 420.12 {
+		t.Errorf("unexpected valid fields for previous day: %+v", previous)
+	}
+}
+
+func TestProcessTimeSeriesSortsByParsedDate(t *testing.T) {
+	timeSeries := map[string]DailyData{
+		"2023-12-29": {Close: "376.04"},
+		"2024-01-02": {Close: "370.87"},
+		"2024-1-3":   {Close: "999.99"}, // not ISO formatted, skipped
+		"2023-12-28": {Close: "375.28"},
+	}
+
+	result, err := processTimeSeries(zap.NewNop(), "MSFT", 2, timeSeries)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result.Prices) != 2 {
+		t.Fatalf("expected 2 prices, got %d", len(result.Prices))
+	}
+	if result.Prices[0].Date != "2024-01-02" || result.Prices[1].Date != "2023-12-29" {
+		t.Errorf("expected latest two dates across the year boundary, got %s and %s", result.Prices[0].Date, result.Prices[1].Date)
+	}
+}
 
+func TestProcessTimeSeriesInsufficientData(t *testing.T) {
 	timeSeries := map[string]DailyData{
 		"2024-01-19": {Close: "416.85"},
 	}
 
-	result, err := client.processTimeSeries("MSFT", 3, timeSeries)
+	result, err := processTimeSeries(zap.NewNop(), "MSFT", 3, timeSeries)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 	if result.NDays != 1 {
 		t.Errorf("expected NDays to be adjusted to 1, got %d", result.NDays)
 	}
+	if result.Change != 0 || result.ChangePercent != 0 {
+		t.Errorf("expected zero change for a single day, got %f (%f%%)", result.Change, result.ChangePercent)
+	}
+	if result.Volatility != 0 || math.IsNaN(result.Volatility) {
+		t.Errorf("expected zero volatility for a single day, got %f", result.Volatility)
+	}
+	if result.Min != 416.85 || result.Max != 416.85 {
+		t.Errorf("expected min and max to equal the only close, got %.2f and %.2f", result.Min, result.Max)
+	}
 }
 
 func TestProcessTimeSeriesInvalidPrice(t *testing.T) {
-	client := createTestClient()
-
 	timeSeries := map[string]DailyData{
 		"2024-01-17": {Close: "invalid"},
 		"2024-01-18": {Close: "420.12"},
 		"2024-01-19": {Close: "416.85"},
 	}
 
-	result, err := client.processTimeSeries("MSFT", 3, timeSeries)
+	result, err := processTimeSeries(zap.NewNop(), "MSFT", 3, timeSeries)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 	if result.NDays != 2 { // Should skip invalid price and use only 2 valid ones
 		t.Errorf("expected NDays to be 2 (only valid prices), got %d", result.NDays)
 	}
+	if result.SkippedDays != 1 {
+		t.Errorf("expected SkippedDays to be 1, got %d", result.SkippedDays)
+	}
+	if len(result.Warnings) != 1 || !strings.HasPrefix(result.Warnings[0], "2024-01-17:") {
+		t.Errorf("expected a warning naming 2024-01-17, got %q", result.Warnings)
+	}
+	if want := (420.12 + 416.85) / 2; result.Average != want {
+		t.Errorf("expected the average of the valid prices, %g, got %g", want, result.Average)
+	}
 }
 
 func TestGetStockData(t *testing.T) {
@@ -164,9 +278,9 @@ func TestGetStockData(t *testing.T) {
 	})
 
 	// Set the API URL to our mock server
-	client.apiURL = server.URL + "/query"
+	setAPIURL(client, server.URL + "/query")
 
-	result, err := client.GetStockData("MSFT", 3, apiDurationHist)
+	result, err := client.GetStockData(context.Background(), "MSFT", 3, PeriodDaily, apiDurationHist)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -199,13 +313,13 @@ func TestGetStockDataCacheHit(t *testing.T) {
 	})
 
 	// First call - should be cache miss
-	_, err := client.GetStockData("MSFT", 3, apiDurationHist)
+	_, err := client.GetStockData(context.Background(), "MSFT", 3, PeriodDaily, apiDurationHist)
 	if err == nil {
 		t.Skip("Skipping cache test due to API call - would need mock server")
 	}
 
 	// Second call - should be cache hit
-	_, err = client.GetStockData("MSFT", 3, apiDurationHist)
+	_, err = client.GetStockData(context.Background(), "MSFT", 3, PeriodDaily, apiDurationHist)
 	if err == nil {
 		t.Skip("Skipping cache test due to API call - would need mock server")
 	}
@@ -222,12 +336,1268 @@ func TestGetStockDataCircuitBreaker(t *testing.T) {
 
 	// Force circuit breaker to open by causing failures
 	for i := 0; i < 10; i++ {
-		client.GetStockData("INVALID_SYMBOL", 3, apiDurationHist)
+		client.GetStockData(context.Background(), "INVALID_SYMBOL", 3, PeriodDaily, apiDurationHist)
 	}
 
 	// This should fail due to circuit breaker
-	_, err := client.GetStockData("MSFT", 3, apiDurationHist)
+	_, err := client.GetStockData(context.Background(), "MSFT", 3, PeriodDaily, apiDurationHist)
 	if err == nil {
 		t.Skip("Skipping circuit breaker test - would need controlled failure scenario")
 	}
-}
\ No newline at end of file
+}
+
+func TestGetStockDataCoalescesConcurrentMisses(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		// Hold the request open long enough for every caller to pile up
+		time.Sleep(100 * time.Millisecond)
+		response := AlphaVantageResponse{
+			TimeSeriesDaily: map[string]DailyData{
+				"2024-01-19": {Close: "416.85"},
+				"2024-01-18": {Close: "420.12"},
+			},
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	client := createTestClient()
+	setAPIURL(client, server.URL + "/query")
+
+	var wg sync.WaitGroup
+	results := make([]*StockData, 10)
+	errs := make([]error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = client.GetStockData(context.Background(), "AAPL", 2, PeriodDaily, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("expected 1 upstream call, got %d", got)
+	}
+	for i := range results {
+		if errs[i] != nil {
+			t.Fatalf("request %d: unexpected error: %v", i, errs[i])
+		}
+		// Each caller gets its own annotated copy of the one result
+		if &results[i].Prices[0] != &results[0].Prices[0] {
+			t.Errorf("request %d: expected shared result", i)
+		}
+	}
+}
+
+func TestGetStockDataCountsCoalescedRequests(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		response := AlphaVantageResponse{
+			TimeSeriesDaily: map[string]DailyData{
+				"2024-01-19": {Close: "416.85"},
+				"2024-01-18": {Close: "420.12"},
+			},
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	client := createTestClient()
+	setAPIURL(client, server.URL+"/query")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.GetStockData(context.Background(), "AAPL", 2, PeriodDaily, nil); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every caller but the one that started the fetch waited on it
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected 1 upstream call, got %d", got)
+	}
+	if got := testutil.ToFloat64(client.coalescedRequests); got != 9 {
+		t.Errorf("expected 9 coalesced requests, got %g", got)
+	}
+
+	// A cache hit waits on nothing
+	if _, err := client.GetStockData(context.Background(), "AAPL", 2, PeriodDaily, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(client.coalescedRequests); got != 9 {
+		t.Errorf("expected cache hit not to count, got %g", got)
+	}
+}
+
+func TestGetStockDataCoalescedErrorPropagates(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := createTestClient()
+	setAPIURL(client, server.URL + "/query")
+
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = client.GetStockData(context.Background(), "AAPL", 2, PeriodDaily, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("expected 1 upstream call, got %d", got)
+	}
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("request %d: expected error to propagate to waiter", i)
+		}
+	}
+}
+
+func TestGetStockDataContextCancelled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := createTestClient()
+	setAPIURL(client, server.URL + "/query")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := client.GetStockData(ctx, "MSFT", 3, PeriodDaily, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected prompt return after cancel, took %v", elapsed)
+	}
+}
+
+// gatedProvider blocks each fetch until release is closed, reporting the
+// context error if its context ends first.
+type gatedProvider struct {
+	started chan struct{}
+	release chan struct{}
+	calls   atomic.Int32
+}
+
+func (p *gatedProvider) Name() string { return "gated" }
+
+func (p *gatedProvider) FetchDailySeries(ctx context.Context, symbol string, ndays int, period Period) (*StockData, error) {
+	if err := p.wait(ctx); err != nil {
+		return nil, err
+	}
+	return &StockData{Symbol: symbol, NDays: ndays}, nil
+}
+
+func (p *gatedProvider) FetchQuote(ctx context.Context, symbol string) (*Quote, error) {
+	if err := p.wait(ctx); err != nil {
+		return nil, err
+	}
+	return &Quote{Symbol: symbol}, nil
+}
+
+func (p *gatedProvider) FetchExchangeRate(ctx context.Context, from, to string) (float64, error) {
+	if err := p.wait(ctx); err != nil {
+		return 0, err
+	}
+	return 0.9, nil
+}
+
+// wait counts a call and blocks until it is released or ctx ends.
+func (p *gatedProvider) wait(ctx context.Context) error {
+	p.calls.Add(1)
+	p.started <- struct{}{}
+	select {
+	case <-p.release:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrUpstream, ctx.Err())
+	}
+}
+
+func TestGetStockDataLeaderCancelKeepsSharedFetch(t *testing.T) {
+	provider := &gatedProvider{started: make(chan struct{}, 1), release: make(chan struct{})}
+	cb := circuitbreaker.NewCircuitBreaker(1, 1, time.Minute)
+	client := NewClient(ClientOptions{
+		Providers:      []StockProvider{provider},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: cb,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := client.GetStockData(ctx, "MSFT", 7, PeriodDaily, nil)
+		first <- err
+	}()
+	<-provider.started
+
+	second := make(chan error, 1)
+	go func() {
+		data, err := client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+		if err == nil && data.Symbol != "MSFT" {
+			err = fmt.Errorf("unexpected data %+v", data)
+		}
+		second <- err
+	}()
+	// Give the second request time to join the fetch
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the first request to be cancelled, got %v", err)
+	}
+	close(provider.release)
+	if err := <-second; err != nil {
+		t.Errorf("expected the coalesced request to succeed, got %v", err)
+	}
+	if got := provider.calls.Load(); got != 1 {
+		t.Errorf("expected 1 upstream call, got %d", got)
+	}
+	if state := cb.GetState(); state != circuitbreaker.StateClosed {
+		t.Errorf("expected the breaker to stay closed, got %s", state)
+	}
+}
+
+func TestGetStockDataWeekly(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fn := r.URL.Query().Get("function"); fn != "TIME_SERIES_WEEKLY" {
+			t.Errorf("expected function=TIME_SERIES_WEEKLY, got %s", fn)
+		}
+		w.Write([]byte(`{"Weekly Time Series": {
+			"2024-01-19": {"4. close": "416.85"},
+			"2024-01-12": {"4. close": "388.47"}
+		}}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient()
+	setAPIURL(client, server.URL + "/query")
+
+	result, err := client.GetStockData(context.Background(), "MSFT", 2, PeriodWeekly, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.NDays != 2 {
+		t.Errorf("expected 2 weeks, got %d", result.NDays)
+	}
+
+	// The daily entry for the same symbol and window must not be served
+	// from the weekly cache entry.
+	if _, found := client.cache.Get("MSFT_2_daily"); found {
+		t.Error("expected weekly result not to populate the daily cache key")
+	}
+}
+
+func TestGetStockDataIntraday(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fn := r.URL.Query().Get("function"); fn != "TIME_SERIES_INTRADAY" {
+			t.Errorf("expected function=TIME_SERIES_INTRADAY, got %s", fn)
+		}
+		if interval := r.URL.Query().Get("interval"); interval != "5min" {
+			t.Errorf("expected interval=5min, got %s", interval)
+		}
+		w.Write([]byte(`{"Meta Data": {"1. Information": "Intraday (5min)"}, "Time Series (5min)": {
+			"2024-01-19 15:55:00": {"4. close": "398.10"},
+			"2024-01-19 16:00:00": {"4. close": "398.67"},
+			"2024-01-19 15:50:00": {"4. close": "397.90"}
+		}}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient()
+	setAPIURL(client, server.URL + "/query")
+
+	result, err := client.GetStockData(context.Background(), "MSFT", 2, PeriodIntraday5Min, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Prices) != 2 || result.Prices[0].Date != "2024-01-19 16:00:00" || result.Prices[1].Date != "2024-01-19 15:55:00" {
+		t.Errorf("expected the latest two bars newest first, got %+v", result.Prices)
+	}
+	if _, found := client.cache.Get("MSFT_2_5min"); !found {
+		t.Error("expected intraday result under its own cache key")
+	}
+}
+
+func TestGetStockDataAdjusted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fn := r.URL.Query().Get("function"); fn != "TIME_SERIES_DAILY_ADJUSTED" {
+			t.Errorf("expected function=TIME_SERIES_DAILY_ADJUSTED, got %s", fn)
+		}
+		w.Write([]byte(`{"Time Series (Daily)": {
+			"2024-01-03": {"4. close": "200.00", "5. adjusted close": "100.00", "6. volume": "1000"},
+			"2024-01-02": {"4. close": "190.00", "5. adjusted close": "95.00", "6. volume": "2000"}
+		}}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient()
+	setAPIURL(client, server.URL + "/query")
+
+	result, err := client.GetStockData(context.Background(), "MSFT", 2, PeriodDailyAdjusted, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Prices[0].AdjustedClose != 100 || result.Prices[0].Close != 200 || result.Prices[0].Volume != 1000 {
+		t.Errorf("unexpected first point %+v", result.Prices[0])
+	}
+	if result.Average != 97.5 {
+		t.Errorf("expected average of adjusted closes 97.5, got %f", result.Average)
+	}
+}
+
+func TestGetStockDataAdjustedNotEntitled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Information": "Thank you for using Alpha Vantage! This is a premium endpoint."}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient()
+	setAPIURL(client, server.URL + "/query")
+
+	_, err := client.GetStockData(context.Background(), "MSFT", 2, PeriodDailyAdjusted, nil)
+	if !errors.Is(err, ErrNotEntitled) {
+		t.Errorf("expected ErrNotEntitled, got %v", err)
+	}
+}
+
+func TestGetStockDataPremiumEndpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+    "Information": "Thank you for using Alpha Vantage! This is a premium endpoint. You may subscribe to any of the premium plans at https://www.alphavantage.co/premium/ to instantly unlock all premium endpoints"
+}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient()
+	setAPIURL(client, server.URL + "/query")
+
+	_, err := client.GetStockData(context.Background(), "MSFT", 2, PeriodDaily, nil)
+	if !errors.Is(err, ErrPremiumRequired) || !errors.Is(err, ErrNotEntitled) {
+		t.Fatalf("expected ErrPremiumRequired, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "premium plans") {
+		t.Errorf("expected Alpha Vantage's message in the error, got %q", err)
+	}
+
+	// Rate limit messages also mention the premium plans
+	if isPremiumEndpointMessage("Thank you for using Alpha Vantage! Our standard API rate limit is 25 requests per day. Please subscribe to any of the premium plans at https://www.alphavantage.co/premium/ to instantly remove all daily rate limits.") {
+		t.Error("expected a rate limit message not to be a premium endpoint message")
+	}
+}
+
+func TestGetStockDataDemoKeyLimitation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Information": "The **demo** API key is for demo purposes only. Please claim your free API key at (https://www.alphavantage.co/support/#api-key) to explore our full API offerings. It takes fewer than 20 seconds."}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient()
+	setAPIURL(client, server.URL + "/query")
+
+	_, err := client.GetStockData(context.Background(), "MSFT", 2, PeriodDaily, nil)
+	if !errors.Is(err, ErrDemoKey) {
+		t.Fatalf("expected ErrDemoKey, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "set APIKEY") {
+		t.Errorf("expected the error to say how to fix it, got %q", err)
+	}
+
+	// Premium-only messages for other keys are not mistaken for it
+	if isDemoKeyLimitation("Thank you for using Alpha Vantage! This is a premium endpoint.") {
+		t.Error("expected a premium endpoint message not to be a demo key limitation")
+	}
+}
+
+func TestGetStockDataUnexpectedPayloads(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantErr     error
+		wantInError string
+	}{
+		{
+			name:        "HTML error page",
+			contentType: "text/html; charset=utf-8",
+			body:        "<html><body><h1>502 Bad Gateway</h1></body></html>",
+			wantErr:     ErrUpstream,
+			wantInError: "<h1>502 Bad Gateway</h1>",
+		},
+		{
+			name:        "empty body",
+			contentType: "application/json",
+			body:        "  \n",
+			wantErr:     ErrUpstream,
+			wantInError: "empty body",
+		},
+		{
+			name:        "malformed JSON",
+			contentType: "application/json",
+			body:        `{"Meta Data": `,
+			wantErr:     ErrUpstream,
+			wantInError: `{\"Meta Data\":`,
+		},
+		{
+			name:        "Information notice",
+			contentType: "application/json",
+			body:        `{"Information": "This API function is temporarily unavailable."}`,
+			wantErr:     ErrUpstream,
+			wantInError: "temporarily unavailable",
+		},
+		{
+			name:        "Information rate limit",
+			contentType: "application/json",
+			body:        `{"Information": "Thank you for using Alpha Vantage! Our standard API rate limit is 25 requests per day."}`,
+			wantErr:     ErrRateLimited,
+			wantInError: "25 requests per day",
+		},
+		{
+			name:        "unknown JSON shape",
+			contentType: "application/json",
+			body:        `{"unexpected": true}`,
+			wantErr:     ErrUpstream,
+			wantInError: `{\"unexpected\": true}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := createTestClient()
+			setAPIURL(client, server.URL+"/query")
+
+			_, err := client.GetStockData(context.Background(), "MSFT", 2, PeriodDaily, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if !strings.Contains(err.Error(), tt.wantInError) {
+				t.Errorf("expected the error to contain %q, got %q", tt.wantInError, err)
+			}
+		})
+	}
+}
+
+func TestBodySnippet(t *testing.T) {
+	if got := bodySnippet([]byte("  short \n")); got != "short" {
+		t.Errorf("expected the trimmed body, got %q", got)
+	}
+
+	long := strings.Repeat("a", maxBodySnippet-1) + "é" + strings.Repeat("b", 100)
+	got := bodySnippet([]byte(long))
+	if !strings.HasSuffix(got, "...") || len(got) > maxBodySnippet+len("...") {
+		t.Errorf("expected a truncated snippet, got %d bytes", len(got))
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("expected truncation not to split a character, got %q", got[len(got)-5:])
+	}
+}
+
+func TestGetStockDataOversizedResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		// A proxy error page padded far past the limit
+		w.Write([]byte(`{"Error Message": "` + strings.Repeat("x", 4096) + `"}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient()
+	setAPIURL(client, server.URL)
+	client.providers[0].(*AlphaVantageProvider).maxBodyBytes = 1024
+
+	_, err := client.GetStockData(context.Background(), "IBM", 7, PeriodDaily, nil)
+	if !errors.Is(err, ErrResponseTooLarge) || !errors.Is(err, ErrUpstream) {
+		t.Errorf("expected ErrResponseTooLarge wrapping ErrUpstream, got %v", err)
+	}
+}
+
+func TestReadBody(t *testing.T) {
+	body, err := readBody(strings.NewReader("12345"), 5)
+	if err != nil || string(body) != "12345" {
+		t.Errorf("expected a body at the limit to be read, got %q, %v", body, err)
+	}
+	if _, err := readBody(strings.NewReader("123456"), 5); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("expected ErrResponseTooLarge, got %v", err)
+	}
+}
+
+func TestIsJSONContentType(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"":                                true,
+		"application/json":                true,
+		"application/json; charset=utf-8": true,
+		"application/problem+json":        true,
+		"text/plain; charset=utf-8":       true,
+		"text/html":                       false,
+		"text/csv":                        false,
+		"not a media type;;":              false,
+	} {
+		if got := isJSONContentType(contentType); got != want {
+			t.Errorf("isJSONContentType(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	for _, input := range []string{"1min", "5min", "15min", "30min", "60min"} {
+		got, err := ParseInterval(input)
+		if err != nil {
+			t.Errorf("ParseInterval(%q): unexpected error: %v", input, err)
+		}
+		if !got.Intraday() {
+			t.Errorf("ParseInterval(%q) = %q, expected an intraday period", input, got)
+		}
+	}
+
+	for _, input := range []string{"", "2min", "daily"} {
+		if _, err := ParseInterval(input); err == nil {
+			t.Errorf("ParseInterval(%q): expected error", input)
+		}
+	}
+	if PeriodDaily.Intraday() {
+		t.Error("expected daily not to be intraday")
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := map[string]Period{
+		"":        PeriodDaily,
+		"daily":   PeriodDaily,
+		"weekly":  PeriodWeekly,
+		"monthly": PeriodMonthly,
+	}
+	for input, want := range tests {
+		got, err := ParsePeriod(input)
+		if err != nil {
+			t.Errorf("ParsePeriod(%q): unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Errorf("ParsePeriod(%q) = %q, want %q", input, got, want)
+		}
+	}
+
+	if _, err := ParsePeriod("hourly"); err == nil {
+		t.Error("expected error for unsupported period")
+	}
+}
+
+func TestGetStockDataErrorClassification(t *testing.T) {
+	tests := map[string]struct {
+		handler http.HandlerFunc
+		want    error
+	}{
+		"non-200": {
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusBadGateway) },
+			want:    ErrUpstream,
+		},
+		"error message": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"Error Message": "Invalid API call. Please retry or visit the documentation (https://www.alphavantage.co/documentation/) for TIME_SERIES_DAILY."}`))
+			},
+			want: ErrSymbolNotFound,
+		},
+		"throttled": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"Note": "Thank you for using Alpha Vantage! Our standard API call frequency is 5 calls per minute and 500 calls per day."}`))
+			},
+			want: ErrRateLimited,
+		},
+	}
+
+	for name, tt := range tests {
+		server := httptest.NewServer(tt.handler)
+
+		client := createTestClient()
+		setAPIURL(client, server.URL + "/query")
+
+		_, err := client.GetStockData(context.Background(), "NOPE", 3, PeriodDaily, nil)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", name, tt.want, err)
+		}
+
+		server.Close()
+	}
+}
+
+func TestGetStockDataRateLimitedCountsAsFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Note": "Our standard API call frequency is 5 calls per minute."}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient()
+	setAPIURL(client, server.URL + "/query")
+
+	if _, err := client.GetStockData(context.Background(), "MSFT", 3, PeriodDaily, nil); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	if _, failures, _ := client.circuitBreaker.GetMetrics(); failures != 1 {
+		t.Errorf("expected 1 breaker failure, got %d", failures)
+	}
+}
+
+func TestGetStockDataFullOutputSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		points := compactOutputSize
+		if r.URL.Query().Get("outputsize") == OutputSizeFull {
+			points = 5000
+		}
+
+		// Close price i+1 for the i-th most recent day
+		series := make(map[string]DailyData, points)
+		day := time.Date(2024, 1, 19, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < points; i++ {
+			series[day.AddDate(0, 0, -i).Format("2006-01-02")] = DailyData{Close: fmt.Sprintf("%d", i+1)}
+		}
+		json.NewEncoder(w).Encode(AlphaVantageResponse{TimeSeriesDaily: series})
+	}))
+	defer server.Close()
+
+	client := createTestClient()
+	setAPIURL(client, server.URL + "/query")
+
+	result, err := client.GetStockData(context.Background(), "MSFT", 150, PeriodDaily, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.NDays != 150 {
+		t.Fatalf("expected 150 days, got %d", result.NDays)
+	}
+
+	// Mean of 1..150
+	if expected := 75.5; math.Abs(result.Average-expected) > 0.0001 {
+		t.Errorf("expected average %.2f, got %.2f", expected, result.Average)
+	}
+}
+
+func TestOutputSizeFor(t *testing.T) {
+	tests := []struct {
+		mode  string
+		ndays int
+		want  string
+	}{
+		{OutputSizeAuto, 7, OutputSizeCompact},
+		{OutputSizeAuto, 100, OutputSizeCompact},
+		{OutputSizeAuto, 101, OutputSizeFull},
+		{OutputSizeCompact, 500, OutputSizeCompact},
+		{OutputSizeFull, 7, OutputSizeFull},
+	}
+
+	for _, tt := range tests {
+		provider := &AlphaVantageProvider{outputSize: tt.mode}
+		if got := provider.outputSizeFor(tt.ndays); got != tt.want {
+			t.Errorf("outputSizeFor(%d) in %s mode = %s, want %s", tt.ndays, tt.mode, got, tt.want)
+		}
+	}
+}
+
+type fakeProvider struct {
+	name  string
+	data  *StockData
+	err   error
+	calls int
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+
+func (p *fakeProvider) FetchDailySeries(ctx context.Context, symbol string, ndays int, period Period) (*StockData, error) {
+	p.calls++
+	return p.data, p.err
+}
+
+func TestGetStockDataDegradedMode(t *testing.T) {
+	provider := &fakeProvider{name: "primary", data: &StockData{Symbol: "AAPL", NDays: 7}}
+	client := NewClient(ClientOptions{
+		Providers:      []StockProvider{provider},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(5, 1, time.Minute),
+	})
+
+	if _, err := client.GetStockData(context.Background(), "AAPL", 7, PeriodDaily, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client.SetDegraded(true)
+
+	data, err := client.GetStockData(context.Background(), "AAPL", 7, PeriodDaily, nil)
+	if err != nil || data.Symbol != "AAPL" || data.Source != SourceCache {
+		t.Errorf("expected a cache hit in degraded mode, got %+v, %v", data, err)
+	}
+
+	if _, err := client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil); !errors.Is(err, ErrDegradedModeMiss) {
+		t.Errorf("expected ErrDegradedModeMiss for a miss, got %v", err)
+	}
+	if provider.calls != 1 {
+		t.Errorf("expected no upstream calls in degraded mode, got %d calls in total", provider.calls)
+	}
+
+	client.SetDegraded(false)
+	if _, err := client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil); err != nil {
+		t.Errorf("expected misses to be fetched again once degraded mode is off, got %v", err)
+	}
+}
+
+func TestGetStockDataBlockedSymbols(t *testing.T) {
+	provider := &fakeProvider{name: "primary", data: &StockData{Symbol: "AAPL", NDays: 7}}
+	stockCache := cache.NewCache(time.Minute)
+	client := NewClient(ClientOptions{
+		Providers:      []StockProvider{provider},
+		Cache:          stockCache,
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(5, 1, time.Minute),
+		BlockedSymbols: []string{" tsla ", "BRK.B"},
+	})
+
+	for _, symbol := range []string{"TSLA", "tsla", " Tsla", "brk.b"} {
+		if _, err := client.GetStockData(context.Background(), symbol, 7, PeriodDaily, nil); !errors.Is(err, ErrSymbolBlocked) {
+			t.Errorf("%q: expected ErrSymbolBlocked, got %v", symbol, err)
+		}
+	}
+	if _, err := client.GetQuote(context.Background(), "tsla"); !errors.Is(err, ErrSymbolBlocked) {
+		t.Errorf("expected ErrSymbolBlocked for a quote, got %v", err)
+	}
+	if provider.calls != 0 {
+		t.Errorf("expected no upstream calls for blocked symbols, got %d", provider.calls)
+	}
+	if stats := stockCache.Stats(); stats.Hits+stats.Misses != 0 {
+		t.Errorf("expected the cache not to be consulted, got %+v", stats)
+	}
+
+	if _, err := client.GetStockData(context.Background(), "aapl", 7, PeriodDaily, nil); err != nil {
+		t.Errorf("expected an allowed symbol to be served, got %v", err)
+	}
+	if provider.calls != 1 {
+		t.Errorf("expected 1 upstream call for an allowed symbol, got %d", provider.calls)
+	}
+}
+
+func TestGetStockDataDegradedModeStale(t *testing.T) {
+	provider := &countingProvider{}
+	client := createTestClient()
+	client.providers = []StockProvider{provider}
+	memoryCache := cache.NewCache(50 * time.Millisecond)
+	memoryCache.SetStaleTTL(time.Minute)
+	client.cache = memoryCache
+
+	if _, err := client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(75 * time.Millisecond)
+	client.SetDegraded(true)
+
+	result, err := client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	if err != nil || !result.Stale {
+		t.Fatalf("expected stale data in degraded mode, got %+v, %v", result, err)
+	}
+	time.Sleep(25 * time.Millisecond)
+	if calls := provider.callCount(); calls != 1 {
+		t.Errorf("expected no background refresh in degraded mode, got %d calls", calls)
+	}
+}
+
+// concurrencyProvider records the peak number of concurrent fetches,
+// holding each one until release is closed.
+type concurrencyProvider struct {
+	release chan struct{}
+	current atomic.Int32
+	peak    atomic.Int32
+}
+
+func (p *concurrencyProvider) Name() string { return "concurrency" }
+
+func (p *concurrencyProvider) FetchDailySeries(ctx context.Context, symbol string, ndays int, period Period) (*StockData, error) {
+	n := p.current.Add(1)
+	defer p.current.Add(-1)
+	for {
+		peak := p.peak.Load()
+		if n <= peak || p.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	<-p.release
+	return &StockData{Symbol: symbol, NDays: ndays}, nil
+}
+
+func TestGetStockDataLimitsConcurrentUpstreamCalls(t *testing.T) {
+	provider := &concurrencyProvider{release: make(chan struct{})}
+	client := NewClient(ClientOptions{
+		Providers:             []StockProvider{provider},
+		Cache:                 cache.NewCache(time.Minute),
+		CircuitBreaker:        circuitbreaker.NewCircuitBreaker(5, 10, 30*time.Second),
+		MaxConcurrentUpstream: 3,
+	})
+
+	// Distinct symbols so the calls aren't coalesced
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.GetStockData(context.Background(), fmt.Sprintf("SYM%d", i), 1, PeriodDaily, nil); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	// Let the burst pile up against the limit before releasing it
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(client.upstreamInFlight) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := testutil.ToFloat64(client.upstreamInFlight); got != 3 {
+		t.Errorf("expected 3 upstream calls in flight, got %v", got)
+	}
+	close(provider.release)
+	wg.Wait()
+
+	if peak := provider.peak.Load(); peak > 3 {
+		t.Errorf("expected at most 3 concurrent upstream calls, got %d", peak)
+	}
+	if got := testutil.ToFloat64(client.upstreamInFlight); got != 0 {
+		t.Errorf("expected no upstream calls in flight after the burst, got %v", got)
+	}
+}
+
+func TestGetStockDataUpstreamWaitHonoursContext(t *testing.T) {
+	provider := &concurrencyProvider{release: make(chan struct{})}
+	defer close(provider.release)
+	client := NewClient(ClientOptions{
+		Providers:             []StockProvider{provider},
+		Cache:                 cache.NewCache(time.Minute),
+		CircuitBreaker:        circuitbreaker.NewCircuitBreaker(5, 10, 30*time.Second),
+		MaxConcurrentUpstream: 1,
+	})
+
+	go client.GetStockData(context.Background(), "AAPL", 1, PeriodDaily, nil)
+	for testutil.ToFloat64(client.upstreamInFlight) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.fetchStockData(ctx, "MSFT", 1, PeriodDaily, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the wait for a slot to end with the context, got %v", err)
+	}
+}
+
+func TestGetStockDataProviderFailover(t *testing.T) {
+	primary := &fakeProvider{name: "primary", err: fmt.Errorf("%w: rate limited", ErrUpstream)}
+	secondary := &fakeProvider{name: "secondary", data: &StockData{Symbol: "MSFT", NDays: 1}}
+
+	client := createTestClient()
+	client.providers = []StockProvider{primary, secondary}
+
+	result, err := client.GetStockData(context.Background(), "MSFT", 1, PeriodDaily, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Source != "secondary" {
+		t.Errorf("expected result from the secondary provider, got %q", result.Source)
+	}
+	if primary.calls != 1 || secondary.calls != 1 {
+		t.Errorf("expected each provider to be called once, got %d and %d", primary.calls, secondary.calls)
+	}
+}
+
+func TestGetStockDataAllProvidersFail(t *testing.T) {
+	primary := &fakeProvider{name: "primary", err: fmt.Errorf("%w: status 500", ErrUpstream)}
+	secondary := &fakeProvider{name: "secondary", err: fmt.Errorf("%w: unknown symbol", ErrSymbolNotFound)}
+
+	client := createTestClient()
+	client.providers = []StockProvider{primary, secondary}
+
+	_, err := client.GetStockData(context.Background(), "MSFT", 1, PeriodDaily, nil)
+	if !errors.Is(err, ErrUpstream) || !errors.Is(err, ErrSymbolNotFound) {
+		t.Errorf("expected both provider errors to be reported, got %v", err)
+	}
+}
+
+func TestGetStockDataNegativeCaching(t *testing.T) {
+	provider := &fakeProvider{name: "primary", err: fmt.Errorf("%w: unknown symbol", ErrSymbolNotFound)}
+
+	client := createTestClient()
+	client.providers = []StockProvider{provider}
+
+	for i := 0; i < 3; i++ {
+		_, err := client.GetStockData(context.Background(), "NOPE", 7, PeriodDaily, nil)
+		if !errors.Is(err, ErrSymbolNotFound) {
+			t.Fatalf("request %d: expected ErrSymbolNotFound, got %v", i, err)
+		}
+	}
+	if provider.calls != 1 {
+		t.Errorf("expected a single upstream call for an unknown symbol, got %d", provider.calls)
+	}
+
+	// The symbol is unknown regardless of the requested window
+	client.GetStockData(context.Background(), "NOPE", 30, PeriodWeekly, nil)
+	if provider.calls != 1 {
+		t.Errorf("expected negative cache to apply to every window, got %d calls", provider.calls)
+	}
+}
+
+func TestGetStockDataTransientErrorsNotNegativelyCached(t *testing.T) {
+	tests := []struct {
+		name      string
+		providers []*fakeProvider
+	}{
+		{
+			name:      "upstream error",
+			providers: []*fakeProvider{{name: "primary", err: fmt.Errorf("%w: status 500", ErrUpstream)}},
+		},
+		{
+			name: "mixed provider errors",
+			providers: []*fakeProvider{
+				{name: "primary", err: fmt.Errorf("%w: status 500", ErrUpstream)},
+				{name: "secondary", err: fmt.Errorf("%w: unknown symbol", ErrSymbolNotFound)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := createTestClient()
+			client.providers = nil
+			for _, p := range tt.providers {
+				client.providers = append(client.providers, p)
+			}
+
+			client.GetStockData(context.Background(), "MSFT", 1, PeriodDaily, nil)
+			client.GetStockData(context.Background(), "MSFT", 1, PeriodDaily, nil)
+
+			if tt.providers[0].calls != 2 {
+				t.Errorf("expected transient failure to be retried, got %d calls", tt.providers[0].calls)
+			}
+		})
+	}
+}
+
+func TestGetStockDataSourceAndFetchedAt(t *testing.T) {
+	provider := &fakeProvider{name: "primary", data: &StockData{Symbol: "MSFT", NDays: 7}}
+	client := createTestClient()
+	client.providers = []StockProvider{provider}
+	now := time.Date(2024, 1, 9, 18, 0, 0, 0, time.UTC)
+	client.now = func() time.Time { return now }
+
+	fresh, err := client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fresh.Source != "primary" {
+		t.Errorf("expected source primary, got %q", fresh.Source)
+	}
+	if !fresh.FetchedAt.Equal(now) {
+		t.Errorf("expected fetchedAt %v from the client's clock, got %v", now, fresh.FetchedAt)
+	}
+	if !client.LastSuccessAt().Equal(now) {
+		t.Errorf("expected last success %v from the client's clock, got %v", now, client.LastSuccessAt())
+	}
+	if provider.data.Source != "" {
+		t.Error("expected the provider's value not to be modified")
+	}
+
+	now = now.Add(time.Minute)
+	cached, err := client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cached.Source != SourceCache {
+		t.Errorf("expected source %q, got %q", SourceCache, cached.Source)
+	}
+	if !cached.FetchedAt.Equal(fresh.FetchedAt) {
+		t.Errorf("expected fetchedAt of the original fetch %v, got %v", fresh.FetchedAt, cached.FetchedAt)
+	}
+
+	body, err := json.Marshal(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"source":"cache"`) || !strings.Contains(string(body), `"fetchedAt":"`+fresh.FetchedAt.Format(time.RFC3339Nano)+`"`) {
+		t.Errorf("expected source and RFC 3339 fetchedAt in %s", body)
+	}
+}
+
+func TestGetStockDataDetectsWrongCachedType(t *testing.T) {
+	provider := &fakeProvider{name: "primary", data: &StockData{Symbol: "MSFT", NDays: 7}}
+
+	core, logs := observer.New(zap.ErrorLevel)
+	client := createTestClient()
+	client.logger = zap.New(core)
+	client.providers = []StockProvider{provider}
+	client.cache.Set("MSFT_7_daily", "not stock data")
+
+	result, err := client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Source != "primary" {
+		t.Errorf("expected the mistyped entry to be refetched, got source %q", result.Source)
+	}
+
+	entries := logs.FilterMessage("unexpected type in stock cache").All()
+	if len(entries) != 1 {
+		t.Fatalf("expected the mistyped entry to be logged once, got %d", len(entries))
+	}
+	if typ := entries[0].ContextMap()["type"]; typ != "string" {
+		t.Errorf("expected logged type string, got %v", typ)
+	}
+
+	// The refetched value replaced the bad entry
+	if cached, _ := client.cache.Get("MSFT_7_daily"); cached.(*StockData).Source != "primary" {
+		t.Errorf("expected cache to hold the refetched data, got %v", cached)
+	}
+}
+
+func TestGetStockDataStaleWhileRevalidate(t *testing.T) {
+	provider := &countingProvider{}
+	client := createTestClient()
+	client.providers = []StockProvider{provider}
+	memoryCache := cache.NewCache(50 * time.Millisecond)
+	memoryCache.SetStaleTTL(time.Minute)
+	client.cache = memoryCache
+
+	if _, err := client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(75 * time.Millisecond)
+
+	result, err := client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Stale {
+		t.Error("expected expired data to be served flagged as stale")
+	}
+
+	// The background refresh replaces the entry with fresh data
+	deadline := time.Now().Add(time.Second)
+	for provider.callCount() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	result, err = client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Stale {
+		t.Error("expected fresh data after the background refresh")
+	}
+}
+
+func TestGetStockDataServesStaleWhenRefreshFails(t *testing.T) {
+	provider := &fakeProvider{name: "primary", data: &StockData{Symbol: "MSFT", NDays: 7}}
+	client := createTestClient()
+	client.providers = []StockProvider{provider}
+	memoryCache := cache.NewCache(50 * time.Millisecond)
+	memoryCache.SetStaleTTL(time.Minute)
+	client.cache = memoryCache
+
+	client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	time.Sleep(75 * time.Millisecond)
+
+	provider.data = nil
+	provider.err = fmt.Errorf("%w: status 503", ErrUpstream)
+
+	for i := 0; i < 3; i++ {
+		result, err := client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+		if err != nil || !result.Stale {
+			t.Fatalf("request %d: expected stale data while upstream fails, got %v, %v", i, result, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGetStockDataSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	provider := &fakeProvider{name: "primary", data: &StockData{Symbol: "MSFT", NDays: 1}}
+	client := createTestClient()
+	client.providers = []StockProvider{provider}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetStockData(context.Background(), "MSFT", 1, PeriodDaily, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	var requests []map[attribute.Key]attribute.Value
+	names := make(map[string]int)
+	for _, span := range recorder.Ended() {
+		names[span.Name()]++
+		if span.Name() == "circuitbreaker.Call" {
+			for _, kv := range span.Attributes() {
+				if kv.Key == "circuit_breaker.state" && kv.Value.AsString() != "closed" {
+					t.Errorf("expected breaker state closed, got %s", kv.Value.AsString())
+				}
+			}
+		}
+		if span.Name() != "stock.GetStockData" {
+			continue
+		}
+		attrs := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes() {
+			attrs[kv.Key] = kv.Value
+		}
+		requests = append(requests, attrs)
+	}
+
+	if names["stock.GetStockData"] != 2 || names["cache.Get"] != 2 || names["circuitbreaker.Call"] != 1 {
+		t.Errorf("unexpected spans %v", names)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 GetStockData spans, got %d", len(requests))
+	}
+	if requests[0]["stock.symbol"].AsString() != "MSFT" || requests[0]["stock.ndays"].AsInt64() != 1 {
+		t.Errorf("expected symbol and ndays attributes, got %v", requests[0])
+	}
+	if requests[0]["cache.hit"].AsBool() || !requests[1]["cache.hit"].AsBool() {
+		t.Errorf("expected a miss then a hit, got %v and %v", requests[0]["cache.hit"], requests[1]["cache.hit"])
+	}
+}
+
+// closeCountingCache counts Close calls on the cache it wraps.
+type closeCountingCache struct {
+	cache.Cache
+	closes int
+}
+
+func (c *closeCountingCache) Close() error {
+	c.closes++
+	return c.Cache.Close()
+}
+
+// idleConnProvider counts CloseIdleConnections calls.
+type idleConnProvider struct {
+	fakeProvider
+	idleCloses int
+}
+
+func (p *idleConnProvider) CloseIdleConnections() { p.idleCloses++ }
+
+func TestClientClose(t *testing.T) {
+	stockCache := &closeCountingCache{Cache: cache.NewCache(time.Minute)}
+	fxCache := &closeCountingCache{Cache: cache.NewCache(time.Minute)}
+	provider := &idleConnProvider{fakeProvider: fakeProvider{name: "idle"}}
+	client := NewClient(ClientOptions{
+		Providers:      []StockProvider{provider},
+		Cache:          stockCache,
+		FXCache:        fxCache,
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(5, 10, 30*time.Second),
+	})
+
+	for i := 0; i < 3; i++ {
+		if err := client.Close(); err != nil {
+			t.Fatalf("Close %d returned %v", i, err)
+		}
+	}
+
+	if stockCache.closes != 1 || fxCache.closes != 1 {
+		t.Errorf("expected each cache closed once, got %d and %d", stockCache.closes, fxCache.closes)
+	}
+	if provider.idleCloses != 1 {
+		t.Errorf("expected idle connections closed once, got %d", provider.idleCloses)
+	}
+}
+
+func TestClientCloseReturnsCacheError(t *testing.T) {
+	closeErr := errors.New("close failed")
+	client := NewClient(ClientOptions{
+		Providers:      []StockProvider{&fakeProvider{name: "fake"}},
+		Cache:          failingCloseCache{Cache: cache.NewCache(time.Minute), err: closeErr},
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(5, 10, 30*time.Second),
+	})
+
+	if err := client.Close(); !errors.Is(err, closeErr) {
+		t.Fatalf("expected the cache's error, got %v", err)
+	}
+	if err := client.Close(); !errors.Is(err, closeErr) {
+		t.Errorf("expected a repeated Close to return the same error, got %v", err)
+	}
+}
+
+// failingCloseCache is a cache whose Close fails with err.
+type failingCloseCache struct {
+	cache.Cache
+	err error
+}
+
+func (c failingCloseCache) Close() error {
+	c.Cache.Close()
+	return c.err
+}
+
+// slowProvider answers after delay unless ctx ends first.
+type slowProvider struct {
+	delay time.Duration
+}
+
+func (p *slowProvider) Name() string { return "slow" }
+
+func (p *slowProvider) FetchDailySeries(ctx context.Context, symbol string, ndays int, period Period) (*StockData, error) {
+	select {
+	case <-time.After(p.delay):
+		return &StockData{Symbol: symbol, NDays: ndays}, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %w", ErrUpstream, ctx.Err())
+	}
+}
+
+func TestGetStockDataCallTimeout(t *testing.T) {
+	newClient := func() *Client {
+		return NewClient(ClientOptions{
+			Providers:      []StockProvider{&slowProvider{delay: 100 * time.Millisecond}},
+			Cache:          cache.NewCache(time.Minute),
+			CircuitBreaker: circuitbreaker.NewCircuitBreaker(5, 1, time.Minute),
+		})
+	}
+
+	start := time.Now()
+	ctx := WithCallTimeout(context.Background(), 10*time.Millisecond)
+	if _, err := newClient().GetStockData(ctx, "MSFT", 7, PeriodDaily, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the call timeout to expire, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Errorf("expected the call to give up before the provider answered, took %s", elapsed)
+	}
+
+	// Without a call timeout the slow provider is waited for
+	if _, err := newClient().GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil); err != nil {
+		t.Errorf("expected the call without a timeout to succeed, got %v", err)
+	}
+}
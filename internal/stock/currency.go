@@ -0,0 +1,95 @@
+package stock
+
+import (
+	"context"
+	"fmt"
+)
+
+// BaseCurrency is the currency providers quote prices in.
+const BaseCurrency = "USD"
+
+// ExchangeRateProvider is implemented by providers that can quote currency
+// exchange rates.
+type ExchangeRateProvider interface {
+	// FetchExchangeRate returns how many units of to one unit of from buys.
+	FetchExchangeRate(ctx context.Context, from, to string) (float64, error)
+}
+
+// GetExchangeRate returns the rate converting BaseCurrency into currency,
+// asking the first provider that supports exchange rates. Rates are cached
+// separately from stock data and don't go through the circuit breaker, so
+// a failed lookup never trips it for price requests.
+func (c *Client) GetExchangeRate(ctx context.Context, currency string) (float64, error) {
+	if currency == BaseCurrency {
+		return 1, nil
+	}
+
+	cacheKey := fmt.Sprintf("fx_%s_%s", BaseCurrency, currency)
+	if c.fxCache != nil {
+		if cached, found := c.fxCache.Get(cacheKey); found {
+			if rate, ok := cached.(float64); ok {
+				return rate, nil
+			}
+		}
+	}
+
+	var rates ExchangeRateProvider
+	for _, p := range c.providers {
+		if r, ok := p.(ExchangeRateProvider); ok {
+			rates = r
+			break
+		}
+	}
+	if rates == nil {
+		return 0, fmt.Errorf("no configured provider supports exchange rates")
+	}
+
+	if c.Degraded() {
+		return 0, fmt.Errorf("%w: exchange rate for %s", ErrDegradedModeMiss, currency)
+	}
+
+	// Share one lookup between concurrent requests for the same currency
+	res, err := c.doShared(ctx, cacheKey, func(ctx context.Context) (interface{}, error) {
+		if err := c.acquireUpstream(ctx); err != nil {
+			return nil, err
+		}
+		defer c.releaseUpstream()
+
+		rate, err := rates.FetchExchangeRate(ctx, BaseCurrency, currency)
+		if err != nil {
+			return nil, err
+		}
+		if c.fxCache != nil {
+			c.fxCache.Set(cacheKey, rate)
+		}
+		return rate, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.(float64), nil
+}
+
+// ConvertCurrency returns a copy of stockData with its prices and price
+// statistics multiplied by rate and Currency set to currency. Percentages
+// and volumes are unaffected. stockData may be shared with the cache, so
+// it is never modified.
+func ConvertCurrency(stockData *StockData, currency string, rate float64) *StockData {
+	converted := *stockData
+	converted.Currency = currency
+	converted.Prices = make([]PricePoint, len(stockData.Prices))
+	for i, p := range stockData.Prices {
+		p.Open *= rate
+		p.High *= rate
+		p.Low *= rate
+		p.Close *= rate
+		p.AdjustedClose *= rate
+		converted.Prices[i] = p
+	}
+	converted.Average *= rate
+	converted.Change *= rate
+	converted.Volatility *= rate
+	converted.Min *= rate
+	converted.Max *= rate
+	return &converted
+}
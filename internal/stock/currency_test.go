@@ -0,0 +1,159 @@
+package stock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/cache"
+)
+
+func TestConvertCurrency(t *testing.T) {
+	original := &StockData{
+		Symbol:        "AAPL",
+		Prices:        []PricePoint{{Date: "2024-01-02", Open: 10, High: 12, Low: 9, Close: 11, Volume: 100}},
+		Average:       11,
+		Change:        2,
+		ChangePercent: 20,
+		Volatility:    1,
+		Min:           9,
+		Max:           12,
+		Currency:      BaseCurrency,
+	}
+
+	converted := ConvertCurrency(original, "EUR", 0.5)
+
+	if converted.Currency != "EUR" {
+		t.Errorf("expected currency EUR, got %s", converted.Currency)
+	}
+	p := converted.Prices[0]
+	if p.Open != 5 || p.High != 6 || p.Low != 4.5 || p.Close != 5.5 || p.Volume != 100 {
+		t.Errorf("unexpected converted price point %+v", p)
+	}
+	if converted.Average != 5.5 || converted.Min != 4.5 || converted.Max != 6 || converted.Change != 1 || converted.Volatility != 0.5 {
+		t.Errorf("unexpected converted statistics %+v", converted)
+	}
+	if converted.ChangePercent != 20 {
+		t.Errorf("expected percentage change to be unaffected, got %v", converted.ChangePercent)
+	}
+
+	if original.Currency != BaseCurrency || original.Prices[0].Close != 11 || original.Average != 11 {
+		t.Error("expected the original data to be left untouched")
+	}
+}
+
+func TestGetExchangeRate(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if got := r.URL.Query().Get("function"); got != "CURRENCY_EXCHANGE_RATE" {
+			t.Errorf("expected CURRENCY_EXCHANGE_RATE, got %s", got)
+		}
+		if from, to := r.URL.Query().Get("from_currency"), r.URL.Query().Get("to_currency"); from != "USD" || to != "EUR" {
+			t.Errorf("expected USD to EUR, got %s to %s", from, to)
+		}
+		w.Write([]byte(`{"Realtime Currency Exchange Rate": {"1. From_Currency Code": "USD", "3. To_Currency Code": "EUR", "5. Exchange Rate": "0.92000000"}}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient()
+	client.fxCache = cache.NewCache(time.Minute)
+	setAPIURL(client, server.URL)
+
+	for i := 0; i < 2; i++ {
+		rate, err := client.GetExchangeRate(context.Background(), "EUR")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if rate != 0.92 {
+			t.Errorf("expected rate 0.92, got %v", rate)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the rate to be cached after one call, got %d calls", calls)
+	}
+
+	if rate, err := client.GetExchangeRate(context.Background(), BaseCurrency); err != nil || rate != 1 {
+		t.Errorf("expected rate 1 for the base currency, got %v (%v)", rate, err)
+	}
+}
+
+func TestGetExchangeRateLeaderCancelKeepsSharedFetch(t *testing.T) {
+	provider := &gatedProvider{started: make(chan struct{}, 1), release: make(chan struct{})}
+	client := NewClient(ClientOptions{
+		Providers: []StockProvider{provider},
+		Cache:     cache.NewCache(time.Minute),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := client.GetExchangeRate(ctx, "EUR")
+		first <- err
+	}()
+	<-provider.started
+
+	second := make(chan error, 1)
+	go func() {
+		rate, err := client.GetExchangeRate(context.Background(), "EUR")
+		if err == nil && rate != 0.9 {
+			err = fmt.Errorf("unexpected rate %v", rate)
+		}
+		second <- err
+	}()
+	// Give the second request time to join the lookup
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the first request to be cancelled, got %v", err)
+	}
+	close(provider.release)
+	if err := <-second; err != nil {
+		t.Errorf("expected the coalesced request to succeed, got %v", err)
+	}
+	if got := provider.calls.Load(); got != 1 {
+		t.Errorf("expected 1 upstream call, got %d", got)
+	}
+}
+
+func TestGetExchangeRateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr error
+	}{
+		{"invalid currency", `{"Error Message": "Invalid API call."}`, ErrUpstream},
+		{"throttled", `{"Note": "Thank you for using Alpha Vantage!"}`, ErrRateLimited},
+		{"missing rate", `{}`, ErrUpstream},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := createTestClient()
+			setAPIURL(client, server.URL)
+
+			if _, err := client.GetExchangeRate(context.Background(), "XYZ"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestGetExchangeRateUnsupported(t *testing.T) {
+	client := createTestClient()
+	client.providers = []StockProvider{&fakeProvider{name: "primary"}}
+
+	if _, err := client.GetExchangeRate(context.Background(), "EUR"); err == nil {
+		t.Error("expected an error when no provider supports exchange rates")
+	}
+}
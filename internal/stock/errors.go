@@ -0,0 +1,43 @@
+package stock
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUpstream wraps failures talking to the data provider: transport
+	// errors, non-200 responses and unusable payloads.
+	ErrUpstream = errors.New("upstream error")
+
+	// ErrSymbolNotFound is returned when the provider rejects the symbol.
+	ErrSymbolNotFound = errors.New("symbol not found")
+
+	// ErrResponseTooLarge is returned when a provider response is larger
+	// than the configured limit, e.g. an error page injected by a proxy.
+	// It wraps ErrUpstream.
+	ErrResponseTooLarge = fmt.Errorf("%w: response body too large", ErrUpstream)
+
+	// ErrNotEntitled is returned when the API key isn't allowed to use the
+	// requested data, e.g. a premium-only series on a free key.
+	ErrNotEntitled = errors.New("not entitled")
+
+	// ErrPremiumRequired is returned when the provider answers a free API
+	// key with a premium-endpoint notice. It wraps ErrNotEntitled.
+	ErrPremiumRequired = fmt.Errorf("premium API key required: %w", ErrNotEntitled)
+
+	// ErrDemoKey is returned when Alpha Vantage's demo API key is used for
+	// a symbol it doesn't serve.
+	ErrDemoKey = errors.New("demo API key only supports IBM; set APIKEY")
+
+	// ErrDegradedModeMiss is returned for data that isn't cached while the
+	// client is in degraded mode and makes no upstream calls.
+	ErrDegradedModeMiss = errors.New("degraded mode: data not cached")
+
+	// ErrRateLimited is returned when the provider throttled the request.
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrSymbolBlocked is returned for symbols the service is configured
+	// never to serve.
+	ErrSymbolBlocked = errors.New("symbol blocked")
+)
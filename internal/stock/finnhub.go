@@ -0,0 +1,208 @@
+package stock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/tracing"
+	"go.uber.org/zap"
+)
+
+// ProviderFinnhub is the name of the Finnhub provider.
+const ProviderFinnhub = "finnhub"
+
+// FinnhubCandleResponse is the /stock/candle payload. Each slice holds one
+// value per candle, aligned by index.
+type FinnhubCandleResponse struct {
+	Close     []float64 `json:"c"`
+	High      []float64 `json:"h"`
+	Low       []float64 `json:"l"`
+	Open      []float64 `json:"o"`
+	Volume    []float64 `json:"v"`
+	Timestamp []int64   `json:"t"`
+	Status    string    `json:"s"`
+	Error     string    `json:"error"`
+}
+
+// FinnhubProvider fetches candles from the Finnhub API.
+type FinnhubProvider struct {
+	httpClient   *http.Client
+	apiKey       string
+	apiURL       string
+	maxBodyBytes int64
+	logger       *zap.Logger
+	now          func() time.Time
+}
+
+// NewFinnhubProvider returns a provider that sends requests through
+// transport, or http.DefaultTransport when it is nil. Responses larger
+// than maxBodyBytes are rejected; zero means DefaultMaxBodyBytes.
+func NewFinnhubProvider(apiKey string, timeout time.Duration, maxBodyBytes int64, transport http.RoundTripper, logger *zap.Logger) *FinnhubProvider {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = DefaultMaxBodyBytes
+	}
+	return &FinnhubProvider{
+		httpClient: &http.Client{
+			Timeout:   timeout,
+			Transport: tracing.Transport(transport),
+		},
+		apiKey:       apiKey,
+		apiURL:       "https://finnhub.io/api/v1/stock/candle",
+		maxBodyBytes: maxBodyBytes,
+		logger:       logger,
+		now:          time.Now,
+	}
+}
+
+// CloseIdleConnections closes connections left open by earlier requests.
+func (p *FinnhubProvider) CloseIdleConnections() {
+	p.httpClient.CloseIdleConnections()
+}
+
+func (p *FinnhubProvider) Name() string {
+	return ProviderFinnhub
+}
+
+func (p *FinnhubProvider) FetchDailySeries(ctx context.Context, symbol string, ndays int, period Period) (*StockData, error) {
+	resolution, span := finnhubResolution(period)
+
+	// Ask for a generous range so weekends and holidays still leave ndays
+	// candles; processTimeSeries truncates to the latest ndays.
+	to := p.now()
+	from := to.Add(-time.Duration(ndays+10) * 2 * span)
+	if weekend := to.Add(-4 * 24 * time.Hour); period.Intraday() && from.After(weekend) {
+		// Reach back past a weekend so there are candles outside trading hours
+		from = weekend
+	}
+
+	query := url.Values{}
+	query.Set("symbol", symbol)
+	query.Set("resolution", resolution)
+	query.Set("from", strconv.FormatInt(from.Unix(), 10))
+	query.Set("to", strconv.FormatInt(to.Unix(), 10))
+
+	p.logger.Info("calling Finnhub API", zap.String("symbol", symbol), zap.String("resolution", resolution))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.apiURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Finnhub request: %w", err)
+	}
+	req.Header.Set("X-Finnhub-Token", p.apiKey)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		p.logger.Error("failed to call Finnhub API", zap.Error(err))
+		return nil, fmt.Errorf("%w: failed to call Finnhub API: %w", ErrUpstream, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := readBody(resp.Body, p.maxBodyBytes)
+	if err != nil {
+		p.logger.Error("failed to read response body", zap.Error(err))
+		return nil, err
+	}
+
+	var candles FinnhubCandleResponse
+	if err := json.Unmarshal(body, &candles); err != nil {
+		p.logger.Error("failed to unmarshal response", zap.Error(err), zap.Int("status", resp.StatusCode))
+		return nil, fmt.Errorf("%w: Finnhub API returned status %d", ErrUpstream, resp.StatusCode)
+	}
+
+	// Finnhub reports auth, entitlement and throttling problems as
+	// {"error": "..."}, usually with a 4xx status.
+	if resp.StatusCode == http.StatusTooManyRequests {
+		p.logger.Warn("Finnhub API rate limited", zap.String("error", candles.Error))
+		return nil, fmt.Errorf("%w: Finnhub API: %s", ErrRateLimited, candles.Error)
+	}
+	// A bad key or one without access to the symbol or resolution is an
+	// answer about the request, not an upstream failure
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		p.logger.Warn("Finnhub API access denied", zap.String("error", candles.Error), zap.Int("status", resp.StatusCode))
+		return nil, fmt.Errorf("%w: Finnhub API: %s", ErrNotEntitled, candles.Error)
+	}
+	if candles.Error != "" {
+		p.logger.Error("Finnhub API error", zap.String("error", candles.Error), zap.Int("status", resp.StatusCode))
+		return nil, fmt.Errorf("%w: Finnhub API error: %s", ErrUpstream, candles.Error)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		p.logger.Error("Finnhub API returned non-200 status", zap.Int("status", resp.StatusCode))
+		return nil, fmt.Errorf("%w: Finnhub API returned status %d", ErrUpstream, resp.StatusCode)
+	}
+
+	if candles.Status == "no_data" {
+		return nil, fmt.Errorf("%w: Finnhub returned no data for %s", ErrSymbolNotFound, symbol)
+	}
+
+	layout := dateLayout
+	if period.Intraday() {
+		layout = dateTimeLayout
+	}
+	timeSeries := candles.timeSeries(layout)
+	if len(timeSeries) == 0 {
+		p.logger.Error("no candle data returned")
+		return nil, fmt.Errorf("%w: no candle data returned", ErrUpstream)
+	}
+
+	return processTimeSeries(p.logger, symbol, ndays, timeSeries)
+}
+
+// timeSeries converts the parallel candle arrays into the date-keyed form
+// used by processTimeSeries, formatting each timestamp with layout.
+func (r *FinnhubCandleResponse) timeSeries(layout string) map[string]DailyData {
+	timeSeries := make(map[string]DailyData, len(r.Timestamp))
+	for i, ts := range r.Timestamp {
+		if i >= len(r.Close) {
+			break
+		}
+
+		date := time.Unix(ts, 0).UTC().Format(layout)
+		timeSeries[date] = DailyData{
+			Open:   formatCandleValue(r.Open, i),
+			High:   formatCandleValue(r.High, i),
+			Low:    formatCandleValue(r.Low, i),
+			Close:  strconv.FormatFloat(r.Close[i], 'f', -1, 64),
+			Volume: formatCandleVolume(r.Volume, i),
+		}
+	}
+	return timeSeries
+}
+
+func formatCandleValue(values []float64, i int) string {
+	if i >= len(values) {
+		return ""
+	}
+	return strconv.FormatFloat(values[i], 'f', -1, 64)
+}
+
+func formatCandleVolume(values []float64, i int) string {
+	if i >= len(values) {
+		return ""
+	}
+	return strconv.FormatInt(int64(values[i]), 10)
+}
+
+// finnhubResolution returns the candle resolution for period and the
+// approximate duration of one candle.
+func finnhubResolution(period Period) (string, time.Duration) {
+	switch period {
+	case PeriodWeekly:
+		return "W", 7 * 24 * time.Hour
+	case PeriodMonthly:
+		return "M", 31 * 24 * time.Hour
+	case PeriodIntraday1Min, PeriodIntraday5Min, PeriodIntraday15Min, PeriodIntraday30Min, PeriodIntraday60Min:
+		minutes, _ := strconv.Atoi(strings.TrimSuffix(string(period), "min"))
+		return strconv.Itoa(minutes), time.Duration(minutes) * time.Minute
+	default:
+		return "D", 24 * time.Hour
+	}
+}
@@ -0,0 +1,113 @@
+package stock
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func newTestFinnhubProvider(url string) *FinnhubProvider {
+	provider := NewFinnhubProvider("test-token", 10*time.Second, 0, nil, zap.NewNop())
+	provider.apiURL = url
+	provider.now = func() time.Time { return time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC) }
+	return provider
+}
+
+func TestFinnhubFetchDailySeries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.Header.Get("X-Finnhub-Token"); token != "test-token" {
+			t.Errorf("expected token header test-token, got %q", token)
+		}
+		if res := r.URL.Query().Get("resolution"); res != "D" {
+			t.Errorf("expected resolution D, got %s", res)
+		}
+		if sym := r.URL.Query().Get("symbol"); sym != "MSFT" {
+			t.Errorf("expected symbol MSFT, got %s", sym)
+		}
+
+		// 2024-01-17, 2024-01-18 and 2024-01-19 at 00:00 UTC
+		w.Write([]byte(`{
+			"c": [412.89, 420.12, 416.85],
+			"h": [415.00, 421.00, 418.20],
+			"l": [410.00, 414.00, 414.10],
+			"o": [411.00, 415.50, 415.00],
+			"v": [1000, 2000, 3000],
+			"t": [1705449600, 1705536000, 1705622400],
+			"s": "ok"
+		}`))
+	}))
+	defer server.Close()
+
+	result, err := newTestFinnhubProvider(server.URL).FetchDailySeries(context.Background(), "MSFT", 2, PeriodDaily)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result.NDays != 2 {
+		t.Fatalf("expected 2 days, got %d", result.NDays)
+	}
+	latest := result.Prices[0]
+	if latest.Date != "2024-01-19" || latest.Close != 416.85 || latest.Open != 415.00 || latest.Volume != 3000 {
+		t.Errorf("unexpected latest candle %+v", latest)
+	}
+
+	expectedAverage := (416.85 + 420.12) / 2
+	if math.Abs(result.Average-expectedAverage) > 0.01 {
+		t.Errorf("expected average %.2f, got %.2f", expectedAverage, result.Average)
+	}
+}
+
+func TestFinnhubErrors(t *testing.T) {
+	tests := map[string]struct {
+		status int
+		body   string
+		want   error
+	}{
+		"error payload": {http.StatusInternalServerError, `{"error": "Internal error."}`, ErrUpstream},
+		"forbidden":     {http.StatusForbidden, `{"error": "You don't have access to this resource."}`, ErrNotEntitled},
+		"unauthorized":  {http.StatusUnauthorized, `{"error": "Invalid API key."}`, ErrNotEntitled},
+		"rate limited":  {http.StatusTooManyRequests, `{"error": "API limit reached. Please try again later."}`, ErrRateLimited},
+		"no data":       {http.StatusOK, `{"s": "no_data"}`, ErrSymbolNotFound},
+		"non-json":      {http.StatusBadGateway, `<html>bad gateway</html>`, ErrUpstream},
+	}
+
+	for name, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		}))
+
+		_, err := newTestFinnhubProvider(server.URL).FetchDailySeries(context.Background(), "MSFT", 2, PeriodDaily)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", name, tt.want, err)
+		}
+
+		server.Close()
+	}
+}
+
+func TestFinnhubResolution(t *testing.T) {
+	tests := []struct {
+		period     Period
+		resolution string
+		span       time.Duration
+	}{
+		{PeriodDaily, "D", 24 * time.Hour},
+		{PeriodWeekly, "W", 7 * 24 * time.Hour},
+		{PeriodIntraday1Min, "1", time.Minute},
+		{PeriodIntraday60Min, "60", time.Hour},
+	}
+
+	for _, tt := range tests {
+		resolution, span := finnhubResolution(tt.period)
+		if resolution != tt.resolution || span != tt.span {
+			t.Errorf("finnhubResolution(%q) = %s, %s; want %s, %s", tt.period, resolution, span, tt.resolution, tt.span)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package stock
+
+import "fmt"
+
+// Supported moving-average indicators.
+const (
+	IndicatorSMA = "sma"
+	IndicatorEMA = "ema"
+)
+
+// IndicatorPoint is an indicator value for the window ending on Date.
+type IndicatorPoint struct {
+	Date  string  `json:"date"`
+	Value float64 `json:"value"`
+}
+
+// ComputeIndicator dispatches to SMA or EMA by name.
+func ComputeIndicator(indicator string, prices []PricePoint, window int) ([]IndicatorPoint, error) {
+	switch indicator {
+	case IndicatorSMA:
+		return SMA(prices, window), nil
+	case IndicatorEMA:
+		return EMA(prices, window), nil
+	default:
+		return nil, fmt.Errorf("invalid indicator %q: must be sma or ema", indicator)
+	}
+}
+
+// SMA returns the simple moving average of closing prices over window.
+// prices are newest first, as in StockData, and so is the result; it has
+// len(prices)-window+1 points, or none if there is not enough data.
+func SMA(prices []PricePoint, window int) []IndicatorPoint {
+	if window < 1 || window > len(prices) {
+		return nil
+	}
+
+	values := make([]IndicatorPoint, len(prices)-window+1)
+	var sum float64
+	for i := len(prices) - 1; i >= 0; i-- {
+		sum += prices[i].Close
+		if i+window < len(prices) {
+			sum -= prices[i+window].Close
+		}
+		if i <= len(prices)-window {
+			values[i] = IndicatorPoint{Date: prices[i].Date, Value: sum / float64(window)}
+		}
+	}
+	return values
+}
+
+// EMA returns the exponential moving average of closing prices with
+// smoothing factor 2/(window+1), seeded with the SMA of the oldest window
+// closes. Ordering and length match SMA.
+func EMA(prices []PricePoint, window int) []IndicatorPoint {
+	if window < 1 || window > len(prices) {
+		return nil
+	}
+
+	alpha := 2 / float64(window+1)
+	values := make([]IndicatorPoint, len(prices)-window+1)
+
+	seed := len(prices) - window
+	var ema float64
+	for i := len(prices) - 1; i >= seed; i-- {
+		ema += prices[i].Close
+	}
+	ema /= float64(window)
+	values[seed] = IndicatorPoint{Date: prices[seed].Date, Value: ema}
+
+	for i := seed - 1; i >= 0; i-- {
+		ema = alpha*prices[i].Close + (1-alpha)*ema
+		values[i] = IndicatorPoint{Date: prices[i].Date, Value: ema}
+	}
+	return values
+}
@@ -0,0 +1,64 @@
+package stock
+
+import (
+	"math"
+	"testing"
+)
+
+// newestFirst builds price points from closes given oldest first.
+func newestFirst(closes ...float64) []PricePoint {
+	prices := make([]PricePoint, len(closes))
+	for i, c := range closes {
+		prices[len(closes)-1-i] = PricePoint{Date: string(rune('a' + i)), Close: c}
+	}
+	return prices
+}
+
+func TestSMA(t *testing.T) {
+	prices := newestFirst(1, 2, 3, 4, 5)
+
+	values := SMA(prices, 3)
+	expected := []float64{4, 3, 2} // newest first
+	if len(values) != len(expected) {
+		t.Fatalf("expected %d values, got %d", len(expected), len(values))
+	}
+	for i, want := range expected {
+		if math.Abs(values[i].Value-want) > 1e-9 {
+			t.Errorf("value %d: expected %.4f, got %.4f", i, want, values[i].Value)
+		}
+	}
+	if values[0].Date != prices[0].Date {
+		t.Errorf("expected latest value dated %s, got %s", prices[0].Date, values[0].Date)
+	}
+}
+
+func TestEMA(t *testing.T) {
+	// Window 3 gives alpha = 2/(3+1) = 0.5. Seed with SMA(10, 11, 12) = 11,
+	// then EMA = 0.5*close + 0.5*previous:
+	//   13 -> 12, 12 -> 12, 15 -> 13.5
+	prices := newestFirst(10, 11, 12, 13, 12, 15)
+
+	values := EMA(prices, 3)
+	expected := []float64{13.5, 12, 12, 11}
+	if len(values) != len(expected) {
+		t.Fatalf("expected %d values, got %d", len(expected), len(values))
+	}
+	for i, want := range expected {
+		if math.Abs(values[i].Value-want) > 1e-9 {
+			t.Errorf("value %d: expected %.4f, got %.4f", i, want, values[i].Value)
+		}
+	}
+}
+
+func TestIndicatorsInsufficientData(t *testing.T) {
+	prices := newestFirst(1, 2)
+	if values := SMA(prices, 3); values != nil {
+		t.Errorf("expected no SMA values, got %v", values)
+	}
+	if values := EMA(prices, 0); values != nil {
+		t.Errorf("expected no EMA values, got %v", values)
+	}
+	if _, err := ComputeIndicator("macd", prices, 1); err == nil {
+		t.Error("expected error for unsupported indicator")
+	}
+}
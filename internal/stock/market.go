@@ -0,0 +1,29 @@
+package stock
+
+import "time"
+
+// marketLocation is the time zone the providers date daily prices in. It
+// falls back to UTC where the zone database isn't available.
+var marketLocation = loadMarketLocation()
+
+func loadMarketLocation() *time.Location {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// marketLikelyClosed reports whether latestTradingDay, a YYYY-MM-DD date,
+// is before today in the market's time zone. Holidays aren't modelled; a
+// series that stops before today, as it does over weekends and holidays
+// and before the day's first trade, is simply assumed to be closed. An
+// empty or malformed date is not flagged.
+func marketLikelyClosed(latestTradingDay string, now time.Time) bool {
+	latest, err := time.ParseInLocation(dateLayout, latestTradingDay, marketLocation)
+	if err != nil {
+		return false
+	}
+	y, m, d := now.In(marketLocation).Date()
+	return latest.Before(time.Date(y, m, d, 0, 0, 0, 0, marketLocation))
+}
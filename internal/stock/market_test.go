@@ -0,0 +1,82 @@
+package stock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/cache"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
+	"go.uber.org/zap"
+)
+
+func TestMarketLikelyClosed(t *testing.T) {
+	// Monday 2024-01-08, mid-afternoon in New York
+	now := time.Date(2024, 1, 8, 20, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		latest string
+		want   bool
+	}{
+		{"2024-01-08", false},
+		{"2024-01-05", true}, // Friday's close on a Monday
+		{"2024-01-09", false},
+		{"", false},
+		{"not a date", false},
+	}
+	for _, tt := range tests {
+		if got := marketLikelyClosed(tt.latest, now); got != tt.want {
+			t.Errorf("marketLikelyClosed(%q) = %v, want %v", tt.latest, got, tt.want)
+		}
+	}
+
+	// 01:00 UTC on Tuesday is still Monday evening in New York
+	if marketLikelyClosed("2024-01-08", time.Date(2024, 1, 9, 1, 0, 0, 0, time.UTC)) && marketLocation != time.UTC {
+		t.Error("expected today to be judged in the market's time zone")
+	}
+}
+
+func TestGetStockDataMarketLikelyClosed(t *testing.T) {
+	// The latest close is from the Friday before a Tuesday
+	series, err := processTimeSeries(zap.NewNop(), "IBM", 3, map[string]DailyData{
+		"2024-01-05": {Close: "160.00"},
+		"2024-01-04": {Close: "158.00"},
+		"2024-01-03": {Close: "157.00"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series.LatestTradingDay != "2024-01-05" {
+		t.Fatalf("expected latest trading day 2024-01-05, got %q", series.LatestTradingDay)
+	}
+
+	client := NewClient(ClientOptions{
+		Providers:      []StockProvider{&fakeProvider{name: "primary", data: series}},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(5, 1, time.Minute),
+	})
+	client.now = func() time.Time { return time.Date(2024, 1, 9, 18, 0, 0, 0, time.UTC) }
+
+	for i := 0; i < 2; i++ {
+		data, err := client.GetStockData(context.Background(), "IBM", 3, PeriodDaily, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !data.MarketLikelyClosed {
+			t.Errorf("call %d: expected data several days old to be flagged", i)
+		}
+	}
+
+	// Served again on the day of the latest close, it's current
+	client.now = func() time.Time { return time.Date(2024, 1, 5, 18, 0, 0, 0, time.UTC) }
+	data, err := client.GetStockData(context.Background(), "IBM", 3, PeriodDaily, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.MarketLikelyClosed {
+		t.Error("expected same-day data not to be flagged")
+	}
+	if series.MarketLikelyClosed {
+		t.Error("expected the cached value to be left alone")
+	}
+}
@@ -0,0 +1,206 @@
+package stock
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ProviderMock is the name of the synthetic provider used in mock mode.
+const ProviderMock = "mock"
+
+// Market hours of the synthetic intraday series, in marketLocation.
+const (
+	mockMarketOpen  = 9*time.Hour + 30*time.Minute
+	mockMarketClose = 16 * time.Hour
+)
+
+// MockProvider serves synthetic but plausible prices for any symbol, so
+// the service can run locally without an API key. Every point is derived
+// from the symbol and its date alone: the same symbol and date always get
+// the same prices, and overlapping windows agree. Weekends are skipped;
+// holidays aren't modelled.
+type MockProvider struct {
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+// NewMockProvider creates the synthetic provider used in mock mode.
+func NewMockProvider(logger *zap.Logger) *MockProvider {
+	return &MockProvider{logger: logger, now: time.Now}
+}
+
+func (p *MockProvider) Name() string {
+	return ProviderMock
+}
+
+func (p *MockProvider) FetchDailySeries(ctx context.Context, symbol string, ndays int, period Period) (*StockData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	timeSeries := make(map[string]DailyData, ndays)
+	for _, t := range p.timestamps(ndays, period) {
+		key := t.Format(dateLayout)
+		if period.Intraday() {
+			key = t.Format(dateTimeLayout)
+		}
+		point := mockPoint(symbol, key, t)
+		if period == PeriodDailyAdjusted {
+			// No splits or dividends, so nothing to adjust
+			point.AdjustedClose = point.Close
+		}
+		timeSeries[key] = point
+	}
+	return processTimeSeries(p.logger, symbol, ndays, timeSeries)
+}
+
+// FetchQuote returns the latest synthetic close, with the change from the
+// trading day before.
+func (p *MockProvider) FetchQuote(ctx context.Context, symbol string) (*Quote, error) {
+	data, err := p.FetchDailySeries(ctx, symbol, 2, PeriodDaily)
+	if err != nil {
+		return nil, err
+	}
+	latest, previous := data.Prices[0], data.Prices[len(data.Prices)-1]
+	quote := &Quote{
+		Symbol:           symbol,
+		Price:            latest.Close,
+		Change:           latest.Close - previous.Close,
+		Volume:           latest.Volume,
+		LatestTradingDay: latest.Date,
+	}
+	if previous.Close != 0 {
+		quote.ChangePercent = quote.Change / previous.Close * 100
+	}
+	return quote, nil
+}
+
+// FetchExchangeRate returns a fixed synthetic rate. Each currency gets a
+// value in US dollars, so a rate and its inverse agree.
+func (p *MockProvider) FetchExchangeRate(ctx context.Context, from, to string) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return mockDollarValue(from) / mockDollarValue(to), nil
+}
+
+// timestamps returns the times of the latest n points of period, newest
+// first.
+func (p *MockProvider) timestamps(n int, period Period) []time.Time {
+	now := p.now().In(marketLocation)
+	latest := mockLatestTradingDay(now)
+
+	times := make([]time.Time, 0, n)
+	switch {
+	case period.Intraday():
+		minutes, _ := strconv.Atoi(strings.TrimSuffix(string(period), "min"))
+		step := time.Duration(minutes) * time.Minute
+		for day := latest; len(times) < n; day = previousWeekday(day) {
+			for t := day.Add(mockMarketClose - step); t.Sub(day) >= mockMarketOpen && len(times) < n; t = t.Add(-step) {
+				times = append(times, t)
+			}
+		}
+	case period == PeriodWeekly:
+		// The current week is keyed by its latest trading day, earlier
+		// weeks by their Friday
+		for day := latest; len(times) < n; {
+			times = append(times, day)
+			day = day.AddDate(0, 0, -int((day.Weekday()-time.Friday+7)%7))
+			if day.Equal(times[len(times)-1]) {
+				day = day.AddDate(0, 0, -7)
+			}
+		}
+	case period == PeriodMonthly:
+		// Months are keyed by their last trading day
+		for day := latest; len(times) < n; {
+			times = append(times, day)
+			day = time.Date(day.Year(), day.Month(), 0, 0, 0, 0, 0, marketLocation)
+			for day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+				day = day.AddDate(0, 0, -1)
+			}
+		}
+	default:
+		for day := latest; len(times) < n; day = previousWeekday(day) {
+			times = append(times, day)
+		}
+	}
+	return times
+}
+
+// mockLatestTradingDay is midnight on the latest weekday whose session has
+// closed by now.
+func mockLatestTradingDay(now time.Time) time.Time {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, marketLocation)
+	if now.Sub(day) < mockMarketClose {
+		day = day.AddDate(0, 0, -1)
+	}
+	for day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		day = day.AddDate(0, 0, -1)
+	}
+	return day
+}
+
+// previousWeekday is midnight on the weekday before day.
+func previousWeekday(day time.Time) time.Time {
+	day = day.AddDate(0, 0, -1)
+	for day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		day = day.AddDate(0, 0, -1)
+	}
+	return day
+}
+
+// mockPoint derives a point from symbol and key: a base price chosen by
+// the symbol, a slow cycle over time and a little noise from the key.
+func mockPoint(symbol, key string, t time.Time) DailyData {
+	base := 20 + 480*mockUnit(symbol, "base")
+	phase := 2 * math.Pi * mockUnit(symbol, "phase")
+	days := float64(t.Unix()) / (24 * 60 * 60)
+	trend := 1 + 0.15*math.Sin(2*math.Pi*days/180+phase)
+
+	closePrice := base * trend * (1 + 0.02*mockNoise(symbol, key, "close"))
+	openPrice := closePrice * (1 + 0.01*mockNoise(symbol, key, "open"))
+	high := math.Max(openPrice, closePrice) * (1 + 0.01*mockUnit(symbol, key, "high"))
+	low := math.Min(openPrice, closePrice) * (1 - 0.01*mockUnit(symbol, key, "low"))
+	volume := 500_000 + int64(9_500_000*mockUnit(symbol, key, "volume"))
+
+	return DailyData{
+		Open:   formatMockPrice(openPrice),
+		High:   formatMockPrice(high),
+		Low:    formatMockPrice(low),
+		Close:  formatMockPrice(closePrice),
+		Volume: strconv.FormatInt(volume, 10),
+	}
+}
+
+// mockDollarValue is a currency's synthetic value in US dollars.
+func mockDollarValue(currency string) float64 {
+	if strings.EqualFold(currency, "USD") {
+		return 1
+	}
+	return 0.01 + 1.5*mockUnit(strings.ToUpper(currency), "fx")
+}
+
+// mockUnit hashes parts to a value in [0, 1).
+func mockUnit(parts ...string) float64 {
+	h := fnv.New64a()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// mockNoise hashes parts to a value in [-1, 1).
+func mockNoise(parts ...string) float64 {
+	return 2*mockUnit(parts...) - 1
+}
+
+func formatMockPrice(price float64) string {
+	return strconv.FormatFloat(price, 'f', 4, 64)
+}
@@ -0,0 +1,134 @@
+package stock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestMockProvider returns a mock provider fixed at Wednesday
+// 2024-01-10, after the close in New York.
+func newTestMockProvider() *MockProvider {
+	p := NewMockProvider(zap.NewNop())
+	p.now = func() time.Time { return time.Date(2024, 1, 10, 22, 0, 0, 0, time.UTC) }
+	return p
+}
+
+func TestMockProviderDeterministic(t *testing.T) {
+	ctx := context.Background()
+	first, err := newTestMockProvider().FetchDailySeries(ctx, "MSFT", 10, PeriodDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newTestMockProvider().FetchDailySeries(ctx, "MSFT", 5, PeriodDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(first.Prices) != 10 || len(second.Prices) != 5 {
+		t.Fatalf("expected 10 and 5 prices, got %d and %d", len(first.Prices), len(second.Prices))
+	}
+	// Overlapping windows agree point for point
+	for i, p := range second.Prices {
+		if p != first.Prices[i] {
+			t.Errorf("point %d differs between requests: %+v vs %+v", i, p, first.Prices[i])
+		}
+	}
+
+	other, err := newTestMockProvider().FetchDailySeries(ctx, "AAPL", 10, PeriodDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Prices[0].Close == first.Prices[0].Close {
+		t.Error("expected different symbols to get different prices")
+	}
+}
+
+func TestMockProviderDailySeries(t *testing.T) {
+	data, err := newTestMockProvider().FetchDailySeries(context.Background(), "IBM", 7, PeriodDailyAdjusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"2024-01-10", "2024-01-09", "2024-01-08", "2024-01-05", "2024-01-04", "2024-01-03", "2024-01-02"}
+	for i, p := range data.Prices {
+		if p.Date != want[i] {
+			t.Errorf("point %d: expected %s, got %s", i, want[i], p.Date)
+		}
+		if p.Close <= 0 || p.Low > p.Open || p.Low > p.Close || p.High < p.Open || p.High < p.Close || p.Volume <= 0 {
+			t.Errorf("point %d: implausible prices %+v", i, p)
+		}
+		if p.AdjustedClose != p.Close {
+			t.Errorf("point %d: expected the adjusted close to equal the close, got %+v", i, p)
+		}
+	}
+	if data.LatestTradingDay != "2024-01-10" {
+		t.Errorf("expected latest trading day 2024-01-10, got %s", data.LatestTradingDay)
+	}
+}
+
+func TestMockProviderPeriods(t *testing.T) {
+	tests := []struct {
+		period Period
+		want   []string
+	}{
+		{PeriodWeekly, []string{"2024-01-10", "2024-01-05", "2023-12-29"}},
+		{PeriodMonthly, []string{"2024-01-10", "2023-12-29", "2023-11-30"}},
+		{PeriodIntraday30Min, []string{"2024-01-10 15:30:00", "2024-01-10 15:00:00", "2024-01-10 14:30:00"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.period), func(t *testing.T) {
+			if marketLocation == time.UTC && tt.period.Intraday() {
+				t.Skip("time zone database unavailable")
+			}
+			data, err := newTestMockProvider().FetchDailySeries(context.Background(), "IBM", len(tt.want), tt.period)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, p := range data.Prices {
+				if p.Date != tt.want[i] {
+					t.Errorf("point %d: expected %s, got %s", i, tt.want[i], p.Date)
+				}
+			}
+		})
+	}
+}
+
+func TestMockProviderIntradaySpansDays(t *testing.T) {
+	// 13 half-hour bars a session, so 15 reach into the day before
+	data, err := newTestMockProvider().FetchDailySeries(context.Background(), "IBM", 15, PeriodIntraday30Min)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Prices) != 15 {
+		t.Fatalf("expected 15 bars, got %d", len(data.Prices))
+	}
+	if got := data.Prices[13].Date; got != "2024-01-09 15:30:00" {
+		t.Errorf("expected the 14th bar to be the previous day's last, got %s", got)
+	}
+}
+
+func TestMockProviderQuoteAndExchangeRate(t *testing.T) {
+	ctx := context.Background()
+	p := newTestMockProvider()
+
+	quote, err := p.FetchQuote(ctx, "IBM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	series, _ := p.FetchDailySeries(ctx, "IBM", 2, PeriodDaily)
+	if quote.Price != series.Prices[0].Close || quote.LatestTradingDay != "2024-01-10" {
+		t.Errorf("expected the quote to match the latest close, got %+v", quote)
+	}
+
+	rate, err := p.FetchExchangeRate(ctx, "USD", "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inverse, _ := p.FetchExchangeRate(ctx, "EUR", "USD")
+	if rate <= 0 || rate*inverse < 0.999999 || rate*inverse > 1.000001 {
+		t.Errorf("expected consistent rates, got %g and %g", rate, inverse)
+	}
+}
@@ -0,0 +1,85 @@
+package stock
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxTrackedSymbols bounds how many symbols the client remembers request
+// counts for, so scans over random symbols can't grow it without limit.
+const maxTrackedSymbols = 1000
+
+// SymbolStats is how often a symbol has been requested.
+type SymbolStats struct {
+	Symbol     string    `json:"symbol"`
+	Requests   int64     `json:"requests"`
+	LastAccess time.Time `json:"lastAccess"`
+}
+
+// symbolTracker counts requests per symbol in an LRU of at most capacity
+// symbols. The least recently requested symbol is forgotten first.
+type symbolTracker struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // of *SymbolStats, most recent first
+	entries  map[string]*list.Element
+}
+
+func newSymbolTracker(capacity int) *symbolTracker {
+	return &symbolTracker{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (t *symbolTracker) record(symbol string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if elem, ok := t.entries[symbol]; ok {
+		stats := elem.Value.(*SymbolStats)
+		stats.Requests++
+		stats.LastAccess = now
+		t.order.MoveToFront(elem)
+		return
+	}
+
+	if t.order.Len() >= t.capacity {
+		oldest := t.order.Back()
+		t.order.Remove(oldest)
+		delete(t.entries, oldest.Value.(*SymbolStats).Symbol)
+	}
+	t.entries[symbol] = t.order.PushFront(&SymbolStats{Symbol: symbol, Requests: 1, LastAccess: now})
+}
+
+// top returns up to limit symbols by request count, most recently
+// requested first among equal counts.
+func (t *symbolTracker) top(limit int) []SymbolStats {
+	t.mu.Lock()
+	stats := make([]SymbolStats, 0, t.order.Len())
+	for elem := t.order.Front(); elem != nil; elem = elem.Next() {
+		stats = append(stats, *elem.Value.(*SymbolStats))
+	}
+	t.mu.Unlock()
+
+	// The list is in recency order, so a stable sort keeps ties recent first
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].Requests > stats[j].Requests
+	})
+	if len(stats) > limit {
+		stats = stats[:limit]
+	}
+	return stats
+}
+
+// TopSymbols returns up to limit of the most requested symbols. Only the
+// most recently requested maxTrackedSymbols symbols are counted.
+func (c *Client) TopSymbols(limit int) []SymbolStats {
+	if c.symbols == nil {
+		return []SymbolStats{}
+	}
+	return c.symbols.top(limit)
+}
@@ -0,0 +1,58 @@
+package stock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSymbolTrackerTop(t *testing.T) {
+	tracker := newSymbolTracker(10)
+	now := time.Now()
+
+	tracker.record("AAPL", now)
+	tracker.record("MSFT", now.Add(time.Second))
+	tracker.record("MSFT", now.Add(2*time.Second))
+	tracker.record("GOOG", now.Add(3*time.Second))
+
+	top := tracker.top(10)
+	if len(top) != 3 {
+		t.Fatalf("expected 3 symbols, got %d", len(top))
+	}
+	// MSFT has the most requests; GOOG and AAPL tie, GOOG more recently
+	if top[0].Symbol != "MSFT" || top[1].Symbol != "GOOG" || top[2].Symbol != "AAPL" {
+		t.Errorf("unexpected order %+v", top)
+	}
+	if top[0].Requests != 2 || !top[0].LastAccess.Equal(now.Add(2*time.Second)) {
+		t.Errorf("unexpected MSFT stats %+v", top[0])
+	}
+
+	if limited := tracker.top(1); len(limited) != 1 || limited[0].Symbol != "MSFT" {
+		t.Errorf("expected only MSFT with limit 1, got %+v", limited)
+	}
+}
+
+func TestSymbolTrackerEvictsLeastRecent(t *testing.T) {
+	tracker := newSymbolTracker(2)
+	now := time.Now()
+
+	tracker.record("AAPL", now)
+	tracker.record("MSFT", now)
+	tracker.record("AAPL", now)
+	tracker.record("GOOG", now)
+
+	top := tracker.top(10)
+	if len(top) != 2 {
+		t.Fatalf("expected the tracker to stay at 2 symbols, got %+v", top)
+	}
+	for _, s := range top {
+		if s.Symbol == "MSFT" {
+			t.Errorf("expected MSFT to be evicted, got %+v", top)
+		}
+	}
+}
+
+func TestTopSymbolsWithoutTracker(t *testing.T) {
+	if top := (&Client{}).TopSymbols(5); top == nil || len(top) != 0 {
+		t.Errorf("expected an empty list, got %#v", top)
+	}
+}
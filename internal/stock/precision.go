@@ -0,0 +1,37 @@
+package stock
+
+import "math"
+
+// RoundPrices returns a copy of stockData with its prices, price
+// statistics and ChangePercent rounded to decimals places. Volumes are
+// unaffected. stockData may be shared with the cache, so it is never
+// modified.
+func RoundPrices(stockData *StockData, decimals int) *StockData {
+	round := func(v float64) float64 {
+		return roundTo(v, decimals)
+	}
+
+	rounded := *stockData
+	rounded.Prices = make([]PricePoint, len(stockData.Prices))
+	for i, p := range stockData.Prices {
+		p.Open = round(p.Open)
+		p.High = round(p.High)
+		p.Low = round(p.Low)
+		p.Close = round(p.Close)
+		p.AdjustedClose = round(p.AdjustedClose)
+		rounded.Prices[i] = p
+	}
+	rounded.Average = round(rounded.Average)
+	rounded.Change = round(rounded.Change)
+	rounded.ChangePercent = round(rounded.ChangePercent)
+	rounded.Volatility = round(rounded.Volatility)
+	rounded.Min = round(rounded.Min)
+	rounded.Max = round(rounded.Max)
+	return &rounded
+}
+
+// roundTo rounds v half away from zero to decimals places.
+func roundTo(v float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(v*scale) / scale
+}
@@ -0,0 +1,67 @@
+package stock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/cache"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
+	"go.uber.org/zap"
+)
+
+func TestRoundPrices(t *testing.T) {
+	data := &StockData{
+		Prices:        []PricePoint{{Date: "2024-01-10", Open: 416.8500000000001, High: 417.129, Low: 415.995, Close: 416.85, AdjustedClose: 416.8449, Volume: 1234}},
+		Average:       416.8500000000001,
+		Change:        -1.23456,
+		ChangePercent: 0.29615,
+		Volatility:    0.4999,
+		Min:           415.001,
+		Max:           418.999,
+	}
+
+	rounded := RoundPrices(data, 2)
+	want := PricePoint{Date: "2024-01-10", Open: 416.85, High: 417.13, Low: 416, Close: 416.85, AdjustedClose: 416.84, Volume: 1234}
+	if rounded.Prices[0] != want {
+		t.Errorf("expected %+v, got %+v", want, rounded.Prices[0])
+	}
+	if rounded.Average != 416.85 || rounded.Change != -1.23 || rounded.ChangePercent != 0.3 || rounded.Volatility != 0.5 || rounded.Min != 415 || rounded.Max != 419 {
+		t.Errorf("unexpected statistics %+v", rounded)
+	}
+	if data.Prices[0].High != 417.129 || data.Average != 416.8500000000001 {
+		t.Error("expected the original data to be left unrounded")
+	}
+}
+
+func TestGetStockDataPricePrecision(t *testing.T) {
+	// Rounding each close first would average to 1.00
+	series, err := processTimeSeries(zap.NewNop(), "IBM", 3, map[string]DailyData{
+		"2024-01-10": {Close: "1.0149"},
+		"2024-01-09": {Close: "1.0049"},
+		"2024-01-08": {Close: "1.0049"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(ClientOptions{
+		Providers:      []StockProvider{&fakeProvider{name: "primary", data: series}},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(5, 1, time.Minute),
+		PricePrecision: 2,
+	})
+
+	data, err := client.GetStockData(context.Background(), "IBM", 3, PeriodDaily, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Prices[0].Close != 1.01 || data.Prices[1].Close != 1 {
+		t.Errorf("expected closes rounded to 2 places, got %+v", data.Prices)
+	}
+	if data.Average != 1.01 {
+		t.Errorf("expected the average of the unrounded closes, 1.01, got %v", data.Average)
+	}
+	if series.Prices[0].Close != 1.0149 {
+		t.Error("expected the cached data to stay unrounded")
+	}
+}
@@ -0,0 +1,13 @@
+package stock
+
+import "context"
+
+// StockProvider is a source of historical price data.
+type StockProvider interface {
+	// Name identifies the provider in logs and metrics.
+	Name() string
+
+	// FetchDailySeries returns the latest ndays points of symbol's series
+	// aggregated by period.
+	FetchDailySeries(ctx context.Context, symbol string, ndays int, period Period) (*StockData, error)
+}
@@ -0,0 +1,86 @@
+package stock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/ticker"
+)
+
+// Quote is a symbol's latest price, a lighter alternative to a full
+// series for current-price displays.
+type Quote struct {
+	Symbol        string  `json:"symbol"`
+	Price         float64 `json:"price"`
+	Change        float64 `json:"change"`
+	ChangePercent float64 `json:"changePercent"`
+	Volume        int64   `json:"volume"`
+	// LatestTradingDay is the date, YYYY-MM-DD, the price was traded on.
+	LatestTradingDay string `json:"latestTradingDay"`
+}
+
+// QuoteProvider is implemented by providers that can return a latest quote
+// without fetching a series.
+type QuoteProvider interface {
+	// FetchQuote returns symbol's latest quote.
+	FetchQuote(ctx context.Context, symbol string) (*Quote, error)
+}
+
+// GetQuote returns symbol's latest quote, asking the first provider that
+// supports quotes. Quotes are cached in their own short-lived cache,
+// separately from stock data, and fetched through the circuit breaker.
+func (c *Client) GetQuote(ctx context.Context, symbol string) (*Quote, error) {
+	symbol = ticker.Normalize(symbol)
+	if err := c.checkBlocked(symbol); err != nil {
+		return nil, err
+	}
+	cacheKey := "quote_" + symbol
+	if c.quoteCache != nil {
+		if cached, found := c.quoteCache.Get(cacheKey); found {
+			if quote, ok := cached.(*Quote); ok {
+				return quote, nil
+			}
+		}
+	}
+
+	var quotes QuoteProvider
+	for _, p := range c.providers {
+		if q, ok := p.(QuoteProvider); ok {
+			quotes = q
+			break
+		}
+	}
+	if quotes == nil {
+		return nil, fmt.Errorf("no configured provider supports quotes")
+	}
+
+	if c.Degraded() {
+		return nil, fmt.Errorf("%w: quote for %s", ErrDegradedModeMiss, symbol)
+	}
+
+	// Share one lookup between concurrent requests for the same symbol
+	res, err := c.doShared(ctx, cacheKey, func(ctx context.Context) (interface{}, error) {
+		if err := c.acquireUpstream(ctx); err != nil {
+			return nil, err
+		}
+		defer c.releaseUpstream()
+
+		var quote *Quote
+		err := c.breakerCall(ctx, func() error {
+			var err error
+			quote, err = quotes.FetchQuote(ctx, symbol)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if c.quoteCache != nil {
+			c.quoteCache.Set(cacheKey, quote)
+		}
+		return quote, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*Quote), nil
+}
@@ -0,0 +1,140 @@
+package stock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/cache"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
+)
+
+func TestGetQuote(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if got := r.URL.Query().Get("function"); got != "GLOBAL_QUOTE" {
+			t.Errorf("expected GLOBAL_QUOTE, got %s", got)
+		}
+		w.Write([]byte(`{
+    "Global Quote": {
+        "01. symbol": "IBM",
+        "02. open": "167.5000",
+        "03. high": "168.9900",
+        "04. low": "166.2000",
+        "05. price": "167.1500",
+        "06. volume": "3512384",
+        "07. latest trading day": "2024-05-10",
+        "08. previous close": "166.2700",
+        "09. change": "0.8800",
+        "10. change percent": "0.5293%"
+    }
+}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient()
+	client.quoteCache = cache.NewCache(time.Minute)
+	setAPIURL(client, server.URL)
+
+	for i := 0; i < 2; i++ {
+		quote, err := client.GetQuote(context.Background(), "IBM")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := Quote{Symbol: "IBM", Price: 167.15, Change: 0.88, ChangePercent: 0.5293, Volume: 3512384, LatestTradingDay: "2024-05-10"}
+		if *quote != want {
+			t.Errorf("expected %+v, got %+v", want, *quote)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the quote to be cached after one call, got %d calls", calls)
+	}
+	if client.cache.Len() != 0 {
+		t.Error("expected quotes to stay out of the series cache")
+	}
+}
+
+func TestGetQuoteLeaderCancelKeepsSharedFetch(t *testing.T) {
+	provider := &gatedProvider{started: make(chan struct{}, 1), release: make(chan struct{})}
+	client := NewClient(ClientOptions{
+		Providers:      []StockProvider{provider},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(1, 1, time.Minute),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := client.GetQuote(ctx, "IBM")
+		first <- err
+	}()
+	<-provider.started
+
+	second := make(chan error, 1)
+	go func() {
+		quote, err := client.GetQuote(context.Background(), "IBM")
+		if err == nil && quote.Symbol != "IBM" {
+			err = fmt.Errorf("unexpected quote %+v", quote)
+		}
+		second <- err
+	}()
+	// Give the second request time to join the lookup
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the first request to be cancelled, got %v", err)
+	}
+	close(provider.release)
+	if err := <-second; err != nil {
+		t.Errorf("expected the coalesced request to succeed, got %v", err)
+	}
+	if got := provider.calls.Load(); got != 1 {
+		t.Errorf("expected 1 upstream call, got %d", got)
+	}
+}
+
+func TestGetQuoteErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr error
+	}{
+		{"empty quote", `{"Global Quote": {}}`, ErrSymbolNotFound},
+		{"invalid symbol", `{"Error Message": "Invalid API call."}`, ErrSymbolNotFound},
+		{"throttled", `{"Note": "Thank you for using Alpha Vantage!"}`, ErrRateLimited},
+		{"rate limit information", `{"Information": "Our standard API rate limit is 25 requests per day."}`, ErrRateLimited},
+		{"malformed price", `{"Global Quote": {"01. symbol": "IBM", "05. price": "n/a"}}`, ErrUpstream},
+		{"not JSON", `<html></html>`, ErrUpstream},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := createTestClient()
+			setAPIURL(client, server.URL)
+
+			if _, err := client.GetQuote(context.Background(), "IBM"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestGetQuoteUnsupported(t *testing.T) {
+	client := createTestClient()
+	client.providers = []StockProvider{&fakeProvider{name: "primary"}}
+
+	if _, err := client.GetQuote(context.Background(), "IBM"); err == nil {
+		t.Error("expected an error when no provider supports quotes")
+	}
+}
@@ -0,0 +1,156 @@
+package stock
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RefreshOptions configures the background refresher.
+type RefreshOptions struct {
+	// TTL is the lifetime of cached entries; keys are refreshed once they
+	// are within Lead of it.
+	TTL  time.Duration
+	Lead time.Duration
+	// MinHits is the number of requests a key needs since its last fetch
+	// to be considered hot.
+	MinHits int
+	// Interval is how often the refresher scans for hot keys.
+	Interval time.Duration
+}
+
+// keyActivity tracks how often a cache key is requested between fetches.
+// It is kept by the client rather than the cache so the refresher works
+// with every cache backend.
+type keyActivity struct {
+	symbol    string
+	ndays     int
+	period    Period
+	hits      int
+	fetchedAt time.Time
+}
+
+// StartRefresher re-fetches hot keys shortly before they expire so popular
+// symbols keep being served from the cache. The returned function stops
+// the refresher and waits for an in-progress refresh to finish; Close does
+// the same. Either may be called more than once.
+func (c *Client) StartRefresher(opts RefreshOptions) (stop func()) {
+	c.hotMu.Lock()
+	c.hotKeys = make(map[string]*keyActivity)
+	c.hotMu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.refreshHotKeys(ctx, opts)
+			}
+		}
+	}()
+
+	stop = sync.OnceFunc(func() {
+		cancel()
+		wg.Wait()
+	})
+	c.hotMu.Lock()
+	c.refresherStops = append(c.refresherStops, stop)
+	c.hotMu.Unlock()
+	return stop
+}
+
+// recordAccess counts a request for key and its symbol. Only keys that
+// have been cached while the refresher is running are counted, so failed
+// or unknown lookups aren't kept; symbols are always counted for
+// TopSymbols.
+func (c *Client) recordAccess(key, symbol string) {
+	if c.symbols != nil {
+		c.symbols.record(symbol, c.now())
+	}
+
+	c.hotMu.Lock()
+	defer c.hotMu.Unlock()
+
+	if activity, ok := c.hotKeys[key]; ok {
+		activity.hits++
+	}
+}
+
+// recordFetch marks key as freshly cached and starts a new hit count,
+// tracking it from now on if the refresher is running.
+func (c *Client) recordFetch(key, symbol string, ndays int, period Period) {
+	c.hotMu.Lock()
+	defer c.hotMu.Unlock()
+
+	if c.hotKeys == nil {
+		return
+	}
+	activity, ok := c.hotKeys[key]
+	if !ok {
+		activity = &keyActivity{symbol: symbol, ndays: ndays, period: period}
+		c.hotKeys[key] = activity
+	}
+	activity.hits = 0
+	activity.fetchedAt = c.now()
+}
+
+// refreshHotKeys refreshes keys that are about to expire and were requested
+// at least MinHits times since they were cached. Keys that are about to
+// expire without being hot are forgotten.
+func (c *Client) refreshHotKeys(ctx context.Context, opts RefreshOptions) {
+	due := make(map[string]keyActivity)
+
+	c.hotMu.Lock()
+	for key, activity := range c.hotKeys {
+		if c.now().Sub(activity.fetchedAt) < opts.TTL-opts.Lead {
+			continue
+		}
+		if activity.hits >= opts.MinHits {
+			due[key] = *activity
+		} else {
+			delete(c.hotKeys, key)
+		}
+	}
+	c.hotMu.Unlock()
+
+	for key, activity := range due {
+		// Leave entries to expire rather than call upstream; they're
+		// refetched once degraded mode is turned off
+		if ctx.Err() != nil || c.Degraded() {
+			return
+		}
+
+		// Sharing the singleflight key lets a concurrent cache miss wait
+		// for this refresh instead of starting another upstream call.
+		_, err, _ := c.inflight.Do(key, func() (interface{}, error) {
+			var result *StockData
+			err := c.breakerCall(ctx, func() error {
+				var err error
+				result, err = c.fetchStockData(ctx, activity.symbol, activity.ndays, activity.period, nil)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			c.cache.Set(key, result)
+			c.recordFetch(key, activity.symbol, activity.ndays, activity.period)
+			c.lastSuccess.Store(c.now().UnixNano())
+			return result, nil
+		})
+		if err != nil {
+			c.logger.Warn("background refresh failed", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		c.logger.Info("background refresh", zap.String("key", key))
+	}
+}
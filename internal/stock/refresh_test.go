@@ -0,0 +1,143 @@
+package stock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/cache"
+)
+
+// countingProvider is a fakeProvider that is safe to call from the
+// refresher goroutine.
+type countingProvider struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *countingProvider) Name() string { return "counting" }
+
+func (p *countingProvider) FetchDailySeries(ctx context.Context, symbol string, ndays int, period Period) (*StockData, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	return &StockData{Symbol: symbol, NDays: ndays}, nil
+}
+
+func (p *countingProvider) callCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func TestRefresherKeepsHotKeysWarm(t *testing.T) {
+	const ttl = 200 * time.Millisecond
+
+	provider := &countingProvider{}
+	client := createTestClient()
+	client.providers = []StockProvider{provider}
+	client.cache = cache.NewCache(ttl)
+
+	stop := client.StartRefresher(RefreshOptions{
+		TTL:      ttl,
+		Lead:     100 * time.Millisecond,
+		MinHits:  2,
+		Interval: 10 * time.Millisecond,
+	})
+	defer stop()
+
+	// MSFT is hot, AAPL is requested once and should be left to expire
+	for i := 0; i < 3; i++ {
+		client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	}
+	client.GetStockData(context.Background(), "AAPL", 7, PeriodDaily, nil)
+
+	time.Sleep(150 * time.Millisecond)
+	if calls := provider.callCount(); calls != 3 {
+		t.Fatalf("expected one background refresh on top of 2 fetches, got %d calls", calls)
+	}
+
+	// Past the original TTL the hot key is still a hit
+	time.Sleep(100 * time.Millisecond)
+	if _, found := client.cache.Get("MSFT_7_daily"); !found {
+		t.Error("expected hot key to still be cached after its original TTL")
+	}
+	if _, found := client.cache.Get("AAPL_7_daily"); found {
+		t.Error("expected cold key to expire")
+	}
+}
+
+func TestRefresherOnlyTracksCachedKeys(t *testing.T) {
+	provider := &fakeProvider{name: "fake", err: fmt.Errorf("%w: bad symbol", ErrSymbolNotFound)}
+	client := createTestClient()
+	client.providers = []StockProvider{provider}
+
+	stop := client.StartRefresher(RefreshOptions{TTL: time.Minute, Interval: time.Hour})
+	defer stop()
+
+	// A scan of symbols that don't exist leaves nothing to refresh
+	for i := 0; i < 10; i++ {
+		client.GetStockData(context.Background(), fmt.Sprintf("ZZZ%c", 'A'+i), 7, PeriodDaily, nil)
+	}
+	client.hotMu.Lock()
+	tracked := len(client.hotKeys)
+	client.hotMu.Unlock()
+	if tracked != 0 {
+		t.Errorf("expected failed lookups not to be tracked, got %d keys", tracked)
+	}
+
+	provider.err = nil
+	provider.data = &StockData{Symbol: "MSFT", NDays: 7}
+	client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	client.hotMu.Lock()
+	tracked = len(client.hotKeys)
+	client.hotMu.Unlock()
+	if tracked != 1 {
+		t.Errorf("expected the cached key to be tracked, got %d keys", tracked)
+	}
+}
+
+func TestRefresherStop(t *testing.T) {
+	provider := &countingProvider{}
+	client := createTestClient()
+	client.providers = []StockProvider{provider}
+
+	stop := client.StartRefresher(RefreshOptions{
+		TTL:      time.Millisecond,
+		MinHits:  0,
+		Interval: time.Millisecond,
+	})
+	client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	stop()
+
+	calls := provider.callCount()
+	time.Sleep(20 * time.Millisecond)
+	if provider.callCount() != calls {
+		t.Error("expected no refreshes after stop")
+	}
+}
+
+func TestClientCloseStopsRefresher(t *testing.T) {
+	provider := &countingProvider{}
+	client := createTestClient()
+	client.providers = []StockProvider{provider}
+
+	stop := client.StartRefresher(RefreshOptions{
+		TTL:      time.Millisecond,
+		MinHits:  0,
+		Interval: time.Millisecond,
+	})
+	client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	client.Close()
+
+	calls := provider.callCount()
+	time.Sleep(20 * time.Millisecond)
+	if provider.callCount() != calls {
+		t.Error("expected no refreshes after Close")
+	}
+
+	// The refresher's own stop still returns once it has been closed
+	stop()
+}
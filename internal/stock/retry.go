@@ -0,0 +1,123 @@
+package stock
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// callUpstream fetches through the circuit breaker, retrying transient
+// failures up to c.retries times. By default the breaker sees one outcome
+// per logical call, so a burst of retries against a failing upstream
+// counts as a single failure. With breakerCountsRetries every attempt is
+// its own breaker call, which opens the breaker sooner and stops the
+// remaining retries once it does.
+func (c *Client) callUpstream(ctx context.Context, symbol string, ndays int, period Period, apiDurationHist prometheus.Histogram) (*StockData, error) {
+	fetch := func() (*StockData, error) {
+		return c.fetchStockData(ctx, symbol, ndays, period, apiDurationHist)
+	}
+	throughBreaker := func(fn func() (*StockData, error)) (*StockData, error) {
+		var result *StockData
+		err := c.breakerCall(ctx, func() error {
+			var err error
+			result, err = fn()
+			return err
+		})
+		return result, err
+	}
+
+	if c.breakerCountsRetries {
+		return c.retry(ctx, symbol, func() (*StockData, error) {
+			return throughBreaker(fetch)
+		})
+	}
+	return throughBreaker(func() (*StockData, error) {
+		return c.retry(ctx, symbol, fetch)
+	})
+}
+
+// breakerCall runs fn through the circuit breaker and returns its error,
+// or ErrCircuitBreakerOpen if the breaker didn't let it run. Only errors
+// that say something about upstream health count as breaker failures.
+// Others, such as unknown symbols or callers going away, are reported to
+// the breaker as ErrNoOutcome: they neither open it for everyone nor
+// reset its failure count or close it while the upstream is down.
+func (c *Client) breakerCall(ctx context.Context, fn func() error) error {
+	var err error
+	breakerErr := c.circuitBreaker.Call(func() error {
+		err = fn()
+		if err != nil && !isUpstreamFailure(ctx, err) {
+			return circuitbreaker.ErrNoOutcome
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return breakerErr
+}
+
+// isUpstreamFailure reports whether err, returned by a call made with ctx,
+// means the upstream is unhealthy. Answers about the request itself, such
+// as an unknown symbol or a key that isn't entitled to it, come from a
+// working upstream, and a call whose context ended was given up on.
+func isUpstreamFailure(ctx context.Context, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case ctx.Err() != nil, errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, ErrNotEntitled), errors.Is(err, ErrDemoKey):
+		return false
+	case isSymbolNotFound(err):
+		return false
+	}
+	return true
+}
+
+// retry calls fn until it succeeds, fails with an error that isn't worth
+// retrying, or has been retried c.retries times. The wait before retry n
+// is c.retryBackoff doubled n-1 times.
+func (c *Client) retry(ctx context.Context, symbol string, fn func() (*StockData, error)) (*StockData, error) {
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		result, err := fn()
+		if err == nil || attempt >= c.retries || !isRetryable(err) {
+			return result, err
+		}
+
+		c.loggerFor(ctx).Warn("retrying upstream fetch",
+			zap.String("symbol", symbol),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isRetryable reports whether err is a transient upstream failure that
+// another attempt might not hit. Unknown symbols, entitlement and quota
+// errors, an open breaker and cancellation are final.
+func isRetryable(err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, circuitbreaker.ErrCircuitBreakerOpen):
+		return false
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrNotEntitled), errors.Is(err, ErrDemoKey):
+		return false
+	case isSymbolNotFound(err):
+		return false
+	}
+	return errors.Is(err, ErrUpstream)
+}
@@ -0,0 +1,227 @@
+package stock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/cache"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
+)
+
+func newRetryTestClient(provider StockProvider, cb *circuitbreaker.CircuitBreaker, countRetries bool) *Client {
+	return NewClient(ClientOptions{
+		Providers:            []StockProvider{provider},
+		Cache:                cache.NewCache(time.Minute),
+		CircuitBreaker:       cb,
+		Retries:              2,
+		RetryBackoff:         time.Millisecond,
+		BreakerCountsRetries: countRetries,
+	})
+}
+
+func TestExhaustedRetriesCountOnceTowardsBreaker(t *testing.T) {
+	provider := &fakeProvider{name: "fake", err: fmt.Errorf("%w: status 500", ErrUpstream)}
+	cb := circuitbreaker.NewCircuitBreaker(5, 1, time.Minute)
+	client := newRetryTestClient(provider, cb, false)
+
+	_, err := client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	if !errors.Is(err, ErrUpstream) {
+		t.Fatalf("expected ErrUpstream, got %v", err)
+	}
+	if provider.calls != 3 {
+		t.Errorf("expected 1 call and 2 retries, got %d calls", provider.calls)
+	}
+	if _, failures, _ := cb.GetMetrics(); failures != 1 {
+		t.Errorf("expected the breaker to record 1 failure, got %d", failures)
+	}
+}
+
+func TestBreakerCountsEachRetry(t *testing.T) {
+	provider := &fakeProvider{name: "fake", err: fmt.Errorf("%w: status 500", ErrUpstream)}
+	cb := circuitbreaker.NewCircuitBreaker(5, 1, time.Minute)
+	client := newRetryTestClient(provider, cb, true)
+
+	client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	if _, failures, _ := cb.GetMetrics(); failures != 3 {
+		t.Errorf("expected the breaker to record every attempt, got %d failures", failures)
+	}
+}
+
+func TestBreakerOpeningStopsRetries(t *testing.T) {
+	provider := &fakeProvider{name: "fake", err: fmt.Errorf("%w: status 500", ErrUpstream)}
+	cb := circuitbreaker.NewCircuitBreaker(2, 1, time.Minute)
+	client := newRetryTestClient(provider, cb, true)
+
+	_, err := client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	if !errors.Is(err, circuitbreaker.ErrCircuitBreakerOpen) {
+		t.Errorf("expected the last attempt to be turned away by the breaker, got %v", err)
+	}
+	if provider.calls != 2 {
+		t.Errorf("expected no upstream calls once the breaker opened, got %d", provider.calls)
+	}
+}
+
+func TestRetrySucceedsAfterTransientFailure(t *testing.T) {
+	provider := &flakyProvider{failures: 1}
+	cb := circuitbreaker.NewCircuitBreaker(5, 1, time.Minute)
+	client := newRetryTestClient(provider, cb, false)
+
+	data, err := client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	if err != nil || data == nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+	if _, failures, _ := cb.GetMetrics(); failures != 0 {
+		t.Errorf("expected a call that succeeded on retry not to count as a failure, got %d", failures)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("%w: status 500", ErrUpstream), true},
+		{errors.Join(fmt.Errorf("%w: timeout", ErrUpstream), fmt.Errorf("%w: bad symbol", ErrSymbolNotFound)), true},
+		{fmt.Errorf("%w: bad symbol", ErrSymbolNotFound), false},
+		{fmt.Errorf("%w: quota", ErrRateLimited), false},
+		{fmt.Errorf("%w: premium", ErrNotEntitled), false},
+		{ErrDemoKey, false},
+		{circuitbreaker.ErrCircuitBreakerOpen, false},
+		{fmt.Errorf("%w: %w", ErrUpstream, context.DeadlineExceeded), false},
+		{errors.New("unexpected"), false},
+	}
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRequestErrorsDontOpenBreaker(t *testing.T) {
+	provider := &fakeProvider{name: "fake"}
+	cb := circuitbreaker.NewCircuitBreaker(1, 1, time.Minute)
+	client := newRetryTestClient(provider, cb, false)
+
+	for i, err := range []error{
+		fmt.Errorf("%w: bad symbol", ErrSymbolNotFound),
+		fmt.Errorf("%w: bad symbol", ErrSymbolNotFound),
+		fmt.Errorf("%w: bad symbol", ErrSymbolNotFound),
+		fmt.Errorf("%w: premium", ErrNotEntitled),
+		ErrDemoKey,
+	} {
+		provider.err = err
+		symbol := fmt.Sprintf("ZZZ%c", 'A'+i)
+		if _, got := client.GetStockData(context.Background(), symbol, 7, PeriodDaily, nil); !errors.Is(got, err) {
+			t.Fatalf("%s: expected %v, got %v", symbol, err, got)
+		}
+	}
+
+	// A caller that went away says nothing about the upstream either
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	provider.err = fmt.Errorf("%w: %w", ErrUpstream, context.Canceled)
+	if _, err := client.callUpstream(ctx, "MSFT", 7, PeriodDaily, nil); err == nil {
+		t.Fatal("expected the cancelled call to fail")
+	}
+
+	if state := cb.GetState(); state != circuitbreaker.StateClosed {
+		t.Errorf("expected the breaker to stay closed, got %s", state)
+	}
+	if _, failures, _ := cb.GetMetrics(); failures != 0 {
+		t.Errorf("expected no breaker failures, got %d", failures)
+	}
+
+	// Upstream failures still count
+	provider.err = fmt.Errorf("%w: status 500", ErrUpstream)
+	client.GetStockData(context.Background(), "MSFT", 7, PeriodDaily, nil)
+	if state := cb.GetState(); state != circuitbreaker.StateOpen {
+		t.Errorf("expected an upstream failure to open the breaker, got %s", state)
+	}
+
+	t.Run("between failures", func(t *testing.T) {
+		provider := &fakeProvider{name: "fake"}
+		cb := circuitbreaker.NewCircuitBreaker(3, 1, time.Minute)
+		client := newRetryTestClient(provider, cb, false)
+
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		upstream := fmt.Errorf("%w: status 500", ErrUpstream)
+
+		// Cancellations and unknown symbols between upstream failures neither
+		// count as failures nor clear the ones already seen
+		for _, call := range []struct {
+			ctx context.Context
+			err error
+		}{
+			{context.Background(), upstream},
+			{cancelled, fmt.Errorf("%w: %w", ErrUpstream, context.Canceled)},
+			{context.Background(), upstream},
+			{context.Background(), fmt.Errorf("%w: bad symbol", ErrSymbolNotFound)},
+			{context.Background(), upstream},
+		} {
+			provider.err = call.err
+			client.callUpstream(call.ctx, "MSFT", 7, PeriodDaily, nil)
+		}
+		if state := cb.GetState(); state != circuitbreaker.StateOpen {
+			t.Errorf("expected 3 upstream failures to open the breaker, got %s", state)
+		}
+	})
+
+	t.Run("cancelled probe", func(t *testing.T) {
+		provider := &fakeProvider{name: "fake", err: fmt.Errorf("%w: status 500", ErrUpstream)}
+		clk := &breakerClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		cb := circuitbreaker.NewCircuitBreaker(1, 1, time.Minute)
+		cb.SetClock(clk)
+		client := newRetryTestClient(provider, cb, false)
+
+		client.callUpstream(context.Background(), "MSFT", 7, PeriodDaily, nil)
+		if state := cb.GetState(); state != circuitbreaker.StateOpen {
+			t.Fatalf("expected the breaker to open, got %s", state)
+		}
+		clk.now = clk.now.Add(2 * time.Minute)
+
+		// The probe's caller goes away, which says nothing about the upstream
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		provider.err = fmt.Errorf("%w: %w", ErrUpstream, context.Canceled)
+		client.callUpstream(ctx, "MSFT", 7, PeriodDaily, nil)
+		if state, _, successes := cb.GetMetrics(); state != circuitbreaker.StateHalfOpen || successes != 0 {
+			t.Fatalf("expected the breaker to stay half-open with no successes, got %s with %d", state, successes)
+		}
+
+		// The slot is free for the next probe, which finds the upstream down
+		provider.err = fmt.Errorf("%w: status 500", ErrUpstream)
+		if _, err := client.callUpstream(context.Background(), "MSFT", 7, PeriodDaily, nil); !errors.Is(err, ErrUpstream) {
+			t.Fatalf("expected the next probe to reach the upstream, got %v", err)
+		}
+		if state := cb.GetState(); state != circuitbreaker.StateOpen {
+			t.Errorf("expected the failed probe to reopen the breaker, got %s", state)
+		}
+	})
+}
+
+// breakerClock is a clock for breaker tests that only moves when advanced.
+type breakerClock struct {
+	now time.Time
+}
+
+func (c *breakerClock) Now() time.Time { return c.now }
+
+// flakyProvider fails its first failures calls with ErrUpstream.
+type flakyProvider struct {
+	failures int
+	calls    int
+}
+
+func (p *flakyProvider) Name() string { return "flaky" }
+
+func (p *flakyProvider) FetchDailySeries(ctx context.Context, symbol string, ndays int, period Period) (*StockData, error) {
+	p.calls++
+	if p.calls <= p.failures {
+		return nil, fmt.Errorf("%w: status 503", ErrUpstream)
+	}
+	return &StockData{Symbol: symbol, NDays: ndays}, nil
+}
@@ -0,0 +1,8 @@
+package stock
+
+import "github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/ticker"
+
+// ValidateSymbol checks symbol against ticker.DefaultPattern.
+func ValidateSymbol(symbol string) error {
+	return ticker.Validate(symbol)
+}
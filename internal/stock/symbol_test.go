@@ -0,0 +1,12 @@
+package stock
+
+import "testing"
+
+func TestValidateSymbol(t *testing.T) {
+	if err := ValidateSymbol("brk.b"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := ValidateSymbol("INVALID_SYMBOL_12345"); err == nil {
+		t.Error("expected error for invalid symbol")
+	}
+}
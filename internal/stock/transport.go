@@ -0,0 +1,37 @@
+package stock
+
+import (
+	"net/http"
+	"time"
+)
+
+// TransportOptions tunes the connection pool providers use to reach their
+// APIs. Zero values keep net/http's defaults.
+type TransportOptions struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	TLSHandshakeTimeout time.Duration
+}
+
+// NewTransport returns an http.Transport configured by opts, starting from
+// http.DefaultTransport's settings. Share one transport between providers
+// so keep-alive connections are reused rather than redialled per request;
+// the default of two idle connections per host churns TCP and TLS
+// connections under load.
+func NewTransport(opts TransportOptions) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.MaxIdleConns > 0 {
+		transport.MaxIdleConns = opts.MaxIdleConns
+	}
+	if opts.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	}
+	if opts.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = opts.IdleConnTimeout
+	}
+	if opts.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	}
+	return transport
+}
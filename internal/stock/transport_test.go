@@ -0,0 +1,81 @@
+package stock
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// countingRoundTripper counts requests and how many of them reused an
+// existing connection.
+type countingRoundTripper struct {
+	next     http.RoundTripper
+	requests atomic.Int32
+	reused   atomic.Int32
+}
+
+func (c *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				c.reused.Add(1)
+			}
+		},
+	}
+	return c.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+func TestNewTransportOptions(t *testing.T) {
+	transport := NewTransport(TransportOptions{
+		MaxIdleConns:        50,
+		MaxIdleConnsPerHost: 20,
+		IdleConnTimeout:     time.Minute,
+		TLSHandshakeTimeout: 3 * time.Second,
+	})
+	if transport.MaxIdleConns != 50 || transport.MaxIdleConnsPerHost != 20 ||
+		transport.IdleConnTimeout != time.Minute || transport.TLSHandshakeTimeout != 3*time.Second {
+		t.Errorf("options not applied: %+v", transport)
+	}
+
+	defaults := http.DefaultTransport.(*http.Transport)
+	zero := NewTransport(TransportOptions{})
+	if zero.MaxIdleConns != defaults.MaxIdleConns || zero.IdleConnTimeout != defaults.IdleConnTimeout ||
+		zero.TLSHandshakeTimeout != defaults.TLSHandshakeTimeout {
+		t.Errorf("expected zero options to keep the defaults, got %+v", zero)
+	}
+}
+
+func TestProvidersReuseConnections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(AlphaVantageResponse{
+			TimeSeriesDaily: map[string]DailyData{"2024-01-19": {Close: "416.85"}},
+		})
+	}))
+	defer server.Close()
+
+	counter := &countingRoundTripper{next: NewTransport(TransportOptions{MaxIdleConnsPerHost: 10})}
+	provider := NewAlphaVantageProvider("test-api-key", OutputSizeAuto, time.Second, 0, counter, zap.NewNop())
+	provider.apiURL = server.URL
+
+	const requests = 5
+	for i := 0; i < requests; i++ {
+		if _, err := provider.FetchDailySeries(context.Background(), "MSFT", 1, PeriodDaily); err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+	}
+
+	if got := counter.requests.Load(); got != requests {
+		t.Fatalf("expected %d requests through the transport, got %d", requests, got)
+	}
+	if got := counter.reused.Load(); got != requests-1 {
+		t.Errorf("expected %d requests to reuse the first connection, got %d", requests-1, got)
+	}
+}
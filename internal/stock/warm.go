@@ -0,0 +1,44 @@
+package stock
+
+import (
+	"context"
+	"sync"
+)
+
+// WarmResult is the outcome of warming one symbol; Err is nil if its data
+// is now cached.
+type WarmResult struct {
+	Symbol string
+	Err    error
+}
+
+// Warm fetches the daily series of each symbol over ndays into the cache,
+// at most parallelism at a time. Fetches go through GetStockData, so they
+// respect the circuit breaker and upstream limits like any request. Warm
+// returns when every symbol has been tried or ctx is done; symbols that
+// weren't tried by then report ctx's error. Results are in symbols order.
+func (c *Client) Warm(ctx context.Context, symbols []string, ndays, parallelism int) []WarmResult {
+	results := make([]WarmResult, len(symbols))
+	slots := make(chan struct{}, max(parallelism, 1))
+	var wg sync.WaitGroup
+
+	for i, symbol := range symbols {
+		results[i].Symbol = symbol
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			_, results[i].Err = c.GetStockData(ctx, symbol, ndays, PeriodDaily, nil)
+		}()
+	}
+
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,85 @@
+package stock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/cache"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
+)
+
+func TestWarmFillsCacheWithBoundedParallelism(t *testing.T) {
+	provider := &concurrencyProvider{release: make(chan struct{})}
+	client := NewClient(ClientOptions{
+		Providers:      []StockProvider{provider},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(5, 1, time.Minute),
+	})
+
+	symbols := []string{"AAPL", "MSFT", "GOOG", "AMZN", "IBM"}
+	done := make(chan []WarmResult)
+	go func() { done <- client.Warm(context.Background(), symbols, 7, 2) }()
+
+	// Let every fetch through once they have had a chance to pile up
+	time.Sleep(50 * time.Millisecond)
+	close(provider.release)
+	results := <-done
+
+	if peak := provider.peak.Load(); peak != 2 {
+		t.Errorf("expected at most 2 concurrent fetches, peaked at %d", peak)
+	}
+	for i, result := range results {
+		if result.Symbol != symbols[i] || result.Err != nil {
+			t.Errorf("expected %s to warm, got %+v", symbols[i], result)
+		}
+		if _, found := client.cache.Get(cacheKey(cacheParams{Symbol: symbols[i], NDays: 7, Period: PeriodDaily})); !found {
+			t.Errorf("expected %s to be cached", symbols[i])
+		}
+	}
+}
+
+func TestWarmGivesUpAtDeadline(t *testing.T) {
+	provider := &concurrencyProvider{release: make(chan struct{})}
+	defer close(provider.release)
+	client := NewClient(ClientOptions{
+		Providers:      []StockProvider{provider},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(5, 1, time.Minute),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	results := client.Warm(ctx, []string{"AAPL", "MSFT", "GOOG"}, 7, 1)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected Warm to return at the deadline, took %s", elapsed)
+	}
+	for _, result := range results {
+		if !errors.Is(result.Err, context.DeadlineExceeded) {
+			t.Errorf("expected %s to report the deadline, got %v", result.Symbol, result.Err)
+		}
+	}
+}
+
+func TestWarmReportsFailures(t *testing.T) {
+	provider := &fakeProvider{name: "fake", err: fmt.Errorf("%w: status 500", ErrUpstream)}
+	cb := circuitbreaker.NewCircuitBreaker(1, 1, time.Minute)
+	client := NewClient(ClientOptions{
+		Providers:      []StockProvider{provider},
+		Cache:          cache.NewCache(time.Minute),
+		CircuitBreaker: cb,
+	})
+
+	results := client.Warm(context.Background(), []string{"AAPL", "MSFT"}, 7, 1)
+	if !errors.Is(results[0].Err, ErrUpstream) {
+		t.Errorf("expected the first symbol to fail upstream, got %v", results[0].Err)
+	}
+	// The first failure opened the breaker, so the second never reached the provider
+	if !errors.Is(results[1].Err, circuitbreaker.ErrCircuitBreakerOpen) || provider.calls != 1 {
+		t.Errorf("expected the open breaker to stop the second fetch, got %v after %d calls", results[1].Err, provider.calls)
+	}
+}
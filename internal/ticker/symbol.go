@@ -0,0 +1,54 @@
+// Package ticker validates and normalizes ticker symbols. It has no
+// internal dependencies so config, handlers and stock can all share it.
+package ticker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultPattern accepts 1-5 uppercase letters with an optional
+// exchange or share-class suffix, e.g. MSFT, BRK.B or SHOP.TO.
+const DefaultPattern = `^[A-Z]{1,5}(\.[A-Z]{1,4})?$`
+
+// Validator checks ticker symbols before they reach the upstream API.
+type Validator struct {
+	pattern *regexp.Regexp
+}
+
+var defaultValidator = &Validator{pattern: regexp.MustCompile(DefaultPattern)}
+
+// NewValidator compiles pattern into a validator. An empty pattern
+// selects DefaultPattern.
+func NewValidator(pattern string) (*Validator, error) {
+	if pattern == "" {
+		return defaultValidator, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid symbol pattern %q: %w", pattern, err)
+	}
+	return &Validator{pattern: re}, nil
+}
+
+// Validate returns an error if symbol, once normalized, does not match the
+// pattern.
+func (v *Validator) Validate(symbol string) error {
+	if !v.pattern.MatchString(Normalize(symbol)) {
+		return fmt.Errorf("invalid symbol %q: must match %s", symbol, v.pattern)
+	}
+	return nil
+}
+
+// Validate checks symbol against DefaultPattern.
+func Validate(symbol string) error {
+	return defaultValidator.Validate(symbol)
+}
+
+// Normalize trims surrounding whitespace and uppercases symbol, so
+// aapl and AAPL share a cache entry and reach the provider the same way.
+func Normalize(symbol string) string {
+	return strings.ToUpper(strings.TrimSpace(symbol))
+}
@@ -0,0 +1,46 @@
+package ticker
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	valid := []string{"A", "MSFT", "GOOGL", "BRK.B", "SHOP.TO", "RDS.A", "msft", " brk.b "}
+	for _, symbol := range valid {
+		if err := Validate(symbol); err != nil {
+			t.Errorf("Validate(%q): unexpected error: %v", symbol, err)
+		}
+	}
+
+	invalid := []string{"", "TOOLONG", "INVALID_SYMBOL_12345", "MS FT", "BRK.", ".B", "AAPL.B.C", "123"}
+	for _, symbol := range invalid {
+		if err := Validate(symbol); err == nil {
+			t.Errorf("Validate(%q): expected error", symbol)
+		}
+	}
+}
+
+func TestNewValidatorCustomPattern(t *testing.T) {
+	// Allow numeric tickers such as Hong Kong listings
+	v, err := NewValidator(`^([A-Z]{1,5}|[0-9]{4}\.HK)$`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := v.Validate("0700.HK"); err != nil {
+		t.Errorf("expected custom pattern to accept 0700.HK: %v", err)
+	}
+	if err := v.Validate("BRK.B"); err == nil {
+		t.Error("expected custom pattern to reject BRK.B")
+	}
+
+	if _, err := NewValidator("[unclosed"); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for in, want := range map[string]string{"aapl": "AAPL", " AAPL\t": "AAPL", "brk.b": "BRK.B", "": ""} {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Package tracing configures OpenTelemetry tracing for the service.
+//
+// Tracing is off unless Setup is called with it enabled. While it is off
+// the global tracer provider is OpenTelemetry's no-op default, so spans
+// started by instrumented code cost next to nothing, and Middleware and
+// Transport don't wrap anything.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// ServiceName identifies the service in exported traces.
+const ServiceName = "stock-service"
+
+var enabled atomic.Bool
+
+// Setup installs a global tracer provider that batches spans to the OTLP
+// HTTP endpoint, e.g. http://otel-collector:4318. An empty endpoint uses
+// the exporter's default or the standard OTEL_EXPORTER_OTLP_* variables.
+// When enabled is false nothing is installed. The returned function
+// flushes and stops the exporter.
+func Setup(ctx context.Context, isEnabled bool, endpoint string) (func(context.Context) error, error) {
+	if !isEnabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var opts []otlptracehttp.Option
+	if endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	enabled.Store(true)
+
+	return provider.Shutdown, nil
+}
+
+// Enabled reports whether Setup installed a tracer provider.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Middleware starts a server span for each request, continuing any trace
+// propagated by the caller. Spans are named after the matched route
+// template so symbols don't explode span name cardinality.
+func Middleware(next http.Handler) http.Handler {
+	if !Enabled() {
+		return next
+	}
+	return otelhttp.NewHandler(next, ServiceName,
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			if route := mux.CurrentRoute(r); route != nil {
+				if path, err := route.GetPathTemplate(); err == nil {
+					return r.Method + " " + path
+				}
+			}
+			return r.Method
+		}),
+	)
+}
+
+// Transport wraps base so outbound requests get client spans and carry the
+// trace context.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if !Enabled() {
+		return base
+	}
+	return otelhttp.NewTransport(base)
+}
@@ -0,0 +1,33 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDisabledIsNoOp(t *testing.T) {
+	shutdown, err := Setup(context.Background(), false, "http://collector:4318")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if Enabled() {
+		t.Error("expected tracing to stay disabled")
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := Middleware(next)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/AAPL", nil))
+	if _, wrapped := handler.(http.HandlerFunc); !wrapped {
+		t.Errorf("expected the handler to be returned unwrapped, got %T", handler)
+	}
+
+	if transport := Transport(http.DefaultTransport); transport != http.DefaultTransport {
+		t.Errorf("expected the transport to be returned unwrapped, got %T", transport)
+	}
+}
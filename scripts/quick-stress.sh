@@ -7,10 +7,10 @@ echo "Target: http://stock-service.46.225.33.158.nip.io"
 echo "Duration: 60 seconds of pure metric chaos!"
 echo ""
 echo "Watch these metrics in Grafana:"
-echo "  • stock_service_requests_total"
-echo "  • stock_service_request_duration_seconds" 
-echo "  • stock_service_circuit_breaker_state"
-echo "  • stock_service_stock_api_duration_seconds"
+echo "  • stock_api_requests_total"
+echo "  • stock_api_request_duration_seconds" 
+echo "  • stock_api_circuit_breaker_state"
+echo "  • stock_api_external_call_duration_seconds"
 echo ""
 echo "Starting in 3... 2... 1... 🔥"
 
@@ -51,4 +51,4 @@ echo "🎉 METRIC CHAOS COMPLETE! 🎉"
 echo "Check Grafana now - your charts should be MOVING! 🕺💃"
 echo ""
 echo "Quick metrics check:"
-curl -s "http://stock-service.46.225.33.158.nip.io/metrics" | grep -E "(stock_service_requests_total|stock_service_request_duration_seconds)" | tail -3
\ No newline at end of file
+curl -s "http://stock-service.46.225.33.158.nip.io/metrics" | grep -E "(stock_api_requests_total|stock_api_request_duration_seconds)" | tail -3
\ No newline at end of file
@@ -125,12 +125,12 @@ monitor_metrics() {
     echo -e "${CYAN}Monitoring metrics...${NC}"
     
     # Get current request count
-    request_count=$(curl -s "${STOCK_SERVICE_URL}/metrics" 2>/dev/null | grep "stock_service_requests_total" | wc -l)
+    request_count=$(curl -s "${STOCK_SERVICE_URL}/metrics" 2>/dev/null | grep "stock_api_requests_total" | wc -l)
     echo -e "${BLUE}Available metrics: ${request_count}${NC}"
     
     # Show some key metrics
     echo -e "${YELLOW}Key metrics:${NC}"
-    curl -s "${STOCK_SERVICE_URL}/metrics" 2>/dev/null | grep -E "(stock_service_requests_total|stock_service_circuit_breaker|stock_service_errors_total)" | head -10
+    curl -s "${STOCK_SERVICE_URL}/metrics" 2>/dev/null | grep -E "(stock_api_requests_total|stock_api_circuit_breaker|stock_api_errors_total)" | head -10
 }
 
 # Function to run comprehensive test
@@ -201,7 +201,7 @@ run_comprehensive_test() {
             
             # Show current metrics snapshot
             echo -e "${YELLOW}Current metrics snapshot:${NC}"
-            curl -s "${STOCK_SERVICE_URL}/metrics" 2>/dev/null | grep -E "(stock_service_requests_total|stock_service_circuit_breaker_state|stock_service_errors_total)" | tail -5
+            curl -s "${STOCK_SERVICE_URL}/metrics" 2>/dev/null | grep -E "(stock_api_requests_total|stock_api_circuit_breaker_state|stock_api_errors_total)" | tail -5
         fi
         
         sleep 1
@@ -228,12 +228,12 @@ show_grafana_urls() {
     echo -e "${BLUE}Main Grafana: http://grafana.46.225.33.158.nip.io${NC}"
     echo -e "${BLUE}Direct Prometheus: http://prometheus.46.225.33.158.nip.io${NC}"
     echo -e "${YELLOW}Look for these metrics in Grafana:${NC}"
-    echo -e "  • stock_service_requests_total"
-    echo -e "  • stock_service_request_duration_seconds"
-    echo -e "  • stock_service_circuit_breaker_state"
-    echo -e "  • stock_service_circuit_breaker_failures_total"
-    echo -e "  • stock_service_stock_api_duration_seconds"
-    echo -e "  • stock_service_errors_total"
+    echo -e "  • stock_api_requests_total"
+    echo -e "  • stock_api_request_duration_seconds"
+    echo -e "  • stock_api_circuit_breaker_state"
+    echo -e "  • stock_api_circuit_breaker_failures_total"
+    echo -e "  • stock_api_external_call_duration_seconds"
+    echo -e "  • stock_api_errors_total"
     echo -e "${CYAN}========================================${NC}"
 }
 
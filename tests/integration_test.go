@@ -11,6 +11,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/cache"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/config"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/handlers"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/stock"
@@ -35,8 +37,13 @@ func setupTestServer() (*httptest.Server, *config.Config) {
 	}
 
 	logger := zap.NewNop()
-	stockClient := stock.NewClient(cfg, logger)
-	handler := handlers.NewHandler(cfg, stockClient, logger)
+	stockClient := stock.NewClient(stock.ClientOptions{
+		Providers:      []stock.StockProvider{stock.NewAlphaVantageProvider(cfg.APIKey, stock.OutputSizeAuto, 10*time.Second, 0, nil, logger)},
+		Logger:         logger,
+		Cache:          cache.NewCache(5 * time.Minute),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(5, 10, 30*time.Second),
+	})
+	handler := handlers.New(handlers.HandlerOptions{Config: cfg, StockClient: stockClient, Logger: logger})
 
 	r := mux.NewRouter()
 	
@@ -119,11 +126,11 @@ func TestMetricsEndpoint(t *testing.T) {
 	expectedMetrics := []string{
 		"go_info",
 		"go_goroutines",
-		"ping_service_requests_total",
-		"ping_service_request_duration_seconds",
-		"ping_service_stock_api_duration_seconds",
-		"ping_service_cache_hits_total",
-		"ping_service_cache_misses_total",
+		"stock_api_requests_total",
+		"stock_api_request_duration_seconds",
+		"stock_api_external_call_duration_seconds",
+		"stock_api_cache_hits_total",
+		"stock_api_cache_misses_total",
 	}
 
 	for _, metric := range expectedMetrics {
@@ -13,12 +13,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/cache"
+	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/circuitbreaker"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/config"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/handlers"
 	"github.com/awsh-code/Overly-Serious-Simple-Stock-Service/internal/stock"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
 )
 
 func setupTestServer(t *testing.T) (*httptest.Server, *prometheus.Registry) {
@@ -36,10 +39,15 @@ func setupTestServer(t *testing.T) (*httptest.Server, *prometheus.Registry) {
 	}
 
 	// Create stock client with test API key
-	stockClient := stock.NewClient(cfg.APIKey, reg)
+	stockClient := stock.NewClient(stock.ClientOptions{
+		Providers:      []stock.StockProvider{stock.NewAlphaVantageProvider(cfg.APIKey, stock.OutputSizeAuto, 10*time.Second, 0, nil, zap.NewNop())},
+		Cache:          cache.NewCache(cfg.CacheTTL),
+		CircuitBreaker: circuitbreaker.NewCircuitBreaker(cfg.CircuitBreakerThreshold, 10, cfg.CircuitBreakerTimeout),
+		Registerer:     reg,
+	})
 
 	// Create handler with test configuration
-	handler := handlers.New(cfg, stockClient, reg)
+	handler := handlers.New(handlers.HandlerOptions{Config: cfg, StockClient: stockClient, Registerer: reg, Gatherer: reg})
 
 	// Create router
 	router := mux.NewRouter()
@@ -132,7 +140,7 @@ func TestMetricsEndpoint(t *testing.T) {
 	expectedMetrics := []string{
 		"# HELP",
 		"# TYPE",
-		"ping_service_",
+		"stock_api_",
 	}
 
 	for _, expected := range expectedMetrics {
@@ -327,10 +335,10 @@ func TestCacheHitMetrics(t *testing.T) {
 	updatedMetrics := string(body)
 
 	// Check that cache metrics are present
-	if !strings.Contains(updatedMetrics, "ping_service_cache_hits_total") {
+	if !strings.Contains(updatedMetrics, "stock_api_cache_hits_total") {
 		t.Error("Expected cache hits metric to be present")
 	}
-	if !strings.Contains(updatedMetrics, "ping_service_cache_misses_total") {
+	if !strings.Contains(updatedMetrics, "stock_api_cache_misses_total") {
 		t.Error("Expected cache misses metric to be present")
 	}
 }
@@ -363,10 +371,10 @@ func TestCircuitBreakerMetrics(t *testing.T) {
 	metrics := string(body)
 
 	// Check that circuit breaker metrics are present
-	if !strings.Contains(metrics, "ping_service_circuit_breaker_state") {
+	if !strings.Contains(metrics, "stock_api_circuit_breaker_state") {
 		t.Error("Expected circuit breaker state metric to be present")
 	}
-	if !strings.Contains(metrics, "ping_service_stock_api_duration_seconds") {
+	if !strings.Contains(metrics, "stock_api_external_call_duration_seconds") {
 		t.Error("Expected stock API duration metric to be present")
 	}
 }